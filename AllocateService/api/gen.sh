@@ -0,0 +1,15 @@
+#!/bin/sh -e
+
+# Generates the Go code for allocator.proto into the allocator service and the director,
+# which each build from their own directory and so keep their own copy.
+# Requires protoc and protoc-gen-go v1.3.2:
+#   GO111MODULE=on go get github.com/golang/protobuf/protoc-gen-go@v1.3.2
+
+SCRIPT_DIR=$(cd $(dirname $0); pwd)
+
+for OUT in \
+  $SCRIPT_DIR/../mod_allocator-service/allocatorpb \
+  $SCRIPT_DIR/../../OpenMatch/mod_matchmaker101/director/allocatorpb; do
+  mkdir -p $OUT
+  protoc -I $SCRIPT_DIR --go_out=plugins=grpc,paths=source_relative:$OUT allocator.proto
+done
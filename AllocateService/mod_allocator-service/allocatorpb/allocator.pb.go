@@ -0,0 +1,1289 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// source: allocator.proto
+
+package allocatorpb
+
+import (
+	context "context"
+	fmt "fmt"
+	proto "github.com/golang/protobuf/proto"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+	math "math"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.ProtoPackageIsVersion3 // please upgrade the proto package
+
+type AllocationError_Code int32
+
+const (
+	AllocationError_UNKNOWN AllocationError_Code = 0
+	// The request parameters are invalid.
+	AllocationError_INVALID_REQUEST AllocationError_Code = 1
+	// A requested fleet is not in the allocator's allowlist.
+	AllocationError_FLEET_NOT_ALLOWED AllocationError_Code = 2
+	// The client did not authenticate.
+	AllocationError_UNAUTHORIZED AllocationError_Code = 3
+	// None of the requested fleets has a Ready GameServer matching the selectors.
+	AllocationError_NO_READY_REPLICAS AllocationError_Code = 4
+	// A call to the Kubernetes API failed.
+	AllocationError_API_FAILURE AllocationError_Code = 5
+	// The GameServer does not exist or is not Allocated.
+	AllocationError_NOT_FOUND AllocationError_Code = 6
+	// Other allocations kept competing for the same GameServers.
+	AllocationError_CONTENTION AllocationError_Code = 7
+	// The client canceled the request before it was done.
+	AllocationError_CANCELED AllocationError_Code = 8
+	// The deadline of the request passed before it was done.
+	AllocationError_DEADLINE_EXCEEDED AllocationError_Code = 9
+)
+
+var AllocationError_Code_name = map[int32]string{
+	0: "UNKNOWN",
+	1: "INVALID_REQUEST",
+	2: "FLEET_NOT_ALLOWED",
+	3: "UNAUTHORIZED",
+	4: "NO_READY_REPLICAS",
+	5: "API_FAILURE",
+	6: "NOT_FOUND",
+	7: "CONTENTION",
+	8: "CANCELED",
+	9: "DEADLINE_EXCEEDED",
+}
+
+var AllocationError_Code_value = map[string]int32{
+	"UNKNOWN":           0,
+	"INVALID_REQUEST":   1,
+	"FLEET_NOT_ALLOWED": 2,
+	"UNAUTHORIZED":      3,
+	"NO_READY_REPLICAS": 4,
+	"API_FAILURE":       5,
+	"NOT_FOUND":         6,
+	"CONTENTION":        7,
+	"CANCELED":          8,
+	"DEADLINE_EXCEEDED": 9,
+}
+
+func (x AllocationError_Code) String() string {
+	return proto.EnumName(AllocationError_Code_name, int32(x))
+}
+
+func (AllocationError_Code) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{15, 0}
+}
+
+// The match a GameServer is allocated for. It is recorded on the GameServer.
+type MatchMetadata struct {
+	MatchId   string   `protobuf:"bytes,1,opt,name=match_id,json=matchId,proto3" json:"match_id,omitempty"`
+	GameMode  string   `protobuf:"bytes,2,opt,name=game_mode,json=gameMode,proto3" json:"game_mode,omitempty"`
+	TicketIds []string `protobuf:"bytes,3,rep,name=ticket_ids,json=ticketIds,proto3" json:"ticket_ids,omitempty"`
+	// W3C trace context of the match ("traceparent", "tracestate"). It is carried per request
+	// rather than in the call metadata because a batch holds the matches of many traces.
+	TraceContext         map[string]string `protobuf:"bytes,4,rep,name=trace_context,json=traceContext,proto3" json:"trace_context,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *MatchMetadata) Reset()         { *m = MatchMetadata{} }
+func (m *MatchMetadata) String() string { return proto.CompactTextString(m) }
+func (*MatchMetadata) ProtoMessage()    {}
+func (*MatchMetadata) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{0}
+}
+
+func (m *MatchMetadata) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_MatchMetadata.Unmarshal(m, b)
+}
+func (m *MatchMetadata) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_MatchMetadata.Marshal(b, m, deterministic)
+}
+func (m *MatchMetadata) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MatchMetadata.Merge(m, src)
+}
+func (m *MatchMetadata) XXX_Size() int {
+	return xxx_messageInfo_MatchMetadata.Size(m)
+}
+func (m *MatchMetadata) XXX_DiscardUnknown() {
+	xxx_messageInfo_MatchMetadata.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MatchMetadata proto.InternalMessageInfo
+
+func (m *MatchMetadata) GetMatchId() string {
+	if m != nil {
+		return m.MatchId
+	}
+	return ""
+}
+
+func (m *MatchMetadata) GetGameMode() string {
+	if m != nil {
+		return m.GameMode
+	}
+	return ""
+}
+
+func (m *MatchMetadata) GetTicketIds() []string {
+	if m != nil {
+		return m.TicketIds
+	}
+	return nil
+}
+
+func (m *MatchMetadata) GetTraceContext() map[string]string {
+	if m != nil {
+		return m.TraceContext
+	}
+	return nil
+}
+
+type AllocateRequest struct {
+	// Namespace of the fleets, defaults to "default".
+	Namespace string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Fleets to allocate from, tried in order. Defaults to the allocator's default fleet.
+	Fleets []string `protobuf:"bytes,2,rep,name=fleets,proto3" json:"fleets,omitempty"`
+	// Label selector every allocated GameServer must match, e.g. "mode=ctf,region in (asia)".
+	Selector string `protobuf:"bytes,3,opt,name=selector,proto3" json:"selector,omitempty"`
+	// Label selectors to try before falling back to selector, in order.
+	Preferred            []string       `protobuf:"bytes,4,rep,name=preferred,proto3" json:"preferred,omitempty"`
+	Match                *MatchMetadata `protobuf:"bytes,5,opt,name=match,proto3" json:"match,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}       `json:"-"`
+	XXX_unrecognized     []byte         `json:"-"`
+	XXX_sizecache        int32          `json:"-"`
+}
+
+func (m *AllocateRequest) Reset()         { *m = AllocateRequest{} }
+func (m *AllocateRequest) String() string { return proto.CompactTextString(m) }
+func (*AllocateRequest) ProtoMessage()    {}
+func (*AllocateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{1}
+}
+
+func (m *AllocateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AllocateRequest.Unmarshal(m, b)
+}
+func (m *AllocateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AllocateRequest.Marshal(b, m, deterministic)
+}
+func (m *AllocateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AllocateRequest.Merge(m, src)
+}
+func (m *AllocateRequest) XXX_Size() int {
+	return xxx_messageInfo_AllocateRequest.Size(m)
+}
+func (m *AllocateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AllocateRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AllocateRequest proto.InternalMessageInfo
+
+func (m *AllocateRequest) GetNamespace() string {
+	if m != nil {
+		return m.Namespace
+	}
+	return ""
+}
+
+func (m *AllocateRequest) GetFleets() []string {
+	if m != nil {
+		return m.Fleets
+	}
+	return nil
+}
+
+func (m *AllocateRequest) GetSelector() string {
+	if m != nil {
+		return m.Selector
+	}
+	return ""
+}
+
+func (m *AllocateRequest) GetPreferred() []string {
+	if m != nil {
+		return m.Preferred
+	}
+	return nil
+}
+
+func (m *AllocateRequest) GetMatch() *MatchMetadata {
+	if m != nil {
+		return m.Match
+	}
+	return nil
+}
+
+type Port struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Port                 int32    `protobuf:"varint,2,opt,name=port,proto3" json:"port,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *Port) Reset()         { *m = Port{} }
+func (m *Port) String() string { return proto.CompactTextString(m) }
+func (*Port) ProtoMessage()    {}
+func (*Port) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{2}
+}
+
+func (m *Port) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_Port.Unmarshal(m, b)
+}
+func (m *Port) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_Port.Marshal(b, m, deterministic)
+}
+func (m *Port) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Port.Merge(m, src)
+}
+func (m *Port) XXX_Size() int {
+	return xxx_messageInfo_Port.Size(m)
+}
+func (m *Port) XXX_DiscardUnknown() {
+	xxx_messageInfo_Port.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Port proto.InternalMessageInfo
+
+func (m *Port) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Port) GetPort() int32 {
+	if m != nil {
+		return m.Port
+	}
+	return 0
+}
+
+type AllocateResponse struct {
+	GameServerName       string   `protobuf:"bytes,1,opt,name=game_server_name,json=gameServerName,proto3" json:"game_server_name,omitempty"`
+	Fleet                string   `protobuf:"bytes,2,opt,name=fleet,proto3" json:"fleet,omitempty"`
+	Address              string   `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"`
+	Ports                []*Port  `protobuf:"bytes,4,rep,name=ports,proto3" json:"ports,omitempty"`
+	NodeName             string   `protobuf:"bytes,5,opt,name=node_name,json=nodeName,proto3" json:"node_name,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AllocateResponse) Reset()         { *m = AllocateResponse{} }
+func (m *AllocateResponse) String() string { return proto.CompactTextString(m) }
+func (*AllocateResponse) ProtoMessage()    {}
+func (*AllocateResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{3}
+}
+
+func (m *AllocateResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AllocateResponse.Unmarshal(m, b)
+}
+func (m *AllocateResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AllocateResponse.Marshal(b, m, deterministic)
+}
+func (m *AllocateResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AllocateResponse.Merge(m, src)
+}
+func (m *AllocateResponse) XXX_Size() int {
+	return xxx_messageInfo_AllocateResponse.Size(m)
+}
+func (m *AllocateResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_AllocateResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AllocateResponse proto.InternalMessageInfo
+
+func (m *AllocateResponse) GetGameServerName() string {
+	if m != nil {
+		return m.GameServerName
+	}
+	return ""
+}
+
+func (m *AllocateResponse) GetFleet() string {
+	if m != nil {
+		return m.Fleet
+	}
+	return ""
+}
+
+func (m *AllocateResponse) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+func (m *AllocateResponse) GetPorts() []*Port {
+	if m != nil {
+		return m.Ports
+	}
+	return nil
+}
+
+func (m *AllocateResponse) GetNodeName() string {
+	if m != nil {
+		return m.NodeName
+	}
+	return ""
+}
+
+type AllocateBatchRequest struct {
+	Requests             []*AllocateRequest `protobuf:"bytes,1,rep,name=requests,proto3" json:"requests,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}           `json:"-"`
+	XXX_unrecognized     []byte             `json:"-"`
+	XXX_sizecache        int32              `json:"-"`
+}
+
+func (m *AllocateBatchRequest) Reset()         { *m = AllocateBatchRequest{} }
+func (m *AllocateBatchRequest) String() string { return proto.CompactTextString(m) }
+func (*AllocateBatchRequest) ProtoMessage()    {}
+func (*AllocateBatchRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{4}
+}
+
+func (m *AllocateBatchRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AllocateBatchRequest.Unmarshal(m, b)
+}
+func (m *AllocateBatchRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AllocateBatchRequest.Marshal(b, m, deterministic)
+}
+func (m *AllocateBatchRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AllocateBatchRequest.Merge(m, src)
+}
+func (m *AllocateBatchRequest) XXX_Size() int {
+	return xxx_messageInfo_AllocateBatchRequest.Size(m)
+}
+func (m *AllocateBatchRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_AllocateBatchRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AllocateBatchRequest proto.InternalMessageInfo
+
+func (m *AllocateBatchRequest) GetRequests() []*AllocateRequest {
+	if m != nil {
+		return m.Requests
+	}
+	return nil
+}
+
+// The result of one request of a batch. Exactly one of allocation and error is set.
+type AllocateResult struct {
+	Allocation           *AllocateResponse `protobuf:"bytes,1,opt,name=allocation,proto3" json:"allocation,omitempty"`
+	Error                *AllocationError  `protobuf:"bytes,2,opt,name=error,proto3" json:"error,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *AllocateResult) Reset()         { *m = AllocateResult{} }
+func (m *AllocateResult) String() string { return proto.CompactTextString(m) }
+func (*AllocateResult) ProtoMessage()    {}
+func (*AllocateResult) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{5}
+}
+
+func (m *AllocateResult) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AllocateResult.Unmarshal(m, b)
+}
+func (m *AllocateResult) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AllocateResult.Marshal(b, m, deterministic)
+}
+func (m *AllocateResult) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AllocateResult.Merge(m, src)
+}
+func (m *AllocateResult) XXX_Size() int {
+	return xxx_messageInfo_AllocateResult.Size(m)
+}
+func (m *AllocateResult) XXX_DiscardUnknown() {
+	xxx_messageInfo_AllocateResult.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AllocateResult proto.InternalMessageInfo
+
+func (m *AllocateResult) GetAllocation() *AllocateResponse {
+	if m != nil {
+		return m.Allocation
+	}
+	return nil
+}
+
+func (m *AllocateResult) GetError() *AllocationError {
+	if m != nil {
+		return m.Error
+	}
+	return nil
+}
+
+type AllocateBatchResponse struct {
+	// Results in the order of the requests.
+	Results              []*AllocateResult `protobuf:"bytes,1,rep,name=results,proto3" json:"results,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}          `json:"-"`
+	XXX_unrecognized     []byte            `json:"-"`
+	XXX_sizecache        int32             `json:"-"`
+}
+
+func (m *AllocateBatchResponse) Reset()         { *m = AllocateBatchResponse{} }
+func (m *AllocateBatchResponse) String() string { return proto.CompactTextString(m) }
+func (*AllocateBatchResponse) ProtoMessage()    {}
+func (*AllocateBatchResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{6}
+}
+
+func (m *AllocateBatchResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AllocateBatchResponse.Unmarshal(m, b)
+}
+func (m *AllocateBatchResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AllocateBatchResponse.Marshal(b, m, deterministic)
+}
+func (m *AllocateBatchResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AllocateBatchResponse.Merge(m, src)
+}
+func (m *AllocateBatchResponse) XXX_Size() int {
+	return xxx_messageInfo_AllocateBatchResponse.Size(m)
+}
+func (m *AllocateBatchResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_AllocateBatchResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AllocateBatchResponse proto.InternalMessageInfo
+
+func (m *AllocateBatchResponse) GetResults() []*AllocateResult {
+	if m != nil {
+		return m.Results
+	}
+	return nil
+}
+
+type DeallocateRequest struct {
+	Namespace      string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	GameServerName string `protobuf:"bytes,2,opt,name=game_server_name,json=gameServerName,proto3" json:"game_server_name,omitempty"`
+	// Why the GameServer is released, recorded in the allocator's log.
+	Reason               string   `protobuf:"bytes,3,opt,name=reason,proto3" json:"reason,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeallocateRequest) Reset()         { *m = DeallocateRequest{} }
+func (m *DeallocateRequest) String() string { return proto.CompactTextString(m) }
+func (*DeallocateRequest) ProtoMessage()    {}
+func (*DeallocateRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{7}
+}
+
+func (m *DeallocateRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeallocateRequest.Unmarshal(m, b)
+}
+func (m *DeallocateRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeallocateRequest.Marshal(b, m, deterministic)
+}
+func (m *DeallocateRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeallocateRequest.Merge(m, src)
+}
+func (m *DeallocateRequest) XXX_Size() int {
+	return xxx_messageInfo_DeallocateRequest.Size(m)
+}
+func (m *DeallocateRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeallocateRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeallocateRequest proto.InternalMessageInfo
+
+func (m *DeallocateRequest) GetNamespace() string {
+	if m != nil {
+		return m.Namespace
+	}
+	return ""
+}
+
+func (m *DeallocateRequest) GetGameServerName() string {
+	if m != nil {
+		return m.GameServerName
+	}
+	return ""
+}
+
+func (m *DeallocateRequest) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+type DeallocateResponse struct {
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *DeallocateResponse) Reset()         { *m = DeallocateResponse{} }
+func (m *DeallocateResponse) String() string { return proto.CompactTextString(m) }
+func (*DeallocateResponse) ProtoMessage()    {}
+func (*DeallocateResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{8}
+}
+
+func (m *DeallocateResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_DeallocateResponse.Unmarshal(m, b)
+}
+func (m *DeallocateResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_DeallocateResponse.Marshal(b, m, deterministic)
+}
+func (m *DeallocateResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_DeallocateResponse.Merge(m, src)
+}
+func (m *DeallocateResponse) XXX_Size() int {
+	return xxx_messageInfo_DeallocateResponse.Size(m)
+}
+func (m *DeallocateResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_DeallocateResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_DeallocateResponse proto.InternalMessageInfo
+
+type ListAllocatedRequest struct {
+	Namespace string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Only list GameServers of this fleet. Defaults to every allowed fleet in the namespace.
+	Fleet                string   `protobuf:"bytes,2,opt,name=fleet,proto3" json:"fleet,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *ListAllocatedRequest) Reset()         { *m = ListAllocatedRequest{} }
+func (m *ListAllocatedRequest) String() string { return proto.CompactTextString(m) }
+func (*ListAllocatedRequest) ProtoMessage()    {}
+func (*ListAllocatedRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{9}
+}
+
+func (m *ListAllocatedRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListAllocatedRequest.Unmarshal(m, b)
+}
+func (m *ListAllocatedRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListAllocatedRequest.Marshal(b, m, deterministic)
+}
+func (m *ListAllocatedRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListAllocatedRequest.Merge(m, src)
+}
+func (m *ListAllocatedRequest) XXX_Size() int {
+	return xxx_messageInfo_ListAllocatedRequest.Size(m)
+}
+func (m *ListAllocatedRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListAllocatedRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListAllocatedRequest proto.InternalMessageInfo
+
+func (m *ListAllocatedRequest) GetNamespace() string {
+	if m != nil {
+		return m.Namespace
+	}
+	return ""
+}
+
+func (m *ListAllocatedRequest) GetFleet() string {
+	if m != nil {
+		return m.Fleet
+	}
+	return ""
+}
+
+type AllocatedGameServer struct {
+	Name    string         `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Fleet   string         `protobuf:"bytes,2,opt,name=fleet,proto3" json:"fleet,omitempty"`
+	Address string         `protobuf:"bytes,3,opt,name=address,proto3" json:"address,omitempty"`
+	Ports   []*Port        `protobuf:"bytes,4,rep,name=ports,proto3" json:"ports,omitempty"`
+	Match   *MatchMetadata `protobuf:"bytes,5,opt,name=match,proto3" json:"match,omitempty"`
+	// When the GameServer was allocated, in Unix seconds. 0 if unknown.
+	AllocatedAt int64 `protobuf:"varint,6,opt,name=allocated_at,json=allocatedAt,proto3" json:"allocated_at,omitempty"`
+	// Players connected to the GameServer as last reported by it. 0 if it has not reported yet.
+	Players int32 `protobuf:"varint,7,opt,name=players,proto3" json:"players,omitempty"`
+	// When the GameServer last reported that its last player left, or that it started with no
+	// players, in Unix seconds. 0 if it has players or has not reported yet.
+	EmptySince           int64    `protobuf:"varint,8,opt,name=empty_since,json=emptySince,proto3" json:"empty_since,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AllocatedGameServer) Reset()         { *m = AllocatedGameServer{} }
+func (m *AllocatedGameServer) String() string { return proto.CompactTextString(m) }
+func (*AllocatedGameServer) ProtoMessage()    {}
+func (*AllocatedGameServer) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{10}
+}
+
+func (m *AllocatedGameServer) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AllocatedGameServer.Unmarshal(m, b)
+}
+func (m *AllocatedGameServer) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AllocatedGameServer.Marshal(b, m, deterministic)
+}
+func (m *AllocatedGameServer) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AllocatedGameServer.Merge(m, src)
+}
+func (m *AllocatedGameServer) XXX_Size() int {
+	return xxx_messageInfo_AllocatedGameServer.Size(m)
+}
+func (m *AllocatedGameServer) XXX_DiscardUnknown() {
+	xxx_messageInfo_AllocatedGameServer.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AllocatedGameServer proto.InternalMessageInfo
+
+func (m *AllocatedGameServer) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *AllocatedGameServer) GetFleet() string {
+	if m != nil {
+		return m.Fleet
+	}
+	return ""
+}
+
+func (m *AllocatedGameServer) GetAddress() string {
+	if m != nil {
+		return m.Address
+	}
+	return ""
+}
+
+func (m *AllocatedGameServer) GetPorts() []*Port {
+	if m != nil {
+		return m.Ports
+	}
+	return nil
+}
+
+func (m *AllocatedGameServer) GetMatch() *MatchMetadata {
+	if m != nil {
+		return m.Match
+	}
+	return nil
+}
+
+func (m *AllocatedGameServer) GetAllocatedAt() int64 {
+	if m != nil {
+		return m.AllocatedAt
+	}
+	return 0
+}
+
+func (m *AllocatedGameServer) GetPlayers() int32 {
+	if m != nil {
+		return m.Players
+	}
+	return 0
+}
+
+func (m *AllocatedGameServer) GetEmptySince() int64 {
+	if m != nil {
+		return m.EmptySince
+	}
+	return 0
+}
+
+type ListAllocatedResponse struct {
+	GameServers          []*AllocatedGameServer `protobuf:"bytes,1,rep,name=game_servers,json=gameServers,proto3" json:"game_servers,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}               `json:"-"`
+	XXX_unrecognized     []byte                 `json:"-"`
+	XXX_sizecache        int32                  `json:"-"`
+}
+
+func (m *ListAllocatedResponse) Reset()         { *m = ListAllocatedResponse{} }
+func (m *ListAllocatedResponse) String() string { return proto.CompactTextString(m) }
+func (*ListAllocatedResponse) ProtoMessage()    {}
+func (*ListAllocatedResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{11}
+}
+
+func (m *ListAllocatedResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_ListAllocatedResponse.Unmarshal(m, b)
+}
+func (m *ListAllocatedResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_ListAllocatedResponse.Marshal(b, m, deterministic)
+}
+func (m *ListAllocatedResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_ListAllocatedResponse.Merge(m, src)
+}
+func (m *ListAllocatedResponse) XXX_Size() int {
+	return xxx_messageInfo_ListAllocatedResponse.Size(m)
+}
+func (m *ListAllocatedResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_ListAllocatedResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_ListAllocatedResponse proto.InternalMessageInfo
+
+func (m *ListAllocatedResponse) GetGameServers() []*AllocatedGameServer {
+	if m != nil {
+		return m.GameServers
+	}
+	return nil
+}
+
+type FleetCapacityRequest struct {
+	Namespace string `protobuf:"bytes,1,opt,name=namespace,proto3" json:"namespace,omitempty"`
+	// Only report this fleet. Defaults to every allowed fleet in the namespace.
+	Fleet                string   `protobuf:"bytes,2,opt,name=fleet,proto3" json:"fleet,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FleetCapacityRequest) Reset()         { *m = FleetCapacityRequest{} }
+func (m *FleetCapacityRequest) String() string { return proto.CompactTextString(m) }
+func (*FleetCapacityRequest) ProtoMessage()    {}
+func (*FleetCapacityRequest) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{12}
+}
+
+func (m *FleetCapacityRequest) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FleetCapacityRequest.Unmarshal(m, b)
+}
+func (m *FleetCapacityRequest) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FleetCapacityRequest.Marshal(b, m, deterministic)
+}
+func (m *FleetCapacityRequest) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FleetCapacityRequest.Merge(m, src)
+}
+func (m *FleetCapacityRequest) XXX_Size() int {
+	return xxx_messageInfo_FleetCapacityRequest.Size(m)
+}
+func (m *FleetCapacityRequest) XXX_DiscardUnknown() {
+	xxx_messageInfo_FleetCapacityRequest.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FleetCapacityRequest proto.InternalMessageInfo
+
+func (m *FleetCapacityRequest) GetNamespace() string {
+	if m != nil {
+		return m.Namespace
+	}
+	return ""
+}
+
+func (m *FleetCapacityRequest) GetFleet() string {
+	if m != nil {
+		return m.Fleet
+	}
+	return ""
+}
+
+type FleetCapacity struct {
+	Name                 string   `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	Replicas             int32    `protobuf:"varint,2,opt,name=replicas,proto3" json:"replicas,omitempty"`
+	ReadyReplicas        int32    `protobuf:"varint,3,opt,name=ready_replicas,json=readyReplicas,proto3" json:"ready_replicas,omitempty"`
+	AllocatedReplicas    int32    `protobuf:"varint,4,opt,name=allocated_replicas,json=allocatedReplicas,proto3" json:"allocated_replicas,omitempty"`
+	ReservedReplicas     int32    `protobuf:"varint,5,opt,name=reserved_replicas,json=reservedReplicas,proto3" json:"reserved_replicas,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *FleetCapacity) Reset()         { *m = FleetCapacity{} }
+func (m *FleetCapacity) String() string { return proto.CompactTextString(m) }
+func (*FleetCapacity) ProtoMessage()    {}
+func (*FleetCapacity) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{13}
+}
+
+func (m *FleetCapacity) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FleetCapacity.Unmarshal(m, b)
+}
+func (m *FleetCapacity) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FleetCapacity.Marshal(b, m, deterministic)
+}
+func (m *FleetCapacity) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FleetCapacity.Merge(m, src)
+}
+func (m *FleetCapacity) XXX_Size() int {
+	return xxx_messageInfo_FleetCapacity.Size(m)
+}
+func (m *FleetCapacity) XXX_DiscardUnknown() {
+	xxx_messageInfo_FleetCapacity.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FleetCapacity proto.InternalMessageInfo
+
+func (m *FleetCapacity) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *FleetCapacity) GetReplicas() int32 {
+	if m != nil {
+		return m.Replicas
+	}
+	return 0
+}
+
+func (m *FleetCapacity) GetReadyReplicas() int32 {
+	if m != nil {
+		return m.ReadyReplicas
+	}
+	return 0
+}
+
+func (m *FleetCapacity) GetAllocatedReplicas() int32 {
+	if m != nil {
+		return m.AllocatedReplicas
+	}
+	return 0
+}
+
+func (m *FleetCapacity) GetReservedReplicas() int32 {
+	if m != nil {
+		return m.ReservedReplicas
+	}
+	return 0
+}
+
+type FleetCapacityResponse struct {
+	Fleets               []*FleetCapacity `protobuf:"bytes,1,rep,name=fleets,proto3" json:"fleets,omitempty"`
+	XXX_NoUnkeyedLiteral struct{}         `json:"-"`
+	XXX_unrecognized     []byte           `json:"-"`
+	XXX_sizecache        int32            `json:"-"`
+}
+
+func (m *FleetCapacityResponse) Reset()         { *m = FleetCapacityResponse{} }
+func (m *FleetCapacityResponse) String() string { return proto.CompactTextString(m) }
+func (*FleetCapacityResponse) ProtoMessage()    {}
+func (*FleetCapacityResponse) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{14}
+}
+
+func (m *FleetCapacityResponse) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_FleetCapacityResponse.Unmarshal(m, b)
+}
+func (m *FleetCapacityResponse) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_FleetCapacityResponse.Marshal(b, m, deterministic)
+}
+func (m *FleetCapacityResponse) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_FleetCapacityResponse.Merge(m, src)
+}
+func (m *FleetCapacityResponse) XXX_Size() int {
+	return xxx_messageInfo_FleetCapacityResponse.Size(m)
+}
+func (m *FleetCapacityResponse) XXX_DiscardUnknown() {
+	xxx_messageInfo_FleetCapacityResponse.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_FleetCapacityResponse proto.InternalMessageInfo
+
+func (m *FleetCapacityResponse) GetFleets() []*FleetCapacity {
+	if m != nil {
+		return m.Fleets
+	}
+	return nil
+}
+
+// Attached as a detail to every error status returned by the service.
+type AllocationError struct {
+	Code   AllocationError_Code `protobuf:"varint,1,opt,name=code,proto3,enum=allocation.AllocationError_Code" json:"code,omitempty"`
+	Reason string               `protobuf:"bytes,2,opt,name=reason,proto3" json:"reason,omitempty"`
+	// Whether trying again later may succeed.
+	Retryable            bool     `protobuf:"varint,3,opt,name=retryable,proto3" json:"retryable,omitempty"`
+	XXX_NoUnkeyedLiteral struct{} `json:"-"`
+	XXX_unrecognized     []byte   `json:"-"`
+	XXX_sizecache        int32    `json:"-"`
+}
+
+func (m *AllocationError) Reset()         { *m = AllocationError{} }
+func (m *AllocationError) String() string { return proto.CompactTextString(m) }
+func (*AllocationError) ProtoMessage()    {}
+func (*AllocationError) Descriptor() ([]byte, []int) {
+	return fileDescriptor_00a1e42ae83b082f, []int{15}
+}
+
+func (m *AllocationError) XXX_Unmarshal(b []byte) error {
+	return xxx_messageInfo_AllocationError.Unmarshal(m, b)
+}
+func (m *AllocationError) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	return xxx_messageInfo_AllocationError.Marshal(b, m, deterministic)
+}
+func (m *AllocationError) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_AllocationError.Merge(m, src)
+}
+func (m *AllocationError) XXX_Size() int {
+	return xxx_messageInfo_AllocationError.Size(m)
+}
+func (m *AllocationError) XXX_DiscardUnknown() {
+	xxx_messageInfo_AllocationError.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_AllocationError proto.InternalMessageInfo
+
+func (m *AllocationError) GetCode() AllocationError_Code {
+	if m != nil {
+		return m.Code
+	}
+	return AllocationError_UNKNOWN
+}
+
+func (m *AllocationError) GetReason() string {
+	if m != nil {
+		return m.Reason
+	}
+	return ""
+}
+
+func (m *AllocationError) GetRetryable() bool {
+	if m != nil {
+		return m.Retryable
+	}
+	return false
+}
+
+func init() {
+	proto.RegisterEnum("allocation.AllocationError_Code", AllocationError_Code_name, AllocationError_Code_value)
+	proto.RegisterType((*MatchMetadata)(nil), "allocation.MatchMetadata")
+	proto.RegisterMapType((map[string]string)(nil), "allocation.MatchMetadata.TraceContextEntry")
+	proto.RegisterType((*AllocateRequest)(nil), "allocation.AllocateRequest")
+	proto.RegisterType((*Port)(nil), "allocation.Port")
+	proto.RegisterType((*AllocateResponse)(nil), "allocation.AllocateResponse")
+	proto.RegisterType((*AllocateBatchRequest)(nil), "allocation.AllocateBatchRequest")
+	proto.RegisterType((*AllocateResult)(nil), "allocation.AllocateResult")
+	proto.RegisterType((*AllocateBatchResponse)(nil), "allocation.AllocateBatchResponse")
+	proto.RegisterType((*DeallocateRequest)(nil), "allocation.DeallocateRequest")
+	proto.RegisterType((*DeallocateResponse)(nil), "allocation.DeallocateResponse")
+	proto.RegisterType((*ListAllocatedRequest)(nil), "allocation.ListAllocatedRequest")
+	proto.RegisterType((*AllocatedGameServer)(nil), "allocation.AllocatedGameServer")
+	proto.RegisterType((*ListAllocatedResponse)(nil), "allocation.ListAllocatedResponse")
+	proto.RegisterType((*FleetCapacityRequest)(nil), "allocation.FleetCapacityRequest")
+	proto.RegisterType((*FleetCapacity)(nil), "allocation.FleetCapacity")
+	proto.RegisterType((*FleetCapacityResponse)(nil), "allocation.FleetCapacityResponse")
+	proto.RegisterType((*AllocationError)(nil), "allocation.AllocationError")
+}
+
+func init() { proto.RegisterFile("allocator.proto", fileDescriptor_00a1e42ae83b082f) }
+
+var fileDescriptor_00a1e42ae83b082f = []byte{
+	// 1066 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0xb4, 0x56, 0x5d, 0x6f, 0x1b, 0x45,
+	0x17, 0x7e, 0xd7, 0x1f, 0xb1, 0x7d, 0x1c, 0x27, 0xeb, 0x69, 0x52, 0x6d, 0xdd, 0xf6, 0xad, 0xb3,
+	0x12, 0xc8, 0x52, 0x85, 0x51, 0x43, 0x25, 0x10, 0x42, 0x42, 0x8e, 0x77, 0x52, 0xdc, 0x3a, 0xeb,
+	0x30, 0xb1, 0x09, 0x94, 0x8b, 0xd5, 0x64, 0x77, 0x0a, 0x56, 0x37, 0x5e, 0x33, 0x33, 0xa9, 0xf0,
+	0x25, 0xff, 0x80, 0x1f, 0xc2, 0x0d, 0xdc, 0xf7, 0x86, 0x7f, 0xc5, 0x1d, 0x9a, 0xd9, 0x5d, 0xef,
+	0x3a, 0xdd, 0x44, 0x54, 0x88, 0xbb, 0x39, 0x67, 0x9e, 0x3d, 0xf3, 0x9c, 0xe7, 0x7c, 0xd8, 0xb0,
+	0x4b, 0xc3, 0x30, 0xf2, 0xa9, 0x8c, 0x78, 0x7f, 0xc9, 0x23, 0x19, 0x21, 0x48, 0x1c, 0xf3, 0x68,
+	0x61, 0xff, 0x65, 0x40, 0xeb, 0x84, 0x4a, 0xff, 0xc7, 0x13, 0x26, 0x69, 0x40, 0x25, 0x45, 0xf7,
+	0xa0, 0x7e, 0xa9, 0x1c, 0xde, 0x3c, 0xb0, 0x8c, 0xae, 0xd1, 0x6b, 0x90, 0x9a, 0xb6, 0x47, 0x01,
+	0xba, 0x0f, 0x8d, 0x1f, 0xe8, 0x25, 0xf3, 0x2e, 0xa3, 0x80, 0x59, 0x25, 0x7d, 0x57, 0x57, 0x8e,
+	0x93, 0x28, 0x60, 0xe8, 0x21, 0x80, 0x9c, 0xfb, 0xaf, 0x99, 0xf4, 0xe6, 0x81, 0xb0, 0xca, 0xdd,
+	0x72, 0xaf, 0x41, 0x1a, 0xb1, 0x67, 0x14, 0x08, 0x74, 0x0a, 0x2d, 0xc9, 0xa9, 0xcf, 0x3c, 0x3f,
+	0x5a, 0x48, 0xf6, 0xb3, 0xb4, 0x2a, 0xdd, 0x72, 0xaf, 0x79, 0xf8, 0xb8, 0x9f, 0x91, 0xe9, 0x6f,
+	0x10, 0xe9, 0x4f, 0x15, 0x7c, 0x18, 0xa3, 0xf1, 0x42, 0xf2, 0x15, 0xd9, 0x96, 0x39, 0x57, 0xe7,
+	0x4b, 0x68, 0xbf, 0x03, 0x41, 0x26, 0x94, 0x5f, 0xb3, 0x55, 0x42, 0x5c, 0x1d, 0xd1, 0x1e, 0x54,
+	0xdf, 0xd0, 0xf0, 0x2a, 0x25, 0x1c, 0x1b, 0x9f, 0x97, 0x3e, 0x33, 0xec, 0x3f, 0x0c, 0xd8, 0x1d,
+	0xc4, 0xaf, 0x33, 0xc2, 0x7e, 0xba, 0x62, 0x42, 0xa2, 0x07, 0xd0, 0x58, 0xd0, 0x4b, 0x26, 0x96,
+	0xd4, 0x67, 0x49, 0x94, 0xcc, 0x81, 0xee, 0xc2, 0xd6, 0xab, 0x90, 0x31, 0x29, 0xac, 0x92, 0xce,
+	0x2f, 0xb1, 0x50, 0x07, 0xea, 0x82, 0x85, 0xcc, 0x97, 0x11, 0xb7, 0xca, 0xb1, 0x2e, 0xa9, 0xad,
+	0x22, 0x2e, 0x39, 0x7b, 0xc5, 0x38, 0x67, 0x81, 0x4e, 0xba, 0x41, 0x32, 0x07, 0xfa, 0x18, 0xaa,
+	0x5a, 0x5d, 0xab, 0xda, 0x35, 0x7a, 0xcd, 0xc3, 0x7b, 0x37, 0xca, 0x41, 0x62, 0x9c, 0xdd, 0x87,
+	0xca, 0x69, 0xc4, 0x25, 0x42, 0x50, 0x51, 0xbc, 0x12, 0x8e, 0xfa, 0xac, 0x7c, 0xcb, 0x88, 0x4b,
+	0x9d, 0x69, 0x95, 0xe8, 0xb3, 0xfd, 0xbb, 0x01, 0x66, 0x96, 0xa4, 0x58, 0x46, 0x0b, 0xc1, 0x50,
+	0x0f, 0x4c, 0x5d, 0x48, 0xc1, 0xf8, 0x1b, 0xc6, 0xbd, 0x5c, 0xa0, 0x1d, 0xe5, 0x3f, 0xd3, 0x6e,
+	0x57, 0x85, 0xdc, 0x83, 0xaa, 0xce, 0x31, 0x55, 0x4f, 0x1b, 0xc8, 0x82, 0x1a, 0x0d, 0x02, 0xce,
+	0x84, 0x48, 0xd2, 0x4d, 0x4d, 0xf4, 0x21, 0x54, 0xd5, 0xb3, 0x22, 0x29, 0xaf, 0x99, 0xcf, 0x47,
+	0xf1, 0x26, 0xf1, 0xb5, 0x6a, 0xa5, 0x45, 0x14, 0xb0, 0xf8, 0xe9, 0x6a, 0x2c, 0x99, 0x72, 0xa8,
+	0x47, 0xed, 0x09, 0xec, 0xa5, 0x94, 0x8f, 0x54, 0xd2, 0x69, 0x71, 0x3e, 0x85, 0x3a, 0x8f, 0x8f,
+	0xc2, 0x32, 0x74, 0xfc, 0xfb, 0xf9, 0xf8, 0xd7, 0x6a, 0x49, 0xd6, 0x60, 0xfb, 0x17, 0x03, 0x76,
+	0xb2, 0x5b, 0x71, 0x15, 0x4a, 0xf4, 0x05, 0xe4, 0xc6, 0x40, 0x27, 0xdf, 0x3c, 0x7c, 0x50, 0x1c,
+	0x2d, 0x16, 0x8d, 0xe4, 0xf0, 0xe8, 0x09, 0x54, 0x19, 0xe7, 0x11, 0xd7, 0xb2, 0x14, 0xd3, 0x98,
+	0x47, 0x0b, 0xac, 0x20, 0x24, 0x46, 0xda, 0x27, 0xb0, 0x7f, 0x2d, 0xa9, 0xa4, 0x18, 0x4f, 0xa1,
+	0xc6, 0x35, 0xa7, 0x34, 0xa9, 0xce, 0x0d, 0x34, 0xae, 0x42, 0x49, 0x52, 0xa8, 0x2d, 0xa0, 0xed,
+	0x30, 0xfa, 0x5e, 0xdd, 0x5b, 0x54, 0xf5, 0x52, 0x61, 0xd5, 0xef, 0xc2, 0x16, 0x67, 0x54, 0x44,
+	0x8b, 0xa4, 0xbc, 0x89, 0x65, 0xef, 0x01, 0xca, 0x3f, 0x1a, 0x27, 0x60, 0x3f, 0x87, 0xbd, 0xf1,
+	0x5c, 0xc8, 0x94, 0x69, 0xf0, 0xcf, 0xd8, 0x14, 0x76, 0x96, 0xfd, 0x6b, 0x09, 0xee, 0xac, 0x03,
+	0x3d, 0x5b, 0xb3, 0x2a, 0x6c, 0xf7, 0xff, 0xaa, 0x37, 0xdf, 0x77, 0x26, 0xd1, 0x01, 0x6c, 0xd3,
+	0x94, 0xb3, 0x47, 0xa5, 0xb5, 0xd5, 0x35, 0x7a, 0x65, 0xd2, 0x5c, 0xfb, 0x06, 0x9a, 0xd5, 0x32,
+	0xa4, 0x2b, 0xc6, 0x85, 0x55, 0xd3, 0xd3, 0x99, 0x9a, 0xe8, 0x11, 0x34, 0xd9, 0xe5, 0x52, 0xae,
+	0x3c, 0x31, 0x5f, 0xf8, 0xcc, 0xaa, 0xeb, 0x6f, 0x41, 0xbb, 0xce, 0x94, 0xc7, 0xfe, 0x1e, 0xf6,
+	0xaf, 0xc9, 0x9b, 0x34, 0xce, 0x11, 0x6c, 0xe7, 0xea, 0x99, 0x76, 0xcf, 0xa3, 0xa2, 0xee, 0xc9,
+	0x49, 0x49, 0x9a, 0x59, 0xb1, 0x85, 0xaa, 0xdd, 0x71, 0xc8, 0x98, 0x1c, 0xd2, 0x25, 0xf5, 0xe7,
+	0x72, 0xf5, 0x6f, 0x6a, 0xf7, 0xa7, 0x01, 0xad, 0x8d, 0x60, 0x85, 0x55, 0xeb, 0xa8, 0x21, 0x5e,
+	0x86, 0x73, 0x9f, 0x8a, 0x64, 0x51, 0xad, 0x6d, 0xf4, 0x01, 0xec, 0x70, 0x46, 0x83, 0x95, 0xb7,
+	0x46, 0x94, 0x35, 0xa2, 0xa5, 0xbd, 0x24, 0x85, 0x7d, 0x04, 0x28, 0xd3, 0x7b, 0x0d, 0xad, 0x68,
+	0x68, 0x9b, 0x66, 0x3a, 0x25, 0xf0, 0xc7, 0xd0, 0xe6, 0x4c, 0x8b, 0x94, 0x43, 0x57, 0x35, 0xda,
+	0x4c, 0x2f, 0x52, 0xb0, 0xfd, 0x1c, 0xf6, 0xaf, 0x09, 0x92, 0xa8, 0xfd, 0x64, 0xbd, 0xfb, 0x63,
+	0x9d, 0x37, 0xda, 0x62, 0xf3, 0x93, 0x04, 0x68, 0xbf, 0x2d, 0xc1, 0xee, 0xb5, 0x6d, 0x80, 0x9e,
+	0x42, 0xc5, 0x8f, 0x82, 0x58, 0x92, 0x9d, 0xc3, 0xee, 0x2d, 0x8b, 0xa3, 0x3f, 0x8c, 0x02, 0x46,
+	0x34, 0x3a, 0x37, 0x90, 0xa5, 0xfc, 0x40, 0xaa, 0x32, 0x71, 0x26, 0xf9, 0x8a, 0x5e, 0x84, 0x4c,
+	0x6b, 0x55, 0x27, 0x99, 0xc3, 0x7e, 0x6b, 0x40, 0x45, 0x05, 0x41, 0x4d, 0xa8, 0xcd, 0xdc, 0x17,
+	0xee, 0xe4, 0xdc, 0x35, 0xff, 0x87, 0xee, 0xc0, 0xee, 0xc8, 0xfd, 0x66, 0x30, 0x1e, 0x39, 0x1e,
+	0xc1, 0x5f, 0xcf, 0xf0, 0xd9, 0xd4, 0x34, 0xd0, 0x3e, 0xb4, 0x8f, 0xc7, 0x18, 0x4f, 0x3d, 0x77,
+	0x32, 0xf5, 0x06, 0xe3, 0xf1, 0xe4, 0x1c, 0x3b, 0x66, 0x09, 0x99, 0xb0, 0x3d, 0x73, 0x07, 0xb3,
+	0xe9, 0x57, 0x13, 0x32, 0x7a, 0x89, 0x1d, 0xb3, 0xac, 0x80, 0xee, 0xc4, 0x23, 0x78, 0xe0, 0x7c,
+	0xe7, 0x11, 0x7c, 0x3a, 0x1e, 0x0d, 0x07, 0x67, 0x66, 0x05, 0xed, 0x42, 0x73, 0x70, 0x3a, 0xf2,
+	0x8e, 0x07, 0xa3, 0xf1, 0x8c, 0x60, 0xb3, 0x8a, 0x5a, 0xd0, 0x50, 0xa1, 0x8e, 0x27, 0x33, 0xd7,
+	0x31, 0xb7, 0xd0, 0x0e, 0xc0, 0x70, 0xe2, 0x4e, 0xb1, 0x3b, 0x1d, 0x4d, 0x5c, 0xb3, 0x86, 0xb6,
+	0xa1, 0x3e, 0x1c, 0xb8, 0x43, 0x3c, 0xc6, 0x8e, 0x59, 0x57, 0x41, 0x1d, 0x3c, 0x70, 0xc6, 0x23,
+	0x17, 0x7b, 0xf8, 0xdb, 0x21, 0xc6, 0x0e, 0x76, 0xcc, 0xc6, 0xe1, 0x6f, 0x65, 0x68, 0x67, 0xa2,
+	0xa8, 0x96, 0x9d, 0xfb, 0x0c, 0x61, 0xa8, 0x27, 0x4e, 0x86, 0x6e, 0xdb, 0xff, 0x9d, 0x5b, 0xd7,
+	0x39, 0x9a, 0x42, 0x6b, 0x63, 0x1f, 0xa3, 0xa2, 0x5a, 0x6c, 0xfc, 0xfe, 0x74, 0x0e, 0x6e, 0x41,
+	0x24, 0x51, 0x5f, 0x00, 0x64, 0x1b, 0x12, 0x3d, 0xcc, 0x7f, 0xf0, 0xce, 0xba, 0xee, 0xfc, 0xff,
+	0xa6, 0xeb, 0x8c, 0xe2, 0xc6, 0xe4, 0x6f, 0x52, 0x2c, 0xda, 0xb9, 0x9d, 0x83, 0x5b, 0x10, 0x49,
+	0xd4, 0x73, 0x30, 0x9f, 0x31, 0xb9, 0x39, 0xa8, 0xdd, 0x9b, 0x9b, 0xb9, 0x28, 0x70, 0xe1, 0x84,
+	0x1c, 0xb5, 0x5e, 0x36, 0xd7, 0x7f, 0x35, 0x97, 0x17, 0x17, 0x5b, 0xfa, 0xdf, 0xe6, 0x27, 0x7f,
+	0x0f, 0x00, 0x90, 0xc4, 0x95, 0x00, 0x80, 0x0a, 0x00, 0x00,
+}
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ context.Context
+var _ grpc.ClientConn
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+const _ = grpc.SupportPackageIsVersion4
+
+// AllocationServiceClient is the client API for AllocationService service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://godoc.org/google.golang.org/grpc#ClientConn.NewStream.
+type AllocationServiceClient interface {
+	// Allocate moves a Ready GameServer of the first requested fleet that has one to Allocated.
+	Allocate(ctx context.Context, in *AllocateRequest, opts ...grpc.CallOption) (*AllocateResponse, error)
+	// AllocateBatch allocates a GameServer for each request, e.g. every match of a director tick.
+	// Requests are handled independently: each result carries either an allocation or an error.
+	AllocateBatch(ctx context.Context, in *AllocateBatchRequest, opts ...grpc.CallOption) (*AllocateBatchResponse, error)
+	// Deallocate shuts down an Allocated GameServer so that its fleet replaces it with a Ready one.
+	Deallocate(ctx context.Context, in *DeallocateRequest, opts ...grpc.CallOption) (*DeallocateResponse, error)
+	// ListAllocated lists the Allocated GameServers of the allowed fleets.
+	ListAllocated(ctx context.Context, in *ListAllocatedRequest, opts ...grpc.CallOption) (*ListAllocatedResponse, error)
+	// GetFleetCapacity reports the replica counts of the allowed fleets. It is read-only and
+	// informational: allocation never depends on it, since fleet status is eventually consistent.
+	GetFleetCapacity(ctx context.Context, in *FleetCapacityRequest, opts ...grpc.CallOption) (*FleetCapacityResponse, error)
+}
+
+type allocationServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+func NewAllocationServiceClient(cc *grpc.ClientConn) AllocationServiceClient {
+	return &allocationServiceClient{cc}
+}
+
+func (c *allocationServiceClient) Allocate(ctx context.Context, in *AllocateRequest, opts ...grpc.CallOption) (*AllocateResponse, error) {
+	out := new(AllocateResponse)
+	err := c.cc.Invoke(ctx, "/allocation.AllocationService/Allocate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *allocationServiceClient) AllocateBatch(ctx context.Context, in *AllocateBatchRequest, opts ...grpc.CallOption) (*AllocateBatchResponse, error) {
+	out := new(AllocateBatchResponse)
+	err := c.cc.Invoke(ctx, "/allocation.AllocationService/AllocateBatch", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *allocationServiceClient) Deallocate(ctx context.Context, in *DeallocateRequest, opts ...grpc.CallOption) (*DeallocateResponse, error) {
+	out := new(DeallocateResponse)
+	err := c.cc.Invoke(ctx, "/allocation.AllocationService/Deallocate", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *allocationServiceClient) ListAllocated(ctx context.Context, in *ListAllocatedRequest, opts ...grpc.CallOption) (*ListAllocatedResponse, error) {
+	out := new(ListAllocatedResponse)
+	err := c.cc.Invoke(ctx, "/allocation.AllocationService/ListAllocated", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *allocationServiceClient) GetFleetCapacity(ctx context.Context, in *FleetCapacityRequest, opts ...grpc.CallOption) (*FleetCapacityResponse, error) {
+	out := new(FleetCapacityResponse)
+	err := c.cc.Invoke(ctx, "/allocation.AllocationService/GetFleetCapacity", in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+// AllocationServiceServer is the server API for AllocationService service.
+type AllocationServiceServer interface {
+	// Allocate moves a Ready GameServer of the first requested fleet that has one to Allocated.
+	Allocate(context.Context, *AllocateRequest) (*AllocateResponse, error)
+	// AllocateBatch allocates a GameServer for each request, e.g. every match of a director tick.
+	// Requests are handled independently: each result carries either an allocation or an error.
+	AllocateBatch(context.Context, *AllocateBatchRequest) (*AllocateBatchResponse, error)
+	// Deallocate shuts down an Allocated GameServer so that its fleet replaces it with a Ready one.
+	Deallocate(context.Context, *DeallocateRequest) (*DeallocateResponse, error)
+	// ListAllocated lists the Allocated GameServers of the allowed fleets.
+	ListAllocated(context.Context, *ListAllocatedRequest) (*ListAllocatedResponse, error)
+	// GetFleetCapacity reports the replica counts of the allowed fleets. It is read-only and
+	// informational: allocation never depends on it, since fleet status is eventually consistent.
+	GetFleetCapacity(context.Context, *FleetCapacityRequest) (*FleetCapacityResponse, error)
+}
+
+// UnimplementedAllocationServiceServer can be embedded to have forward compatible implementations.
+type UnimplementedAllocationServiceServer struct {
+}
+
+func (*UnimplementedAllocationServiceServer) Allocate(ctx context.Context, req *AllocateRequest) (*AllocateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Allocate not implemented")
+}
+func (*UnimplementedAllocationServiceServer) AllocateBatch(ctx context.Context, req *AllocateBatchRequest) (*AllocateBatchResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method AllocateBatch not implemented")
+}
+func (*UnimplementedAllocationServiceServer) Deallocate(ctx context.Context, req *DeallocateRequest) (*DeallocateResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Deallocate not implemented")
+}
+func (*UnimplementedAllocationServiceServer) ListAllocated(ctx context.Context, req *ListAllocatedRequest) (*ListAllocatedResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method ListAllocated not implemented")
+}
+func (*UnimplementedAllocationServiceServer) GetFleetCapacity(ctx context.Context, req *FleetCapacityRequest) (*FleetCapacityResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetFleetCapacity not implemented")
+}
+
+func RegisterAllocationServiceServer(s *grpc.Server, srv AllocationServiceServer) {
+	s.RegisterService(&_AllocationService_serviceDesc, srv)
+}
+
+func _AllocationService_Allocate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AllocateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AllocationServiceServer).Allocate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/allocation.AllocationService/Allocate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AllocationServiceServer).Allocate(ctx, req.(*AllocateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AllocationService_AllocateBatch_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(AllocateBatchRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AllocationServiceServer).AllocateBatch(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/allocation.AllocationService/AllocateBatch",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AllocationServiceServer).AllocateBatch(ctx, req.(*AllocateBatchRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AllocationService_Deallocate_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(DeallocateRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AllocationServiceServer).Deallocate(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/allocation.AllocationService/Deallocate",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AllocationServiceServer).Deallocate(ctx, req.(*DeallocateRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AllocationService_ListAllocated_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ListAllocatedRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AllocationServiceServer).ListAllocated(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/allocation.AllocationService/ListAllocated",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AllocationServiceServer).ListAllocated(ctx, req.(*ListAllocatedRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _AllocationService_GetFleetCapacity_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(FleetCapacityRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(AllocationServiceServer).GetFleetCapacity(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/allocation.AllocationService/GetFleetCapacity",
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(AllocationServiceServer).GetFleetCapacity(ctx, req.(*FleetCapacityRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+var _AllocationService_serviceDesc = grpc.ServiceDesc{
+	ServiceName: "allocation.AllocationService",
+	HandlerType: (*AllocationServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "Allocate",
+			Handler:    _AllocationService_Allocate_Handler,
+		},
+		{
+			MethodName: "AllocateBatch",
+			Handler:    _AllocationService_AllocateBatch_Handler,
+		},
+		{
+			MethodName: "Deallocate",
+			Handler:    _AllocationService_Deallocate_Handler,
+		},
+		{
+			MethodName: "ListAllocated",
+			Handler:    _AllocationService_ListAllocated_Handler,
+		},
+		{
+			MethodName: "GetFleetCapacity",
+			Handler:    _AllocationService_GetFleetCapacity_Handler,
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "allocator.proto",
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/subtle"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Where the credentials of the clients allowed to call /address are read from, unless
+// overridden by -credentials-dir. Each file in the directory is one client: the file
+// name is the key and its content the secret, which is how a mounted Kubernetes Secret looks.
+const defaultCredentialsDir = "/etc/allocator/clients"
+
+// How often the credentials are re-read by default, so rotated Secrets are picked up without a restart
+const defaultCredentialsReloadInterval = 30 * time.Second
+
+// The credentials of the clients allowed to call /address, keyed by client key.
+// Several keys are valid at once so that clients can switch to a new key before the old one is removed.
+type clientCredentials struct {
+	mu   sync.RWMutex
+	keys map[string]string
+}
+
+var credentials = &clientCredentials{}
+
+// Load the credentials and keep reloading them in the background
+func watchCredentials() {
+	credentials.reload()
+	go func() {
+		for range time.Tick(conf.CredentialsReloadInterval) {
+			credentials.reload()
+		}
+	}()
+}
+
+// Re-read the credentials from the Secret directory and CLIENT_CREDENTIALS
+func (c *clientCredentials) reload() {
+	keys := map[string]string{}
+
+	dir := conf.CredentialsDir
+	if err := readCredentialsDir(dir, keys); err != nil && !os.IsNotExist(err) {
+		logger.WithError(err).WithField("dir", dir).Error("Could not read client credentials")
+		// Keep the credentials we already have rather than locking every client out
+		return
+	}
+
+	// CLIENT_CREDENTIALS is a comma separated list of "key:secret"
+	for _, entry := range strings.Split(os.Getenv("CLIENT_CREDENTIALS"), ",") {
+		kv := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			continue
+		}
+		keys[kv[0]] = kv[1]
+	}
+
+	if len(keys) == 0 {
+		logger.Warn("No client credentials configured, every request to /address will be rejected")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(keys) != len(c.keys) {
+		logger.WithField("clients", len(keys)).Info("Loaded client credentials")
+	}
+	c.keys = keys
+}
+
+// Read one client per file from a mounted Secret directory
+func readCredentialsDir(dir string, keys map[string]string) error {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range files {
+		// Skip the ..data and timestamped directories Kubernetes uses for atomic updates
+		if strings.HasPrefix(f.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, f.Name())
+		// The key files are symlinks into ..data, so Stat follows them
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+		secret, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		if s := strings.TrimSpace(string(secret)); s != "" {
+			keys[f.Name()] = s
+		}
+	}
+	return nil
+}
+
+// Check a key and secret against every configured client in constant time
+func (c *clientCredentials) valid(key, secret string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ok := 0
+	for k, s := range c.keys {
+		keyMatch := subtle.ConstantTimeCompare([]byte(key), []byte(k))
+		secretMatch := subtle.ConstantTimeCompare([]byte(secret), []byte(s))
+		ok |= keyMatch & secretMatch
+	}
+	return ok == 1
+}
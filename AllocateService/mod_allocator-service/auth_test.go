@@ -0,0 +1,120 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write one client of the Secret directory
+func writeClient(t *testing.T, dir, key, secret string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, key), []byte(secret+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCredentialsReload(t *testing.T) {
+	dir := t.TempDir()
+	conf.CredentialsDir = dir
+	t.Setenv("CLIENT_CREDENTIALS", "")
+	c := &clientCredentials{}
+
+	writeClient(t, dir, "client-a", "secret-a")
+	// Kubernetes keeps the Secret data in dot-directories next to the key files
+	if err := os.Mkdir(filepath.Join(dir, "..data"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	writeClient(t, dir, ".hidden", "secret-hidden")
+	c.reload()
+	if !c.valid("client-a", "secret-a") {
+		t.Error("client-a is not valid after the first reload")
+	}
+	if c.valid(".hidden", "secret-hidden") {
+		t.Error("dot-file is valid")
+	}
+
+	// A new client is valid once the directory is re-read, while the old one still is
+	writeClient(t, dir, "client-b", "secret-b")
+	if c.valid("client-b", "secret-b") {
+		t.Error("client-b is valid before the reload")
+	}
+	c.reload()
+	if !c.valid("client-a", "secret-a") || !c.valid("client-b", "secret-b") {
+		t.Error("client-a and client-b are not both valid after adding client-b")
+	}
+
+	// A removed client is rejected after the next reload
+	if err := os.Remove(filepath.Join(dir, "client-a")); err != nil {
+		t.Fatal(err)
+	}
+	c.reload()
+	if c.valid("client-a", "secret-a") {
+		t.Error("client-a is still valid after it was removed")
+	}
+	if !c.valid("client-b", "secret-b") {
+		t.Error("client-b is not valid after removing client-a")
+	}
+}
+
+func TestCredentialsReloadKeepsKeysOnError(t *testing.T) {
+	dir := t.TempDir()
+	conf.CredentialsDir = dir
+	t.Setenv("CLIENT_CREDENTIALS", "")
+	c := &clientCredentials{}
+	writeClient(t, dir, "client-a", "secret-a")
+	c.reload()
+
+	// A path that exists but cannot be read as a directory
+	conf.CredentialsDir = filepath.Join(dir, "client-a")
+	c.reload()
+	if !c.valid("client-a", "secret-a") {
+		t.Error("client-a is not valid after a failed reload")
+	}
+}
+
+func TestCredentialsReloadEnv(t *testing.T) {
+	conf.CredentialsDir = filepath.Join(t.TempDir(), "missing")
+	t.Setenv("CLIENT_CREDENTIALS", "client-a:secret-a, client-b:secret:b,invalid,:no-key")
+	c := &clientCredentials{}
+	c.reload()
+	if !c.valid("client-a", "secret-a") {
+		t.Error("client-a is not valid")
+	}
+	// Only the first colon separates the key from the secret
+	if !c.valid("client-b", "secret:b") {
+		t.Error("client-b is not valid")
+	}
+	if len(c.keys) != 2 {
+		t.Errorf("got %v clients, want 2", len(c.keys))
+	}
+}
+
+func TestCredentialsValid(t *testing.T) {
+	c := &clientCredentials{keys: map[string]string{"client-a": "secret-a", "client-b": "secret-b"}}
+	tests := []struct {
+		name   string
+		key    string
+		secret string
+		want   bool
+	}{
+		{name: "valid", key: "client-a", secret: "secret-a", want: true},
+		{name: "second client", key: "client-b", secret: "secret-b", want: true},
+		{name: "wrong secret", key: "client-a", secret: "secret-b", want: false},
+		{name: "secret prefix", key: "client-a", secret: "secret-", want: false},
+		{name: "unknown key", key: "client-c", secret: "secret-a", want: false},
+		{name: "empty", key: "", secret: "", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := c.valid(tt.key, tt.secret); got != tt.want {
+				t.Errorf("valid(%q, %q) = %v, want %v", tt.key, tt.secret, got, tt.want)
+			}
+		})
+	}
+
+	if (&clientCredentials{}).valid("", "") {
+		t.Error("empty credentials accept an empty key")
+	}
+}
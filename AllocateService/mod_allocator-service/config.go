@@ -0,0 +1,72 @@
+package main
+
+import (
+	"time"
+
+	"common/config"
+)
+
+// The settings of the allocator service
+type allocatorConfig struct {
+	// Port of the HTTP(S) server serving /address, /capacity and /metrics
+	Port int
+	// Port of the gRPC AllocationService
+	GRPCPort int
+	// TLS certificate and key, both servers use TLS when they are set
+	CertFile string
+	KeyFile  string
+	// CA certificate verifying client certificates, requires CertFile and KeyFile
+	ClientCAFile string
+	// Directory of the mounted Secret with one file per client: the file name is the client key and its content the secret
+	CredentialsDir string
+	// How often the client credentials are re-read
+	CredentialsReloadInterval time.Duration
+	// Comma separated list of "namespace/fleet" or "fleet" in the default namespace
+	AllowedFleets string
+	// Kubeconfig file used instead of the in-cluster config, e.g. to run the service on a workstation
+	KubeConfig string
+}
+
+// The settings, loaded at the start of main
+var conf allocatorConfig
+
+// Load the settings from the defaults, a config file, flags and environment variables, in that order, and validate them
+func loadConfig(args []string) (allocatorConfig, error) {
+	var c allocatorConfig
+	l := config.NewLoader()
+	l.IntVar(&c.Port, "port", "PORT", 80, "The port to listen on")
+	l.IntVar(&c.GRPCPort, "grpc-port", "GRPC_PORT", 50551, "The port the gRPC AllocationService listens on")
+	l.StringVar(&c.CertFile, "cert", "TLS_CERT", "", "TLS certificate file, serves HTTPS when set together with -key")
+	l.StringVar(&c.KeyFile, "key", "TLS_KEY", "", "TLS private key file")
+	l.StringVar(&c.ClientCAFile, "client-ca", "CLIENT_CA", "", "CA certificate used to verify client certificates, requires one on /address when set")
+	l.StringVar(&c.CredentialsDir, "credentials-dir", "CLIENT_CREDENTIALS_DIR", defaultCredentialsDir, "Directory of the mounted client credentials")
+	l.DurationVar(&c.CredentialsReloadInterval, "credentials-reload-interval", "CREDENTIALS_RELOAD_INTERVAL", defaultCredentialsReloadInterval, "How often the client credentials are re-read")
+	l.StringVar(&c.AllowedFleets, "allowed-fleets", "ALLOWED_FLEETS", defaultNamespace+"/"+defaultFleetname, "Comma separated fleets clients may allocate from, as namespace/fleet or fleet")
+	l.StringVar(&c.KubeConfig, "kubeconfig", "KUBECONFIG", "", "Kubeconfig file to use instead of the in-cluster config")
+	if err := l.Load(args); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+// Report every invalid setting at once
+func (c allocatorConfig) validate() error {
+	var errs config.Errors
+	errs.Port("port", c.Port)
+	errs.Port("grpc-port", c.GRPCPort)
+	if c.Port == c.GRPCPort {
+		errs.Add("port and grpc-port must differ, got %v", c.Port)
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		errs.Add("cert and key must be set together")
+	}
+	if c.ClientCAFile != "" && c.CertFile == "" {
+		errs.Add("client-ca requires cert and key")
+	}
+	errs.NonEmpty("credentials-dir", c.CredentialsDir)
+	errs.Positive("credentials-reload-interval", c.CredentialsReloadInterval)
+	if len(parseAllowedFleets(c.AllowedFleets)) == 0 {
+		errs.Add("allowed-fleets must name at least one fleet")
+	}
+	return errs.Err()
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"agones.dev/agones/examples/allocator-service/allocatorpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// Codes of the errors returned from /address
+const (
+	// The request parameters are invalid
+	codeInvalidRequest = "InvalidRequest"
+	// The requested fleet is not in ALLOWED_FLEETS
+	codeFleetNotAllowed = "FleetNotAllowed"
+	// The client did not authenticate
+	codeUnauthorized = "Unauthorized"
+	// Only GET is supported
+	codeMethodNotAllowed = "MethodNotAllowed"
+	// None of the requested fleets has a Ready GameServer matching the selectors
+	codeNoReadyReplicas = "NoReadyReplicas"
+	// Other allocations kept competing for the same GameServers
+	codeContention = "Contention"
+	// A call to the Kubernetes API failed
+	codeAPIFailure = "APIFailure"
+	// The GameServer to deallocate does not exist or is not allocated
+	codeNotFound = "NotFound"
+	// The client canceled the request or its deadline passed before it was done
+	codeCanceled = "Canceled"
+)
+
+// The structure of the json error response
+type errorResult struct {
+	Error *apiError `json:"error"`
+}
+
+// An error returned to the client with an HTTP status, a code from the list above,
+// a human readable reason and whether trying again later may succeed.
+// Over gRPC the same error is sent as a status carrying an AllocationError detail.
+type apiError struct {
+	status    int
+	grpcCode  codes.Code
+	pbCode    allocatorpb.AllocationError_Code
+	Code      string `json:"code"`
+	Reason    string `json:"reason"`
+	Retryable bool   `json:"retryable"`
+}
+
+func (e *apiError) Error() string {
+	return e.Code + ": " + e.Reason
+}
+
+// The AllocationError sent over gRPC
+func (e *apiError) proto() *allocatorpb.AllocationError {
+	return &allocatorpb.AllocationError{
+		Code:      e.pbCode,
+		Reason:    e.Reason,
+		Retryable: e.Retryable,
+	}
+}
+
+// Let grpc-go send the error as a status with the AllocationError detail
+func (e *apiError) GRPCStatus() *status.Status {
+	st := status.New(e.grpcCode, e.Reason)
+	detailed, err := st.WithDetails(e.proto())
+	if err != nil {
+		return st
+	}
+	return detailed
+}
+
+func invalidRequest(reason string) *apiError {
+	return &apiError{status: http.StatusBadRequest, grpcCode: codes.InvalidArgument, pbCode: allocatorpb.AllocationError_INVALID_REQUEST,
+		Code: codeInvalidRequest, Reason: reason}
+}
+
+func fleetNotAllowed(reason string) *apiError {
+	return &apiError{status: http.StatusForbidden, grpcCode: codes.PermissionDenied, pbCode: allocatorpb.AllocationError_FLEET_NOT_ALLOWED,
+		Code: codeFleetNotAllowed, Reason: reason}
+}
+
+func unauthorized(reason string) *apiError {
+	return &apiError{status: http.StatusUnauthorized, grpcCode: codes.Unauthenticated, pbCode: allocatorpb.AllocationError_UNAUTHORIZED,
+		Code: codeUnauthorized, Reason: reason}
+}
+
+func methodNotAllowed(reason string) *apiError {
+	return &apiError{status: http.StatusMethodNotAllowed, grpcCode: codes.Unimplemented, pbCode: allocatorpb.AllocationError_INVALID_REQUEST,
+		Code: codeMethodNotAllowed, Reason: reason}
+}
+
+func noReadyReplicas(reason string) *apiError {
+	return &apiError{status: http.StatusServiceUnavailable, grpcCode: codes.ResourceExhausted, pbCode: allocatorpb.AllocationError_NO_READY_REPLICAS,
+		Code: codeNoReadyReplicas, Reason: reason, Retryable: true}
+}
+
+func contention(reason string) *apiError {
+	return &apiError{status: http.StatusConflict, grpcCode: codes.Aborted, pbCode: allocatorpb.AllocationError_CONTENTION,
+		Code: codeContention, Reason: reason, Retryable: true}
+}
+
+func apiFailure(reason string) *apiError {
+	return &apiError{status: http.StatusBadGateway, grpcCode: codes.Unavailable, pbCode: allocatorpb.AllocationError_API_FAILURE,
+		Code: codeAPIFailure, Reason: reason, Retryable: true}
+}
+
+func notFound(reason string) *apiError {
+	return &apiError{status: http.StatusNotFound, grpcCode: codes.NotFound, pbCode: allocatorpb.AllocationError_NOT_FOUND,
+		Code: codeNotFound, Reason: reason}
+}
+
+func canceled(err error) *apiError {
+	grpcCode, pbCode := codes.Canceled, allocatorpb.AllocationError_CANCELED
+	if err == context.DeadlineExceeded {
+		grpcCode, pbCode = codes.DeadlineExceeded, allocatorpb.AllocationError_DEADLINE_EXCEEDED
+	}
+	return &apiError{status: http.StatusGatewayTimeout, grpcCode: grpcCode, pbCode: pbCode,
+		Code: codeCanceled, Reason: err.Error(), Retryable: true}
+}
+
+// Write an error as json with its HTTP status
+func writeError(w http.ResponseWriter, err *apiError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(err.status)
+	if encErr := json.NewEncoder(w).Encode(&errorResult{err}); encErr != nil {
+		logger.WithError(encErr).Error("Error writing json error response")
+	}
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"agones.dev/agones/examples/allocator-service/allocatorpb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestErrorStatus(t *testing.T) {
+	tests := []struct {
+		name          string
+		err           *apiError
+		wantHTTP      int
+		wantGRPC      codes.Code
+		wantCode      allocatorpb.AllocationError_Code
+		wantRetryable bool
+	}{
+		{name: "invalid request", err: invalidRequest("r"), wantHTTP: http.StatusBadRequest, wantGRPC: codes.InvalidArgument, wantCode: allocatorpb.AllocationError_INVALID_REQUEST},
+		{name: "fleet not allowed", err: fleetNotAllowed("r"), wantHTTP: http.StatusForbidden, wantGRPC: codes.PermissionDenied, wantCode: allocatorpb.AllocationError_FLEET_NOT_ALLOWED},
+		{name: "unauthorized", err: unauthorized("r"), wantHTTP: http.StatusUnauthorized, wantGRPC: codes.Unauthenticated, wantCode: allocatorpb.AllocationError_UNAUTHORIZED},
+		{name: "method not allowed", err: methodNotAllowed("r"), wantHTTP: http.StatusMethodNotAllowed, wantGRPC: codes.Unimplemented, wantCode: allocatorpb.AllocationError_INVALID_REQUEST},
+		{name: "no ready replicas", err: noReadyReplicas("r"), wantHTTP: http.StatusServiceUnavailable, wantGRPC: codes.ResourceExhausted, wantCode: allocatorpb.AllocationError_NO_READY_REPLICAS, wantRetryable: true},
+		{name: "contention", err: contention("r"), wantHTTP: http.StatusConflict, wantGRPC: codes.Aborted, wantCode: allocatorpb.AllocationError_CONTENTION, wantRetryable: true},
+		{name: "api failure", err: apiFailure("r"), wantHTTP: http.StatusBadGateway, wantGRPC: codes.Unavailable, wantCode: allocatorpb.AllocationError_API_FAILURE, wantRetryable: true},
+		{name: "not found", err: notFound("r"), wantHTTP: http.StatusNotFound, wantGRPC: codes.NotFound, wantCode: allocatorpb.AllocationError_NOT_FOUND},
+		{name: "canceled", err: canceled(context.Canceled), wantHTTP: http.StatusGatewayTimeout, wantGRPC: codes.Canceled, wantCode: allocatorpb.AllocationError_CANCELED, wantRetryable: true},
+		{name: "deadline exceeded", err: canceled(context.DeadlineExceeded), wantHTTP: http.StatusGatewayTimeout, wantGRPC: codes.DeadlineExceeded, wantCode: allocatorpb.AllocationError_DEADLINE_EXCEEDED, wantRetryable: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Over gRPC the status carries the AllocationError as a detail
+			st, ok := status.FromError(tt.err)
+			if !ok {
+				t.Fatalf("status.FromError(%v) is not a status", tt.err)
+			}
+			if st.Code() != tt.wantGRPC {
+				t.Errorf("grpc code = %v, want %v", st.Code(), tt.wantGRPC)
+			}
+			var detail *allocatorpb.AllocationError
+			for _, d := range st.Details() {
+				if e, ok := d.(*allocatorpb.AllocationError); ok {
+					detail = e
+				}
+			}
+			if detail == nil {
+				t.Fatalf("status %v has no AllocationError detail", st)
+			}
+			if detail.GetCode() != tt.wantCode || detail.GetRetryable() != tt.wantRetryable || detail.GetReason() != tt.err.Reason {
+				t.Errorf("detail = %v, want code %v, retryable %v", detail, tt.wantCode, tt.wantRetryable)
+			}
+
+			// Over HTTP the error is json with the HTTP status
+			w := httptest.NewRecorder()
+			writeError(w, tt.err)
+			if w.Code != tt.wantHTTP {
+				t.Errorf("http status = %v, want %v", w.Code, tt.wantHTTP)
+			}
+			var res struct {
+				Error struct {
+					Code      string `json:"code"`
+					Retryable bool   `json:"retryable"`
+				} `json:"error"`
+			}
+			if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+				t.Fatalf("invalid json error response: %v", err)
+			}
+			if res.Error.Code != tt.err.Code || res.Error.Retryable != tt.wantRetryable {
+				t.Errorf("json error = %+v, want code %v, retryable %v", res.Error, tt.err.Code, tt.wantRetryable)
+			}
+		})
+	}
+}
+
+func TestAllocateSeverityAcrossFleets(t *testing.T) {
+	apiErr := errors.New("connection refused")
+	tests := []struct {
+		name      string
+		results   map[string][]fleetResult
+		wantFleet string
+		wantCode  allocatorpb.AllocationError_Code
+	}{
+		{
+			name:     "every fleet has no ready replicas",
+			results:  map[string][]fleetResult{"fleet-a": {unallocated}, "fleet-b": {unallocated}},
+			wantCode: allocatorpb.AllocationError_NO_READY_REPLICAS,
+		},
+		{
+			name:     "contention is more severe than no ready replicas",
+			results:  map[string][]fleetResult{"fleet-a": {contended}, "fleet-b": {unallocated}},
+			wantCode: allocatorpb.AllocationError_CONTENTION,
+		},
+		{
+			name:     "contention of the last fleet is kept",
+			results:  map[string][]fleetResult{"fleet-a": {unallocated}, "fleet-b": {contended}},
+			wantCode: allocatorpb.AllocationError_CONTENTION,
+		},
+		{
+			name:     "api failure is more severe than contention",
+			results:  map[string][]fleetResult{"fleet-a": {{err: apiErr}}, "fleet-b": {contended}},
+			wantCode: allocatorpb.AllocationError_API_FAILURE,
+		},
+		{
+			name:     "api failure of the last fleet is kept",
+			results:  map[string][]fleetResult{"fleet-a": {unallocated}, "fleet-b": {{err: apiErr}}},
+			wantCode: allocatorpb.AllocationError_API_FAILURE,
+		},
+		{
+			name:      "an allocated fleet hides the errors of the others",
+			results:   map[string][]fleetResult{"fleet-a": {{err: apiErr}}, "fleet-b": {allocated}},
+			wantFleet: "fleet-b",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := useFakeAgones(t, "fleet-a,fleet-b")
+			scriptAllocations(client, tt.results)
+
+			fleet, status, err := allocate(context.Background(), allocationRequest{namespace: defaultNamespace, fleets: []string{"fleet-a", "fleet-b"}})
+			if tt.wantFleet != "" {
+				if err != nil {
+					t.Fatalf("allocate() error = %v, want nil", err)
+				}
+				if fleet != tt.wantFleet || status.GameServerName == "" {
+					t.Errorf("allocate() = %v, %v, want a GameServer of %v", fleet, status.GameServerName, tt.wantFleet)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("allocate() allocated from %v, want an error", fleet)
+			}
+			if err.pbCode != tt.wantCode {
+				t.Errorf("allocate() error code = %v, want %v", err.pbCode, tt.wantCode)
+			}
+		})
+	}
+}
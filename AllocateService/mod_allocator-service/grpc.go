@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"encoding/base64"
+	"net"
+	"strings"
+
+	"agones.dev/agones/examples/allocator-service/allocatorpb"
+	"google.golang.org/grpc"
+	grpccredentials "google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+// Run the gRPC server of the AllocationService, with the same TLS settings as the HTTP server
+func serveGRPC(port, certFile, keyFile, clientCAFile string) {
+	var opts []grpc.ServerOption
+	if certFile != "" && keyFile != "" {
+		tlsConfig, err := serverTLSConfig(clientCAFile)
+		if err != nil {
+			logger.WithError(err).Fatal("Could not load the client CA")
+		}
+		cert, err := loadKeyPair(certFile, keyFile)
+		if err != nil {
+			logger.WithError(err).Fatal("Could not load the gRPC server certificate")
+		}
+		tlsConfig.Certificates = cert
+		opts = append(opts, grpc.Creds(grpccredentials.NewTLS(tlsConfig)))
+	}
+	opts = append(opts, grpc.UnaryInterceptor(authInterceptor(clientCAFile != "")))
+
+	lis, err := net.Listen("tcp", ":"+port)
+	if err != nil {
+		logger.WithError(err).Fatal("gRPC server failed to listen")
+	}
+	server := grpc.NewServer(opts...)
+	allocatorpb.RegisterAllocationServiceServer(server, service)
+
+	logger.WithField("port", port).WithField("tls", certFile != "").Info("gRPC server is running")
+	if err := server.Serve(lis); err != nil {
+		logger.WithError(err).Fatal("gRPC server failed to run")
+	}
+}
+
+// Let the gRPC server do basic authentication against the configured client credentials,
+// and reject peers without a verified client certificate when mTLS is required
+func authInterceptor(requireCert bool) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if requireCert && !hasVerifiedClientCert(ctx) {
+			return nil, unauthorized("client certificate required")
+		}
+		key, value, ok := basicAuthFromMetadata(ctx)
+		if !ok || !credentials.valid(key, value) {
+			return nil, unauthorized("authorization failed")
+		}
+		return handler(ctx, req)
+	}
+}
+
+// Read the "authorization: Basic ..." metadata sent by the client
+func basicAuthFromMetadata(ctx context.Context) (string, string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok || len(md.Get("authorization")) == 0 {
+		return "", "", false
+	}
+	auth := md.Get("authorization")[0]
+	const prefix = "Basic "
+	if !strings.HasPrefix(auth, prefix) {
+		return "", "", false
+	}
+	decoded, err := base64.StdEncoding.DecodeString(auth[len(prefix):])
+	if err != nil {
+		return "", "", false
+	}
+	pair := strings.SplitN(string(decoded), ":", 2)
+	if len(pair) != 2 {
+		return "", "", false
+	}
+	return pair[0], pair[1], true
+}
+
+func hasVerifiedClientCert(ctx context.Context) bool {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return false
+	}
+	tlsInfo, ok := p.AuthInfo.(grpccredentials.TLSInfo)
+	return ok && len(tlsInfo.State.VerifiedChains) > 0
+}
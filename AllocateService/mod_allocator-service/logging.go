@@ -0,0 +1,34 @@
+package main
+
+import (
+	"agones.dev/agones/examples/allocator-service/allocatorpb"
+	"agones.dev/agones/pkg/util/runtime"
+	"github.com/sirupsen/logrus"
+
+	"common/logging"
+)
+
+// Configure the logrus logger used by the Agones runtime to write JSON at the level in LOG_LEVEL
+// (debug, info, warn or error, defaults to info) and return the logger of this service
+func newLogger(source string) *logrus.Entry {
+	logrus.SetFormatter(&logrus.JSONFormatter{})
+	entry := runtime.NewLoggerWithSource(source)
+	logging.SetLevelFromEnv(logrus.StandardLogger(), entry)
+	return entry
+}
+
+// The fields identifying the match of a request, empty when the request is not for a match
+func matchFields(match *allocatorpb.MatchMetadata) logrus.Fields {
+	fields := logrus.Fields{}
+	if match.GetMatchId() != "" {
+		fields[logging.FieldMatchID] = match.GetMatchId()
+	}
+	// The director names its profiles after the game modes
+	if match.GetGameMode() != "" {
+		fields[logging.FieldProfile] = match.GetGameMode()
+	}
+	if len(match.GetTicketIds()) > 0 {
+		fields[logging.FieldTicketID] = match.GetTicketIds()
+	}
+	return fields
+}
@@ -2,26 +2,34 @@ package main
 
 import (
 	"encoding/json"
-	"errors"
+	"fmt"
 	"io"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
 
+	"agones.dev/agones/examples/allocator-service/allocatorpb"
 	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
 	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
 	"agones.dev/agones/pkg/client/clientset/versioned"
-	"agones.dev/agones/pkg/util/runtime" // for the logger
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
 )
 
-// Constants which define the fleet and namespace we are using
-const namespace = "default"
-const fleetname = "simple-udp"
+// Constants which define the fleet and namespace used when a request does not specify them
+const defaultNamespace = "default"
+const defaultFleetname = "simple-udp"
 
-// Variables for the logger and Agones Clientset
+// Variables for the logger, Agones Clientset, the fleets clients may allocate from
+// and the AllocationService shared by gRPC and /address
+// The Clientset is an interface so that the generated fake Clientset can stand in for it.
 var (
-	logger       = runtime.NewLoggerWithSource("main")
-	agonesClient = getAgonesClient()
+	logger        = newLogger("main")
+	agonesClient  versioned.Interface
+	allowedFleets map[string]bool
+	service       = &allocationService{}
 )
 
 // A handler for the web server
@@ -32,41 +40,117 @@ type result struct {
 	Status allocationv1.GameServerAllocationStatus `json:"status"`
 }
 
-// Main will set up an http server and three endpoints
+// The structure of the json response of /capacity
+type capacityResult struct {
+	Fleets []fleetCapacity `json:"fleets"`
+}
+
+type fleetCapacity struct {
+	Name              string `json:"name"`
+	Replicas          int32  `json:"replicas"`
+	ReadyReplicas     int32  `json:"readyReplicas"`
+	AllocatedReplicas int32  `json:"allocatedReplicas"`
+	ReservedReplicas  int32  `json:"reservedReplicas"`
+}
+
+// Main will set up a gRPC server, and an http server with five endpoints
 func main() {
+	var err error
+	if conf, err = loadConfig(os.Args[1:]); err != nil {
+		logger.WithError(err).Fatal("Could not load the config")
+	}
+	if agonesClient, err = getAgonesClient(conf.KubeConfig); err != nil {
+		logger.WithError(err).Fatal("Could not create the agones api clientset")
+	}
+	logger.Info("Created the agones api clientset")
+	allowedFleets = parseAllowedFleets(conf.AllowedFleets)
+	logger.WithField("allowedFleets", allowedFleets).Info("Loaded allowed fleets")
+	useTLS := conf.CertFile != ""
+
+	// Load the client credentials for /address and gRPC and keep them up to date
+	watchCredentials()
+
+	// Serve the AllocationService over gRPC
+	go serveGRPC(strconv.Itoa(conf.GRPCPort), conf.CertFile, conf.KeyFile, conf.ClientCAFile)
+
 	// Serve 200 status on / for k8s health checks
 	http.HandleFunc("/", handleRoot)
 
 	// Serve 200 status on /healthz for k8s health checks
 	http.HandleFunc("/healthz", handleHealthz)
 
+	// Serve the allocation metrics on /metrics for Prometheus
+	http.Handle("/metrics", promhttp.Handler())
+
 	// Return the GameServerStatus of the allocated replica to the authorized client
-	http.HandleFunc("/address", getOnly(basicAuth(handleAddress)))
+	address := getOnly(basicAuth(handleAddress))
+	if conf.ClientCAFile != "" {
+		address = requireClientCert(address)
+	}
+	http.HandleFunc("/address", address)
+
+	// Return the replica counts of the allowed fleets to the authorized client
+	capacity := getOnly(basicAuth(handleCapacity))
+	if conf.ClientCAFile != "" {
+		capacity = requireClientCert(capacity)
+	}
+	http.HandleFunc("/capacity", capacity)
+
+	server := &http.Server{Addr: ":" + strconv.Itoa(conf.Port)}
+	if !useTLS {
+		logger.WithField("port", conf.Port).Info("HTTP server is running")
+		if err := server.ListenAndServe(); err != nil {
+			logger.WithError(err).Fatal("HTTP server failed to run")
+		}
+		return
+	}
 
 	// Run the HTTP server using the bound certificate and key for TLS
-	if err := http.ListenAndServe(":80", nil); err != nil {
-		logger.WithError(err).Fatal("HTTP server failed to run")
-	} else {
-		logger.Info("HTTP server is running on port 80")
+	tlsConfig, err := serverTLSConfig(conf.ClientCAFile)
+	if err != nil {
+		logger.WithError(err).Fatal("Could not load the client CA")
+	}
+	server.TLSConfig = tlsConfig
+	logger.WithField("port", conf.Port).WithField("mtls", conf.ClientCAFile != "").Info("HTTPS server is running")
+	if err := server.ListenAndServeTLS(conf.CertFile, conf.KeyFile); err != nil {
+		logger.WithError(err).Fatal("HTTPS server failed to run")
 	}
 }
 
 // Set up our client which we will use to call the API
-func getAgonesClient() *versioned.Clientset {
-	// Create the in-cluster config
-	config, err := rest.InClusterConfig()
+// It uses the in-cluster config, or the kubeconfig file when one is given to run outside the cluster.
+func getAgonesClient(kubeconfig string) (versioned.Interface, error) {
+	var config *rest.Config
+	var err error
+	if kubeconfig == "" {
+		// Create the in-cluster config
+		config, err = rest.InClusterConfig()
+	} else {
+		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
+	}
 	if err != nil {
-		logger.WithError(err).Fatal("Could not create in cluster config")
+		return nil, fmt.Errorf("could not create the kubernetes config: %v", err)
 	}
 
 	// Access to the Agones resources through the Agones Clientset
-	agonesClient, err := versioned.NewForConfig(config)
-	if err != nil {
-		logger.WithError(err).Fatal("Could not create the agones api clientset")
-	} else {
-		logger.Info("Created the agones api clientset")
+	return versioned.NewForConfig(config)
+}
+
+// Parse the fleets clients may allocate from,
+// a comma separated list of "namespace/fleet" or "fleet" in the default namespace
+func parseAllowedFleets(list string) map[string]bool {
+	allowed := map[string]bool{}
+	for _, entry := range strings.Split(list, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			entry = defaultNamespace + "/" + entry
+		}
+		allowed[entry] = true
 	}
-	return agonesClient
+	return allowed
 }
 
 // Limit verbs the web server handles
@@ -76,16 +160,16 @@ func getOnly(h handler) handler {
 			h(w, r)
 			return
 		}
-		http.Error(w, "Get Only", http.StatusMethodNotAllowed)
+		writeError(w, methodNotAllowed("Get Only"))
 	}
 }
 
-// Let the web server do basic authentication
+// Let the web server do basic authentication against the configured client credentials
 func basicAuth(pass handler) handler {
 	return func(w http.ResponseWriter, r *http.Request) {
-		key, value, _ := r.BasicAuth()
-		if key != "v1GameClientKey" || value != "EAEC945C371B2EC361DE399C2F11E" {
-			http.Error(w, "authorization failed", http.StatusUnauthorized)
+		key, value, ok := r.BasicAuth()
+		if !ok || !credentials.valid(key, value) {
+			writeError(w, unauthorized("authorization failed"))
 			return
 		}
 		pass(w, r)
@@ -98,7 +182,7 @@ func handleRoot(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, err := io.WriteString(w, "Healthy")
 	if err != nil {
-		logger.WithError(err).Fatal("Error writing string Healthy from /")
+		logger.WithError(err).Error("Error writing string Healthy from /")
 	}
 }
 
@@ -108,74 +192,94 @@ func handleHealthz(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	_, err := io.WriteString(w, "Healthy")
 	if err != nil {
-		logger.WithError(err).Fatal("Error writing string Healthy from /healthz")
+		logger.WithError(err).Error("Error writing string Healthy from /healthz")
 	}
 }
 
 // Let /address return the GameServerStatus
+//
+// Query parameters (all optional):
+//
+//	namespace  namespace of the fleets, defaults to "default"
+//	fleet      fleet to allocate from, repeat to fall back to the next fleet in order
+//	selector   label selector every allocated GameServer must match, e.g. "mode=ctf,region in (asia)"
+//	preferred  label selector to try before falling back to selector, repeat for ordering
+//	match_id   id of the match the GameServer is allocated for, recorded on the GameServer
+//	game_mode  game mode of the match, recorded on the GameServer
+//
+// The request is passed to the gRPC AllocationService in-process, this endpoint is a gateway for it.
 func handleAddress(w http.ResponseWriter, r *http.Request) {
-	status, err := allocate()
+	query := r.URL.Query()
+	in := &allocatorpb.AllocateRequest{
+		Namespace: query.Get("namespace"),
+		Fleets:    query["fleet"],
+		Selector:  query.Get("selector"),
+		Preferred: query["preferred"],
+	}
+	if query.Get("match_id") != "" || query.Get("game_mode") != "" {
+		in.Match = &allocatorpb.MatchMetadata{MatchId: query.Get("match_id"), GameMode: query.Get("game_mode")}
+	}
+
+	res, err := service.Allocate(withHTTPTraceparent(r), in)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		apiErr, ok := err.(*apiError)
+		if !ok {
+			apiErr = apiFailure(err.Error())
+		}
+		writeError(w, apiErr)
+		return
+	}
+
+	status := allocationv1.GameServerAllocationStatus{
+		State:          allocationv1.GameServerAllocationAllocated,
+		GameServerName: res.GameServerName,
+		Address:        res.Address,
+		NodeName:       res.NodeName,
+	}
+	for _, port := range res.Ports {
+		status.Ports = append(status.Ports, agonesv1.GameServerStatusPort{Name: port.Name, Port: port.Port})
 	}
 	w.Header().Set("Content-Type", "application/json")
 	err = json.NewEncoder(w).Encode(&result{status})
 	if err != nil {
-		logger.WithError(err).Fatal("Error writing json from /address")
+		logger.WithError(err).Error("Error writing json from /address")
 	}
 }
 
-// Return the number of ready game servers available to this fleet for allocation
-func checkReadyReplicas() int32 {
-	// Get a FleetInterface for this namespace
-	fleetInterface := agonesClient.AgonesV1().Fleets(namespace)
-	// Get our fleet
-	fleet, err := fleetInterface.Get(fleetname, metav1.GetOptions{})
+// Let /capacity return the replica counts of the allowed fleets
+// It is informational only, allocation on /address does not depend on it.
+//
+// Query parameters (all optional):
+//
+//	namespace  namespace of the fleets, defaults to "default"
+//	fleet      fleet to report, defaults to every allowed fleet in the namespace
+func handleCapacity(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	res, err := service.GetFleetCapacity(r.Context(), &allocatorpb.FleetCapacityRequest{
+		Namespace: query.Get("namespace"),
+		Fleet:     query.Get("fleet"),
+	})
 	if err != nil {
-		logger.WithError(err).Info("Get fleet failed")
+		apiErr, ok := err.(*apiError)
+		if !ok {
+			apiErr = apiFailure(err.Error())
+		}
+		writeError(w, apiErr)
+		return
 	}
 
-	return fleet.Status.ReadyReplicas
-}
-
-// Move a replica from ready to allocated and return the GameServerStatus
-func allocate() (allocationv1.GameServerAllocationStatus, error) {
-	var gsas allocationv1.GameServerAllocationStatus
-
-	// Log the values used in the allocation
-	logger.WithField("namespace", namespace).Info("namespace for gsa")
-	logger.WithField("fleetname", fleetname).Info("fleetname for gsa")
-
-	// Find out how many ready replicas the fleet has - we need at least one
-	readyReplicas := checkReadyReplicas()
-	logger.WithField("readyReplicas", readyReplicas).Info("number of ready replicas")
-
-	// Log and return an error if there are no ready replicas
-	if readyReplicas < 1 {
-		logger.WithField("fleetname", fleetname).Info("Insufficient ready replicas, cannot create fleet allocation")
-		gsas.State = allocationv1.GameServerAllocationUnAllocated
-		return gsas, errors.New("insufficient ready replicas, cannot create fleet allocation")
+	capacity := capacityResult{Fleets: []fleetCapacity{}}
+	for _, fleet := range res.Fleets {
+		capacity.Fleets = append(capacity.Fleets, fleetCapacity{
+			Name:              fleet.Name,
+			Replicas:          fleet.Replicas,
+			ReadyReplicas:     fleet.ReadyReplicas,
+			AllocatedReplicas: fleet.AllocatedReplicas,
+			ReservedReplicas:  fleet.ReservedReplicas,
+		})
 	}
-
-	// Get a AllocationInterface for this namespace
-	allocationInterface := agonesClient.AllocationV1().GameServerAllocations(namespace)
-
-	// Define the allocation using the constants set earlier
-	gsa := &allocationv1.GameServerAllocation{
-		Spec: allocationv1.GameServerAllocationSpec{
-			Required: metav1.LabelSelector{MatchLabels: map[string]string{agonesv1.FleetNameLabel: fleetname}},
-		}}
-
-	// Create a new allocation
-	gsa, err := allocationInterface.Create(gsa)
-	if err != nil {
-		// Log and return the error if the call to Create fails
-		logger.WithError(err).Info("Failed to create allocation")
-		gsas.State = allocationv1.GameServerAllocationUnAllocated
-		return gsas, errors.New("failed to create allocation")
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&capacity); err != nil {
+		logger.WithError(err).Error("Error writing json from /capacity")
 	}
-
-	// Log the GameServer.Staus of the new allocation, then return those values
-	logger.Info("New GameServer allocated: ", gsa.Status.State)
-	return gsa.Status, nil
 }
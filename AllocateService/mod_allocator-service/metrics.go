@@ -0,0 +1,38 @@
+package main
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// The outcome label of a successful allocation, failures use the code of their apiError
+const outcomeAllocated = "Allocated"
+
+// Metrics of the allocations served over gRPC, batches and /address, exposed on /metrics
+var (
+	allocationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "allocator_allocations_total",
+		Help: "Number of allocation requests by outcome.",
+	}, []string{"outcome"})
+
+	allocationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "allocator_allocation_duration_seconds",
+		Help:    "Time taken to serve an allocation request, including contention retries and fleet fallbacks.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"outcome"})
+)
+
+func init() {
+	prometheus.MustRegister(allocationsTotal, allocationDuration)
+}
+
+// Record the outcome and latency of an allocation request started at start
+func observeAllocation(start time.Time, apiErr *apiError) {
+	outcome := outcomeAllocated
+	if apiErr != nil {
+		outcome = apiErr.Code
+	}
+	allocationsTotal.WithLabelValues(outcome).Inc()
+	allocationDuration.WithLabelValues(outcome).Observe(time.Since(start).Seconds())
+}
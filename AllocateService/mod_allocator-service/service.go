@@ -0,0 +1,449 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"agones.dev/agones/examples/allocator-service/allocatorpb"
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"common/logging"
+)
+
+// Limits of AllocateBatch
+const (
+	// The most requests accepted in one batch
+	maxBatchSize = 100
+	// The most GameServerAllocations created at once for a batch
+	batchConcurrency = 8
+)
+
+// Retries of a GameServerAllocation that ended in Contention
+const (
+	// The most times a GameServerAllocation is created for one fleet
+	contentionMaxAttempts = 3
+	// The wait before the first retry, doubled on each retry
+	contentionInitialBackoff = 50 * time.Millisecond
+)
+
+// Labels and annotations recording the match a GameServer was allocated for
+const (
+	gameModeLabel         = "matchmaker/game-mode"
+	matchIDAnnotation     = "matchmaker/match-id"
+	ticketIDsAnnotation   = "matchmaker/ticket-ids"
+	allocatedAtAnnotation = "matchmaker/allocated-at"
+	traceparentAnnotation = "matchmaker/traceparent"
+	// Reported by the game server through the SDK, which prefixes the keys with "agones.dev/sdk-"
+	playersAnnotation    = "agones.dev/sdk-players"
+	emptySinceAnnotation = "agones.dev/sdk-empty-since"
+)
+
+// The fleets and label selectors requested by a client
+type allocationRequest struct {
+	namespace string
+	// Fleets are tried in order until one of them allocates a GameServer
+	fleets    []string
+	required  metav1.LabelSelector
+	preferred []metav1.LabelSelector
+	match     *allocatorpb.MatchMetadata
+	// The W3C traceparent of the match, empty when the client sent none
+	traceparent string
+}
+
+// The AllocationService served over gRPC, and in-process behind /address
+type allocationService struct {
+	allocatorpb.UnimplementedAllocationServiceServer
+}
+
+// Allocate a GameServer from the requested fleets and record the match on it
+func (s *allocationService) Allocate(ctx context.Context, in *allocatorpb.AllocateRequest) (*allocatorpb.AllocateResponse, error) {
+	start := time.Now()
+	traceparent, traceID := requestTraceparent(ctx, in.GetMatch())
+	reqLogger := logger.WithFields(matchFields(in.GetMatch())).WithField(logging.FieldTraceID, traceID)
+	req, apiErr := newAllocationRequest(in)
+	if apiErr != nil {
+		observeAllocation(start, apiErr)
+		reqLogger.WithError(apiErr).Info("Invalid allocation request")
+		return nil, apiErr
+	}
+	req.traceparent = traceparent
+
+	fleetname, status, apiErr := allocate(ctx, req)
+	observeAllocation(start, apiErr)
+	if apiErr != nil {
+		reqLogger.WithError(apiErr).Info("Allocation failed")
+		return nil, apiErr
+	}
+	reqLogger.WithField(logging.FieldGameServer, status.GameServerName).Info("Allocated GameServer for match")
+	return &allocatorpb.AllocateResponse{
+		GameServerName: status.GameServerName,
+		Fleet:          fleetname,
+		Address:        status.Address,
+		Ports:          toPorts(status.Ports),
+		NodeName:       status.NodeName,
+	}, nil
+}
+
+// Allocate a GameServer for each request of a batch, several at a time
+// A failing request does not fail the batch, its error is returned in its result instead.
+func (s *allocationService) AllocateBatch(ctx context.Context, in *allocatorpb.AllocateBatchRequest) (*allocatorpb.AllocateBatchResponse, error) {
+	if len(in.GetRequests()) > maxBatchSize {
+		return nil, invalidRequest(fmt.Sprintf("a batch has at most %d requests, got %d", maxBatchSize, len(in.GetRequests())))
+	}
+
+	results := make([]*allocatorpb.AllocateResult, len(in.GetRequests()))
+	sem := make(chan struct{}, batchConcurrency)
+	var wg sync.WaitGroup
+	for i, req := range in.GetRequests() {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, req *allocatorpb.AllocateRequest) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			res, err := s.Allocate(ctx, req)
+			if err != nil {
+				apiErr, ok := err.(*apiError)
+				if !ok {
+					apiErr = apiFailure(err.Error())
+				}
+				results[i] = &allocatorpb.AllocateResult{Error: apiErr.proto()}
+				return
+			}
+			results[i] = &allocatorpb.AllocateResult{Allocation: res}
+		}(i, req)
+	}
+	wg.Wait()
+
+	logger.WithField("requests", len(results)).Info("Batch allocation finished")
+	return &allocatorpb.AllocateBatchResponse{Results: results}, nil
+}
+
+// Shut down an allocated GameServer of an allowed fleet, e.g. when its match could not be assigned
+func (s *allocationService) Deallocate(ctx context.Context, in *allocatorpb.DeallocateRequest) (*allocatorpb.DeallocateResponse, error) {
+	namespace := in.GetNamespace()
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	if in.GetGameServerName() == "" {
+		return nil, invalidRequest("game_server_name is required")
+	}
+
+	gameServers := agonesClient.AgonesV1().GameServers(namespace)
+	gs, err := gameServers.Get(in.GetGameServerName(), metav1.GetOptions{})
+	if k8serrors.IsNotFound(err) {
+		return nil, notFound(fmt.Sprintf("GameServer %s/%s does not exist", namespace, in.GetGameServerName()))
+	}
+	if err != nil {
+		return nil, apiFailure(fmt.Sprintf("failed to get GameServer %s/%s: %v", namespace, in.GetGameServerName(), err))
+	}
+	fleetname := gs.ObjectMeta.Labels[agonesv1.FleetNameLabel]
+	if !allowedFleets[namespace+"/"+fleetname] {
+		return nil, fleetNotAllowed(fmt.Sprintf("fleet %s/%s is not allowed", namespace, fleetname))
+	}
+	if gs.Status.State != agonesv1.GameServerStateAllocated {
+		return nil, notFound(fmt.Sprintf("GameServer %s/%s is %s, not Allocated", namespace, gs.ObjectMeta.Name, gs.Status.State))
+	}
+
+	if err := gameServers.Delete(gs.ObjectMeta.Name, &metav1.DeleteOptions{}); err != nil {
+		return nil, apiFailure(fmt.Sprintf("failed to delete GameServer %s/%s: %v", namespace, gs.ObjectMeta.Name, err))
+	}
+	logger.WithField(logging.FieldGameServer, gs.ObjectMeta.Name).WithField("reason", in.GetReason()).Info("GameServer deallocated")
+	return &allocatorpb.DeallocateResponse{}, nil
+}
+
+// List the allocated GameServers of one allowed fleet, or of every allowed fleet in the namespace
+func (s *allocationService) ListAllocated(ctx context.Context, in *allocatorpb.ListAllocatedRequest) (*allocatorpb.ListAllocatedResponse, error) {
+	namespace := in.GetNamespace()
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+
+	fleets, apiErr := requestedFleets(namespace, in.GetFleet())
+	if apiErr != nil {
+		return nil, apiErr
+	}
+
+	res := &allocatorpb.ListAllocatedResponse{}
+	if len(fleets) == 0 {
+		return res, nil
+	}
+	list, err := agonesClient.AgonesV1().GameServers(namespace).List(metav1.ListOptions{
+		LabelSelector: fmt.Sprintf("%s in (%s)", agonesv1.FleetNameLabel, strings.Join(fleets, ",")),
+	})
+	if err != nil {
+		return nil, apiFailure(fmt.Sprintf("failed to list GameServers: %v", err))
+	}
+
+	for _, gs := range list.Items {
+		if gs.Status.State != agonesv1.GameServerStateAllocated {
+			continue
+		}
+		allocatedAt, _ := strconv.ParseInt(gs.ObjectMeta.Annotations[allocatedAtAnnotation], 10, 64)
+		players, _ := strconv.Atoi(gs.ObjectMeta.Annotations[playersAnnotation])
+		emptySince, _ := strconv.ParseInt(gs.ObjectMeta.Annotations[emptySinceAnnotation], 10, 64)
+		res.GameServers = append(res.GameServers, &allocatorpb.AllocatedGameServer{
+			Name:        gs.ObjectMeta.Name,
+			Fleet:       gs.ObjectMeta.Labels[agonesv1.FleetNameLabel],
+			Address:     gs.Status.Address,
+			Ports:       toPorts(gs.Status.Ports),
+			Match:       matchFromMeta(gs.ObjectMeta),
+			AllocatedAt: allocatedAt,
+			Players:     int32(players),
+			EmptySince:  emptySince,
+		})
+	}
+	return res, nil
+}
+
+// Report the replica counts of one allowed fleet, or of every allowed fleet in the namespace
+func (s *allocationService) GetFleetCapacity(ctx context.Context, in *allocatorpb.FleetCapacityRequest) (*allocatorpb.FleetCapacityResponse, error) {
+	namespace := in.GetNamespace()
+	if namespace == "" {
+		namespace = defaultNamespace
+	}
+	fleets, apiErr := requestedFleets(namespace, in.GetFleet())
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	sort.Strings(fleets)
+
+	res := &allocatorpb.FleetCapacityResponse{}
+	for _, fleetname := range fleets {
+		fleet, err := agonesClient.AgonesV1().Fleets(namespace).Get(fleetname, metav1.GetOptions{})
+		if err != nil {
+			return nil, apiFailure(fmt.Sprintf("could not get fleet %s/%s: %v", namespace, fleetname, err))
+		}
+		res.Fleets = append(res.Fleets, &allocatorpb.FleetCapacity{
+			Name:              fleetname,
+			Replicas:          fleet.Status.Replicas,
+			ReadyReplicas:     fleet.Status.ReadyReplicas,
+			AllocatedReplicas: fleet.Status.AllocatedReplicas,
+			ReservedReplicas:  fleet.Status.ReservedReplicas,
+		})
+	}
+	return res, nil
+}
+
+// The requested fleet if it is allowed, or every allowed fleet in the namespace when none is requested
+func requestedFleets(namespace, fleet string) ([]string, *apiError) {
+	if fleet != "" {
+		if !allowedFleets[namespace+"/"+fleet] {
+			return nil, fleetNotAllowed(fmt.Sprintf("fleet %s/%s is not allowed", namespace, fleet))
+		}
+		return []string{fleet}, nil
+	}
+
+	var fleets []string
+	for entry := range allowedFleets {
+		if strings.HasPrefix(entry, namespace+"/") {
+			fleets = append(fleets, strings.TrimPrefix(entry, namespace+"/"))
+		}
+	}
+	return fleets, nil
+}
+
+// Read and validate the allocation parameters of a request against the allowed fleets
+func newAllocationRequest(in *allocatorpb.AllocateRequest) (allocationRequest, *apiError) {
+	req := allocationRequest{
+		namespace: in.GetNamespace(),
+		fleets:    in.GetFleets(),
+		match:     in.GetMatch(),
+	}
+	if req.namespace == "" {
+		req.namespace = defaultNamespace
+	}
+	if len(req.fleets) == 0 {
+		req.fleets = []string{defaultFleetname}
+	}
+
+	for _, fleet := range req.fleets {
+		if !allowedFleets[req.namespace+"/"+fleet] {
+			return req, fleetNotAllowed(fmt.Sprintf("fleet %s/%s is not allowed", req.namespace, fleet))
+		}
+	}
+
+	if selector := in.GetSelector(); selector != "" {
+		required, err := metav1.ParseToLabelSelector(selector)
+		if err != nil {
+			return req, invalidRequest(fmt.Sprintf("invalid selector %q: %v", selector, err))
+		}
+		req.required = *required
+	}
+	for _, selector := range in.GetPreferred() {
+		preferred, err := metav1.ParseToLabelSelector(selector)
+		if err != nil {
+			return req, invalidRequest(fmt.Sprintf("invalid preferred selector %q: %v", selector, err))
+		}
+		req.preferred = append(req.preferred, *preferred)
+	}
+
+	return req, nil
+}
+
+// Restrict a label selector to the GameServers of a fleet
+func withFleet(selector metav1.LabelSelector, fleetname string) metav1.LabelSelector {
+	labels := map[string]string{agonesv1.FleetNameLabel: fleetname}
+	for k, v := range selector.MatchLabels {
+		labels[k] = v
+	}
+	return metav1.LabelSelector{
+		MatchLabels:      labels,
+		MatchExpressions: selector.MatchExpressions,
+	}
+}
+
+// Build the labels and annotations recording the match and its trace on the allocated GameServer
+func matchMetaPatch(match *allocatorpb.MatchMetadata, traceparent string) allocationv1.MetaPatch {
+	patch := allocationv1.MetaPatch{
+		Annotations: map[string]string{allocatedAtAnnotation: strconv.FormatInt(time.Now().Unix(), 10)},
+	}
+	if traceparent != "" {
+		patch.Annotations[traceparentAnnotation] = traceparent
+	}
+	if match == nil {
+		return patch
+	}
+	if match.GetGameMode() != "" {
+		patch.Labels = map[string]string{gameModeLabel: match.GetGameMode()}
+	}
+	if match.GetMatchId() != "" {
+		patch.Annotations[matchIDAnnotation] = match.GetMatchId()
+	}
+	if len(match.GetTicketIds()) > 0 {
+		patch.Annotations[ticketIDsAnnotation] = strings.Join(match.GetTicketIds(), ",")
+	}
+	return patch
+}
+
+// Read back the match recorded by matchMetaPatch
+func matchFromMeta(meta metav1.ObjectMeta) *allocatorpb.MatchMetadata {
+	match := &allocatorpb.MatchMetadata{
+		MatchId:  meta.Annotations[matchIDAnnotation],
+		GameMode: meta.Labels[gameModeLabel],
+	}
+	if ids := meta.Annotations[ticketIDsAnnotation]; ids != "" {
+		match.TicketIds = strings.Split(ids, ",")
+	}
+	if traceparent := meta.Annotations[traceparentAnnotation]; traceparent != "" {
+		match.TraceContext = map[string]string{traceparentKey: traceparent}
+	}
+	return match
+}
+
+func toPorts(ports []agonesv1.GameServerStatusPort) []*allocatorpb.Port {
+	var res []*allocatorpb.Port
+	for _, port := range ports {
+		res = append(res, &allocatorpb.Port{Name: port.Name, Port: port.Port})
+	}
+	return res
+}
+
+// Move a replica from ready to allocated and return the fleet and GameServerStatus
+// The requested fleets are tried in order until one of them allocates a GameServer.
+// When every fleet fails the most severe error is returned: APIFailure, then Contention, then NoReadyReplicas.
+// It stops trying once ctx is done.
+func allocate(ctx context.Context, req allocationRequest) (string, allocationv1.GameServerAllocationStatus, *apiError) {
+	var gsas allocationv1.GameServerAllocationStatus
+	gsas.State = allocationv1.GameServerAllocationUnAllocated
+
+	var apiErr *apiError
+	for _, fleetname := range req.fleets {
+		status, err := allocateFromFleet(ctx, req, fleetname)
+		if err == nil {
+			return fleetname, status, nil
+		}
+		if ctx.Err() != nil {
+			return "", gsas, canceled(ctx.Err())
+		}
+		logger.WithFields(matchFields(req.match)).WithError(err).WithField("fleetname", fleetname).Info("Could not allocate from fleet, trying the next one")
+		if apiErr == nil || severity(err) >= severity(apiErr) {
+			apiErr = err
+		}
+	}
+
+	return "", gsas, apiErr
+}
+
+// Order the errors of the fleets by how much they say about the state of the cluster
+func severity(err *apiError) int {
+	switch err.Code {
+	case codeAPIFailure:
+		return 2
+	case codeContention:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// Move a replica of one fleet from ready to allocated and return the GameServerStatus
+// Whether the fleet has capacity is only decided by the GameServerAllocation result, since the
+// ready replicas in the fleet status are eventually consistent: UnAllocated means no capacity,
+// Contention means other allocations raced for the same GameServers and is retried with backoff
+// unless ctx is done first.
+func allocateFromFleet(ctx context.Context, req allocationRequest, fleetname string) (allocationv1.GameServerAllocationStatus, *apiError) {
+	var gsas allocationv1.GameServerAllocationStatus
+	gsas.State = allocationv1.GameServerAllocationUnAllocated
+
+	// Log the values used in the allocation
+	fleetLogger := logger.WithFields(matchFields(req.match)).WithField("namespace", req.namespace).WithField("fleetname", fleetname)
+	fleetLogger.Debug("Allocating from fleet")
+
+	// Get a AllocationInterface for this namespace
+	allocationInterface := agonesClient.AllocationV1().GameServerAllocations(req.namespace)
+
+	// Define the allocation restricted to the fleet, preferring the preferred selectors in order
+	var preferred []metav1.LabelSelector
+	for _, selector := range req.preferred {
+		preferred = append(preferred, withFleet(selector, fleetname))
+	}
+	spec := allocationv1.GameServerAllocationSpec{
+		Required:  withFleet(req.required, fleetname),
+		Preferred: preferred,
+		MetaPatch: matchMetaPatch(req.match, req.traceparent),
+	}
+
+	backoff := contentionInitialBackoff
+	for attempt := 1; ; attempt++ {
+		// Create a new allocation
+		gsa, err := allocationInterface.Create(&allocationv1.GameServerAllocation{Spec: spec})
+		if err != nil {
+			// Log and return the error if the call to Create fails
+			fleetLogger.WithError(err).Error("Failed to create allocation")
+			return gsas, apiFailure(fmt.Sprintf("failed to create allocation: %v", err))
+		}
+
+		switch gsa.Status.State {
+		case allocationv1.GameServerAllocationAllocated:
+			// Log the GameServer.Staus of the new allocation, then return those values
+			fleetLogger.WithField(logging.FieldGameServer, gsa.Status.GameServerName).Info("New GameServer allocated")
+			return gsa.Status, nil
+		case allocationv1.GameServerAllocationContention:
+			if attempt >= contentionMaxAttempts {
+				fleetLogger.Warn("Allocation kept contending, giving up")
+				return gsa.Status, contention(fmt.Sprintf("allocation from fleet %s was in contention %d times", fleetname, attempt))
+			}
+			fleetLogger.WithField("backoff", backoff).Info("Allocation contended, retrying")
+			select {
+			case <-ctx.Done():
+				fleetLogger.WithError(ctx.Err()).Info("Allocation canceled while waiting to retry")
+				return gsa.Status, canceled(ctx.Err())
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+		default:
+			// Log and return an error if no GameServer matched the selectors
+			fleetLogger.WithField("state", gsa.Status.State).Info("No Ready GameServer matched the selectors")
+			return gsa.Status, noReadyReplicas(fmt.Sprintf("no Ready GameServer in fleet %s matched the selectors", fleetname))
+		}
+	}
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"agones.dev/agones/examples/allocator-service/allocatorpb"
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	"agones.dev/agones/pkg/client/clientset/versioned/fake"
+	k8serrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// Replace the Agones Clientset and the allowed fleets for one test
+func useFakeAgones(t *testing.T, fleets string, objects ...runtime.Object) *fake.Clientset {
+	t.Helper()
+	client := fake.NewSimpleClientset(objects...)
+	prevClient, prevFleets := agonesClient, allowedFleets
+	agonesClient, allowedFleets = client, parseAllowedFleets(fleets)
+	t.Cleanup(func() {
+		agonesClient, allowedFleets = prevClient, prevFleets
+	})
+	return client
+}
+
+// A GameServer of the fleet in the default namespace
+func gameServer(name, fleetname string, state agonesv1.GameServerState) *agonesv1.GameServer {
+	return &agonesv1.GameServer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: defaultNamespace,
+			Labels:    map[string]string{agonesv1.FleetNameLabel: fleetname},
+		},
+		Status: agonesv1.GameServerStatus{State: state},
+	}
+}
+
+// A scripted GameServerAllocation result for one fleet: the error of the API call, or the state of the allocation
+type fleetResult struct {
+	err   error
+	state allocationv1.GameServerAllocationState
+}
+
+var (
+	allocated   = fleetResult{state: allocationv1.GameServerAllocationAllocated}
+	unallocated = fleetResult{state: allocationv1.GameServerAllocationUnAllocated}
+	contended   = fleetResult{state: allocationv1.GameServerAllocationContention}
+)
+
+// Answer the GameServerAllocations of each fleet with its results in order, repeating the last one,
+// and return the fleets of the allocations in the order they were created
+func scriptAllocations(client *fake.Clientset, results map[string][]fleetResult) *[]string {
+	var mu sync.Mutex
+	created := []string{}
+	client.PrependReactor("create", "gameserverallocations", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		gsa := action.(k8stesting.CreateAction).GetObject().(*allocationv1.GameServerAllocation).DeepCopy()
+		fleetname := gsa.Spec.Required.MatchLabels[agonesv1.FleetNameLabel]
+
+		mu.Lock()
+		attempt := 0
+		for _, f := range created {
+			if f == fleetname {
+				attempt++
+			}
+		}
+		created = append(created, fleetname)
+		mu.Unlock()
+
+		script := results[fleetname]
+		if len(script) == 0 {
+			return true, nil, fmt.Errorf("no result for fleet %q", fleetname)
+		}
+		if attempt >= len(script) {
+			attempt = len(script) - 1
+		}
+		result := script[attempt]
+		if result.err != nil {
+			return true, nil, result.err
+		}
+		gsa.Status.State = result.state
+		if result.state == allocationv1.GameServerAllocationAllocated {
+			gsa.Status.GameServerName = fleetname + "-gs"
+		}
+		return true, gsa, nil
+	})
+	return &created
+}
+
+// The AllocationError code of an error returned by the service
+func errorCode(t *testing.T, err error) allocatorpb.AllocationError_Code {
+	t.Helper()
+	apiErr, ok := err.(*apiError)
+	if !ok {
+		t.Fatalf("error = %#v, want an *apiError", err)
+	}
+	return apiErr.pbCode
+}
+
+func TestDeallocate(t *testing.T) {
+	tests := []struct {
+		name    string
+		objects []runtime.Object
+		// Makes the Get of the GameServer fail when set
+		getErr        error
+		wantCode      allocatorpb.AllocationError_Code
+		wantRetryable bool
+		wantDeleted   bool
+	}{
+		{
+			name:        "allocated GameServer is deleted",
+			objects:     []runtime.Object{gameServer("gs-1", "simple-udp", agonesv1.GameServerStateAllocated)},
+			wantDeleted: true,
+		},
+		{
+			name:     "missing GameServer is not found",
+			wantCode: allocatorpb.AllocationError_NOT_FOUND,
+		},
+		{
+			name:          "failing Kubernetes API is a retryable api failure",
+			objects:       []runtime.Object{gameServer("gs-1", "simple-udp", agonesv1.GameServerStateAllocated)},
+			getErr:        errors.New("connection refused"),
+			wantCode:      allocatorpb.AllocationError_API_FAILURE,
+			wantRetryable: true,
+		},
+		{
+			name:     "ready GameServer is not found",
+			objects:  []runtime.Object{gameServer("gs-1", "simple-udp", agonesv1.GameServerStateReady)},
+			wantCode: allocatorpb.AllocationError_NOT_FOUND,
+		},
+		{
+			name:     "GameServer of another fleet is not allowed",
+			objects:  []runtime.Object{gameServer("gs-1", "other", agonesv1.GameServerStateAllocated)},
+			wantCode: allocatorpb.AllocationError_FLEET_NOT_ALLOWED,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := useFakeAgones(t, "simple-udp", tt.objects...)
+			if tt.getErr != nil {
+				client.PrependReactor("get", "gameservers", func(action k8stesting.Action) (bool, runtime.Object, error) {
+					return true, nil, tt.getErr
+				})
+			}
+
+			_, err := service.Deallocate(context.Background(), &allocatorpb.DeallocateRequest{GameServerName: "gs-1"})
+			if tt.wantCode == allocatorpb.AllocationError_UNKNOWN {
+				if err != nil {
+					t.Fatalf("Deallocate() error = %v, want nil", err)
+				}
+			} else {
+				if code := errorCode(t, err); code != tt.wantCode {
+					t.Fatalf("Deallocate() error code = %v, want %v", code, tt.wantCode)
+				}
+				if retryable := err.(*apiError).Retryable; retryable != tt.wantRetryable {
+					t.Errorf("Deallocate() retryable = %v, want %v", retryable, tt.wantRetryable)
+				}
+			}
+
+			// The tracker holds the objects without going through the reactors
+			_, getErr := client.Tracker().Get(agonesv1.SchemeGroupVersion.WithResource("gameservers"), defaultNamespace, "gs-1")
+			if deleted := len(tt.objects) > 0 && k8serrors.IsNotFound(getErr); deleted != tt.wantDeleted {
+				t.Errorf("GameServer deleted = %v, want %v", deleted, tt.wantDeleted)
+			}
+		})
+	}
+}
+
+func TestAllocateFromFleetContention(t *testing.T) {
+	tests := []struct {
+		name         string
+		results      []fleetResult
+		wantAttempts int
+		// The backoff waited before the retries, 50ms then 100ms
+		wantWait time.Duration
+		wantCode allocatorpb.AllocationError_Code
+	}{
+		{name: "allocated at once", results: []fleetResult{allocated}, wantAttempts: 1},
+		{name: "allocated on the last retry", results: []fleetResult{contended, contended, allocated}, wantAttempts: 3, wantWait: 150 * time.Millisecond},
+		{name: "contention every time", results: []fleetResult{contended}, wantAttempts: contentionMaxAttempts, wantWait: 150 * time.Millisecond, wantCode: allocatorpb.AllocationError_CONTENTION},
+		{name: "no ready replicas is not retried", results: []fleetResult{unallocated}, wantAttempts: 1, wantCode: allocatorpb.AllocationError_NO_READY_REPLICAS},
+		{name: "api failure is not retried", results: []fleetResult{{err: errors.New("connection refused")}}, wantAttempts: 1, wantCode: allocatorpb.AllocationError_API_FAILURE},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := useFakeAgones(t, "simple-udp")
+			created := scriptAllocations(client, map[string][]fleetResult{"simple-udp": tt.results})
+
+			start := time.Now()
+			status, err := allocateFromFleet(context.Background(), allocationRequest{namespace: defaultNamespace}, "simple-udp")
+			elapsed := time.Since(start)
+			if len(*created) != tt.wantAttempts {
+				t.Errorf("created %v allocations, want %v", len(*created), tt.wantAttempts)
+			}
+			if elapsed < tt.wantWait {
+				t.Errorf("returned after %v, want a backoff of at least %v", elapsed, tt.wantWait)
+			}
+			if tt.wantCode == allocatorpb.AllocationError_UNKNOWN {
+				if err != nil || status.GameServerName == "" {
+					t.Fatalf("allocateFromFleet() = %v, %v, want a GameServer", status.GameServerName, err)
+				}
+				return
+			}
+			if err == nil || err.pbCode != tt.wantCode {
+				t.Fatalf("allocateFromFleet() error = %v, want code %v", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestAllocateFromFleetCanceledDuringBackoff(t *testing.T) {
+	client := useFakeAgones(t, "simple-udp")
+	created := scriptAllocations(client, map[string][]fleetResult{"simple-udp": {contended}})
+
+	// The deadline passes while waiting for the first retry
+	ctx, cancel := context.WithTimeout(context.Background(), contentionInitialBackoff/5)
+	defer cancel()
+	_, err := allocateFromFleet(ctx, allocationRequest{namespace: defaultNamespace}, "simple-udp")
+	if err == nil || err.pbCode != allocatorpb.AllocationError_DEADLINE_EXCEEDED {
+		t.Fatalf("allocateFromFleet() error = %v, want code %v", err, allocatorpb.AllocationError_DEADLINE_EXCEEDED)
+	}
+	if len(*created) != 1 {
+		t.Errorf("created %v allocations, want 1", len(*created))
+	}
+}
+
+func TestAllocateFleetFallback(t *testing.T) {
+	tests := []struct {
+		name        string
+		results     map[string][]fleetResult
+		wantFleet   string
+		wantCreated []string
+	}{
+		{
+			name:        "first fleet allocates",
+			results:     map[string][]fleetResult{"fleet-a": {allocated}, "fleet-b": {allocated}, "fleet-c": {allocated}},
+			wantFleet:   "fleet-a",
+			wantCreated: []string{"fleet-a"},
+		},
+		{
+			name:        "falls back in the requested order",
+			results:     map[string][]fleetResult{"fleet-a": {unallocated}, "fleet-b": {{err: errors.New("connection refused")}}, "fleet-c": {allocated}},
+			wantFleet:   "fleet-c",
+			wantCreated: []string{"fleet-a", "fleet-b", "fleet-c"},
+		},
+		{
+			name:        "retries a contended fleet before falling back",
+			results:     map[string][]fleetResult{"fleet-a": {contended}, "fleet-b": {allocated}, "fleet-c": {allocated}},
+			wantFleet:   "fleet-b",
+			wantCreated: []string{"fleet-a", "fleet-a", "fleet-a", "fleet-b"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := useFakeAgones(t, "fleet-a,fleet-b,fleet-c")
+			created := scriptAllocations(client, tt.results)
+
+			fleet, _, err := allocate(context.Background(), allocationRequest{namespace: defaultNamespace, fleets: []string{"fleet-a", "fleet-b", "fleet-c"}})
+			if err != nil {
+				t.Fatalf("allocate() error = %v", err)
+			}
+			if fleet != tt.wantFleet {
+				t.Errorf("allocate() fleet = %v, want %v", fleet, tt.wantFleet)
+			}
+			if fmt.Sprint(*created) != fmt.Sprint(tt.wantCreated) {
+				t.Errorf("created allocations for %v, want %v", *created, tt.wantCreated)
+			}
+		})
+	}
+}
+
+func TestAllocateStopsWhenCanceled(t *testing.T) {
+	client := useFakeAgones(t, "fleet-a,fleet-b")
+	created := scriptAllocations(client, map[string][]fleetResult{"fleet-a": {unallocated}, "fleet-b": {allocated}})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, _, err := allocate(ctx, allocationRequest{namespace: defaultNamespace, fleets: []string{"fleet-a", "fleet-b"}})
+	if err == nil || err.pbCode != allocatorpb.AllocationError_CANCELED {
+		t.Fatalf("allocate() error = %v, want code %v", err, allocatorpb.AllocationError_CANCELED)
+	}
+	if fmt.Sprint(*created) != fmt.Sprint([]string{"fleet-a"}) {
+		t.Errorf("created allocations for %v, want only fleet-a", *created)
+	}
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+
+	"agones.dev/agones/examples/allocator-service/allocatorpb"
+	"google.golang.org/grpc/metadata"
+)
+
+// The allocator is built inside the Agones tree, which does not vendor OpenTelemetry, so it does
+// not record spans itself. It carries the W3C trace context of the match instead: the trace id is
+// logged with the allocation and the traceparent is recorded on the GameServer, so the allocation
+// can be found from the director's AssignMatch span and the game server's PlayerJoin span.
+
+// The W3C trace context header and gRPC metadata key
+const traceparentKey = "traceparent"
+
+// version-traceid-parentid-flags, see https://www.w3.org/TR/trace-context/#traceparent-header
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-[0-9a-f]{16}-[0-9a-f]{2}$`)
+
+// Return the traceparent of an allocation request and its trace id
+// A batch carries the trace context of each match in the request itself, a single Allocate call
+// may carry it in the gRPC metadata instead. Invalid values are ignored.
+func requestTraceparent(ctx context.Context, match *allocatorpb.MatchMetadata) (string, string) {
+	candidates := []string{match.GetTraceContext()[traceparentKey]}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		candidates = append(candidates, md.Get(traceparentKey)...)
+	}
+	for _, traceparent := range candidates {
+		if m := traceparentPattern.FindStringSubmatch(traceparent); m != nil && m[1] != "00000000000000000000000000000000" {
+			return traceparent, m[1]
+		}
+	}
+	return "", ""
+}
+
+// Pass the traceparent header of an HTTP request to the AllocationService as gRPC metadata
+func withHTTPTraceparent(r *http.Request) context.Context {
+	traceparent := r.Header.Get(traceparentKey)
+	if traceparent == "" {
+		return r.Context()
+	}
+	return metadata.NewIncomingContext(r.Context(), metadata.Pairs(traceparentKey, traceparent))
+}
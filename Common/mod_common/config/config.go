@@ -0,0 +1,245 @@
+// Package config は全てのバイナリで共有する設定の読み込みと検証
+//
+// 設定はフラグで定義し、-config(環境変数CONFIG_FILE)のJSONファイルと環境変数からも読み込む
+// 優先順位は 既定値 < 設定ファイル < フラグ < 環境変数 で、環境変数がフラグを上書きする従来の動作に合わせている
+// 各バイナリはconfig.goで設定の構造体をLoaderに登録し、Errorsで検証する
+package config
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Loader defines flags and also reads their values from a config file and environment variables.
+type Loader struct {
+	fs *flag.FlagSet
+	// envs フラグ名 -> 上書きする環境変数名
+	envs map[string]string
+}
+
+// NewLoader returns a Loader defining its flags on the command line flag set.
+func NewLoader() *Loader {
+	return &Loader{fs: flag.CommandLine, envs: map[string]string{}}
+}
+
+// StringVar defines a string setting stored in p, overridden by the environment variable env.
+func (l *Loader) StringVar(p *string, name, env, value, usage string) {
+	l.fs.StringVar(p, name, value, usage+" (env "+env+")")
+	l.envs[name] = env
+}
+
+// IntVar defines an int setting stored in p, overridden by the environment variable env.
+func (l *Loader) IntVar(p *int, name, env string, value int, usage string) {
+	l.fs.IntVar(p, name, value, usage+" (env "+env+")")
+	l.envs[name] = env
+}
+
+// FloatVar defines a float64 setting stored in p, overridden by the environment variable env.
+func (l *Loader) FloatVar(p *float64, name, env string, value float64, usage string) {
+	l.fs.Float64Var(p, name, value, usage+" (env "+env+")")
+	l.envs[name] = env
+}
+
+// BoolVar defines a bool setting stored in p, overridden by the environment variable env.
+func (l *Loader) BoolVar(p *bool, name, env string, value bool, usage string) {
+	l.fs.BoolVar(p, name, value, usage+" (env "+env+")")
+	l.envs[name] = env
+}
+
+// DurationVar defines a time.Duration setting stored in p, overridden by the environment variable env.
+func (l *Loader) DurationVar(p *time.Duration, name, env string, value time.Duration, usage string) {
+	l.fs.DurationVar(p, name, value, usage+" (env "+env+")")
+	l.envs[name] = env
+}
+
+// JSONVar defines a structured setting stored in p, such as a map or a slice of structs, given
+// as JSON and overridden by the environment variable env. The value of p when it is defined is
+// the default, and a value from the config file, the flag or env replaces it as a whole.
+func (l *Loader) JSONVar(p interface{}, name, env, usage string) {
+	l.fs.Var(&jsonValue{p: p}, name, usage+" (env "+env+")")
+	l.envs[name] = env
+}
+
+// jsonValue JSONで受け取るflag.Value
+type jsonValue struct {
+	p interface{}
+}
+
+func (v *jsonValue) String() string {
+	// flagパッケージは既定値の判定のためにゼロ値のjsonValueでも呼ぶ
+	if v == nil || v.p == nil {
+		return ""
+	}
+	b, err := json.Marshal(v.p)
+	if err != nil {
+		return ""
+	}
+	return string(b)
+}
+
+func (v *jsonValue) Set(s string) error {
+	// 既定値にマージせず、新しい値で置き換える
+	value := reflect.New(reflect.TypeOf(v.p).Elem())
+	dec := json.NewDecoder(strings.NewReader(s))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(value.Interface()); err != nil {
+		return err
+	}
+	reflect.ValueOf(v.p).Elem().Set(value.Elem())
+	return nil
+}
+
+// Load parses the flags in args, then applies the -config file (env CONFIG_FILE) and the
+// environment variables. The config file is a JSON object keyed by flag name whose values
+// are strings, numbers or booleans, or JSON objects and arrays for the settings defined
+// with JSONVar.
+func (l *Loader) Load(args []string) error {
+	path := l.fs.String("config", "", "JSON file of settings keyed by flag name (env CONFIG_FILE)")
+	if err := l.fs.Parse(args); err != nil {
+		return err
+	}
+	explicit := map[string]bool{}
+	l.fs.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+
+	if env := os.Getenv("CONFIG_FILE"); env != "" {
+		*path = env
+	}
+	if *path != "" {
+		values, err := readFile(*path)
+		if err != nil {
+			return err
+		}
+		for name, value := range values {
+			if _, ok := l.envs[name]; !ok {
+				return fmt.Errorf("unknown setting %q in %v", name, *path)
+			}
+			if explicit[name] {
+				continue
+			}
+			if err := l.fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid %v in %v, got %w", name, *path, err)
+			}
+		}
+	}
+
+	for name, env := range l.envs {
+		if value := os.Getenv(env); value != "" {
+			if err := l.fs.Set(name, value); err != nil {
+				return fmt.Errorf("invalid %v %q, got %w", env, value, err)
+			}
+		}
+	}
+	return nil
+}
+
+// readFile 設定ファイルを読み、値をフラグの文字列表現にする
+func readFile(path string) (map[string]string, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file, got %w", err)
+	}
+	raw := map[string]interface{}{}
+	if err := json.Unmarshal(b, &raw); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %v, got %w", path, err)
+	}
+	values := map[string]string{}
+	for name, v := range raw {
+		switch v := v.(type) {
+		case string:
+			values[name] = v
+		case float64:
+			values[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		case map[string]interface{}, []interface{}:
+			b, err := json.Marshal(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %v in %v, got %w", name, path, err)
+			}
+			values[name] = string(b)
+		default:
+			return nil, fmt.Errorf("setting %q in %v must be a string, number, boolean, object or array", name, path)
+		}
+	}
+	return values, nil
+}
+
+// Errors are the invalid settings found while validating a config, reported all at once by Err.
+type Errors []string
+
+// Add records an invalid setting.
+func (e *Errors) Add(format string, a ...interface{}) {
+	*e = append(*e, fmt.Sprintf(format, a...))
+}
+
+// NonEmpty requires value to be set.
+func (e *Errors) NonEmpty(name, value string) {
+	if value == "" {
+		e.Add("%v must not be empty", name)
+	}
+}
+
+// Endpoint requires value to be host:port.
+func (e *Errors) Endpoint(name, value string) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil || host == "" {
+		e.Add("%v must be host:port, got %q", name, value)
+		return
+	}
+	if p, err := strconv.Atoi(port); err != nil || p < 1 || p > 65535 {
+		e.Add("%v has an invalid port, got %q", name, value)
+	}
+}
+
+// HTTPURL requires value to be an absolute http or https URL.
+func (e *Errors) HTTPURL(name, value string) {
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		e.Add("%v must be an http or https URL, got %q", name, value)
+	}
+}
+
+// Port requires value to be a TCP or UDP port.
+func (e *Errors) Port(name string, value int) {
+	if value < 1 || value > 65535 {
+		e.Add("%v must be between 1 and 65535, got %v", name, value)
+	}
+}
+
+// PositiveInt requires value to be at least 1.
+func (e *Errors) PositiveInt(name string, value int) {
+	if value < 1 {
+		e.Add("%v must be at least 1, got %v", name, value)
+	}
+}
+
+// NonNegative requires value to be 0 or more.
+func (e *Errors) NonNegative(name string, value int) {
+	if value < 0 {
+		e.Add("%v must not be negative, got %v", name, value)
+	}
+}
+
+// Positive requires value to be a positive duration.
+func (e *Errors) Positive(name string, value time.Duration) {
+	if value <= 0 {
+		e.Add("%v must be a positive duration such as \"5s\", got %v", name, value)
+	}
+}
+
+// Err returns every recorded invalid setting as one error, or nil when there is none.
+func (e Errors) Err() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return fmt.Errorf("invalid config: %v", strings.Join(e, "; "))
+}
@@ -0,0 +1,204 @@
+package config
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// newTestLoader コマンドラインのフラグを汚さないLoader
+func newTestLoader() *Loader {
+	return &Loader{fs: flag.NewFlagSet("test", flag.ContinueOnError), envs: map[string]string{}}
+}
+
+// writeConfig 設定ファイルを書いてパスを返す
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		env  string
+		want string
+	}{
+		{name: "default", want: "default"},
+		{name: "file over default", file: `{"endpoint": "file"}`, want: "file"},
+		{name: "flag over file", file: `{"endpoint": "file"}`, args: []string{"-endpoint", "flag"}, want: "flag"},
+		{name: "env over file", file: `{"endpoint": "file"}`, env: "env", want: "env"},
+		{name: "env over flag", file: `{"endpoint": "file"}`, args: []string{"-endpoint", "flag"}, env: "env", want: "env"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_ENDPOINT", tt.env)
+			t.Setenv("CONFIG_FILE", "")
+			args := tt.args
+			if tt.file != "" {
+				args = append([]string{"-config", writeConfig(t, tt.file)}, args...)
+			}
+
+			var endpoint string
+			l := newTestLoader()
+			l.StringVar(&endpoint, "endpoint", "TEST_ENDPOINT", "default", "Endpoint")
+			if err := l.Load(args); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if endpoint != tt.want {
+				t.Errorf("endpoint = %q, want %q", endpoint, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigFileEnv(t *testing.T) {
+	path := writeConfig(t, `{"port": 7000, "timeout": "5s", "enabled": true, "ratio": 0.5}`)
+	t.Setenv("CONFIG_FILE", path)
+
+	var (
+		port    int
+		timeout time.Duration
+		enabled bool
+		ratio   float64
+	)
+	l := newTestLoader()
+	l.IntVar(&port, "port", "TEST_PORT", 80, "Port")
+	l.DurationVar(&timeout, "timeout", "TEST_TIMEOUT", time.Second, "Timeout")
+	l.BoolVar(&enabled, "enabled", "TEST_ENABLED", false, "Enabled")
+	l.FloatVar(&ratio, "ratio", "TEST_RATIO", 1, "Ratio")
+	if err := l.Load(nil); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if port != 7000 || timeout != 5*time.Second || !enabled || ratio != 0.5 {
+		t.Errorf("got port %v, timeout %v, enabled %v, ratio %v from %v", port, timeout, enabled, ratio, path)
+	}
+}
+
+func TestLoadJSONVarReplaces(t *testing.T) {
+	type policy struct {
+		Min int `json:"min"`
+		Max int `json:"max"`
+	}
+	tests := []struct {
+		name string
+		file string
+		env  string
+		want map[string]policy
+	}{
+		{
+			name: "default",
+			want: map[string]policy{"a": {Min: 1, Max: 2}, "b": {Min: 3, Max: 4}},
+		},
+		{
+			// 既定値とマージせず、キーも中身も設定ファイルの値だけになる
+			name: "file replaces the default",
+			file: `{"policies": {"c": {"max": 5}}}`,
+			want: map[string]policy{"c": {Max: 5}},
+		},
+		{
+			name: "env replaces the file",
+			file: `{"policies": {"c": {"max": 5}}}`,
+			env:  `{"a": {"min": 6}}`,
+			want: map[string]policy{"a": {Min: 6}},
+		},
+		{
+			name: "empty object clears the default",
+			file: `{"policies": {}}`,
+			want: map[string]policy{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_POLICIES", tt.env)
+			t.Setenv("CONFIG_FILE", "")
+			args := []string{}
+			if tt.file != "" {
+				args = append(args, "-config", writeConfig(t, tt.file))
+			}
+
+			policies := map[string]policy{"a": {Min: 1, Max: 2}, "b": {Min: 3, Max: 4}}
+			l := newTestLoader()
+			l.JSONVar(&policies, "policies", "TEST_POLICIES", "Policies")
+			if err := l.Load(args); err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			if !reflect.DeepEqual(policies, tt.want) {
+				t.Errorf("policies = %v, want %v", policies, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		env  string
+	}{
+		{name: "unknown setting in the file", file: `{"unknown": "x"}`},
+		{name: "invalid value in the file", file: `{"port": "x"}`},
+		{name: "setting of an unsupported type", file: `{"port": null}`},
+		{name: "invalid json file", file: `{"port": `},
+		{name: "unknown field of a json setting", file: `{"policies": {"a": {"min": 1}}}`},
+		{name: "invalid env", env: "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("TEST_PORT", tt.env)
+			t.Setenv("CONFIG_FILE", "")
+			args := []string{}
+			if tt.file != "" {
+				args = append(args, "-config", writeConfig(t, tt.file))
+			}
+
+			var port int
+			policies := map[string]struct {
+				Max int `json:"max"`
+			}{}
+			l := newTestLoader()
+			l.IntVar(&port, "port", "TEST_PORT", 80, "Port")
+			l.JSONVar(&policies, "policies", "TEST_POLICIES", "Policies")
+			if err := l.Load(args); err == nil {
+				t.Error("Load() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestErrors(t *testing.T) {
+	var errs Errors
+	errs.NonEmpty("name", "x")
+	errs.Endpoint("endpoint", "localhost:50504")
+	errs.HTTPURL("url", "https://example.com/path")
+	errs.Port("port", 65535)
+	errs.PositiveInt("count", 1)
+	errs.NonNegative("min", 0)
+	errs.Positive("timeout", time.Second)
+	if err := errs.Err(); err != nil {
+		t.Fatalf("Err() = %v for valid settings", err)
+	}
+
+	errs.NonEmpty("name", "")
+	errs.Endpoint("endpoint", ":50504")
+	errs.Endpoint("endpoint", "localhost:0")
+	errs.HTTPURL("url", "example.com")
+	errs.Port("port", 65536)
+	errs.PositiveInt("count", 0)
+	errs.NonNegative("min", -1)
+	errs.Positive("timeout", 0)
+	if len(errs) != 8 {
+		t.Errorf("got %v errors, want 8: %v", len(errs), errs)
+	}
+	if errs.Err() == nil {
+		t.Error("Err() = nil with invalid settings")
+	}
+}
@@ -0,0 +1,44 @@
+// Package logging は全てのバイナリで共有する構造化ログの設定とフィールド名
+package logging
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Field names of the structured logs, the same in every binary so that the logs of a
+// ticket or a match can be searched across them.
+const (
+	FieldTicketID   = "ticket_id"
+	FieldMatchID    = "match_id"
+	FieldProfile    = "profile"
+	FieldGameServer = "gameserver"
+	FieldMode       = "mode"
+	FieldTraceID    = "trace_id"
+)
+
+// New returns a JSON logger writing to stderr whose entries carry the service name.
+// Its level is set by LOG_LEVEL (debug, info, warn or error, defaults to info).
+func New(service string) *logrus.Entry {
+	l := logrus.New()
+	l.SetFormatter(&logrus.JSONFormatter{})
+	entry := l.WithField("service", service)
+	SetLevelFromEnv(l, entry)
+	return entry
+}
+
+// SetLevelFromEnv sets the level of l from LOG_LEVEL, and warns on entry and keeps the
+// current level when it is invalid.
+func SetLevelFromEnv(l *logrus.Logger, entry *logrus.Entry) {
+	env := os.Getenv("LOG_LEVEL")
+	if env == "" {
+		return
+	}
+	level, err := logrus.ParseLevel(env)
+	if err != nil {
+		entry.WithError(err).Warnf("Invalid LOG_LEVEL %q, using %v", env, l.GetLevel())
+		return
+	}
+	l.SetLevel(level)
+}
@@ -0,0 +1,36 @@
+// Package relaxation は待ち時間に応じたマッチの条件の緩和スケジュール
+//
+// DirectorがゲームモードごとのスケジュールをJSONでMatchProfileのExtensionsに入れ、MMFがそれを読んで緩和する
+// 両者が同じ定義と検証を使うようにここで共有する
+package relaxation
+
+import "fmt"
+
+// Step relaxes the params of a new match once its longest waiting
+// player has waited AfterSeconds. Zero fields keep the params of the previous step.
+type Step struct {
+	AfterSeconds float64 `json:"after_seconds"`
+	// MinPlayers replaces the minimum players of a match when positive.
+	MinPlayers int `json:"min_players,omitempty"`
+	// RatingWindow replaces the maximum rating difference in a match when positive.
+	RatingWindow float64 `json:"rating_window,omitempty"`
+	// CrossRegion lets players of different regions into the same match.
+	CrossRegion bool `json:"cross_region,omitempty"`
+}
+
+// Schedule is the relaxation steps of a profile in ascending order of AfterSeconds.
+// The relaxation level of a match is the number of steps applied to it.
+type Schedule []Step
+
+// Validate reports schedules with negative values or whose steps are not in ascending order.
+func (s Schedule) Validate() error {
+	for i, step := range s {
+		if step.AfterSeconds < 0 || step.MinPlayers < 0 || step.RatingWindow < 0 {
+			return fmt.Errorf("step %v must not be negative, got %+v", i, step)
+		}
+		if i > 0 && step.AfterSeconds < s[i-1].AfterSeconds {
+			return fmt.Errorf("step %v must not come before step %v, got %v < %v seconds", i, i-1, step.AfterSeconds, s[i-1].AfterSeconds)
+		}
+	}
+	return nil
+}
@@ -2,17 +2,17 @@
 
 SCRIPT_DIR=$(cd $(dirname $0); pwd)
 
-cd $SCRIPT_DIR/../AllocateService/mod_allocator-service/
-docker build -t localimage/mod_allocator-service:0.1 .
+# Every image builds from the repository root so that the shared Common/mod_common module is in the context
+cd $SCRIPT_DIR/..
 
-cd $SCRIPT_DIR/../GameServer/mod_simple-udp/
-docker build -t localimage/mod_simple-udp:0.1 .
+docker build -t localimage/mod_allocator-service:0.1 -f AllocateService/mod_allocator-service/Dockerfile .
 
-cd $SCRIPT_DIR/../OpenMatch/mod_matchmaker101/frontend/
-docker build -t localimage/mod_frontend:0.1 .
+docker build -t localimage/mod_simple-udp:0.1 -f GameServer/mod_simple-udp/Dockerfile .
 
-cd $SCRIPT_DIR/../OpenMatch/mod_matchmaker101/matchfunction/
-docker build -t localimage/mod_matchfunction:0.1 .
+docker build -t localimage/mod_frontend:0.1 -f OpenMatch/mod_matchmaker101/frontend/Dockerfile .
 
-cd $SCRIPT_DIR/../OpenMatch/mod_matchmaker101/director/
-docker build -t localimage/mod_director:0.1 .
\ No newline at end of file
+docker build -t localimage/mod_matchfunction:0.1 -f OpenMatch/mod_matchmaker101/matchfunction/Dockerfile .
+
+docker build -t localimage/mod_director:0.1 -f OpenMatch/mod_matchmaker101/director/Dockerfile .
+
+docker build -t localimage/mod_autoscaler:0.1 -f OpenMatch/mod_matchmaker101/autoscaler/Dockerfile .
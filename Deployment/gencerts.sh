@@ -0,0 +1,50 @@
+#!/bin/sh -e
+
+# Generates a CA, a server certificate for the fleet allocator and a client certificate
+# for the director, for trying mTLS between them locally.
+#
+#   ./gencerts.sh            writes the certificates to ./certs
+#   ./gencerts.sh --apply    also creates the Kubernetes Secrets used by Allocator.yaml and director.yaml
+
+SCRIPT_DIR=$(cd $(dirname $0); pwd)
+OUT=$SCRIPT_DIR/certs
+ALLOCATOR_HOST=fleet-allocator-endpoint.default.svc.cluster.local
+
+mkdir -p $OUT
+cd $OUT
+
+# CA
+openssl req -x509 -newkey rsa:2048 -nodes -days 365 -subj "/CN=allocator-ca" \
+  -keyout ca.key -out ca.crt
+
+# Allocator server certificate
+cat > server.ext <<EOT
+subjectAltName = DNS:${ALLOCATOR_HOST}, DNS:fleet-allocator-endpoint, DNS:localhost, IP:127.0.0.1
+extendedKeyUsage = serverAuth
+EOT
+openssl req -newkey rsa:2048 -nodes -subj "/CN=${ALLOCATOR_HOST}" \
+  -keyout server.key -out server.csr
+openssl x509 -req -in server.csr -CA ca.crt -CAkey ca.key -CAcreateserial -days 365 \
+  -extfile server.ext -out server.crt
+
+# Director client certificate
+cat > client.ext <<EOT
+extendedKeyUsage = clientAuth
+EOT
+openssl req -newkey rsa:2048 -nodes -subj "/CN=director" \
+  -keyout client.key -out client.csr
+openssl x509 -req -in client.csr -CA ca.crt -CAkey ca.key -CAcreateserial -days 365 \
+  -extfile client.ext -out client.crt
+
+rm -f server.csr client.csr server.ext client.ext
+
+if [ "$1" = "--apply" ]; then
+  kubectl -n default create secret generic fleet-allocator-tls \
+    --from-file=tls.crt=server.crt --from-file=tls.key=server.key --from-file=ca.crt=ca.crt \
+    --dry-run=client -o yaml | kubectl apply -f -
+  kubectl -n openmatch create secret generic director-allocator-tls \
+    --from-file=tls.crt=client.crt --from-file=tls.key=client.key --from-file=ca.crt=ca.crt \
+    --dry-run=client -o yaml | kubectl apply -f -
+fi
+
+echo "Certificates written to $OUT"
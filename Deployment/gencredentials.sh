@@ -0,0 +1,23 @@
+#!/bin/sh -e
+
+# Generates a client key and secret for the director to call the fleet allocator, and creates
+# the Kubernetes Secrets used by Allocator.yaml (fleet-allocator-clients) and director.yaml
+# (allocator-client-credentials). Run it once before applying those manifests.
+#
+#   ./gencredentials.sh                 generates a random secret for the client key "director"
+#   ./gencredentials.sh <key> <secret>  uses the given key and secret
+#
+# To rotate, create fleet-allocator-clients by hand with both the old and the new key,
+# switch allocator-client-credentials over to the new key, then remove the old one.
+
+KEY=${1:-director}
+SECRET=${2:-$(openssl rand -hex 16)}
+
+kubectl -n default create secret generic fleet-allocator-clients \
+  --from-literal=${KEY}=${SECRET} \
+  --dry-run=client -o yaml | kubectl apply -f -
+kubectl -n openmatch create secret generic allocator-client-credentials \
+  --from-literal=key=${KEY} --from-literal=secret=${SECRET} \
+  --dry-run=client -o yaml | kubectl apply -f -
+
+echo "Created the allocator client credentials for key $KEY"
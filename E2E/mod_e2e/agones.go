@@ -0,0 +1,158 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	"agones.dev/agones/pkg/client/clientset/versioned/fake"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// AllocateServiceと同じ、GameServerに割り当てたマッチを記録するラベルとアノテーション
+const (
+	gameModeLabel     = "matchmaker/game-mode"
+	matchIDAnnotation = "matchmaker/match-id"
+	// GameServerがSDKで報告するプレイヤー数
+	playersAnnotation = "agones.dev/sdk-players"
+)
+
+var gameServersResource = agonesv1.SchemeGroupVersion.WithResource("gameservers")
+
+// fakeAgones client-goのfakeのClientsetに、AgonesのコントローラーのGameServerAllocationの処理を加えたもの
+// GameServerAllocationを作成すると、ラベルセレクタに合うReadyなGameServerをAllocatedにする
+// AllocateServiceからはfakeKubeAPIを通して使う
+type fakeAgones struct {
+	client *fake.Clientset
+	// mu 割り当てとSDKからの変更を1件ずつ行い、同じGameServerの二重の割り当てや変更の上書きを防ぐ
+	mu sync.Mutex
+}
+
+func newFakeAgones() *fakeAgones {
+	a := &fakeAgones{client: fake.NewSimpleClientset()}
+	a.client.PrependReactor("create", "gameserverallocations", a.reactAllocation)
+	return a
+}
+
+// addGameServer fleetのGameServerをScheduledの状態で登録する。SDKのReadyでReadyになる
+func (a *fakeAgones) addGameServer(namespace, fleet, name string, port int) error {
+	gs := &agonesv1.GameServer{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        name,
+			Namespace:   namespace,
+			Labels:      map[string]string{agonesv1.FleetNameLabel: fleet},
+			Annotations: map[string]string{},
+		},
+		Status: agonesv1.GameServerStatus{
+			State:    agonesv1.GameServerStateScheduled,
+			Address:  "127.0.0.1",
+			Ports:    []agonesv1.GameServerStatusPort{{Name: "default", Port: int32(port)}},
+			NodeName: "localhost",
+		},
+	}
+	_, err := a.client.AgonesV1().GameServers(namespace).Create(gs)
+	return err
+}
+
+// gameServer GameServerを取得する
+func (a *fakeAgones) gameServer(namespace, name string) (*agonesv1.GameServer, error) {
+	return a.client.AgonesV1().GameServers(namespace).Get(name, metav1.GetOptions{})
+}
+
+// updateGameServer GameServerをfで変更する
+// reactAllocationと同じくtrackerを直接使い、Clientsetのロックとmuの順序が逆にならないようにする
+func (a *fakeAgones) updateGameServer(namespace, name string, f func(gs *agonesv1.GameServer)) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	obj, err := a.client.Tracker().Get(gameServersResource, namespace, name)
+	if err != nil {
+		return err
+	}
+	gs := obj.(*agonesv1.GameServer).DeepCopy()
+	f(gs)
+	return a.client.Tracker().Update(gameServersResource, gs, namespace)
+}
+
+// reactAllocation GameServerAllocationの作成をAgonesのコントローラーの代わりに処理する
+// reactorの中からClientsetを呼ぶとfakeのロックでデッドロックするので、trackerを直接使う
+func (a *fakeAgones) reactAllocation(action k8stesting.Action) (bool, runtime.Object, error) {
+	gsa := action.(k8stesting.CreateAction).GetObject().(*allocationv1.GameServerAllocation).DeepCopy()
+	namespace := action.GetNamespace()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	obj, err := a.client.Tracker().List(gameServersResource, agonesv1.SchemeGroupVersion.WithKind("GameServer"), namespace)
+	if err != nil {
+		return true, nil, err
+	}
+	candidates := obj.(*agonesv1.GameServerList).Items
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Name < candidates[j].Name })
+
+	required, err := metav1.LabelSelectorAsSelector(&gsa.Spec.Required)
+	if err != nil {
+		return true, nil, err
+	}
+	var ready []agonesv1.GameServer
+	for _, gs := range candidates {
+		if gs.Status.State == agonesv1.GameServerStateReady && required.Matches(labels.Set(gs.Labels)) {
+			ready = append(ready, gs)
+		}
+	}
+	if len(ready) == 0 {
+		gsa.Status.State = allocationv1.GameServerAllocationUnAllocated
+		return true, gsa, nil
+	}
+
+	// Preferredを先頭から順に試し、どれにも合わなければRequiredに合う最初のGameServerにする
+	chosen := ready[0]
+	for i := range gsa.Spec.Preferred {
+		preferred, err := metav1.LabelSelectorAsSelector(&gsa.Spec.Preferred[i])
+		if err != nil {
+			return true, nil, err
+		}
+		if gs, ok := firstMatching(ready, preferred); ok {
+			chosen = gs
+			break
+		}
+	}
+
+	gs := chosen.DeepCopy()
+	gs.Status.State = agonesv1.GameServerStateAllocated
+	if gs.Labels == nil {
+		gs.Labels = map[string]string{}
+	}
+	if gs.Annotations == nil {
+		gs.Annotations = map[string]string{}
+	}
+	for k, v := range gsa.Spec.MetaPatch.Labels {
+		gs.Labels[k] = v
+	}
+	for k, v := range gsa.Spec.MetaPatch.Annotations {
+		gs.Annotations[k] = v
+	}
+	if err := a.client.Tracker().Update(gameServersResource, gs, namespace); err != nil {
+		return true, nil, err
+	}
+
+	gsa.Status = allocationv1.GameServerAllocationStatus{
+		State:          allocationv1.GameServerAllocationAllocated,
+		GameServerName: gs.Name,
+		Ports:          gs.Status.Ports,
+		Address:        gs.Status.Address,
+		NodeName:       gs.Status.NodeName,
+	}
+	return true, gsa, nil
+}
+
+func firstMatching(gameServers []agonesv1.GameServer, selector labels.Selector) (agonesv1.GameServer, bool) {
+	for _, gs := range gameServers {
+		if selector.Matches(labels.Set(gs.Labels)) {
+			return gs, true
+		}
+	}
+	return agonesv1.GameServer{}, false
+}
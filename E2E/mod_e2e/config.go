@@ -0,0 +1,49 @@
+package main
+
+import (
+	"time"
+
+	"common/config"
+)
+
+// e2eConfig E2Eのビルド元、ログの出力先、タイムアウトの設定
+// go testではフラグを使わず、環境変数だけで指定する
+type e2eConfig struct {
+	// RepoRoot リポジトリのルート。各バイナリをここからビルドする
+	RepoRoot string
+	// WorkDir バイナリとテストごとのログの出力先。空なら一時ディレクトリを作る
+	WorkDir string
+	// Timeout テスト1つ(stackの起動を含む)のタイムアウト。go runではstackの起動のタイムアウト
+	Timeout time.Duration
+	// GameServers go runで起動するGameServerの台数(loadgenなどから使う)
+	GameServers int
+}
+
+// conf E2Eの設定。mainの最初で読み込む
+var conf e2eConfig
+
+// loadConfig 設定を既定値、設定ファイル、フラグ、環境変数の順に読み込んで検証する
+// 既定値はE2E/mod_e2eで go test . か go run . することを想定している
+func loadConfig(args []string) (e2eConfig, error) {
+	var c e2eConfig
+	l := config.NewLoader()
+	l.StringVar(&c.RepoRoot, "repo", "REPO_ROOT", "../..", "Root of the repository to build the binaries from")
+	l.StringVar(&c.WorkDir, "work-dir", "WORK_DIR", "", "Directory for the binaries and the logs of each test, a temporary one if empty")
+	l.DurationVar(&c.Timeout, "timeout", "E2E_TIMEOUT", time.Minute, "Timeout of one test including starting the processes, or of starting the stack with go run")
+	l.IntVar(&c.GameServers, "game-servers", "GAME_SERVERS", 8, "Number of game servers started with go run")
+	if err := l.Load(args); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+// validate 設定の誤りをまとめて返す
+func (c e2eConfig) validate() error {
+	var errs config.Errors
+	errs.NonEmpty("repo", c.RepoRoot)
+	errs.Positive("timeout", c.Timeout)
+	if c.GameServers < 1 {
+		errs.Add("game-servers must be at least 1, got %v", c.GameServers)
+	}
+	return errs.Err()
+}
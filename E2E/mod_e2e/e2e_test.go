@@ -0,0 +1,300 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// gameMode テストでマッチメイクするゲームモード
+const gameMode = "mode.demo"
+
+// testWorkDir バイナリとテストごとのログの出力先。TestMainでバイナリをビルドする
+var testWorkDir string
+
+// TestMain 各バイナリを1回だけビルドしてからテストを実行する。-shortではビルドせず、テストもスキップする
+// 設定は環境変数で指定する(REPO_ROOT、WORK_DIR、E2E_TIMEOUT)
+func TestMain(m *testing.M) {
+	flag.Parse()
+	if testing.Short() {
+		os.Exit(m.Run())
+	}
+
+	var err error
+	if conf, err = loadConfig(nil); err != nil {
+		logger.WithError(err).Fatal("Failed to load the config")
+	}
+	if testWorkDir = conf.WorkDir; testWorkDir == "" {
+		if testWorkDir, err = ioutil.TempDir("", "e2e"); err != nil {
+			logger.WithError(err).Fatal("Failed to create the work directory")
+		}
+	}
+	logger.Infof("Writing binaries and logs to %v", testWorkDir)
+	if err := buildBinaries(context.Background(), conf.RepoRoot, filepath.Join(testWorkDir, "bin")); err != nil {
+		logger.WithError(err).Fatal("Failed to build the binaries")
+	}
+	os.Exit(m.Run())
+}
+
+// startTestStack テストの名前のディレクトリにログを出力するstackを起動する。止めるのは呼び出し元
+func startTestStack(ctx context.Context, t *testing.T, gameServers int) *stack {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("skipping the end-to-end test in short mode")
+	}
+	logDir := filepath.Join(testWorkDir, t.Name())
+	s, err := startStack(ctx, filepath.Join(testWorkDir, "bin"), logDir, gameServers)
+	if err != nil {
+		t.Fatalf("failed to start the stack, see the logs in %v: %v", logDir, err)
+	}
+	t.Logf("Writing the logs to %v", logDir)
+	return s
+}
+
+// waitUntil condがtrueになるまで待ち、ctxが終了したらテストを失敗させる
+func waitUntil(ctx context.Context, t *testing.T, what string, cond func() bool) {
+	t.Helper()
+	if err := waitFor(ctx, what, cond); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestMatching 4人が同時にマッチングを要求し、同じGameServerに割り当てられて接続する
+func TestMatching(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Timeout)
+	defer cancel()
+	s := startTestStack(ctx, t, 2)
+	defer s.stop()
+
+	// 4枚のチケットが揃うまでFetchMatchesを止め、1つのマッチにまとめさせる
+	s.om.pause()
+	results := requestMatches(ctx, s, 4)
+	waitUntil(ctx, t, "4 player tickets", func() bool { return s.om.countTickets(gameMode, "player") == 4 })
+	s.om.resume()
+
+	players, err := joinMatches(ctx, results, 4)
+	defer closePlayers(players)
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection, err := sameConnection(players)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gs, err := allocatedGameServer(s, connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := gs.Labels[gameModeLabel]; got != gameMode {
+		t.Errorf("GameServer %v has game mode %q, want %q", gs.Name, got, gameMode)
+	}
+	if gs.Annotations[matchIDAnnotation] == "" {
+		t.Errorf("GameServer %v has no match id", gs.Name)
+	}
+	waitPlayers(ctx, t, s, gs.Name, 4)
+}
+
+// TestBackfill 2人のマッチのGameServerの空席2つを、後から来た2人でBackfillする
+func TestBackfill(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Timeout)
+	defer cancel()
+	s := startTestStack(ctx, t, 2)
+	defer s.stop()
+
+	s.om.pause()
+	first := requestMatches(ctx, s, 2)
+	waitUntil(ctx, t, "2 player tickets", func() bool { return s.om.countTickets(gameMode, "player") == 2 })
+	s.om.resume()
+
+	players, err := joinMatches(ctx, first, 2)
+	defer func() { closePlayers(players) }()
+	if err != nil {
+		t.Fatal(err)
+	}
+	connection, err := sameConnection(players)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gs, err := allocatedGameServer(s, connection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitPlayers(ctx, t, s, gs.Name, 2)
+
+	// 2人が揃ってセッションが始まると、GameServerが空席2つのBackfillTicketを登録する
+	waitUntil(ctx, t, "a backfill ticket", func() bool { return s.om.countTickets(gameMode, "backfill") == 1 })
+	s.om.pause()
+	second := requestMatches(ctx, s, 2)
+	waitUntil(ctx, t, "2 more player tickets", func() bool { return s.om.countTickets(gameMode, "player") == 2 })
+	s.om.resume()
+
+	joined, err := joinMatches(ctx, second, 2)
+	players = append(players, joined...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := sameConnection(players); err != nil {
+		t.Fatal(err)
+	} else if got != connection {
+		t.Fatalf("backfilled players got %v, want the first match's %v", got, connection)
+	}
+	waitPlayers(ctx, t, s, gs.Name, 4)
+	if _, err := allocatedGameServer(s, connection); err != nil {
+		t.Fatal(err)
+	}
+	// 満席になったBackfillTicketはFrontendが削除する
+	waitUntil(ctx, t, "the backfill ticket to be deleted", func() bool { return s.om.countTickets(gameMode, "backfill") == 0 })
+}
+
+// matchResponse FrontendのGET /match/:gamemodeの応答
+type matchResponse struct {
+	IP   string `json:"ip"`
+	Port string `json:"port"`
+}
+
+// matchResult requestMatchesの1人分の結果
+type matchResult struct {
+	res matchResponse
+	err error
+}
+
+// requestMatches n人のプレイヤーとしてFrontendにマッチングを要求する。応答は返ってきた順に送る
+func requestMatches(ctx context.Context, s *stack, n int) <-chan matchResult {
+	results := make(chan matchResult, n)
+	for i := 0; i < n; i++ {
+		go func() {
+			res, err := requestMatch(ctx, s.frontendURL)
+			results <- matchResult{res: res, err: err}
+		}()
+	}
+	return results
+}
+
+func requestMatch(ctx context.Context, frontendURL string) (matchResponse, error) {
+	var res matchResponse
+	req, err := http.NewRequest("GET", frontendURL+"/match/"+gameMode, nil)
+	if err != nil {
+		return res, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return res, fmt.Errorf("failed to request a match, got %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("failed to request a match: status %v", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, fmt.Errorf("failed to decode the match, got %w", err)
+	}
+	return res, nil
+}
+
+// player GameServerに接続したプレイヤー
+type player struct {
+	connection string
+	conn       net.Conn
+}
+
+// joinMatches n人分のマッチングの結果を待ち、それぞれ割り当てられたGameServerに接続する
+func joinMatches(ctx context.Context, results <-chan matchResult, n int) ([]*player, error) {
+	var players []*player
+	for i := 0; i < n; i++ {
+		var r matchResult
+		select {
+		case <-ctx.Done():
+			return players, fmt.Errorf("timed out waiting for %v matches, got %v", n, i)
+		case r = <-results:
+		}
+		if r.err != nil {
+			return players, r.err
+		}
+		p, err := join(net.JoinHostPort(r.res.IP, r.res.Port))
+		if err != nil {
+			return players, err
+		}
+		players = append(players, p)
+	}
+	return players, nil
+}
+
+// join GameServerにUDPで接続し、応答を確認する
+// GameServerは送信元のポートごとにプレイヤーを数えるので、プレイヤーごとにソケットを分ける
+func join(connection string) (*player, error) {
+	conn, err := net.Dial("udp", connection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %v, got %w", connection, err)
+	}
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Write([]byte("HELLO\n")); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("failed to send to %v, got %w", connection, err)
+	}
+	b := make([]byte, 1024)
+	if _, err := conn.Read(b); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("no answer from %v, got %w", connection, err)
+	}
+	return &player{connection: connection, conn: conn}, nil
+}
+
+func closePlayers(players []*player) {
+	for _, p := range players {
+		p.conn.Close()
+	}
+}
+
+// sameConnection 全員が同じGameServerに割り当てられていればその接続先を返す
+func sameConnection(players []*player) (string, error) {
+	connection := players[0].connection
+	for _, p := range players[1:] {
+		if p.connection != connection {
+			return "", fmt.Errorf("players were split between %v and %v", connection, p.connection)
+		}
+	}
+	return connection, nil
+}
+
+// allocatedGameServer AllocatedなGameServerがconnectionの1台だけであることを確認して返す
+func allocatedGameServer(s *stack, connection string) (*agonesv1.GameServer, error) {
+	list, err := s.agones.client.AgonesV1().GameServers(gameServerNamespace).List(metav1.ListOptions{})
+	if err != nil {
+		return nil, err
+	}
+	var allocated []agonesv1.GameServer
+	for _, gs := range list.Items {
+		if gs.Status.State == agonesv1.GameServerStateAllocated {
+			allocated = append(allocated, gs)
+		}
+	}
+	if len(allocated) != 1 {
+		return nil, fmt.Errorf("%v GameServers are Allocated, want 1", len(allocated))
+	}
+	gs := &allocated[0]
+	if got := net.JoinHostPort(gs.Status.Address, strconv.Itoa(int(gs.Status.Ports[0].Port))); got != connection {
+		return nil, fmt.Errorf("GameServer %v is at %v, players got %v", gs.Name, got, connection)
+	}
+	return gs, nil
+}
+
+// waitPlayers GameServerがSDKでn人のプレイヤーを報告するまで待つ
+func waitPlayers(ctx context.Context, t *testing.T, s *stack, name string, n int) {
+	t.Helper()
+	waitUntil(ctx, t, fmt.Sprintf("GameServer %v to report %v players", name, n), func() bool {
+		gs, err := s.agones.gameServer(gameServerNamespace, name)
+		return err == nil && gs.Annotations[playersAnnotation] == strconv.Itoa(n)
+	})
+}
@@ -0,0 +1,17 @@
+module mod_e2e
+
+go 1.13
+
+require (
+	common v0.0.0
+	agones.dev/agones v1.3.0
+	github.com/golang/protobuf v1.5.2
+	github.com/sirupsen/logrus v1.4.2
+	google.golang.org/grpc v1.53.0
+	k8s.io/apimachinery v0.0.0-20191004074956-01f8b7d1121a
+	k8s.io/client-go v0.0.0-20191004102537-eb5b9a8cfde7
+	open-match.dev/open-match v0.9.0
+)
+
+// 全てのバイナリで共有する設定とログのパッケージ
+replace common => ../../Common/mod_common
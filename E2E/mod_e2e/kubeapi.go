@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	allocationv1 "agones.dev/agones/pkg/apis/allocation/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// fakeKubeAPI Kubernetes APIのうちAllocateServiceが使うAgonesのリソースを、fakeAgonesのClientsetで処理するHTTPサーバー
+// AllocateServiceは-kubeconfigでこのサーバーを指定して起動し、クラスタと同じくClientset経由でGameServerを割り当てる
+//
+//	POST   /apis/allocation.agones.dev/v1/namespaces/{namespace}/gameserverallocations
+//	GET    /apis/agones.dev/v1/namespaces/{namespace}/gameservers(?labelSelector=...)
+//	GET    /apis/agones.dev/v1/namespaces/{namespace}/gameservers/{name}
+//	DELETE /apis/agones.dev/v1/namespaces/{namespace}/gameservers/{name}
+type fakeKubeAPI struct {
+	agones *fakeAgones
+
+	server   *http.Server
+	listener net.Listener
+}
+
+const (
+	agonesAPIPrefix     = "/apis/agones.dev/v1/namespaces/"
+	allocationAPIPrefix = "/apis/allocation.agones.dev/v1/namespaces/"
+)
+
+// statusKind エラーと削除の応答のStatusのKind。Statusはコアグループのv1として返す
+var statusKind = schema.GroupVersionKind{Version: "v1", Kind: "Status"}
+
+// newFakeKubeAPI fakeAgonesのAPIサーバーを127.0.0.1の空いているポートで起動する
+func newFakeKubeAPI(agones *fakeAgones) (*fakeKubeAPI, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the fake kubernetes api, got %w", err)
+	}
+	k := &fakeKubeAPI{agones: agones, listener: ln}
+	mux := http.NewServeMux()
+	mux.HandleFunc(agonesAPIPrefix, k.handleGameServers)
+	mux.HandleFunc(allocationAPIPrefix, k.handleAllocations)
+	k.server = &http.Server{Handler: mux}
+	go k.server.Serve(ln)
+	return k, nil
+}
+
+func (k *fakeKubeAPI) stop() {
+	k.server.Close()
+}
+
+// writeKubeconfig このサーバーに認証なしで接続するkubeconfigをpathに書き込む
+func (k *fakeKubeAPI) writeKubeconfig(path string) error {
+	kubeconfig := fmt.Sprintf(`apiVersion: v1
+kind: Config
+clusters:
+- name: e2e
+  cluster:
+    server: http://%v
+users:
+- name: e2e
+  user: {}
+contexts:
+- name: e2e
+  context:
+    cluster: e2e
+    user: e2e
+current-context: e2e
+`, k.listener.Addr())
+	return ioutil.WriteFile(path, []byte(kubeconfig), 0600)
+}
+
+// handleGameServers GameServerの一覧、取得、削除
+func (k *fakeKubeAPI) handleGameServers(w http.ResponseWriter, r *http.Request) {
+	namespace, resource, name := splitResourcePath(r.URL.Path, agonesAPIPrefix)
+	gr := agonesv1.SchemeGroupVersion.WithResource(resource).GroupResource()
+	if resource != "gameservers" {
+		writeStatus(w, apierrors.NewNotFound(gr, name))
+		return
+	}
+	gameServers := k.agones.client.AgonesV1().GameServers(namespace)
+
+	switch {
+	case r.Method == http.MethodGet && name == "":
+		list, err := gameServers.List(metav1.ListOptions{LabelSelector: r.URL.Query().Get("labelSelector")})
+		if err != nil {
+			writeStatus(w, err)
+			return
+		}
+		writeObject(w, http.StatusOK, list, agonesv1.SchemeGroupVersion.WithKind("GameServerList"))
+	case r.Method == http.MethodGet:
+		gs, err := gameServers.Get(name, metav1.GetOptions{})
+		if err != nil {
+			writeStatus(w, err)
+			return
+		}
+		writeObject(w, http.StatusOK, gs, agonesv1.SchemeGroupVersion.WithKind("GameServer"))
+	case r.Method == http.MethodDelete && name != "":
+		if err := gameServers.Delete(name, &metav1.DeleteOptions{}); err != nil {
+			writeStatus(w, err)
+			return
+		}
+		writeObject(w, http.StatusOK, &metav1.Status{Status: metav1.StatusSuccess, Code: http.StatusOK}, statusKind)
+	default:
+		writeStatus(w, apierrors.NewMethodNotSupported(gr, r.Method))
+	}
+}
+
+// handleAllocations GameServerAllocationの作成。fakeAgonesのreactAllocationがGameServerを割り当てる
+func (k *fakeKubeAPI) handleAllocations(w http.ResponseWriter, r *http.Request) {
+	namespace, resource, name := splitResourcePath(r.URL.Path, allocationAPIPrefix)
+	gr := allocationv1.SchemeGroupVersion.WithResource(resource).GroupResource()
+	if resource != "gameserverallocations" || name != "" {
+		writeStatus(w, apierrors.NewNotFound(gr, name))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeStatus(w, apierrors.NewMethodNotSupported(gr, r.Method))
+		return
+	}
+	var gsa allocationv1.GameServerAllocation
+	if err := json.NewDecoder(r.Body).Decode(&gsa); err != nil {
+		writeStatus(w, apierrors.NewBadRequest(fmt.Sprintf("invalid GameServerAllocation: %v", err)))
+		return
+	}
+	created, err := k.agones.client.AllocationV1().GameServerAllocations(namespace).Create(&gsa)
+	if err != nil {
+		writeStatus(w, err)
+		return
+	}
+	writeObject(w, http.StatusCreated, created, allocationv1.SchemeGroupVersion.WithKind("GameServerAllocation"))
+}
+
+// splitResourcePath prefix以降の"{namespace}/{resource}/{name}"を分ける。nameは一覧と作成では空
+func splitResourcePath(path, prefix string) (namespace, resource, name string) {
+	parts := strings.SplitN(strings.TrimPrefix(path, prefix), "/", 3)
+	for len(parts) < 3 {
+		parts = append(parts, "")
+	}
+	return parts[0], parts[1], parts[2]
+}
+
+// writeObject objをKindとAPIVersionを付けてJSONで返す。fakeのClientsetが返すオブジェクトには付いていない
+func writeObject(w http.ResponseWriter, code int, obj runtime.Object, gvk schema.GroupVersionKind) {
+	obj = obj.DeepCopyObject()
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(obj); err != nil {
+		logger.WithError(err).Error("Failed to write the fake kubernetes api response")
+	}
+}
+
+// writeStatus errをKubernetes APIと同じStatusで返す。client-goはこれをStatusErrorに戻す
+func writeStatus(w http.ResponseWriter, err error) {
+	status := metav1.Status{Status: metav1.StatusFailure, Message: err.Error(), Reason: metav1.StatusReasonInternalError}
+	if apiStatus, ok := err.(apierrors.APIStatus); ok {
+		status = apiStatus.Status()
+	}
+	if status.Code == 0 {
+		status.Code = http.StatusInternalServerError
+	}
+	writeObject(w, int(status.Code), &status, statusKind)
+}
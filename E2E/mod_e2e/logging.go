@@ -0,0 +1,6 @@
+package main
+
+import "common/logging"
+
+// logger E2EのJSON形式のlogger
+var logger = logging.New("e2e")
@@ -0,0 +1,59 @@
+// Package main はクラスタなしでマッチメイクとBackfillを確認するE2Eのハーネス
+//
+// Open Match(Frontend、Backend、QueryService)、Agones(Clientset、Kubernetes API、SDKサーバー)を
+// プロセス内の偽物で置き換え、リポジトリのMMF、Director、Frontend、AllocateService、GameServerを実際にビルドして起動する
+// プレイヤーはFrontendのREST APIでマッチングを要求し、割り当てられたGameServerにUDPで接続する
+//
+//	cd E2E/mod_e2e && go test .
+//	WORK_DIR=/tmp/e2e go test -run TestBackfill -v .
+//	go run . -game-servers 16
+//
+// テストごとのプロセスのログは<work-dir>/<テスト名>/に残る
+// go runではテストを実行せず、FrontendのURLを出力してCtrl-Cまで起動したままにする(ログは<work-dir>/serve/)
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+)
+
+func main() {
+	var err error
+	if conf, err = loadConfig(os.Args[1:]); err != nil {
+		logger.WithError(err).Fatal("Failed to load the config")
+	}
+
+	workDir := conf.WorkDir
+	if workDir == "" {
+		if workDir, err = ioutil.TempDir("", "e2e"); err != nil {
+			logger.WithError(err).Fatal("Failed to create the work directory")
+		}
+	}
+	logger.Infof("Writing binaries and logs to %v", workDir)
+
+	binDir := filepath.Join(workDir, "bin")
+	if err := buildBinaries(context.Background(), conf.RepoRoot, binDir); err != nil {
+		logger.WithError(err).Fatal("Failed to build the binaries")
+	}
+	serve(binDir, filepath.Join(workDir, "serve"))
+}
+
+// serve stackを起動し、SIGINTかSIGTERMを受けるまで待つ
+func serve(binDir, logDir string) {
+	ctx, cancel := context.WithTimeout(context.Background(), conf.Timeout)
+	s, err := startStack(ctx, binDir, logDir, conf.GameServers)
+	cancel()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to start the stack")
+	}
+	defer s.stop()
+	logger.WithField("frontend", s.frontendURL).Infof("Serving with %v game servers, interrupt to stop", conf.GameServers)
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	<-sigs
+}
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/golang/protobuf/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// queryPageSize QueryTicketsの1回の応答に入れるチケットの最大数
+const queryPageSize = 1000
+
+// fakeOpenMatch Open MatchのFrontend、Backend、QueryServiceの偽物
+// チケットはメモリに保持し、FetchMatchesではリクエストのFunctionConfigのMMFを呼び出してマッチを返す
+// Open Match本体と同じく、FetchMatchesで返したマッチのチケットはAssignTickets、ReleaseTicketsか
+// pendingTTLの経過までQueryTicketsの結果から除く
+// AssignTicketsしたチケットもプールに残す(Backfillの空席数の更新はこれを前提にしている)
+type fakeOpenMatch struct {
+	mu sync.Mutex
+	// idle 実行中のFetchMatchesがなくなったら通知する
+	idle       *sync.Cond
+	tickets    map[string]*pb.Ticket
+	created    map[string]int
+	pending    map[string]time.Time
+	nextID     int
+	paused     bool
+	inflight   int
+	pendingTTL time.Duration
+
+	server   *grpc.Server
+	listener net.Listener
+}
+
+// newFakeOpenMatch 偽のOpen Matchを127.0.0.1の空いているポートで起動する
+// Frontend、Backend、QueryServiceは同じポートで提供する
+func newFakeOpenMatch(pendingTTL time.Duration) (*fakeOpenMatch, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the fake Open Match, got %w", err)
+	}
+	om := &fakeOpenMatch{
+		tickets:    map[string]*pb.Ticket{},
+		created:    map[string]int{},
+		pending:    map[string]time.Time{},
+		pendingTTL: pendingTTL,
+		server:     grpc.NewServer(),
+		listener:   ln,
+	}
+	om.idle = sync.NewCond(&om.mu)
+	pb.RegisterFrontendServiceServer(om.server, &fakeFrontend{om: om})
+	pb.RegisterBackendServiceServer(om.server, &fakeBackend{om: om})
+	pb.RegisterQueryServiceServer(om.server, &fakeQuery{om: om})
+	go om.server.Serve(ln)
+	return om, nil
+}
+
+// addr Frontend、Backend、QueryServiceのhost:port
+func (om *fakeOpenMatch) addr() string {
+	return om.listener.Addr().String()
+}
+
+func (om *fakeOpenMatch) stop() {
+	om.server.Stop()
+}
+
+// pause FetchMatchesを止め、実行中のものが終わるまで待つ
+// 止めている間のFetchMatchesはMMFを呼び出さずにマッチなしで返す
+func (om *fakeOpenMatch) pause() {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	om.paused = true
+	for om.inflight > 0 {
+		om.idle.Wait()
+	}
+}
+
+// resume pauseで止めたFetchMatchesを再開する
+func (om *fakeOpenMatch) resume() {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	om.paused = false
+}
+
+// countTickets tagsを全て持つチケットの数
+func (om *fakeOpenMatch) countTickets(tags ...string) int {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	n := 0
+	for _, t := range om.tickets {
+		if hasTags(t, tags) {
+			n++
+		}
+	}
+	return n
+}
+
+// queryable poolに合い、FetchMatchesで返していないチケットを作成順に返す。muを取得した状態で呼ぶこと
+func (om *fakeOpenMatch) queryable(pool *pb.Pool, now time.Time) []*pb.Ticket {
+	var tickets []*pb.Ticket
+	for id, t := range om.tickets {
+		if until, ok := om.pending[id]; ok {
+			if now.Before(until) {
+				continue
+			}
+			delete(om.pending, id)
+		}
+		if inPool(t, pool) {
+			tickets = append(tickets, proto.Clone(t).(*pb.Ticket))
+		}
+	}
+	sort.Slice(tickets, func(i, j int) bool {
+		return om.created[tickets[i].GetId()] < om.created[tickets[j].GetId()]
+	})
+	return tickets
+}
+
+// fakeFrontend Open MatchのFrontendService
+type fakeFrontend struct {
+	pb.UnimplementedFrontendServiceServer
+	om *fakeOpenMatch
+}
+
+func (f *fakeFrontend) CreateTicket(ctx context.Context, req *pb.CreateTicketRequest) (*pb.CreateTicketResponse, error) {
+	if req.GetTicket() == nil {
+		return nil, status.Error(codes.InvalidArgument, ".ticket is required")
+	}
+	if req.GetTicket().GetId() != "" {
+		return nil, status.Error(codes.InvalidArgument, "tickets cannot be created with an id")
+	}
+	om := f.om
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	om.nextID++
+	t := proto.Clone(req.GetTicket()).(*pb.Ticket)
+	t.Id = fmt.Sprintf("ticket-%d", om.nextID)
+	om.tickets[t.Id] = t
+	om.created[t.Id] = om.nextID
+	return &pb.CreateTicketResponse{Ticket: proto.Clone(t).(*pb.Ticket)}, nil
+}
+
+func (f *fakeFrontend) DeleteTicket(ctx context.Context, req *pb.DeleteTicketRequest) (*pb.DeleteTicketResponse, error) {
+	om := f.om
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	delete(om.tickets, req.GetTicketId())
+	delete(om.created, req.GetTicketId())
+	delete(om.pending, req.GetTicketId())
+	return &pb.DeleteTicketResponse{}, nil
+}
+
+func (f *fakeFrontend) GetTicket(ctx context.Context, req *pb.GetTicketRequest) (*pb.Ticket, error) {
+	om := f.om
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	t, ok := om.tickets[req.GetTicketId()]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "Ticket id: %s not found", req.GetTicketId())
+	}
+	return proto.Clone(t).(*pb.Ticket), nil
+}
+
+// fakeBackend Open MatchのBackendService
+type fakeBackend struct {
+	pb.UnimplementedBackendServiceServer
+	om *fakeOpenMatch
+}
+
+// FetchMatches MMFを呼び出し、他のマッチとチケットが重ならない提案だけを返す
+func (b *fakeBackend) FetchMatches(req *pb.FetchMatchesRequest, stream pb.BackendService_FetchMatchesServer) error {
+	cfg := req.GetConfig()
+	if cfg == nil || req.GetProfile() == nil {
+		return status.Error(codes.InvalidArgument, ".config and .profile are required")
+	}
+	if cfg.Type != pb.FunctionConfig_GRPC {
+		return status.Errorf(codes.InvalidArgument, "the fake backend only calls gRPC match functions, got %v", cfg.Type)
+	}
+
+	om := b.om
+	om.mu.Lock()
+	if om.paused {
+		om.mu.Unlock()
+		return nil
+	}
+	om.inflight++
+	om.mu.Unlock()
+	defer func() {
+		om.mu.Lock()
+		om.inflight--
+		om.idle.Broadcast()
+		om.mu.Unlock()
+	}()
+
+	proposals, err := runMatchFunction(stream.Context(), fmt.Sprintf("%s:%d", cfg.Host, cfg.Port), req.GetProfile())
+	if err != nil {
+		return status.Errorf(codes.Unavailable, "failed to run the match function, got %v", err)
+	}
+
+	for _, match := range om.evaluate(proposals, time.Now()) {
+		if err := stream.Send(&pb.FetchMatchesResponse{Match: match}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// evaluate 先に提案されたマッチとチケットが重なるもの、削除またはpendingのチケットを含むものを捨て、
+// 残ったマッチのチケットをpendingにする
+func (om *fakeOpenMatch) evaluate(proposals []*pb.Match, now time.Time) []*pb.Match {
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	used := map[string]bool{}
+	var matches []*pb.Match
+	for _, match := range proposals {
+		ok := true
+		for _, t := range match.GetTickets() {
+			if _, exists := om.tickets[t.GetId()]; !exists || used[t.GetId()] {
+				ok = false
+				break
+			}
+			// 同時に実行された別のFetchMatchesが返したチケット
+			if until, pending := om.pending[t.GetId()]; pending && now.Before(until) {
+				ok = false
+				break
+			}
+		}
+		if !ok {
+			continue
+		}
+		for _, t := range match.GetTickets() {
+			used[t.GetId()] = true
+			om.pending[t.GetId()] = now.Add(om.pendingTTL)
+		}
+		matches = append(matches, match)
+	}
+	return matches
+}
+
+func (b *fakeBackend) AssignTickets(ctx context.Context, req *pb.AssignTicketsRequest) (*pb.AssignTicketsResponse, error) {
+	om := b.om
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	for _, id := range req.GetTicketIds() {
+		if _, ok := om.tickets[id]; !ok {
+			return nil, status.Errorf(codes.NotFound, "Ticket id: %s not found", id)
+		}
+	}
+	for _, id := range req.GetTicketIds() {
+		om.tickets[id].Assignment = proto.Clone(req.GetAssignment()).(*pb.Assignment)
+		delete(om.pending, id)
+	}
+	return &pb.AssignTicketsResponse{}, nil
+}
+
+func (b *fakeBackend) ReleaseTickets(ctx context.Context, req *pb.ReleaseTicketsRequest) (*pb.ReleaseTicketsResponse, error) {
+	om := b.om
+	om.mu.Lock()
+	defer om.mu.Unlock()
+	for _, id := range req.GetTicketIds() {
+		delete(om.pending, id)
+	}
+	return &pb.ReleaseTicketsResponse{}, nil
+}
+
+// fakeQuery Open MatchのQueryService
+type fakeQuery struct {
+	pb.UnimplementedQueryServiceServer
+	om *fakeOpenMatch
+}
+
+func (q *fakeQuery) QueryTickets(req *pb.QueryTicketsRequest, stream pb.QueryService_QueryTicketsServer) error {
+	if req.GetPool() == nil {
+		return status.Error(codes.InvalidArgument, ".pool is required")
+	}
+	q.om.mu.Lock()
+	tickets := q.om.queryable(req.GetPool(), time.Now())
+	q.om.mu.Unlock()
+
+	for start := 0; start < len(tickets); start += queryPageSize {
+		end := start + queryPageSize
+		if end > len(tickets) {
+			end = len(tickets)
+		}
+		if err := stream.Send(&pb.QueryTicketsResponse{Tickets: tickets[start:end]}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runMatchFunction MMFのRunを呼び出し、提案されたマッチを全て受け取る
+func runMatchFunction(ctx context.Context, addr string, profile *pb.MatchProfile) ([]*pb.Match, error) {
+	conn, err := grpc.Dial(addr, grpc.WithInsecure())
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	stream, err := pb.NewMatchFunctionClient(conn).Run(ctx, &pb.RunRequest{Profile: profile})
+	if err != nil {
+		return nil, err
+	}
+	var proposals []*pb.Match
+	for {
+		resp, err := stream.Recv()
+		if err == io.EOF {
+			return proposals, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		proposals = append(proposals, resp.GetProposal())
+	}
+}
+
+// inPool チケットがpoolのフィルタを全て満たすか
+func inPool(t *pb.Ticket, pool *pb.Pool) bool {
+	fields := t.GetSearchFields()
+	for _, f := range pool.GetTagPresentFilters() {
+		if !hasTags(t, []string{f.GetTag()}) {
+			return false
+		}
+	}
+	for _, f := range pool.GetStringEqualsFilters() {
+		if v, ok := fields.GetStringArgs()[f.GetStringArg()]; !ok || v != f.GetValue() {
+			return false
+		}
+	}
+	for _, f := range pool.GetDoubleRangeFilters() {
+		v, ok := fields.GetDoubleArgs()[f.GetDoubleArg()]
+		if !ok || v < f.GetMin() || v > f.GetMax() {
+			return false
+		}
+	}
+	return true
+}
+
+// hasTags チケットがtagsを全て持つか
+func hasTags(t *pb.Ticket, tags []string) bool {
+	for _, tag := range tags {
+		found := false
+		for _, got := range t.GetSearchFields().GetTags() {
+			if got == tag {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"time"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"agones.dev/agones/pkg/sdk"
+	"google.golang.org/grpc"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+)
+
+// sdkMetadataPrefix SDKで設定したラベルとアノテーションのキーの接頭辞(Agonesのサイドカーと同じ)
+const sdkMetadataPrefix = "agones.dev/sdk-"
+
+// fakeSDKServer AgonesのSDKサーバー(サイドカー)の偽物
+// GameServer1台ごとに起動し、SDKの呼び出しをfakeAgonesのGameServerに反映する
+type fakeSDKServer struct {
+	agones    *fakeAgones
+	namespace string
+	name      string
+
+	server   *grpc.Server
+	listener net.Listener
+}
+
+// newFakeSDKServer GameServerのSDKサーバーを127.0.0.1の空いているポートで起動する
+// GameServerのプロセスには環境変数AGONES_SDK_GRPC_PORTでポートを渡す
+func newFakeSDKServer(agones *fakeAgones, namespace, name string) (*fakeSDKServer, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen for the fake SDK server, got %w", err)
+	}
+	s := &fakeSDKServer{
+		agones:    agones,
+		namespace: namespace,
+		name:      name,
+		server:    grpc.NewServer(),
+		listener:  ln,
+	}
+	sdk.RegisterSDKServer(s.server, s)
+	go s.server.Serve(ln)
+	return s, nil
+}
+
+func (s *fakeSDKServer) port() int {
+	return s.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (s *fakeSDKServer) stop() {
+	s.server.Stop()
+}
+
+// setState GameServerの状態を変更する
+func (s *fakeSDKServer) setState(state agonesv1.GameServerState) error {
+	return s.agones.updateGameServer(s.namespace, s.name, func(gs *agonesv1.GameServer) {
+		gs.Status.State = state
+	})
+}
+
+func (s *fakeSDKServer) Ready(ctx context.Context, e *sdk.Empty) (*sdk.Empty, error) {
+	return e, s.setState(agonesv1.GameServerStateReady)
+}
+
+func (s *fakeSDKServer) Allocate(ctx context.Context, e *sdk.Empty) (*sdk.Empty, error) {
+	return e, s.setState(agonesv1.GameServerStateAllocated)
+}
+
+func (s *fakeSDKServer) Shutdown(ctx context.Context, e *sdk.Empty) (*sdk.Empty, error) {
+	return e, s.setState(agonesv1.GameServerStateShutdown)
+}
+
+// Health ヘルスチェックのpingを受け取るだけで、pingが途絶えてもUnhealthyにはしない
+func (s *fakeSDKServer) Health(stream sdk.SDK_HealthServer) error {
+	for {
+		if _, err := stream.Recv(); err != nil {
+			if err == io.EOF {
+				return stream.SendAndClose(&sdk.Empty{})
+			}
+			return err
+		}
+	}
+}
+
+func (s *fakeSDKServer) GetGameServer(ctx context.Context, e *sdk.Empty) (*sdk.GameServer, error) {
+	gs, err := s.agones.gameServer(s.namespace, s.name)
+	if err != nil {
+		return nil, err
+	}
+	return toSDKGameServer(gs), nil
+}
+
+// WatchGameServer GameServerが変更されるたびに送信する
+func (s *fakeSDKServer) WatchGameServer(e *sdk.Empty, stream sdk.SDK_WatchGameServerServer) error {
+	w, err := s.agones.client.AgonesV1().GameServers(s.namespace).Watch(metav1.ListOptions{})
+	if err != nil {
+		return err
+	}
+	defer w.Stop()
+	for {
+		select {
+		case <-stream.Context().Done():
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+			gs, isGameServer := event.Object.(*agonesv1.GameServer)
+			if !isGameServer || gs.Name != s.name || event.Type == watch.Deleted {
+				continue
+			}
+			if err := stream.Send(toSDKGameServer(gs)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+func (s *fakeSDKServer) SetLabel(ctx context.Context, kv *sdk.KeyValue) (*sdk.Empty, error) {
+	return &sdk.Empty{}, s.agones.updateGameServer(s.namespace, s.name, func(gs *agonesv1.GameServer) {
+		if gs.Labels == nil {
+			gs.Labels = map[string]string{}
+		}
+		gs.Labels[sdkMetadataPrefix+kv.GetKey()] = kv.GetValue()
+	})
+}
+
+func (s *fakeSDKServer) SetAnnotation(ctx context.Context, kv *sdk.KeyValue) (*sdk.Empty, error) {
+	return &sdk.Empty{}, s.agones.updateGameServer(s.namespace, s.name, func(gs *agonesv1.GameServer) {
+		if gs.Annotations == nil {
+			gs.Annotations = map[string]string{}
+		}
+		gs.Annotations[sdkMetadataPrefix+kv.GetKey()] = kv.GetValue()
+	})
+}
+
+// Reserve 指定された秒数だけReservedにし、その間に割り当てられなければReadyに戻す
+func (s *fakeSDKServer) Reserve(ctx context.Context, d *sdk.Duration) (*sdk.Empty, error) {
+	if err := s.setState(agonesv1.GameServerStateReserved); err != nil {
+		return nil, err
+	}
+	if d.GetSeconds() > 0 {
+		time.AfterFunc(time.Duration(d.GetSeconds())*time.Second, func() {
+			s.agones.updateGameServer(s.namespace, s.name, func(gs *agonesv1.GameServer) {
+				if gs.Status.State == agonesv1.GameServerStateReserved {
+					gs.Status.State = agonesv1.GameServerStateReady
+				}
+			})
+		})
+	}
+	return &sdk.Empty{}, nil
+}
+
+// toSDKGameServer SDKに返すGameServer
+func toSDKGameServer(gs *agonesv1.GameServer) *sdk.GameServer {
+	res := &sdk.GameServer{
+		ObjectMeta: &sdk.GameServer_ObjectMeta{
+			Name:        gs.Name,
+			Namespace:   gs.Namespace,
+			Labels:      gs.Labels,
+			Annotations: gs.Annotations,
+		},
+		Status: &sdk.GameServer_Status{
+			State:   string(gs.Status.State),
+			Address: gs.Status.Address,
+		},
+	}
+	for _, p := range gs.Status.Ports {
+		res.Status.Ports = append(res.Status.Ports, &sdk.GameServer_Status_Port{Name: p.Name, Port: p.Port})
+	}
+	return res
+}
@@ -0,0 +1,379 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	agonesv1 "agones.dev/agones/pkg/apis/agones/v1"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// gameServerNamespace, gameServerFleet DirectorのgameModesの割り当て先と同じnamespaceとFleet
+	gameServerNamespace = "default"
+	gameServerFleet     = "simple-udp"
+
+	// allocatorKey, allocatorSecret DirectorとAllocateServiceの間の認証情報
+	allocatorKey    = "e2e"
+	allocatorSecret = "e2e-secret"
+)
+
+// binaries E2Eで起動するバイナリ名 -> リポジトリのルートからのモジュールのディレクトリ
+var binaries = map[string]string{
+	"matchfunction": "OpenMatch/mod_matchmaker101/matchfunction",
+	"director":      "OpenMatch/mod_matchmaker101/director",
+	"allocator":     "AllocateService/mod_allocator-service",
+	"frontend":      "OpenMatch/mod_matchmaker101/frontend",
+	"simple-udp":    "GameServer/mod_simple-udp",
+}
+
+// buildBinaries 各モジュールをビルドしてbinDirに出力する
+func buildBinaries(ctx context.Context, repoRoot, binDir string) error {
+	for name, dir := range binaries {
+		logger.WithField("binary", name).Info("Building")
+		cmd := exec.CommandContext(ctx, "go", "build", "-o", filepath.Join(binDir, name), ".")
+		cmd.Dir = filepath.Join(repoRoot, dir)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("failed to build %v, got %w\n%s", name, err, out)
+		}
+	}
+	return nil
+}
+
+// stack 1つのテストで使う偽のOpen MatchとAgonesと、リポジトリの各バイナリのプロセス
+type stack struct {
+	binDir string
+	logDir string
+
+	om      *fakeOpenMatch
+	agones  *fakeAgones
+	kubeAPI *fakeKubeAPI
+	sdks    []*fakeSDKServer
+	procs   []*process
+
+	// frontendURL FrontendのREST APIのURL
+	frontendURL string
+}
+
+// startStack 偽のサービスを起動し、MMF、AllocateService、Director、Frontendと、gameServers台のGameServerを起動する
+// プロセスのログはlogDirに<名前>.logとして出力する
+func startStack(ctx context.Context, binDir, logDir string, gameServers int) (*stack, error) {
+	s := &stack{binDir: binDir, logDir: logDir}
+	if err := s.start(ctx, gameServers); err != nil {
+		s.stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *stack) start(ctx context.Context, gameServers int) error {
+	if err := os.MkdirAll(s.logDir, 0755); err != nil {
+		return err
+	}
+
+	var err error
+	// MMFのFetchMatchesで返したチケットはDirectorがすぐにAssignTicketsするので、pendingは短くてよい
+	if s.om, err = newFakeOpenMatch(5 * time.Second); err != nil {
+		return err
+	}
+	s.agones = newFakeAgones()
+	if s.kubeAPI, err = newFakeKubeAPI(s.agones); err != nil {
+		return err
+	}
+
+	// MMF
+	mmfPort, err := freeTCPPort()
+	if err != nil {
+		return err
+	}
+	if err := s.run("matchfunction", nil, "-query-service", s.om.addr(), "-port", strconv.Itoa(mmfPort), "-metrics-port", s.metricsPort()); err != nil {
+		return err
+	}
+	if err := waitTCP(ctx, fmt.Sprintf("127.0.0.1:%d", mmfPort)); err != nil {
+		return fmt.Errorf("matchfunction did not start, got %w", err)
+	}
+
+	// AllocateService。fakeKubeAPIのkubeconfigで、fakeAgonesのGameServerを割り当てる
+	allocatorGRPCPort, err := s.startAllocator(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Director。AllocateServiceの認証情報はSecretのマウントと同じ形式でファイルに置く
+	credentialsDir := filepath.Join(s.logDir, "allocator-credentials")
+	if err := writeCredentials(credentialsDir, allocatorKey, allocatorSecret); err != nil {
+		return err
+	}
+	if err := s.run("director", nil,
+		"-om-frontend", s.om.addr(),
+		"-om-backend", s.om.addr(),
+		"-om-query", s.om.addr(),
+		"-function-host", "127.0.0.1",
+		"-function-port", strconv.Itoa(mmfPort),
+		"-allocator", fmt.Sprintf("127.0.0.1:%d", allocatorGRPCPort),
+		"-allocator-credentials-dir", credentialsDir,
+		"-metrics-port", s.metricsPort(),
+	); err != nil {
+		return err
+	}
+
+	// Frontend
+	frontendPort, err := freeTCPPort()
+	if err != nil {
+		return err
+	}
+	if err := s.run("frontend", nil, "-om-frontend", s.om.addr(), "-port", strconv.Itoa(frontendPort), "-poll-interval", "100ms"); err != nil {
+		return err
+	}
+	s.frontendURL = fmt.Sprintf("http://127.0.0.1:%d", frontendPort)
+	if err := waitHTTP(ctx, s.frontendURL+"/metrics"); err != nil {
+		return fmt.Errorf("frontend did not start, got %w", err)
+	}
+
+	for i := 0; i < gameServers; i++ {
+		if err := s.startGameServer(ctx, fmt.Sprintf("%v-%d", gameServerFleet, i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// startAllocator AllocateServiceを起動し、/healthzが応答するまで待ってgRPCのポートを返す
+// クライアントの認証情報はAllocateServiceのSecretのマウントと同じく、キーのファイル名にシークレットを書いて置く
+func (s *stack) startAllocator(ctx context.Context) (int, error) {
+	kubeconfig := filepath.Join(s.logDir, "kubeconfig")
+	if err := s.kubeAPI.writeKubeconfig(kubeconfig); err != nil {
+		return 0, err
+	}
+	clientsDir := filepath.Join(s.logDir, "allocator-clients")
+	if err := os.MkdirAll(clientsDir, 0700); err != nil {
+		return 0, err
+	}
+	if err := ioutil.WriteFile(filepath.Join(clientsDir, allocatorKey), []byte(allocatorSecret), 0600); err != nil {
+		return 0, err
+	}
+
+	port, err := freeTCPPort()
+	if err != nil {
+		return 0, err
+	}
+	grpcPort, err := freeTCPPort()
+	if err != nil {
+		return 0, err
+	}
+	// 環境変数はフラグより優先されるので、シェルのKUBECONFIGで本物のクラスタを使わないよう環境変数で渡す
+	env := []string{"KUBECONFIG=" + kubeconfig}
+	if err := s.run("allocator", env,
+		"-port", strconv.Itoa(port),
+		"-grpc-port", strconv.Itoa(grpcPort),
+		"-credentials-dir", clientsDir,
+		"-allowed-fleets", gameServerNamespace+"/"+gameServerFleet,
+	); err != nil {
+		return 0, err
+	}
+	if err := waitHTTP(ctx, fmt.Sprintf("http://127.0.0.1:%d/healthz", port)); err != nil {
+		return 0, fmt.Errorf("allocator did not start, got %w", err)
+	}
+	if err := waitTCP(ctx, fmt.Sprintf("127.0.0.1:%d", grpcPort)); err != nil {
+		return 0, fmt.Errorf("allocator did not start, got %w", err)
+	}
+	return grpcPort, nil
+}
+
+// startGameServer GameServerを登録し、SDKサーバーとsimple-udpを起動してReadyになるまで待つ
+func (s *stack) startGameServer(ctx context.Context, name string) error {
+	port, err := freeUDPPort()
+	if err != nil {
+		return err
+	}
+	if err := s.agones.addGameServer(gameServerNamespace, gameServerFleet, name, port); err != nil {
+		return err
+	}
+	sdkServer, err := newFakeSDKServer(s.agones, gameServerNamespace, name)
+	if err != nil {
+		return err
+	}
+	s.sdks = append(s.sdks, sdkServer)
+
+	env := []string{
+		"AGONES_SDK_GRPC_PORT=" + strconv.Itoa(sdkServer.port()),
+		"OTEL_TRACES_EXPORTER=none",
+	}
+	// セッションが終わったGameServerはFleetに戻し、go runで起動したstackで繰り返しマッチに使えるようにする
+	if err := s.run(name, env,
+		"-port", strconv.Itoa(port),
+		"-backfillendpoint", s.frontendURL+"/backend",
+		"-metricsport", s.metricsPort(),
+		"-idleaction", "ready",
+		"-emptytimeout", "5",
+	); err != nil {
+		return err
+	}
+	return waitFor(ctx, fmt.Sprintf("GameServer %v to be Ready", name), func() bool {
+		gs, err := s.agones.gameServer(gameServerNamespace, name)
+		return err == nil && gs.Status.State == agonesv1.GameServerStateReady
+	})
+}
+
+// metricsPort /metricsに使う空いているポート。取得に失敗した場合は0にしてバイナリの設定の検証で落とす
+func (s *stack) metricsPort() string {
+	port, err := freeTCPPort()
+	if err != nil {
+		logger.WithError(err).Warn("Failed to find a free port for metrics")
+	}
+	return strconv.Itoa(port)
+}
+
+// run binDirのバイナリnameを起動する。GameServerはsimple-udpを名前を変えて起動する
+func (s *stack) run(name string, env []string, args ...string) error {
+	bin := name
+	if _, ok := binaries[name]; !ok {
+		bin = "simple-udp"
+	}
+	p, err := startProcess(name, filepath.Join(s.binDir, bin), filepath.Join(s.logDir, name+".log"), env, args)
+	if err != nil {
+		return err
+	}
+	s.procs = append(s.procs, p)
+	return nil
+}
+
+// stop 起動した順と逆にプロセスを止め、偽のサービスを止める
+func (s *stack) stop() {
+	for i := len(s.procs) - 1; i >= 0; i-- {
+		s.procs[i].stop()
+	}
+	for _, sdkServer := range s.sdks {
+		sdkServer.stop()
+	}
+	if s.kubeAPI != nil {
+		s.kubeAPI.stop()
+	}
+	if s.om != nil {
+		s.om.stop()
+	}
+}
+
+// process ログをファイルに出力する子プロセス
+type process struct {
+	name string
+	cmd  *exec.Cmd
+	log  *os.File
+	done chan struct{}
+}
+
+func startProcess(name, bin, logPath string, env []string, args []string) (*process, error) {
+	log, err := os.Create(logPath)
+	if err != nil {
+		return nil, err
+	}
+	cmd := exec.Command(bin, args...)
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = log
+	cmd.Stderr = log
+	if err := cmd.Start(); err != nil {
+		log.Close()
+		return nil, fmt.Errorf("failed to start %v, got %w", name, err)
+	}
+	p := &process{name: name, cmd: cmd, log: log, done: make(chan struct{})}
+	go func() {
+		err := cmd.Wait()
+		logger.WithFields(logrus.Fields{"process": name, "log": logPath}).WithError(err).Debug("Process exited")
+		close(p.done)
+	}()
+	return p, nil
+}
+
+// stop SIGINTで止め、5秒で終わらなければkillする
+func (p *process) stop() {
+	defer p.log.Close()
+	select {
+	case <-p.done:
+		return
+	default:
+	}
+	p.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		p.cmd.Process.Kill()
+		<-p.done
+	}
+}
+
+// writeCredentials AllocateServiceの認証情報をdirの"key"と"secret"に書き込む
+func writeCredentials(dir, key, secret string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "key"), []byte(key), 0600); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(filepath.Join(dir, "secret"), []byte(secret), 0600)
+}
+
+// freeTCPPort 127.0.0.1の空いているTCPのポート
+func freeTCPPort() (int, error) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer ln.Close()
+	return ln.Addr().(*net.TCPAddr).Port, nil
+}
+
+// freeUDPPort 127.0.0.1の空いているUDPのポート
+func freeUDPPort() (int, error) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port, nil
+}
+
+// waitTCP addrに接続できるまで待つ
+func waitTCP(ctx context.Context, addr string) error {
+	return waitFor(ctx, addr, func() bool {
+		conn, err := net.DialTimeout("tcp", addr, time.Second)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	})
+}
+
+// waitHTTP urlが200を返すまで待つ
+func waitHTTP(ctx context.Context, url string) error {
+	return waitFor(ctx, url, func() bool {
+		resp, err := http.Get(url)
+		if err != nil {
+			return false
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusOK
+	})
+}
+
+// waitFor condがtrueになるまで100msごとに確認する。ctxが終了したらwhatを含むエラーを返す
+func waitFor(ctx context.Context, what string, cond func() bool) error {
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+	for !cond() {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("timed out waiting for %v", what)
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
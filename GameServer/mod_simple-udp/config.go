@@ -0,0 +1,74 @@
+package main
+
+import (
+	"strings"
+
+	"common/config"
+)
+
+// gameServerConfig GameServerの待ち受け、セッション、BackfillEndpointの設定
+type gameServerConfig struct {
+	// Port UDPのポート。Passthroughの場合はSDKから取得したポートで上書きする
+	Port int
+	// Passthrough ポートをSDKから取得する
+	Passthrough bool
+	// ReadyOnStart 起動時にReadyにする
+	ReadyOnStart bool
+	// GameMode Directorからゲームモードの通知がない場合のゲームモード
+	GameMode string
+	// EmptyTimeout, JoinTimeout, MaxSession セッションの各タイムアウト(秒)。EmptyTimeoutとMaxSessionは0なら無効
+	EmptyTimeout int
+	JoinTimeout  int
+	MaxSession   int
+	// IdleAction セッション終了後の動作("shutdown"か"ready")
+	IdleAction string
+	// MetricsPort /metricsを公開するHTTPのポート
+	MetricsPort int
+	// BackfillEndpoint FrontendのBackfillEndpointのURL。末尾にゲームモードを付けて呼び出す
+	BackfillEndpoint string
+	// BackfillHeartbeat 空席がある間BackfillTicketを登録し直す間隔(秒)。FrontendのBACKFILL_TTLより短くする
+	// 0なら空席数が変わったときだけ登録する
+	BackfillHeartbeat int
+}
+
+// serverConf GameServerの設定。mainの最初で読み込む
+var serverConf gameServerConfig
+
+// loadConfig 設定を既定値、設定ファイル、フラグ、環境変数の順に読み込んで検証する
+func loadConfig(args []string) (gameServerConfig, error) {
+	var c gameServerConfig
+	l := config.NewLoader()
+	l.IntVar(&c.Port, "port", "PORT", 7654, "The port to listen to udp traffic on")
+	l.BoolVar(&c.Passthrough, "passthrough", "PASSTHROUGH", false, "Get listening port from the SDK, rather than use the 'port' value")
+	l.BoolVar(&c.ReadyOnStart, "ready", "READY", true, "Mark this GameServer as Ready on startup")
+	l.StringVar(&c.GameMode, "gamemode", "GAMEMODE", "mode.demo", "The game mode used for backfill requests when the director does not send one")
+	l.IntVar(&c.EmptyTimeout, "emptytimeout", "EMPTY_TIMEOUT", 60, "End the session after it has been empty for this many seconds (0 disables)")
+	l.IntVar(&c.JoinTimeout, "jointimeout", "JOIN_TIMEOUT", 10, "Start the session after this many seconds even if not all assigned players have connected")
+	l.IntVar(&c.MaxSession, "maxsession", "MAX_SESSION", 0, "End the session after this many seconds (0 disables)")
+	l.StringVar(&c.IdleAction, "idleaction", "IDLE_ACTION", "shutdown", "What to do when a session ends: 'shutdown' or 'ready'")
+	l.IntVar(&c.MetricsPort, "metricsport", "METRICS_PORT", 9090, "The port to serve Prometheus metrics on")
+	l.StringVar(&c.BackfillEndpoint, "backfillendpoint", "BACKFILL_ENDPOINT", "http://frontend-endpoint.openmatch.svc.cluster.local/backend", "URL of the frontend backfill endpoint, the game mode is appended to the path")
+	l.IntVar(&c.BackfillHeartbeat, "backfillheartbeat", "BACKFILL_HEARTBEAT", 20, "Register the backfill ticket again every this many seconds while seats are open, shorter than the frontend BACKFILL_TTL (0 disables)")
+	if err := l.Load(args); err != nil {
+		return c, err
+	}
+	c.IdleAction = strings.ToLower(c.IdleAction)
+	return c, c.validate()
+}
+
+// validate 設定の誤りをまとめて返す
+func (c gameServerConfig) validate() error {
+	var errs config.Errors
+	errs.Port("port", c.Port)
+	errs.NonEmpty("gamemode", c.GameMode)
+	errs.NonNegative("emptytimeout", c.EmptyTimeout)
+	errs.NonNegative("jointimeout", c.JoinTimeout)
+	errs.NonNegative("maxsession", c.MaxSession)
+	if c.IdleAction != "shutdown" && c.IdleAction != "ready" {
+		errs.Add("idleaction must be 'shutdown' or 'ready', got %q", c.IdleAction)
+	}
+	errs.Port("metricsport", c.MetricsPort)
+	errs.HTTPURL("backfillendpoint", c.BackfillEndpoint)
+	errs.NonNegative("backfillheartbeat", c.BackfillHeartbeat)
+	return errs.Err()
+}
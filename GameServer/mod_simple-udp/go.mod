@@ -2,4 +2,16 @@ module mod_simple-udp
 
 go 1.13
 
-require agones.dev/agones v1.3.0
+require (
+	common v0.0.0
+	agones.dev/agones v1.3.0
+	github.com/prometheus/client_golang v0.9.2
+	github.com/sirupsen/logrus v1.4.2
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.14.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.14.0
+	go.opentelemetry.io/otel/sdk v1.14.0
+)
+
+// 全てのバイナリで共有する設定とログのパッケージ
+replace common => ../../Common/mod_common
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"common/logging"
+)
+
+// logger GameServerのJSON形式のlogger
+var logger = newLogger("simple-udp")
+
+// newLogger 共通のJSON形式のloggerを作り、環境変数GAMESERVER_NAME(FleetでPod名を渡す)があれば
+// 全てのログにGameServer名を付ける
+func newLogger(service string) *logrus.Entry {
+	entry := logging.New(service)
+	if name := os.Getenv("GAMESERVER_NAME"); name != "" {
+		entry = entry.WithField(logging.FieldGameServer, name)
+	}
+	return entry
+}
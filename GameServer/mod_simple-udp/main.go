@@ -3,84 +3,100 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"flag"
-	"log"
+	"fmt"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	coresdk "agones.dev/agones/pkg/sdk"
 	"agones.dev/agones/pkg/util/signals"
 	sdk "agones.dev/agones/sdks/go"
-)
+	"github.com/sirupsen/logrus"
 
-const (
-	// OpenMatchのBackfillEndpoint
-	mfBackfillEndpoint = "match-frontend.svc.cluster.local:80/backend"
+	"common/logging"
 )
 
 const maxPlayerNum = 4
 
-var addrs = map[uint]net.Addr{}
+var (
+	// mu addrsとcurrentSessionを保護する
+	mu    sync.Mutex
+	addrs = map[uint]net.Addr{}
+)
+
+// defaultGameMode Directorからゲームモードの通知がない場合のゲームモード(BackfillEndpointのパスに使用)
+var defaultGameMode string
 
 // main starts a UDP server that received 1024 byte sized packets at at time
 // converts the bytes to a string, and logs the output
 func main() {
 	go doSignal()
 
-	port := flag.String("port", "7654", "The port to listen to udp traffic on")
-	passthrough := flag.Bool("passthrough", false, "Get listening port from the SDK, rather than use the 'port' value")
-	readyOnStart := flag.Bool("ready", true, "Mark this GameServer as Ready on startup")
-	flag.Parse()
-	if ep := os.Getenv("PORT"); ep != "" {
-		port = &ep
+	var err error
+	if serverConf, err = loadConfig(os.Args[1:]); err != nil {
+		logger.WithError(err).Fatal("Could not load the config")
 	}
-	if epass := os.Getenv("PASSTHROUGH"); epass != "" {
-		p := strings.ToUpper(epass) == "TRUE"
-		passthrough = &p
+	defaultGameMode = serverConf.GameMode
+
+	conf := sessionConfig{
+		emptyTimeout:      time.Duration(serverConf.EmptyTimeout) * time.Second,
+		joinTimeout:       time.Duration(serverConf.JoinTimeout) * time.Second,
+		maxDuration:       time.Duration(serverConf.MaxSession) * time.Second,
+		returnToReady:     serverConf.IdleAction == "ready",
+		backfillHeartbeat: time.Duration(serverConf.BackfillHeartbeat) * time.Second,
 	}
-	if eready := os.Getenv("READY"); eready != "" {
-		r := strings.ToUpper(eready) == "TRUE"
-		readyOnStart = &r
+
+	logger.Infof("Serving metrics on port %v", serverConf.MetricsPort)
+	go serveMetrics(serverConf.MetricsPort)
+
+	if err := setupTracing(context.Background(), "simple-udp"); err != nil {
+		logger.WithError(err).Fatal("Could not set up tracing")
 	}
 
-	log.Print("Creating SDK instance")
+	logger.Info("Creating SDK instance")
 	s, err := sdk.NewSDK()
 	if err != nil {
-		log.Fatalf("Could not connect to sdk: %v", err)
+		logger.WithError(err).Fatal("Could not connect to sdk")
 	}
 
-	log.Print("Starting Health Ping")
+	logger.Info("Starting Health Ping")
 	stop := make(chan struct{})
 	go doHealth(s, stop)
 
-	if *passthrough {
+	port := serverConf.Port
+	if serverConf.Passthrough {
 		var gs *coresdk.GameServer
 		gs, err = s.GameServer()
 		if err != nil {
-			log.Fatalf("Could not get gameserver port details: %s", err)
+			logger.WithError(err).Fatal("Could not get gameserver port details")
 		}
 
-		p := strconv.FormatInt(int64(gs.Status.Ports[0].Port), 10)
-		port = &p
+		port = int(gs.Status.Ports[0].Port)
 	}
 
-	log.Printf("Starting UDP server, listening on port %s", *port)
-	conn, err := net.ListenPacket("udp", ":"+*port)
+	logger.Infof("Starting UDP server, listening on port %v", port)
+	conn, err := net.ListenPacket("udp", fmt.Sprintf(":%d", port))
 	if err != nil {
-		log.Fatalf("Could not start udp server: %v", err)
+		logger.WithError(err).Fatal("Could not start udp server")
 	}
 	defer conn.Close() // nolint: errcheck
 
-	if *readyOnStart {
-		log.Print("Marking this server as ready")
-		ready(s)
+	if serverConf.ReadyOnStart {
+		logger.Info("Marking this server as ready")
+		if err := ready(s); err != nil {
+			logger.WithError(err).Fatal("Could not send ready message")
+		}
 	}
 
+	go watchSession(s, conf)
+
 	readWriteLoop(conn, stop, s)
 }
 
@@ -88,25 +104,39 @@ func main() {
 func doSignal() {
 	stop := signals.NewStopChannel()
 	<-stop
-	log.Println("Exit signal received. Shutting down.")
+	logger.Info("Exit signal received. Shutting down.")
+	flushTracing()
 	os.Exit(0)
 }
 
 func readWriteLoop(conn net.PacketConn, stop chan struct{}, s *sdk.SDK) {
 	b := make([]byte, 1024)
-	var connection string
 	for {
-		sender, txt := readPacket(conn, b)
+		sender, txt, err := readPacket(conn, b)
+		if err != nil {
+			logger.WithError(err).Error("Could not read from udp stream")
+			continue
+		}
+		parts := strings.Split(strings.TrimSpace(txt), " ")
 
 		var recvPort uint
 		switch addr := sender.(type) {
 		case *net.UDPAddr:
 			recvPort = uint(addr.Port)
-			addrs[recvPort] = addr
+			// Directorからの通知はプレイヤーとして扱わない
+			if parts[0] != "CONNECTION" {
+				mu.Lock()
+				_, joined := addrs[recvPort]
+				addrs[recvPort] = addr
+				connection, traceparent := currentSession.connection, currentSession.traceparent
+				mu.Unlock()
+				// セッション中に新しく接続したプレイヤーをマッチのトレースに記録する
+				if !joined && connection != "" && traceparent != "" {
+					recordPlayerJoin(traceparent, connection, addr.String())
+				}
+			}
 		}
 
-		parts := strings.Split(strings.TrimSpace(txt), " ")
-
 		switch parts[0] {
 		// shuts down the gameserver
 		case "EXIT":
@@ -119,171 +149,213 @@ func readWriteLoop(conn net.PacketConn, stop chan struct{}, s *sdk.SDK) {
 			close(stop)
 
 		case "GAMESERVER":
-			respond(conn, sender, gameServerName(s))
+			name, err := gameServerName(s)
+			if err != nil {
+				respondError(conn, sender, "Could not retrieve GameServer", err)
+				continue
+			}
+			respond(conn, sender, name)
 
 		case "READY":
-			ready(s)
+			if err := ready(s); err != nil {
+				respondError(conn, sender, "Could not send ready message", err)
+				continue
+			}
 
 		case "ALLOCATE":
-			allocate(s)
+			if err := allocate(s); err != nil {
+				respondError(conn, sender, "Could not allocate gameserver", err)
+				continue
+			}
 
 		case "RESERVE":
-			reserve(s)
+			if err := reserve(s); err != nil {
+				respondError(conn, sender, "Could not reserve gameserver", err)
+				continue
+			}
 
 		case "WATCH":
-			watchGameServerEvents(s)
+			if err := watchGameServerEvents(s); err != nil {
+				respondError(conn, sender, "Could not watch Game Server events", err)
+				continue
+			}
 
 		case "LABEL":
+			var err error
 			switch len(parts) {
 			case 1:
 				// legacy format
-				setLabel(s, "timestamp", strconv.FormatInt(time.Now().Unix(), 10))
+				err = setLabel(s, "timestamp", strconv.FormatInt(time.Now().Unix(), 10))
 			case 3:
-				setLabel(s, parts[1], parts[2])
+				err = setLabel(s, parts[1], parts[2])
 			default:
 				respond(conn, sender, "ERROR: Invalid LABEL command, must use zero or 2 arguments")
 				continue
 			}
+			if err != nil {
+				respondError(conn, sender, "Could not set label", err)
+				continue
+			}
 
 		case "CRASH":
-			log.Print("Crashing.")
+			logger.Warn("Crashing.")
 			os.Exit(1)
 
 		case "ANNOTATION":
+			var err error
 			switch len(parts) {
 			case 1:
 				// legacy format
-				setAnnotation(s, "timestamp", time.Now().UTC().String())
+				err = setAnnotation(s, "timestamp", time.Now().UTC().String())
 			case 3:
-				setAnnotation(s, parts[1], parts[2])
+				err = setAnnotation(s, parts[1], parts[2])
 			default:
 				respond(conn, sender, "ERROR: Invalid ANNOTATION command, must use zero or 2 arguments\n")
 				continue
 			}
+			if err != nil {
+				respondError(conn, sender, "Could not set annotation", err)
+				continue
+			}
 
 		case "CONNECTION":
-			connection = txt
-			delete(addrs, recvPort)
+			// Directorからの通知 "CONNECTION <ip:port> <割り当てられたプレイヤー数> [<ゲームモード> [<traceparent>]]"
+			if len(parts) < 3 || len(parts) > 5 {
+				respond(conn, sender, "ERROR: Invalid CONNECTION command, must use 2 to 4 arguments\n")
+				continue
+			}
+			assigned, err := strconv.Atoi(parts[2])
+			if err != nil {
+				respond(conn, sender, "ERROR: Invalid CONNECTION command, player count must be a number\n")
+				continue
+			}
+			mode := defaultGameMode
+			if len(parts) >= 4 {
+				mode = parts[3]
+			}
+			traceparent := ""
+			if len(parts) == 5 {
+				traceparent = parts[4]
+			}
+			mu.Lock()
+			startSession(parts[1], assigned, mode, traceparent, time.Now())
+			mu.Unlock()
 
 		case "SESSIONSTART":
-			joinablePlayerNum := maxPlayerNum - len(addrs)
-			if joinablePlayerNum > 0 {
-				// OpenMatchのBackfillEndpointにBackfillTicketの作成を依頼
-				go requestBackfill(connection, joinablePlayerNum)
-			}
+			// 割り当てられたプレイヤーを待たずにセッションを開始
+			mu.Lock()
+			beginSession()
+			mu.Unlock()
 
 		case "LEAVE":
-			// OpenMatchのBackfillEndpointにBackfillTicketの作成を依頼
-			go requestBackfill(connection, 1)
-			delete(addrs, recvPort)
+			mu.Lock()
+			leavePlayer(recvPort)
+			mu.Unlock()
 		}
 
+		mu.Lock()
+		updateEmpty(time.Now())
+		mu.Unlock()
+
 		respond(conn, sender, "ACK: "+txt+"\n")
 	}
 }
 
 // ready attempts to mark this gameserver as ready
-func ready(s *sdk.SDK) {
-	err := s.Ready()
-	if err != nil {
-		log.Fatalf("Could not send ready message")
-	}
+func ready(s *sdk.SDK) error {
+	return s.Ready()
 }
 
 // allocate attempts to allocate this gameserver
-func allocate(s *sdk.SDK) {
-	err := s.Allocate()
-	if err != nil {
-		log.Fatalf("could not allocate gameserver: %v", err)
-	}
+func allocate(s *sdk.SDK) error {
+	return s.Allocate()
 }
 
 // reserve for 10 seconds
-func reserve(s *sdk.SDK) {
-	if err := s.Reserve(10 * time.Second); err != nil {
-		log.Fatalf("could not reserve gameserver: %v", err)
-	}
+func reserve(s *sdk.SDK) error {
+	return s.Reserve(10 * time.Second)
 }
 
 // readPacket reads a string from the connection
-func readPacket(conn net.PacketConn, b []byte) (net.Addr, string) {
+func readPacket(conn net.PacketConn, b []byte) (net.Addr, string, error) {
 	n, sender, err := conn.ReadFrom(b)
 	if err != nil {
-		log.Fatalf("Could not read from udp stream: %v", err)
+		return nil, "", err
 	}
 	txt := strings.TrimSpace(string(b[:n]))
-	log.Printf("Received packet from %v: %v", sender.String(), txt)
-	return sender, txt
+	logger.WithField("sender", sender.String()).Debugf("Received packet: %v", txt)
+	return sender, txt, nil
 }
 
 // respond responds to a given sender.
 func respond(conn net.PacketConn, sender net.Addr, txt string) {
+	mu.Lock()
+	defer mu.Unlock()
 	for _, sendaddr := range addrs {
 		if _, err := conn.WriteTo([]byte(txt), sendaddr); err != nil {
-			log.Fatalf("Could not write to udp stream: %v", err)
+			logger.WithError(err).WithField("addr", sendaddr.String()).Warn("Could not write to udp stream")
 		}
 	}
 }
 
+// respondError コマンドの失敗をログに出力し、送信元にERRORを返す
+func respondError(conn net.PacketConn, sender net.Addr, msg string, err error) {
+	logger.WithError(err).WithField("sender", sender.String()).Error(msg)
+	respond(conn, sender, "ERROR: "+msg+"\n")
+}
+
 // exit shutdowns the server
 func exit(s *sdk.SDK) {
-	log.Printf("Received EXIT command. Exiting.")
+	logger.Info("Received EXIT command. Exiting.")
 	// This tells Agones to shutdown this Game Server
 	shutdownErr := s.Shutdown()
 	if shutdownErr != nil {
-		log.Printf("Could not shutdown")
+		logger.WithError(shutdownErr).Error("Could not shutdown")
 	}
+	flushTracing()
 	os.Exit(0)
 }
 
 // gameServerName returns the GameServer name
-func gameServerName(s *sdk.SDK) string {
+func gameServerName(s *sdk.SDK) (string, error) {
 	var gs *coresdk.GameServer
 	gs, err := s.GameServer()
 	if err != nil {
-		log.Fatalf("Could not retrieve GameServer: %v", err)
+		return "", err
 	}
 	var j []byte
 	j, err = json.Marshal(gs)
 	if err != nil {
-		log.Fatalf("error mashalling GameServer to JSON: %v", err)
+		return "", err
 	}
-	log.Printf("GameServer: %s \n", string(j))
-	return "NAME: " + gs.ObjectMeta.Name + "\n"
+	logger.Debugf("GameServer: %s", string(j))
+	return "NAME: " + gs.ObjectMeta.Name + "\n", nil
 }
 
 // watchGameServerEvents creates a callback to log when
 // gameserver events occur
-func watchGameServerEvents(s *sdk.SDK) {
-	err := s.WatchGameServer(func(gs *coresdk.GameServer) {
+func watchGameServerEvents(s *sdk.SDK) error {
+	return s.WatchGameServer(func(gs *coresdk.GameServer) {
 		j, err := json.Marshal(gs)
 		if err != nil {
-			log.Fatalf("error mashalling GameServer to JSON: %v", err)
+			logger.WithError(err).Error("error mashalling GameServer to JSON")
+			return
 		}
-		log.Printf("GameServer Event: %s \n", string(j))
+		logger.Infof("GameServer Event: %s", string(j))
 	})
-	if err != nil {
-		log.Fatalf("Could not watch Game Server events, %v", err)
-	}
 }
 
 // setAnnotation sets a given annotation
-func setAnnotation(s *sdk.SDK, key, value string) {
-	log.Printf("Setting annotation %v=%v", key, value)
-	err := s.SetAnnotation(key, value)
-	if err != nil {
-		log.Fatalf("could not set annotation: %v", err)
-	}
+func setAnnotation(s *sdk.SDK, key, value string) error {
+	logger.Infof("Setting annotation %v=%v", key, value)
+	return s.SetAnnotation(key, value)
 }
 
 // setLabel sets a given label
-func setLabel(s *sdk.SDK, key, value string) {
-	log.Printf("Setting label %v=%v", key, value)
+func setLabel(s *sdk.SDK, key, value string) error {
+	logger.Infof("Setting label %v=%v", key, value)
 	// label values can only be alpha, - and .
-	err := s.SetLabel(key, value)
-	if err != nil {
-		log.Fatalf("could not set label: %v", err)
-	}
+	return s.SetLabel(key, value)
 }
 
 // doHealth sends the regular Health Pings
@@ -292,11 +364,11 @@ func doHealth(sdk *sdk.SDK, stop <-chan struct{}) {
 	for {
 		err := sdk.Health()
 		if err != nil {
-			log.Fatalf("Could not send health ping, %v", err)
+			logger.WithError(err).Fatal("Could not send health ping")
 		}
 		select {
 		case <-stop:
-			log.Print("Stopped health pings")
+			logger.Info("Stopped health pings")
 			return
 		case <-tick:
 		}
@@ -307,24 +379,77 @@ func doHealth(sdk *sdk.SDK, stop <-chan struct{}) {
 type backfillRequest struct {
 	Connection        string `json:"connection" form:"connection" query:"connection"`
 	JoinablePlayerNum string `json:"joinableplayernum" form:"joinableplayernum" query:"joinableplayernum"`
+	// EndsAt セッションが終わる予定のUNIX時刻(秒)。終了時刻がなければ空
+	EndsAt string `json:"endsat" form:"endsat" query:"endsat"`
 }
 
-// requestBackfill
-func requestBackfill(connection string, joinablePlayerNum int) {
+// requestBackfill BackfillTicketの登録を依頼
+// 同じconnectionの既存のBackfillTicketはjoinablePlayerNumで置き換えられる
+// traceparentを送ってBackfillTicketをセッションのトレースにつなげる
+// endsAtを送り、Match Functionが終わりかけのセッションにプレイヤーを送らないようにする(ゼロ値なら送らない)
+func requestBackfill(connection string, mode string, joinablePlayerNum int, endsAt time.Time, traceparent string) {
+	ok := false
+	defer func() { observeBackfill("request", ok) }()
+	backfillLogger := logger.WithFields(logrus.Fields{"connection": connection, logging.FieldMode: mode})
 	reqBody := backfillRequest{Connection: connection, JoinablePlayerNum: strconv.Itoa(joinablePlayerNum)}
+	if !endsAt.IsZero() {
+		reqBody.EndsAt = strconv.FormatInt(endsAt.Unix(), 10)
+	}
 	body, err := json.Marshal(reqBody)
 	if err != nil {
-		log.Fatal(err)
+		backfillLogger.WithError(err).Error("Could not marshal backfill request")
+		return
 	}
-	backfillReq, err := http.NewRequest("POST", mfBackfillEndpoint, bytes.NewReader(body))
+	backfillReq, err := http.NewRequest("POST", backfillURL(mode), bytes.NewReader(body))
 	if err != nil {
-		log.Fatal(err)
+		backfillLogger.WithError(err).Error("Could not create backfill request")
+		return
+	}
+	backfillReq.Header.Set("Content-Type", "application/json")
+	if traceparent != "" {
+		backfillReq.Header.Set("traceparent", traceparent)
 	}
 	client := new(http.Client)
 	resp, err := client.Do(backfillReq)
 	if err != nil {
-		log.Fatal(err)
+		backfillLogger.WithError(err).Warn("Could not request backfill")
+		return
 	}
 	defer resp.Body.Close()
-	return
+	if resp.StatusCode != http.StatusOK {
+		backfillLogger.Warnf("Could not request backfill: status %v", resp.Status)
+		return
+	}
+	ok = true
+}
+
+// withdrawBackfill 登録中のBackfillTicketの取り下げを依頼
+func withdrawBackfill(connection string, mode string) {
+	ok := false
+	defer func() { observeBackfill("withdraw", ok) }()
+	backfillLogger := logger.WithFields(logrus.Fields{"connection": connection, logging.FieldMode: mode})
+	q := url.Values{}
+	q.Set("connection", connection)
+	withdrawReq, err := http.NewRequest("DELETE", backfillURL(mode)+"?"+q.Encode(), nil)
+	if err != nil {
+		backfillLogger.WithError(err).Error("Could not create backfill withdraw request")
+		return
+	}
+	client := new(http.Client)
+	resp, err := client.Do(withdrawReq)
+	if err != nil {
+		backfillLogger.WithError(err).Warn("Could not withdraw backfill")
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusNotFound {
+		backfillLogger.Warnf("Could not withdraw backfill: status %v", resp.Status)
+		return
+	}
+	ok = true
+}
+
+// backfillURL ゲームモードのBackfillEndpoint
+func backfillURL(mode string) string {
+	return strings.TrimSuffix(serverConf.BackfillEndpoint, "/") + "/" + url.PathEscape(mode)
 }
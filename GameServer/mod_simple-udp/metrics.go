@@ -0,0 +1,42 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// connectedPlayers 接続中のプレイヤー数
+	connectedPlayers = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "gameserver_connected_players",
+		Help: "Number of players connected to the game server.",
+	})
+
+	// backfillRequests BackfillEndpointへの依頼数。actionはrequest(登録)かwithdraw(取り下げ)、resultはokかerror
+	backfillRequests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "gameserver_backfill_requests_total",
+		Help: "Number of completed backfill requests sent to the frontend, by action and result.",
+	}, []string{"action", "result"})
+)
+
+// serveMetrics /metricsをportで公開する
+func serveMetrics(port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		logger.WithError(err).Errorf("Could not serve metrics on port %v", port)
+	}
+}
+
+// observeBackfill BackfillEndpointへの依頼の結果を記録する
+func observeBackfill(action string, ok bool) {
+	result := "ok"
+	if !ok {
+		result = "error"
+	}
+	backfillRequests.WithLabelValues(action, result).Inc()
+}
@@ -0,0 +1,251 @@
+package main
+
+import (
+	"net"
+	"os"
+	"strconv"
+	"time"
+
+	sdk "agones.dev/agones/sdks/go"
+	"github.com/sirupsen/logrus"
+
+	"common/logging"
+)
+
+// プレイヤー数を報告するアノテーション
+// SDKが"agones.dev/sdk-"を付けるので、GameServer上では"agones.dev/sdk-players"などになる
+// Directorはこれを見て、0人のまま猶予期間を過ぎたAllocatedなGameServerを回収する
+const (
+	// playersAnnotation 接続中のプレイヤー数
+	playersAnnotation = "players"
+	// emptySinceAnnotation プレイヤーが0人になった時刻(Unix秒)。プレイヤーがいる間は"0"
+	emptySinceAnnotation = "empty-since"
+)
+
+// sessionConfig セッションを終了させる条件
+type sessionConfig struct {
+	// joinTimeout 割り当てられたプレイヤーが揃わなくてもこの時間でセッションを開始する
+	joinTimeout time.Duration
+	// emptyTimeout プレイヤーが0人の状態がこの時間続いたらセッションを終了する(0なら無効)
+	emptyTimeout time.Duration
+	// maxDuration セッション開始からこの時間が経過したらセッションを終了する(0なら無効)
+	maxDuration time.Duration
+	// returnToReady trueならShutdownせずにReadyに戻してFleetに返却する
+	returnToReady bool
+	// backfillHeartbeat 空席がある間BackfillTicketを登録し直す間隔(0なら無効)
+	// FrontendはBackfillTicketに有効期限を付けるので、登録し直さないと期限切れでBackfillの対象から外れる
+	backfillHeartbeat time.Duration
+}
+
+// session Directorから割り当てられたゲームセッションの状態
+type session struct {
+	connection string
+	gameMode   string
+	// assignedPlayers Directorがこのセッションに割り当てたプレイヤー数
+	assignedPlayers int
+	// allocatedAt Directorから割り当ての通知を受けた時刻
+	allocatedAt time.Time
+	// started プレイヤーが揃う、またはjoinTimeoutでセッションが開始されたか
+	started bool
+	// emptySince プレイヤーが0人になった時刻(プレイヤーがいる間はゼロ値)
+	emptySince time.Time
+	// backfillDirty 空席数が変わりBackfillTicketの更新が必要か
+	backfillDirty bool
+	// backfillRequestedAt 最後にBackfillTicketの登録を依頼した時刻
+	backfillRequestedAt time.Time
+	// traceparent Directorのマッチのspan。プレイヤーの参加とBackfillTicketをこのトレースにつなげる
+	traceparent string
+}
+
+// currentSession 進行中のセッション(connectionが空ならセッションなし)
+// muで保護する
+var currentSession session
+
+// startSession Directorからの割り当てを受けてプレイヤーの接続待ちを開始する。muを取得した状態で呼ぶこと
+func startSession(connection string, assignedPlayers int, mode string, traceparent string, now time.Time) {
+	logger.WithFields(logrus.Fields{"connection": connection, logging.FieldMode: mode}).Infof("Session allocated for %v players", assignedPlayers)
+	currentSession = session{
+		connection:      connection,
+		gameMode:        mode,
+		assignedPlayers: assignedPlayers,
+		allocatedAt:     now,
+		emptySince:      now,
+		traceparent:     traceparent,
+	}
+	updateEmpty(now)
+}
+
+// beginSession セッションを開始し、空席をBackfillの対象にする。muを取得した状態で呼ぶこと
+func beginSession() {
+	if currentSession.connection == "" || currentSession.started {
+		return
+	}
+	logger.WithField("connection", currentSession.connection).Infof("Session started with %v/%v players", len(addrs), currentSession.assignedPlayers)
+	currentSession.started = true
+	currentSession.backfillDirty = true
+}
+
+// shouldBeginSession 割り当てられたプレイヤーが揃ったか、joinTimeoutが経過したか。muを取得した状態で呼ぶこと
+func shouldBeginSession(conf sessionConfig, now time.Time) bool {
+	if currentSession.connection == "" || currentSession.started {
+		return false
+	}
+	return len(addrs) >= currentSession.assignedPlayers || now.Sub(currentSession.allocatedAt) >= conf.joinTimeout
+}
+
+// backfillSeats BackfillTicketの更新が必要なら空席数を返す。muを取得した状態で呼ぶこと
+// 空席数が変わったときと、空席がある間backfillHeartbeatごとに更新する
+func backfillSeats(conf sessionConfig, now time.Time) (int, bool) {
+	if !currentSession.started {
+		return 0, false
+	}
+	seats := maxPlayerNum - len(addrs)
+	if seats < 0 {
+		seats = 0
+	}
+	heartbeat := conf.backfillHeartbeat > 0 && seats > 0 && now.Sub(currentSession.backfillRequestedAt) >= conf.backfillHeartbeat
+	if !currentSession.backfillDirty && !heartbeat {
+		return 0, false
+	}
+	currentSession.backfillDirty = false
+	if seats > 0 {
+		currentSession.backfillRequestedAt = now
+	}
+	return seats, true
+}
+
+// leavePlayer portのプレイヤーの退出を記録する。muを取得した状態で呼ぶこと
+// 空いた席はwatchSessionでまとめてBackfillに反映する
+func leavePlayer(port uint) {
+	delete(addrs, port)
+	currentSession.backfillDirty = true
+}
+
+// updateEmpty プレイヤー数に応じてemptySinceを更新する。muを取得した状態で呼ぶこと
+func updateEmpty(now time.Time) {
+	if currentSession.connection == "" {
+		return
+	}
+	if len(addrs) > 0 {
+		currentSession.emptySince = time.Time{}
+	} else if currentSession.emptySince.IsZero() {
+		currentSession.emptySince = now
+	}
+}
+
+// sessionDeadline maxDurationで終わる時刻(無効ならゼロ値)。muを取得した状態で呼ぶこと
+func sessionDeadline(conf sessionConfig) time.Time {
+	if conf.maxDuration <= 0 || currentSession.connection == "" {
+		return time.Time{}
+	}
+	return currentSession.allocatedAt.Add(conf.maxDuration)
+}
+
+// sessionEndReason セッションを終了すべき理由を返す(終了不要なら空文字)。muを取得した状態で呼ぶこと
+func sessionEndReason(conf sessionConfig, now time.Time) string {
+	if currentSession.connection == "" {
+		return ""
+	}
+	if deadline := sessionDeadline(conf); !deadline.IsZero() && !now.Before(deadline) {
+		return "max session duration reached"
+	}
+	if conf.emptyTimeout > 0 && !currentSession.emptySince.IsZero() && now.Sub(currentSession.emptySince) >= conf.emptyTimeout {
+		return "session empty"
+	}
+	return ""
+}
+
+// watchSession セッションの開始・終了条件とBackfillの空席数を定期的にチェックする
+// Backfillの更新とプレイヤー数の報告は1tickにつき最大1回にまとめる
+func watchSession(s *sdk.SDK, conf sessionConfig) {
+	reportedPlayers := -1
+	for now := range time.Tick(time.Second) {
+		mu.Lock()
+		players := len(addrs)
+		connection := currentSession.connection
+		mode := currentSession.gameMode
+		traceparent := currentSession.traceparent
+		endsAt := sessionDeadline(conf)
+		reason := sessionEndReason(conf, now)
+		if reason != "" {
+			// セッションとプレイヤーをリセット
+			currentSession = session{}
+			addrs = map[uint]net.Addr{}
+			mu.Unlock()
+			endSession(s, conf, connection, mode, reason)
+			continue
+		}
+		if shouldBeginSession(conf, now) {
+			beginSession()
+		}
+		seats, update := backfillSeats(conf, now)
+		mu.Unlock()
+		connectedPlayers.Set(float64(players))
+
+		if players != reportedPlayers {
+			if err := reportPlayers(s, players, now); err != nil {
+				logger.WithError(err).Warnf("Could not report %v players", players)
+			} else {
+				reportedPlayers = players
+			}
+		}
+		if !update {
+			continue
+		}
+		if seats > 0 {
+			// OpenMatchのBackfillEndpointにBackfillTicketの作成を依頼
+			go requestBackfill(connection, mode, seats, endsAt, traceparent)
+		} else {
+			go withdrawBackfill(connection, mode)
+		}
+	}
+}
+
+// reportPlayers 接続中のプレイヤー数と、0人ならその時刻をGameServerのアノテーションで報告する
+// プレイヤー数が変わったときだけ呼ぶので、0人になった時刻が報告される
+func reportPlayers(s *sdk.SDK, players int, now time.Time) error {
+	emptySince := int64(0)
+	if players == 0 {
+		emptySince = now.Unix()
+	}
+	if err := s.SetAnnotation(emptySinceAnnotation, strconv.FormatInt(emptySince, 10)); err != nil {
+		return err
+	}
+	return s.SetAnnotation(playersAnnotation, strconv.Itoa(players))
+}
+
+// endSession BackfillTicketを取り下げ、GameServerをReadyに戻すかShutdownする
+func endSession(s *sdk.SDK, conf sessionConfig, connection string, mode string, reason string) {
+	if closeSession(s, conf, connection, mode, reason) {
+		flushTracing()
+		os.Exit(0)
+	}
+}
+
+// sessionLifecycle セッション終了時に使うSDKの操作。*sdk.SDKが満たす
+type sessionLifecycle interface {
+	Ready() error
+	Shutdown() error
+}
+
+// closeSession BackfillTicketを取り下げてからGameServerをReadyに戻すかShutdownし、Shutdownしたらtrueを返す
+// 先に取り下げないと、FleetへのReady返却やShutdownの後にプレイヤーが送られてくる
+func closeSession(s sessionLifecycle, conf sessionConfig, connection string, mode string, reason string) bool {
+	sessionLogger := logger.WithFields(logrus.Fields{"connection": connection, logging.FieldMode: mode})
+	sessionLogger.Infof("Ending session: %v", reason)
+	withdrawBackfill(connection, mode)
+
+	if conf.returnToReady {
+		sessionLogger.Info("Returning this server to the fleet")
+		if err := s.Ready(); err != nil {
+			sessionLogger.WithError(err).Error("Could not return this server to the fleet")
+		}
+		return false
+	}
+
+	sessionLogger.Info("Shutting down this server")
+	if err := s.Shutdown(); err != nil {
+		sessionLogger.WithError(err).Error("Could not shutdown")
+	}
+	return true
+}
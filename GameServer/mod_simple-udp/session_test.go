@@ -0,0 +1,219 @@
+package main
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// resetSession セッションとプレイヤーを空にする
+func resetSession() {
+	currentSession = session{}
+	addrs = map[uint]net.Addr{}
+}
+
+// joinPlayers ポートportsのプレイヤーを接続させる
+func joinPlayers(ports ...uint) {
+	for _, port := range ports {
+		addrs[port] = &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: int(port)}
+	}
+}
+
+func TestBackfillSeatsAfterLeave(t *testing.T) {
+	resetSession()
+	conf := sessionConfig{joinTimeout: 10 * time.Second}
+	now := time.Unix(1600000000, 0)
+	startSession("127.0.0.1:7000", 4, "mode.demo", "", now)
+	joinPlayers(1, 2, 3, 4)
+	if !shouldBeginSession(conf, now) {
+		t.Fatal("session with all assigned players did not begin")
+	}
+	beginSession()
+
+	// 満席で始まったセッションは空席0を登録する(既存のBackfillTicketの取り下げ)
+	if seats, update := backfillSeats(conf, now); !update || seats != 0 {
+		t.Fatalf("backfillSeats() = %v, %v, want 0, true", seats, update)
+	}
+	if _, update := backfillSeats(conf, now); update {
+		t.Fatal("backfillSeats() updated again without a change")
+	}
+
+	leavePlayer(2)
+	if seats, update := backfillSeats(conf, now); !update || seats != 1 {
+		t.Fatalf("backfillSeats() after a LEAVE = %v, %v, want 1, true", seats, update)
+	}
+}
+
+func TestBackfillSeatsHeartbeat(t *testing.T) {
+	resetSession()
+	conf := sessionConfig{joinTimeout: 10 * time.Second, backfillHeartbeat: 20 * time.Second}
+	now := time.Unix(1600000000, 0)
+	startSession("127.0.0.1:7000", 4, "mode.demo", "", now)
+	joinPlayers(1, 2)
+	if !shouldBeginSession(conf, now.Add(conf.joinTimeout)) {
+		t.Fatal("session did not begin after the join timeout")
+	}
+	beginSession()
+
+	if seats, update := backfillSeats(conf, now); !update || seats != 2 {
+		t.Fatalf("backfillSeats() = %v, %v, want 2, true", seats, update)
+	}
+	if _, update := backfillSeats(conf, now.Add(19*time.Second)); update {
+		t.Fatal("backfillSeats() updated before the heartbeat")
+	}
+	if seats, update := backfillSeats(conf, now.Add(20*time.Second)); !update || seats != 2 {
+		t.Fatalf("backfillSeats() at the heartbeat = %v, %v, want 2, true", seats, update)
+	}
+}
+
+func TestShouldBeginSession(t *testing.T) {
+	conf := sessionConfig{joinTimeout: 10 * time.Second}
+	now := time.Unix(1600000000, 0)
+	tests := []struct {
+		name    string
+		players int
+		elapsed time.Duration
+		want    bool
+	}{
+		{name: "waiting for players", players: 3, elapsed: 9 * time.Second, want: false},
+		{name: "all players joined", players: 4, elapsed: 0, want: true},
+		{name: "join timeout", players: 1, elapsed: 10 * time.Second, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSession()
+			startSession("127.0.0.1:7000", 4, "mode.demo", "", now)
+			for i := 0; i < tt.players; i++ {
+				joinPlayers(uint(i + 1))
+			}
+			if got := shouldBeginSession(conf, now.Add(tt.elapsed)); got != tt.want {
+				t.Errorf("shouldBeginSession() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	resetSession()
+	if shouldBeginSession(conf, now) {
+		t.Error("shouldBeginSession() without a session = true")
+	}
+}
+
+func TestSessionEndReason(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	tests := []struct {
+		name string
+		conf sessionConfig
+		// players 接続中のプレイヤー数。0人になった時刻はセッションの割り当て時刻
+		players int
+		elapsed time.Duration
+		want    string
+	}{
+		{name: "empty within the timeout", conf: sessionConfig{emptyTimeout: time.Minute}, elapsed: 59 * time.Second, want: ""},
+		{name: "empty since the timeout", conf: sessionConfig{emptyTimeout: time.Minute}, elapsed: time.Minute, want: "session empty"},
+		{name: "players keep the session", conf: sessionConfig{emptyTimeout: time.Minute}, players: 1, elapsed: time.Hour, want: ""},
+		{name: "empty timeout disabled", conf: sessionConfig{}, elapsed: time.Hour, want: ""},
+		{name: "before max session duration", conf: sessionConfig{maxDuration: 10 * time.Minute}, players: 2, elapsed: 9 * time.Minute, want: ""},
+		{name: "max session duration", conf: sessionConfig{maxDuration: 10 * time.Minute}, players: 2, elapsed: 10 * time.Minute, want: "max session duration reached"},
+		{name: "max session duration before empty", conf: sessionConfig{emptyTimeout: time.Minute, maxDuration: time.Minute}, elapsed: time.Minute, want: "max session duration reached"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resetSession()
+			startSession("127.0.0.1:7000", 4, "mode.demo", "", now)
+			for i := 0; i < tt.players; i++ {
+				joinPlayers(uint(i + 1))
+			}
+			updateEmpty(now)
+			if got := sessionEndReason(tt.conf, now.Add(tt.elapsed)); got != tt.want {
+				t.Errorf("sessionEndReason() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSessionEndReasonAfterLastLeave(t *testing.T) {
+	resetSession()
+	conf := sessionConfig{emptyTimeout: time.Minute}
+	now := time.Unix(1600000000, 0)
+	startSession("127.0.0.1:7000", 2, "mode.demo", "", now)
+	joinPlayers(1)
+	updateEmpty(now)
+
+	// 最後のプレイヤーが抜けた時刻から数える
+	left := now.Add(time.Hour)
+	leavePlayer(1)
+	updateEmpty(left)
+	if got := sessionEndReason(conf, left.Add(59*time.Second)); got != "" {
+		t.Errorf("sessionEndReason() before the timeout = %q, want none", got)
+	}
+	if got := sessionEndReason(conf, left.Add(time.Minute)); got != "session empty" {
+		t.Errorf("sessionEndReason() = %q, want %q", got, "session empty")
+	}
+}
+
+// recordingLifecycle ReadyとShutdownの呼び出しをcallsに記録するsessionLifecycle
+type recordingLifecycle struct {
+	mu    *sync.Mutex
+	calls *[]string
+}
+
+func (l recordingLifecycle) Ready() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.calls = append(*l.calls, "ready")
+	return nil
+}
+
+func (l recordingLifecycle) Shutdown() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.calls = append(*l.calls, "shutdown")
+	return nil
+}
+
+func TestCloseSessionWithdrawsBackfillFirst(t *testing.T) {
+	tests := []struct {
+		name          string
+		returnToReady bool
+		want          []string
+		wantShutdown  bool
+	}{
+		{name: "ready", returnToReady: true, want: []string{"withdraw /backend/mode.demo", "ready"}},
+		{name: "shutdown", returnToReady: false, want: []string{"withdraw /backend/mode.demo", "shutdown"}, wantShutdown: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var calls []string
+			frontend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				defer mu.Unlock()
+				if r.Method == http.MethodDelete && r.URL.Query().Get("connection") == "127.0.0.1:7000" {
+					calls = append(calls, "withdraw "+r.URL.Path)
+				}
+			}))
+			defer frontend.Close()
+			serverConf.BackfillEndpoint = frontend.URL + "/backend"
+
+			conf := sessionConfig{returnToReady: tt.returnToReady}
+			shutdown := closeSession(recordingLifecycle{mu: &mu, calls: &calls}, conf, "127.0.0.1:7000", "mode.demo", "session empty")
+			if shutdown != tt.wantShutdown {
+				t.Errorf("closeSession() = %v, want %v", shutdown, tt.wantShutdown)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if len(calls) != len(tt.want) {
+				t.Fatalf("calls = %v, want %v", calls, tt.want)
+			}
+			for i := range calls {
+				if calls[i] != tt.want[i] {
+					t.Errorf("calls = %v, want %v", calls, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
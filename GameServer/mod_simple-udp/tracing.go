@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+)
+
+// tracer GameServerのspanを作るTracer
+var tracer = otel.Tracer("simple-udp")
+
+// shutdownTracing 終了前にバッファ中のspanを送り切る。setupTracingで設定する
+var shutdownTracing = func(context.Context) error { return nil }
+
+// setupTracing トレースの出力先を環境変数OTEL_TRACES_EXPORTERで設定する
+// "otlp"ならOTEL_EXPORTER_OTLP_ENDPOINT(既定はlocalhost:4317)のcollectorへ、"stdout"なら標準出力へ出力する
+// 未設定または"none"なら出力しない
+func setupTracing(ctx context.Context, service string) error {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", "none":
+		return nil
+	case "otlp":
+		// クラスタ内のcollectorを想定してTLSは使わない
+		exporter, err = otlptracegrpc.New(ctx, otlptracegrpc.WithInsecure())
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		return fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q, must be otlp, stdout or none", name)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to create the %v trace exporter, got %v", os.Getenv("OTEL_TRACES_EXPORTER"), err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service))),
+	)
+	otel.SetTracerProvider(tp)
+	shutdownTracing = tp.Shutdown
+	return nil
+}
+
+// flushTracing os.Exitの前にspanを送り切る
+func flushTracing() {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	if err := shutdownTracing(ctx); err != nil {
+		logger.WithError(err).Warn("Could not flush traces")
+	}
+}
+
+// recordPlayerJoin プレイヤーの参加をDirectorのマッチのspan(CONNECTIONのtraceparent)の子のspanとして記録する
+func recordPlayerJoin(traceparent string, connection string, player string) {
+	ctx := otel.GetTextMapPropagator().Extract(context.Background(), propagation.MapCarrier{"traceparent": traceparent})
+	_, span := tracer.Start(ctx, "PlayerJoin")
+	span.SetAttributes(attribute.String("connection", connection), attribute.String("player.addr", player))
+	span.End()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"common/config"
+)
+
+// loadgenConfig 負荷をかける先、プレイヤー数、到着レート、滞在時間の設定
+type loadgenConfig struct {
+	// FrontendURL FrontendのREST APIのURL
+	FrontendURL string
+	// GameModes プレイヤーが無作為に選ぶゲームモード(カンマ区切り)
+	GameModes []string
+	// Players 到着させるプレイヤーの総数
+	Players int
+	// ArrivalRate 1秒あたりに到着させるプレイヤー数
+	ArrivalRate int
+	// StayMin, StayMax GameServerに接続してから離脱するまでの時間の範囲。この範囲から一様に選ぶ
+	StayMin time.Duration
+	StayMax time.Duration
+	// MatchTimeout マッチングの応答を待つ時間の上限
+	MatchTimeout time.Duration
+	// MatchWindow 同じGameServerへの割り当てが最初の割り当てからこの時間内なら同じマッチ、以降はBackfillとみなす
+	MatchWindow time.Duration
+	// MaxPlayers GameServer 1台の定員。これを超えて割り当てられたら重複割り当てとして数える
+	MaxPlayers int
+	// ReportFile 結果のJSONの出力先。空なら標準出力
+	ReportFile string
+	// Seed ゲームモードと滞在時間の乱数のシード。0なら現在時刻
+	Seed int64
+}
+
+// conf loadgenの設定。mainの最初で読み込む
+var conf loadgenConfig
+
+// loadConfig 設定を既定値、設定ファイル、フラグ、環境変数の順に読み込んで検証する
+// 既定値はクラスタ内から実行する場合のFrontendのサービス名で、E2Eのハーネスに対しては-frontendで上書きする
+func loadConfig(args []string) (loadgenConfig, error) {
+	var c loadgenConfig
+	var modes string
+	var seed int
+	l := config.NewLoader()
+	l.StringVar(&c.FrontendURL, "frontend", "FRONTEND_URL", "http://frontend-endpoint.openmatch.svc.cluster.local", "URL of the frontend REST API")
+	l.StringVar(&modes, "game-modes", "GAME_MODES", "mode.demo", "Comma separated game modes players choose from at random")
+	l.IntVar(&c.Players, "players", "PLAYERS", 1000, "Total number of simulated players")
+	l.IntVar(&c.ArrivalRate, "rate", "ARRIVAL_RATE", 50, "Players arriving per second")
+	l.DurationVar(&c.StayMin, "stay-min", "STAY_MIN", 10*time.Second, "Minimum time a player stays on the game server")
+	l.DurationVar(&c.StayMax, "stay-max", "STAY_MAX", time.Minute, "Maximum time a player stays on the game server")
+	l.DurationVar(&c.MatchTimeout, "match-timeout", "MATCH_TIMEOUT", time.Minute, "Time a player waits for a match before giving up")
+	l.DurationVar(&c.MatchWindow, "match-window", "MATCH_WINDOW", 3*time.Second, "Assignments to a game server within this time of the first one count as the same match")
+	l.IntVar(&c.MaxPlayers, "max-players", "MAX_PLAYERS", 4, "Capacity of one game server")
+	l.StringVar(&c.ReportFile, "report", "REPORT_FILE", "", "File to write the JSON report to, stdout if empty")
+	l.IntVar(&seed, "seed", "SEED", 0, "Random seed, the current time if 0")
+	if err := l.Load(args); err != nil {
+		return c, err
+	}
+	for _, mode := range strings.Split(modes, ",") {
+		if mode = strings.TrimSpace(mode); mode != "" {
+			c.GameModes = append(c.GameModes, mode)
+		}
+	}
+	c.Seed = int64(seed)
+	if c.Seed == 0 {
+		c.Seed = time.Now().UnixNano()
+	}
+	return c, c.validate()
+}
+
+// validate 設定の誤りをまとめて返す
+func (c loadgenConfig) validate() error {
+	var errs config.Errors
+	errs.HTTPURL("frontend", c.FrontendURL)
+	if len(c.GameModes) == 0 {
+		errs.Add("game-modes must not be empty")
+	}
+	errs.PositiveInt("players", c.Players)
+	errs.PositiveInt("rate", c.ArrivalRate)
+	errs.Positive("stay-min", c.StayMin)
+	if c.StayMax < c.StayMin {
+		errs.Add("stay-max must not be shorter than stay-min, got %v < %v", c.StayMax, c.StayMin)
+	}
+	errs.Positive("match-timeout", c.MatchTimeout)
+	errs.Positive("match-window", c.MatchWindow)
+	errs.PositiveInt("max-players", c.MaxPlayers)
+	return errs.Err()
+}
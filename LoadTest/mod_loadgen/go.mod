@@ -0,0 +1,11 @@
+module mod_loadgen
+
+go 1.13
+
+require (
+	common v0.0.0
+	github.com/sirupsen/logrus v1.4.2
+)
+
+// 全てのバイナリで共有する設定とログのパッケージ
+replace common => ../../Common/mod_common
@@ -0,0 +1,6 @@
+package main
+
+import "common/logging"
+
+// logger loadgenのJSON形式のlogger
+var logger = logging.New("loadgen")
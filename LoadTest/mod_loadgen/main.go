@@ -0,0 +1,90 @@
+// Package main はFrontendに多数のプレイヤーを到着させてマッチメイクを計測する負荷試験クライアント
+//
+// プレイヤーは指定のレートで到着し、GET /match/:gamemodeでマッチングを要求して、
+// 割り当てられたGameServerにUDPで接続し、無作為な時間滞在してからLEAVEで離脱する
+// 終了後にマッチングまでの時間の分位点、Backfillの充足率、定員を超えた重複割り当ての数をJSONで出力する
+//
+// ローカルではE2Eのハーネスをgo runで起動し、出力されたFrontendのURLに対して実行する
+//
+//	cd E2E/mod_e2e && go run .
+//	cd LoadTest/mod_loadgen && go run . -frontend http://127.0.0.1:<port> -players 200 -rate 20
+//
+// クラスタではFrontendのサービスに届く場所(クラスタ内のPodやport-forward)から実行する
+// GameServerのUDPのポートにも届く必要がある
+package main
+
+import (
+	"context"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+func main() {
+	var err error
+	if conf, err = loadConfig(os.Args[1:]); err != nil {
+		logger.WithError(err).Fatal("Failed to load the config")
+	}
+
+	// SIGINTかSIGTERMで到着を止め、滞在中のプレイヤーを離脱させて結果を出力する
+	ctx, stop := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-sigs
+		logger.Infof("Received %v, stopping players", sig)
+		stop()
+	}()
+
+	logger.WithField("seed", conf.Seed).Infof("Starting %v players at %v/s against %v", conf.Players, conf.ArrivalRate, conf.FrontendURL)
+	tracker := newSeatTracker(conf.MaxPlayers, conf.MatchWindow)
+	start := time.Now()
+	results := runPlayers(ctx, tracker)
+	r := newReport(results, tracker.stats(), time.Since(start))
+
+	logger.WithField("outcomes", r.Outcomes).Infof("time to match p50=%.2fs p90=%.2fs p99=%.2fs, backfill fill rate %.2f, %v duplicate assignments",
+		r.TimeToMatch.P50, r.TimeToMatch.P90, r.TimeToMatch.P99, r.BackfillFillRate, r.DuplicateAssignments)
+	if err := r.write(conf.ReportFile); err != nil {
+		logger.WithError(err).Fatal("Failed to write the report")
+	}
+}
+
+// runPlayers ArrivalRateの間隔でプレイヤーを到着させ、全員が離脱するまで待つ
+// ctxが終了したら以降のプレイヤーは到着させない
+func runPlayers(ctx context.Context, tracker *seatTracker) []playerResult {
+	rnd := rand.New(rand.NewSource(conf.Seed))
+	ticker := time.NewTicker(time.Second / time.Duration(conf.ArrivalRate))
+	defer ticker.Stop()
+
+	var mu sync.Mutex
+	var results []playerResult
+	var wg sync.WaitGroup
+arrivals:
+	for id := 0; id < conf.Players; id++ {
+		// ゲームモードと滞在時間は到着順に決め、シードが同じなら同じ負荷になるようにする
+		p := player{
+			id:   id,
+			mode: conf.GameModes[rnd.Intn(len(conf.GameModes))],
+			stay: conf.StayMin + time.Duration(rnd.Int63n(int64(conf.StayMax-conf.StayMin)+1)),
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := p.run(ctx, tracker)
+			mu.Lock()
+			results = append(results, res)
+			mu.Unlock()
+		}()
+
+		select {
+		case <-ctx.Done():
+			break arrivals
+		case <-ticker.C:
+		}
+	}
+	wg.Wait()
+	return results
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"common/logging"
+)
+
+// outcome プレイヤー1人の結果
+type outcome string
+
+const (
+	outcomeMatched      outcome = "matched"
+	outcomeMatchTimeout outcome = "match_timeout"
+	outcomeMatchError   outcome = "match_error"
+	outcomeJoinError    outcome = "join_error"
+)
+
+// playerResult プレイヤー1人の結果と、マッチングの応答までの時間
+type playerResult struct {
+	outcome     outcome
+	mode        string
+	timeToMatch time.Duration
+	backfill    bool
+}
+
+// matchResponse FrontendのGET /match/:gamemodeの応答
+type matchResponse struct {
+	IP   string `json:"ip"`
+	Port string `json:"port"`
+}
+
+// httpClient 同時に待つマッチングの要求ごとに接続を張るため、待機中の接続を多めに残す
+var httpClient = &http.Client{Transport: &http.Transport{MaxIdleConnsPerHost: 256}}
+
+// player 1人のプレイヤー
+type player struct {
+	id   int
+	mode string
+	// stay GameServerに接続してから離脱するまでの時間
+	stay time.Duration
+}
+
+// run マッチングを要求し、割り当てられたGameServerにUDPで接続してstayの間滞在してから離脱する
+// ctxが終了したら滞在を切り上げて離脱する
+func (p player) run(ctx context.Context, tracker *seatTracker) playerResult {
+	res := playerResult{mode: p.mode}
+	playerLogger := logger.WithFields(logrus.Fields{"player": p.id, logging.FieldMode: p.mode})
+
+	// マッチングを諦めてもFrontendはチケットの割り当てを待ち続けるので、
+	// 後から割り当てられても接続しないプレイヤーが残ることがある
+	matchCtx, cancel := context.WithTimeout(ctx, conf.MatchTimeout)
+	start := time.Now()
+	match, err := requestMatch(matchCtx, p.mode)
+	cancel()
+	if err != nil {
+		if matchCtx.Err() == context.DeadlineExceeded {
+			res.outcome = outcomeMatchTimeout
+		} else {
+			res.outcome = outcomeMatchError
+		}
+		playerLogger.WithError(err).Debug("No match")
+		return res
+	}
+	res.timeToMatch = time.Since(start)
+	connection := net.JoinHostPort(match.IP, match.Port)
+	playerLogger = playerLogger.WithField("connection", connection)
+
+	backfill, duplicate := tracker.assign(connection, time.Now())
+	defer tracker.leave(connection)
+	res.backfill = backfill
+	if duplicate {
+		playerLogger.Warn("Assigned to a full game server")
+	}
+
+	conn, err := net.Dial("udp", connection)
+	if err != nil {
+		res.outcome = outcomeJoinError
+		playerLogger.WithError(err).Warn("Failed to connect")
+		return res
+	}
+	defer conn.Close()
+	if err := send(conn, "HELLO"); err != nil {
+		res.outcome = outcomeJoinError
+		playerLogger.WithError(err).Warn("Failed to join")
+		return res
+	}
+	res.outcome = outcomeMatched
+	playerLogger.WithField("backfill", backfill).Debugf("Joined after %v", res.timeToMatch)
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(p.stay):
+	}
+	// 離脱はベストエフォートで、応答がなくても結果には含めない
+	if err := send(conn, "LEAVE"); err != nil {
+		playerLogger.WithError(err).Debug("Failed to leave")
+	}
+	return res
+}
+
+// requestMatch FrontendにマッチングのREST APIを呼び出し、割り当てられたGameServerを返す
+func requestMatch(ctx context.Context, mode string) (matchResponse, error) {
+	var res matchResponse
+	req, err := http.NewRequest("GET", conf.FrontendURL+"/match/"+mode, nil)
+	if err != nil {
+		return res, err
+	}
+	resp, err := httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return res, fmt.Errorf("failed to request a match, got %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return res, fmt.Errorf("failed to request a match: status %v", resp.Status)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return res, fmt.Errorf("failed to decode the match, got %w", err)
+	}
+	return res, nil
+}
+
+// send GameServerにコマンドを送り、ACKを待つ
+func send(conn net.Conn, command string) error {
+	conn.SetDeadline(time.Now().Add(3 * time.Second))
+	if _, err := conn.Write([]byte(command + "\n")); err != nil {
+		return err
+	}
+	b := make([]byte, 1024)
+	_, err := conn.Read(b)
+	return err
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"time"
+)
+
+// report 負荷試験の結果。JSONで出力する
+type report struct {
+	Players         int            `json:"players"`
+	DurationSeconds float64        `json:"duration_seconds"`
+	Outcomes        map[string]int `json:"outcomes"`
+	// TimeToMatch マッチングを要求してから割り当てを受け取るまでの秒数(マッチしたプレイヤーのみ)
+	TimeToMatch percentiles `json:"time_to_match_seconds"`
+	// TimeToMatchByMode ゲームモードごとのTimeToMatch
+	TimeToMatchByMode map[string]percentiles `json:"time_to_match_seconds_by_mode"`
+	// GameServers プレイヤーが割り当てられたGameServerの台数
+	GameServers int `json:"game_servers"`
+	// BackfillJoins Backfillで既存のセッションに割り当てられたプレイヤー数
+	BackfillJoins int `json:"backfill_joins"`
+	// BackfillSeatsOffered Backfillの対象になった空席数
+	BackfillSeatsOffered int `json:"backfill_seats_offered"`
+	// BackfillFillRate BackfillJoins / BackfillSeatsOffered
+	BackfillFillRate float64 `json:"backfill_fill_rate"`
+	// DuplicateAssignments 定員を超えてGameServerに割り当てられたプレイヤー数
+	DuplicateAssignments int `json:"duplicate_assignments"`
+}
+
+// percentiles 秒数の分布
+type percentiles struct {
+	Count int     `json:"count"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// newReport プレイヤーの結果とseatTrackerの集計から結果をまとめる
+func newReport(results []playerResult, seats seatStats, elapsed time.Duration) report {
+	r := report{
+		Players:              len(results),
+		DurationSeconds:      elapsed.Seconds(),
+		Outcomes:             map[string]int{},
+		TimeToMatchByMode:    map[string]percentiles{},
+		GameServers:          seats.servers,
+		BackfillJoins:        seats.backfillJoins,
+		BackfillSeatsOffered: seats.offeredSeats,
+		DuplicateAssignments: seats.duplicates,
+	}
+	if seats.offeredSeats > 0 {
+		r.BackfillFillRate = float64(seats.backfillJoins) / float64(seats.offeredSeats)
+	}
+
+	var all []time.Duration
+	byMode := map[string][]time.Duration{}
+	for _, res := range results {
+		r.Outcomes[string(res.outcome)]++
+		if res.outcome != outcomeMatched {
+			continue
+		}
+		all = append(all, res.timeToMatch)
+		byMode[res.mode] = append(byMode[res.mode], res.timeToMatch)
+	}
+	r.TimeToMatch = newPercentiles(all)
+	for mode, durations := range byMode {
+		r.TimeToMatchByMode[mode] = newPercentiles(durations)
+	}
+	return r
+}
+
+func newPercentiles(durations []time.Duration) percentiles {
+	if len(durations) == 0 {
+		return percentiles{}
+	}
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return percentiles{
+		Count: len(durations),
+		P50:   percentile(durations, 0.50),
+		P90:   percentile(durations, 0.90),
+		P99:   percentile(durations, 0.99),
+		Max:   durations[len(durations)-1].Seconds(),
+	}
+}
+
+// percentile ソート済みのdurationsのp分位点(nearest-rank法)の秒数
+func percentile(sorted []time.Duration, p float64) float64 {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1].Seconds()
+}
+
+// write 結果をJSONでpathに書き込む。pathが空なら標準出力に出力する
+func (r report) write(path string) error {
+	b, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	b = append(b, '\n')
+	if path == "" {
+		_, err = os.Stdout.Write(b)
+		return err
+	}
+	return ioutil.WriteFile(path, b, 0644)
+}
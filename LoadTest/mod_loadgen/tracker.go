@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// seatTracker プレイヤーに割り当てられたGameServer(connection)ごとの在席数を数え、
+// 新規マッチとBackfillの区別、Backfillで埋まった空席、定員を超えた重複割り当てを集計する
+//
+// loadgenからはマッチの単位が見えないので、同じconnectionへの割り当てのうち
+// セッションの最初の割り当てからMatchWindow以内のものを同じマッチ、それ以降をBackfillとみなす
+// 在席数が0になったらセッションは終わったものとする
+type seatTracker struct {
+	mu          sync.Mutex
+	maxPlayers  int
+	matchWindow time.Duration
+	sessions    map[string]*seatSession
+	// servers 割り当てられたことのあるconnection
+	servers map[string]bool
+
+	backfillJoins int
+	duplicates    int
+	// offeredSeats 終わったセッションでBackfillの対象になった空席数
+	offeredSeats int
+}
+
+// seatSession 1台のGameServerの進行中のセッション
+type seatSession struct {
+	startedAt time.Time
+	// matched 新規マッチで割り当てられたプレイヤー数
+	matched int
+	// occupants 在席中のプレイヤー数
+	occupants int
+	// vacated 他のプレイヤーが残っている間に離脱して空いた席の数
+	vacated int
+}
+
+func newSeatTracker(maxPlayers int, matchWindow time.Duration) *seatTracker {
+	return &seatTracker{
+		maxPlayers:  maxPlayers,
+		matchWindow: matchWindow,
+		sessions:    map[string]*seatSession{},
+		servers:     map[string]bool{},
+	}
+}
+
+// assign connectionへの割り当てを記録し、Backfillによる割り当てか、定員を超えたかを返す
+func (t *seatTracker) assign(connection string, now time.Time) (backfill bool, duplicate bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.servers[connection] = true
+	s, ok := t.sessions[connection]
+	if !ok {
+		t.sessions[connection] = &seatSession{startedAt: now, matched: 1, occupants: 1}
+		return false, false
+	}
+
+	s.occupants++
+	if now.Sub(s.startedAt) <= t.matchWindow {
+		s.matched++
+	} else {
+		backfill = true
+		t.backfillJoins++
+	}
+	if s.occupants > t.maxPlayers {
+		duplicate = true
+		t.duplicates++
+	}
+	return backfill, duplicate
+}
+
+// leave connectionからのプレイヤーの離脱を記録する
+func (t *seatTracker) leave(connection string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[connection]
+	if !ok {
+		return
+	}
+	s.occupants--
+	if s.occupants > 0 {
+		s.vacated++
+		return
+	}
+	t.offeredSeats += t.offered(s)
+	delete(t.sessions, connection)
+}
+
+// offered セッションでBackfillの対象になった空席数。muを取得した状態で呼ぶこと
+// 新規マッチで埋まらなかった席と、途中で空いた席の合計
+func (t *seatTracker) offered(s *seatSession) int {
+	empty := t.maxPlayers - s.matched
+	if empty < 0 {
+		empty = 0
+	}
+	return empty + s.vacated
+}
+
+// seatStats seatTrackerの集計結果
+type seatStats struct {
+	servers       int
+	backfillJoins int
+	offeredSeats  int
+	duplicates    int
+}
+
+// stats 進行中のセッションも含めた集計結果
+func (t *seatTracker) stats() seatStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	offered := t.offeredSeats
+	for _, s := range t.sessions {
+		offered += t.offered(s)
+	}
+	return seatStats{
+		servers:       len(t.servers),
+		backfillJoins: t.backfillJoins,
+		offeredSeats:  offered,
+		duplicates:    t.duplicates,
+	}
+}
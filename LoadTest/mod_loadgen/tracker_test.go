@@ -0,0 +1,114 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// seatEvent seatTrackerへの割り当てまたは離脱
+type seatEvent struct {
+	connection string
+	// at 最初のイベントからの経過時間
+	at    time.Duration
+	leave bool
+	// wantBackfill, wantDuplicate 割り当ての戻り値
+	wantBackfill  bool
+	wantDuplicate bool
+}
+
+func TestSeatTracker(t *testing.T) {
+	const a, b = "10.0.0.1:7000", "10.0.0.2:7000"
+	tests := []struct {
+		name   string
+		events []seatEvent
+		want   seatStats
+	}{
+		{
+			name: "full match",
+			events: []seatEvent{
+				{connection: a}, {connection: a}, {connection: a, at: time.Second}, {connection: a, at: 2 * time.Second},
+			},
+			want: seatStats{servers: 1},
+		},
+		{
+			name: "over capacity within the match window",
+			events: []seatEvent{
+				{connection: a}, {connection: a}, {connection: a}, {connection: a},
+				{connection: a, at: time.Second, wantDuplicate: true},
+			},
+			want: seatStats{servers: 1, duplicates: 1},
+		},
+		{
+			name: "backfill into an empty seat",
+			events: []seatEvent{
+				{connection: a}, {connection: a}, {connection: a},
+				{connection: a, at: 10 * time.Second, wantBackfill: true},
+			},
+			want: seatStats{servers: 1, backfillJoins: 1, offeredSeats: 1},
+		},
+		{
+			name: "backfill into a full server",
+			events: []seatEvent{
+				{connection: a}, {connection: a}, {connection: a}, {connection: a},
+				{connection: a, at: 10 * time.Second, wantBackfill: true, wantDuplicate: true},
+			},
+			want: seatStats{servers: 1, backfillJoins: 1, duplicates: 1},
+		},
+		{
+			name: "backfill into a vacated seat",
+			events: []seatEvent{
+				{connection: a}, {connection: a}, {connection: a}, {connection: a},
+				{connection: a, at: 5 * time.Second, leave: true},
+				{connection: a, at: 10 * time.Second, wantBackfill: true},
+			},
+			want: seatStats{servers: 1, backfillJoins: 1, offeredSeats: 1},
+		},
+		{
+			// 在席数が0になったら次の割り当ては新しいセッションの新規マッチ
+			name: "session ends when the last player leaves",
+			events: []seatEvent{
+				{connection: a}, {connection: a},
+				{connection: a, at: time.Second, leave: true},
+				{connection: a, at: time.Second, leave: true},
+				{connection: a, at: 10 * time.Second}, {connection: a, at: 10 * time.Second},
+				{connection: a, at: 10 * time.Second}, {connection: a, at: 10 * time.Second},
+			},
+			// 1つ目のセッションで埋まらなかった2席と、先に抜けたプレイヤーの1席
+			want: seatStats{servers: 1, offeredSeats: 3},
+		},
+		{
+			name: "servers are tracked separately",
+			events: []seatEvent{
+				{connection: a}, {connection: a}, {connection: a}, {connection: a},
+				{connection: b}, {connection: b}, {connection: b}, {connection: b},
+				{connection: b, wantDuplicate: true},
+			},
+			want: seatStats{servers: 2, duplicates: 1},
+		},
+		{
+			name: "leave from an unknown server",
+			events: []seatEvent{
+				{connection: a, leave: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracker := newSeatTracker(4, 3*time.Second)
+			start := time.Unix(1600000000, 0)
+			for i, e := range tt.events {
+				if e.leave {
+					tracker.leave(e.connection)
+					continue
+				}
+				backfill, duplicate := tracker.assign(e.connection, start.Add(e.at))
+				if backfill != e.wantBackfill || duplicate != e.wantDuplicate {
+					t.Errorf("event %v: assign(%v) = %v, %v, want %v, %v", i, e.connection, backfill, duplicate, e.wantBackfill, e.wantDuplicate)
+				}
+			}
+			if got := tracker.stats(); got != tt.want {
+				t.Errorf("stats() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
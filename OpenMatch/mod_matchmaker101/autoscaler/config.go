@@ -0,0 +1,44 @@
+package main
+
+import (
+	"autoscaler/scaler"
+	"common/config"
+)
+
+// autoscalerConfig Autoscalerの接続先と待ち受けの設定
+type autoscalerConfig struct {
+	// QueryServiceEndpoint Open MatchのQueryServiceのhost:port
+	QueryServiceEndpoint string
+	// Port Agonesが呼び出すWebhookのポート
+	Port int
+	// FleetPolicies スケール対象のFleet("namespace/fleet")とその条件
+	// Directorのゲームモード設定と割り当て先に合わせること
+	FleetPolicies map[string]scaler.FleetPolicy
+}
+
+// conf Autoscalerの設定。mainの最初で読み込む
+var conf autoscalerConfig
+
+// loadConfig 設定を既定値、設定ファイル、フラグ、環境変数の順に読み込んで検証する
+func loadConfig(args []string) (autoscalerConfig, error) {
+	c := autoscalerConfig{FleetPolicies: scaler.DefaultFleetPolicies()}
+	l := config.NewLoader()
+	l.StringVar(&c.QueryServiceEndpoint, "query-service", "QUERY_SERVICE_ENDPOINT", "om-query.open-match.svc.cluster.local:50503", "host:port of the Open Match QueryService")
+	l.IntVar(&c.Port, "port", "PORT", 8000, "Port of the webhook called by Agones")
+	l.JSONVar(&c.FleetPolicies, "fleet-policies", "FLEET_POLICIES", "Scaling policy of each fleet keyed by \"namespace/fleet\", as JSON")
+	if err := l.Load(args); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+// validate 設定の誤りをまとめて返す
+func (c autoscalerConfig) validate() error {
+	var errs config.Errors
+	errs.Endpoint("query-service", c.QueryServiceEndpoint)
+	errs.Port("port", c.Port)
+	if err := scaler.ValidateFleetPolicies(c.FleetPolicies); err != nil {
+		errs.Add("%v", err)
+	}
+	return errs.Err()
+}
@@ -0,0 +1,13 @@
+module autoscaler
+
+go 1.13
+
+require (
+	common v0.0.0
+	github.com/sirupsen/logrus v1.4.2
+	google.golang.org/grpc v1.27.1
+	open-match.dev/open-match v0.9.0
+)
+
+// 全てのバイナリで共有する設定とログのパッケージ
+replace common => ../../../Common/mod_common
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"os"
+
+	"autoscaler/scaler"
+)
+
+// This service is a webhook FleetAutoscaler policy for Agones. Agones calls it
+// periodically for each Fleet, and it answers with the replicas the Fleet should
+// have for the Tickets waiting in Open Match.
+// The QueryService endpoint, the webhook port and the policy of each Fleet are
+// read from flags, environment variables or a config file, see config.go.
+
+func main() {
+	var err error
+	if conf, err = loadConfig(os.Args[1:]); err != nil {
+		scaler.Logger.WithError(err).Fatal("Failed to load the config")
+	}
+	scaler.Start(conf.QueryServiceEndpoint, conf.Port, conf.FleetPolicies)
+}
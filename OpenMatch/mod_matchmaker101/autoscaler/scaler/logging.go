@@ -0,0 +1,7 @@
+package scaler
+
+import "common/logging"
+
+// Logger is the JSON logger of the autoscaler. Its level is set by LOG_LEVEL
+// (debug, info, warn or error, defaults to info).
+var Logger = logging.New("autoscaler")
@@ -0,0 +1,82 @@
+package scaler
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// FleetPolicy is how one Fleet is scaled for the Tickets waiting in Open Match.
+type FleetPolicy struct {
+	// Modes このFleetでマッチを行うゲームモード(待ちチケットを数える対象)
+	Modes []string `json:"modes"`
+	// PlayersPerServer 1台のGameServerに入るプレイヤー数
+	PlayersPerServer int32 `json:"players_per_server"`
+	// BufferReplicas 待ちチケットとは別に常にReadyにしておく台数
+	BufferReplicas int32 `json:"buffer_replicas"`
+	// MinReplicas / MaxReplicas レプリカ数の下限と上限(MaxReplicasが0なら上限なし)
+	MinReplicas int32 `json:"min_replicas"`
+	MaxReplicas int32 `json:"max_replicas"`
+}
+
+// DefaultFleetPolicies returns the policies used when the config does not set them, keyed by
+// "namespace/fleet". They have to match the game modes and fleets of the director.
+func DefaultFleetPolicies() map[string]FleetPolicy {
+	return map[string]FleetPolicy{
+		"default/simple-udp": {
+			Modes:            []string{"mode.demo", "mode.ctf", "mode.battleroyale"},
+			PlayersPerServer: 4,
+			BufferReplicas:   2,
+			MinReplicas:      2,
+			MaxReplicas:      20,
+		},
+	}
+}
+
+// ValidateFleetPolicies returns an error describing the first invalid policy, if any.
+func ValidateFleetPolicies(policies map[string]FleetPolicy) error {
+	if len(policies) == 0 {
+		return fmt.Errorf("fleet policies must have at least one fleet")
+	}
+	fleets := make([]string, 0, len(policies))
+	for fleet := range policies {
+		fleets = append(fleets, fleet)
+	}
+	sort.Strings(fleets)
+	for _, fleet := range fleets {
+		policy := policies[fleet]
+		if parts := strings.Split(fleet, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return fmt.Errorf("fleet policy key must be \"namespace/fleet\", got %q", fleet)
+		}
+		if len(policy.Modes) == 0 {
+			return fmt.Errorf("fleet policy %v must have at least one mode", fleet)
+		}
+		if policy.PlayersPerServer < 1 {
+			return fmt.Errorf("players per server of fleet policy %v must be at least 1, got %v", fleet, policy.PlayersPerServer)
+		}
+		if policy.BufferReplicas < 0 || policy.MinReplicas < 0 || policy.MaxReplicas < 0 {
+			return fmt.Errorf("replicas of fleet policy %v must not be negative", fleet)
+		}
+		if policy.MaxReplicas > 0 && policy.MaxReplicas < policy.MinReplicas {
+			return fmt.Errorf("max replicas of fleet policy %v must not be less than min replicas %v, got %v", fleet, policy.MinReplicas, policy.MaxReplicas)
+		}
+	}
+	return nil
+}
+
+// desiredReplicas 使用中のサーバーを残したうえで、待ちプレイヤーを収容できる台数とバッファを確保する
+func desiredReplicas(policy FleetPolicy, status fleetStatus, waitingPlayers int32) int32 {
+	neededServers := int32(0)
+	if policy.PlayersPerServer > 0 {
+		neededServers = (waitingPlayers + policy.PlayersPerServer - 1) / policy.PlayersPerServer
+	}
+
+	replicas := status.AllocatedReplicas + status.ReservedReplicas + neededServers + policy.BufferReplicas
+	if replicas < policy.MinReplicas {
+		replicas = policy.MinReplicas
+	}
+	if policy.MaxReplicas > 0 && replicas > policy.MaxReplicas {
+		replicas = policy.MaxReplicas
+	}
+	return replicas
+}
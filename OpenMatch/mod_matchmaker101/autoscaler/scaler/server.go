@@ -0,0 +1,154 @@
+package scaler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"google.golang.org/grpc"
+	"open-match.dev/open-match/pkg/matchfunction"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// queryTimeout 1回のスケール判定で待ちチケットを問い合わせる時間の上限
+const queryTimeout = 5 * time.Second
+
+// fleetAutoscaleReview AgonesのWebhookポリシーが送受信するFleetAutoscaleReview
+// (autoscaling.agones.dev/v1)のうち、使うフィールドだけを定義したもの
+type fleetAutoscaleReview struct {
+	Request  *fleetAutoscaleRequest  `json:"request"`
+	Response *fleetAutoscaleResponse `json:"response"`
+}
+
+type fleetAutoscaleRequest struct {
+	UID       string      `json:"uid"`
+	Name      string      `json:"name"`
+	Namespace string      `json:"namespace"`
+	Status    fleetStatus `json:"status"`
+}
+
+type fleetStatus struct {
+	Replicas          int32 `json:"replicas"`
+	ReadyReplicas     int32 `json:"readyReplicas"`
+	ReservedReplicas  int32 `json:"reservedReplicas"`
+	AllocatedReplicas int32 `json:"allocatedReplicas"`
+}
+
+type fleetAutoscaleResponse struct {
+	UID      string `json:"uid"`
+	Scale    bool   `json:"scale"`
+	Replicas int32  `json:"replicas"`
+}
+
+// AutoscalerService Open Matchの待ちチケット数からFleetのレプリカ数を決める
+// QueryServiceClientを差し替えればOpen Matchなしで動かせる
+type AutoscalerService struct {
+	queryServiceClient pb.QueryServiceClient
+	// policies スケール対象のFleet("namespace/fleet")とその条件
+	policies map[string]FleetPolicy
+}
+
+// NewAutoscalerService 指定したQueryServiceClientとFleetの条件を使うAutoscalerServiceを作成
+func NewAutoscalerService(queryServiceClient pb.QueryServiceClient, policies map[string]FleetPolicy) *AutoscalerService {
+	return &AutoscalerService{
+		queryServiceClient: queryServiceClient,
+		policies:           policies,
+	}
+}
+
+// Start creates and starts the webhook server and also connects to Open
+// Match's queryService service, which is used to count the waiting Tickets.
+// The Fleets in policies are scaled, keyed by "namespace/fleet".
+func Start(queryServiceAddr string, serverPort int, policies map[string]FleetPolicy) {
+	// Connect to QueryService.
+	conn, err := grpc.Dial(queryServiceAddr, grpc.WithInsecure())
+	if err != nil {
+		Logger.WithError(err).Fatal("Failed to connect to Open Match")
+	}
+	defer conn.Close()
+
+	service := NewAutoscalerService(pb.NewQueryServiceClient(conn), policies)
+	http.HandleFunc("/scale", service.HandleScale)
+	http.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	Logger.Infof("Webhook server listening on port %v", serverPort)
+	err = http.ListenAndServe(fmt.Sprintf(":%d", serverPort), nil)
+	if err != nil {
+		Logger.WithError(err).Fatal("Webhook server failed")
+	}
+}
+
+// HandleScale AgonesからのFleetAutoscaleReviewにレプリカ数を返す
+// 対象外のFleetにはスケールしない応答を返し、チケットを数えられなかった場合はエラーにしてAgonesに現状維持させる
+func (s *AutoscalerService) HandleScale(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Post Only", http.StatusMethodNotAllowed)
+		return
+	}
+	var review fleetAutoscaleReview
+	if err := json.NewDecoder(r.Body).Decode(&review); err != nil || review.Request == nil {
+		http.Error(w, "invalid FleetAutoscaleReview", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), queryTimeout)
+	defer cancel()
+	res, err := s.Scale(ctx, review.Request)
+	if err != nil {
+		Logger.WithError(err).WithField("fleet", review.Request.Namespace+"/"+review.Request.Name).Error("Failed to scale fleet")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	review.Response = res
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&review); err != nil {
+		Logger.WithError(err).Error("Failed to write FleetAutoscaleReview")
+	}
+}
+
+// Scale Fleetの状態と待ちチケット数からレプリカ数を決める
+func (s *AutoscalerService) Scale(ctx context.Context, req *fleetAutoscaleRequest) (*fleetAutoscaleResponse, error) {
+	res := &fleetAutoscaleResponse{UID: req.UID, Replicas: req.Status.Replicas}
+	policy, ok := s.policies[req.Namespace+"/"+req.Name]
+	if !ok {
+		return res, nil
+	}
+
+	waitingPlayers := int32(0)
+	for _, mode := range policy.Modes {
+		n, err := s.countWaitingPlayers(ctx, mode)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count waiting players of %v, got %w", mode, err)
+		}
+		waitingPlayers += n
+	}
+
+	res.Replicas = desiredReplicas(policy, req.Status, waitingPlayers)
+	res.Scale = res.Replicas != req.Status.Replicas
+	if res.Scale {
+		Logger.WithField("fleet", req.Namespace+"/"+req.Name).Infof("Scaling fleet from %v to %v replicas for %v waiting players",
+			req.Status.Replicas, res.Replicas, waitingPlayers)
+	}
+	return res, nil
+}
+
+// countWaitingPlayers ゲームモードのマッチ待ちのプレイヤーチケット数(BackfillTicketは含まない)
+func (s *AutoscalerService) countWaitingPlayers(ctx context.Context, mode string) (int32, error) {
+	pool := &pb.Pool{
+		Name: "pool_waiting_" + mode,
+		TagPresentFilters: []*pb.TagPresentFilter{
+			{Tag: mode},
+			{Tag: "player"},
+		},
+	}
+	tickets, err := matchfunction.QueryPool(ctx, s.queryServiceClient, pool)
+	if err != nil {
+		return 0, err
+	}
+	return int32(len(tickets)), nil
+}
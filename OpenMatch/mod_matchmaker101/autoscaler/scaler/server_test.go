@@ -0,0 +1,233 @@
+package scaler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// fakeQueryService ゲームモードごとの待ちチケットを返すQueryServiceClient
+type fakeQueryService struct {
+	// waiting ゲームモード -> 待ちチケット数
+	waiting map[string]int
+	// err 指定するとQueryTicketsがこのエラーを返す
+	err error
+}
+
+func (f *fakeQueryService) QueryTickets(ctx context.Context, in *pb.QueryTicketsRequest, opts ...grpc.CallOption) (pb.QueryService_QueryTicketsClient, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	mode := ""
+	for _, filter := range in.GetPool().GetTagPresentFilters() {
+		if filter.GetTag() != "player" {
+			mode = filter.GetTag()
+		}
+	}
+	// QueryServiceと同じく複数のレスポンスに分けて返す
+	stream := &fakeTicketStream{}
+	for i := 0; i < f.waiting[mode]; i++ {
+		ticket := &pb.Ticket{Id: fmt.Sprintf("%v-%d", mode, i)}
+		stream.responses = append(stream.responses, &pb.QueryTicketsResponse{Tickets: []*pb.Ticket{ticket}})
+	}
+	return stream, nil
+}
+
+// fakeTicketStream 用意したレスポンスを順に返し、最後にio.EOFを返すストリーム
+type fakeTicketStream struct {
+	grpc.ClientStream
+	responses []*pb.QueryTicketsResponse
+}
+
+func (s *fakeTicketStream) Recv() (*pb.QueryTicketsResponse, error) {
+	if len(s.responses) == 0 {
+		return nil, io.EOF
+	}
+	res := s.responses[0]
+	s.responses = s.responses[1:]
+	return res, nil
+}
+
+var testPolicies = map[string]FleetPolicy{
+	"default/simple-udp": {
+		Modes:            []string{"mode.demo", "mode.ctf"},
+		PlayersPerServer: 4,
+		BufferReplicas:   2,
+		MinReplicas:      3,
+		MaxReplicas:      10,
+	},
+}
+
+// postReview FleetAutoscaleReviewをHandleScaleに送り、レスポンスを返す
+func postReview(t *testing.T, service *AutoscalerService, body string) *httptest.ResponseRecorder {
+	t.Helper()
+	w := httptest.NewRecorder()
+	service.HandleScale(w, httptest.NewRequest(http.MethodPost, "/scale", strings.NewReader(body)))
+	return w
+}
+
+func reviewBody(t *testing.T, namespace, name string, status fleetStatus) string {
+	t.Helper()
+	b, err := json.Marshal(&fleetAutoscaleReview{Request: &fleetAutoscaleRequest{
+		UID:       "uid-1",
+		Name:      name,
+		Namespace: namespace,
+		Status:    status,
+	}})
+	if err != nil {
+		t.Fatalf("failed to marshal review: %v", err)
+	}
+	return string(b)
+}
+
+func TestHandleScale(t *testing.T) {
+	tests := []struct {
+		name    string
+		waiting map[string]int
+		status  fleetStatus
+		want    int32
+	}{
+		{
+			name:   "buffer on top of no waiting players is raised to min replicas",
+			status: fleetStatus{Replicas: 3},
+			want:   3,
+		},
+		{
+			name:    "waiting players of every mode need servers plus the buffer",
+			waiting: map[string]int{"mode.demo": 5, "mode.ctf": 4, "mode.battleroyale": 100},
+			status:  fleetStatus{Replicas: 3},
+			want:    3 + 2,
+		},
+		{
+			name:    "allocated and reserved replicas are kept",
+			waiting: map[string]int{"mode.demo": 1},
+			status:  fleetStatus{Replicas: 6, AllocatedReplicas: 4, ReservedReplicas: 1},
+			want:    4 + 1 + 1 + 2,
+		},
+		{
+			name:    "clamped to max replicas",
+			waiting: map[string]int{"mode.ctf": 40},
+			status:  fleetStatus{Replicas: 3, AllocatedReplicas: 2},
+			want:    10,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := NewAutoscalerService(&fakeQueryService{waiting: tt.waiting}, testPolicies)
+			w := postReview(t, service, reviewBody(t, "default", "simple-udp", tt.status))
+			if w.Code != http.StatusOK {
+				t.Fatalf("got status %v, want %v: %v", w.Code, http.StatusOK, w.Body.String())
+			}
+			var review fleetAutoscaleReview
+			if err := json.NewDecoder(w.Body).Decode(&review); err != nil {
+				t.Fatalf("failed to decode response: %v", err)
+			}
+			res := review.Response
+			if res == nil {
+				t.Fatal("response is missing")
+			}
+			if res.UID != "uid-1" {
+				t.Errorf("got uid %q, want %q", res.UID, "uid-1")
+			}
+			if res.Replicas != tt.want {
+				t.Errorf("got %v replicas, want %v", res.Replicas, tt.want)
+			}
+			if wantScale := tt.want != tt.status.Replicas; res.Scale != wantScale {
+				t.Errorf("got scale %v, want %v", res.Scale, wantScale)
+			}
+		})
+	}
+}
+
+func TestHandleScaleUnknownFleet(t *testing.T) {
+	service := NewAutoscalerService(&fakeQueryService{err: errors.New("must not be queried")}, testPolicies)
+	w := postReview(t, service, reviewBody(t, "default", "other-fleet", fleetStatus{Replicas: 5}))
+	if w.Code != http.StatusOK {
+		t.Fatalf("got status %v, want %v: %v", w.Code, http.StatusOK, w.Body.String())
+	}
+	var review fleetAutoscaleReview
+	if err := json.NewDecoder(w.Body).Decode(&review); err != nil {
+		t.Fatalf("failed to decode response: %v", err)
+	}
+	if review.Response == nil || review.Response.Scale || review.Response.Replicas != 5 {
+		t.Errorf("got response %+v, want no scaling at 5 replicas", review.Response)
+	}
+}
+
+func TestHandleScaleQueryError(t *testing.T) {
+	service := NewAutoscalerService(&fakeQueryService{err: errors.New("query service unavailable")}, testPolicies)
+	w := postReview(t, service, reviewBody(t, "default", "simple-udp", fleetStatus{Replicas: 3}))
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("got status %v, want %v", w.Code, http.StatusInternalServerError)
+	}
+}
+
+func TestHandleScaleInvalidRequest(t *testing.T) {
+	service := NewAutoscalerService(&fakeQueryService{}, testPolicies)
+
+	w := httptest.NewRecorder()
+	service.HandleScale(w, httptest.NewRequest(http.MethodGet, "/scale", nil))
+	if w.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET got status %v, want %v", w.Code, http.StatusMethodNotAllowed)
+	}
+
+	for _, body := range []string{"not json", "{}", `{"request": "simple-udp"}`} {
+		if w := postReview(t, service, body); w.Code != http.StatusBadRequest {
+			t.Errorf("body %q got status %v, want %v", body, w.Code, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestValidateFleetPolicies(t *testing.T) {
+	if err := ValidateFleetPolicies(DefaultFleetPolicies()); err != nil {
+		t.Errorf("default policies are invalid: %v", err)
+	}
+	valid := testPolicies["default/simple-udp"]
+	invalid := map[string]func(p *FleetPolicy){
+		"no modes":          func(p *FleetPolicy) { p.Modes = nil },
+		"no players":        func(p *FleetPolicy) { p.PlayersPerServer = 0 },
+		"negative buffer":   func(p *FleetPolicy) { p.BufferReplicas = -1 },
+		"max less than min": func(p *FleetPolicy) { p.MaxReplicas = p.MinReplicas - 1 },
+		"negative min":      func(p *FleetPolicy) { p.MinReplicas = -1 },
+		"negative max":      func(p *FleetPolicy) { p.MaxReplicas = -1 },
+	}
+	for name, modify := range invalid {
+		policy := valid
+		modify(&policy)
+		if err := ValidateFleetPolicies(map[string]FleetPolicy{"default/simple-udp": policy}); err == nil {
+			t.Errorf("%v: got no error", name)
+		}
+	}
+	for _, key := range []string{"simple-udp", "default/", "/simple-udp", "a/b/c"} {
+		if err := ValidateFleetPolicies(map[string]FleetPolicy{key: valid}); err == nil {
+			t.Errorf("key %q: got no error", key)
+		}
+	}
+	if err := ValidateFleetPolicies(nil); err == nil {
+		t.Error("no policies: got no error")
+	}
+}
+
+func TestFleetPolicyJSON(t *testing.T) {
+	// DirectorやMMFの設定と同じくsnake_caseのキーで書く
+	const raw = `{"modes": ["mode.demo"], "players_per_server": 4, "buffer_replicas": 2, "min_replicas": 1, "max_replicas": 10}`
+	dec := json.NewDecoder(strings.NewReader(raw))
+	dec.DisallowUnknownFields()
+	var got FleetPolicy
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("failed to decode %v: %v", raw, err)
+	}
+	want := FleetPolicy{Modes: []string{"mode.demo"}, PlayersPerServer: 4, BufferReplicas: 2, MinReplicas: 1, MaxReplicas: 10}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("decoded %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"director/allocatorpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+// AllocateError AllocateServiceが返すエラー
+// StatusCodeは呼び出し自体のgRPCステータスで、バッチの1件分のエラーではOK
+type AllocateError struct {
+	StatusCode codes.Code
+	Code       allocatorpb.AllocationError_Code
+	Reason     string
+	Retryable  bool
+}
+
+func (e *AllocateError) Error() string {
+	if e.StatusCode == codes.OK {
+		return fmt.Sprintf("%s: %s", e.Code, e.Reason)
+	}
+	return fmt.Sprintf("%s (%s): %s", e.Code, e.StatusCode, e.Reason)
+}
+
+const (
+	// allocateMaxAttempts リトライ可能なエラーの場合に割り当てを試す最大回数
+	allocateMaxAttempts = 4
+	// allocateInitialBackoff 最初のリトライまでの待ち時間(以降倍々にする)
+	allocateInitialBackoff = 250 * time.Millisecond
+	// allocateBatchSize 1回のAllocateBatchで送るリクエストの最大数(AllocateServiceの上限に合わせる)
+	allocateBatchSize = 100
+)
+
+// allocatorClient AllocateServiceのgRPCクライアント
+var allocatorClient allocatorpb.AllocationServiceClient
+
+// basicAuthCredentials 呼び出しごとに認証情報を読み直してBasic認証のヘッダーを付ける
+type basicAuthCredentials struct {
+	secure bool
+}
+
+func (c basicAuthCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	allocateKey, allocateSecret, err := allocatorCredentials()
+	if err != nil {
+		return nil, err
+	}
+	auth := base64.StdEncoding.EncodeToString([]byte(allocateKey + ":" + allocateSecret))
+	return map[string]string{"authorization": "Basic " + auth}, nil
+}
+
+func (c basicAuthCredentials) RequireTransportSecurity() bool {
+	return c.secure
+}
+
+// setupAllocatorClient AllocateServiceへのクライアントを設定する
+// AllocatorCAFileを指定するとTLSでサーバー証明書を検証し、
+// さらにAllocatorCertFile/AllocatorKeyFileを指定するとクライアント証明書を提示する(mTLS)
+func setupAllocatorClient() error {
+	endpoint := conf.AllocatorEndpoint
+
+	caFile := conf.AllocatorCAFile
+	certFile, keyFile := conf.AllocatorCertFile, conf.AllocatorKeyFile
+	if caFile == "" {
+		conn, err := grpc.Dial(endpoint, grpc.WithInsecure(), grpc.WithPerRPCCredentials(basicAuthCredentials{}),
+			grpc.WithUnaryInterceptor(traceUnaryClientInterceptor))
+		if err != nil {
+			return fmt.Errorf("failed to connect to AllocateService, got %w", err)
+		}
+		allocatorClient = allocatorpb.NewAllocationServiceClient(conn)
+		return nil
+	}
+
+	pem, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return fmt.Errorf("failed to read allocator CA, got %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return fmt.Errorf("no certificates found in %v", caFile)
+	}
+	tlsConfig := &tls.Config{
+		RootCAs:    pool,
+		MinVersion: tls.VersionTLS12,
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return fmt.Errorf("failed to load allocator client certificate, got %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	conn, err := grpc.Dial(endpoint,
+		grpc.WithTransportCredentials(credentials.NewTLS(tlsConfig)),
+		grpc.WithPerRPCCredentials(basicAuthCredentials{secure: true}),
+		grpc.WithUnaryInterceptor(traceUnaryClientInterceptor))
+	if err != nil {
+		return fmt.Errorf("failed to connect to AllocateService, got %w", err)
+	}
+	allocatorClient = allocatorpb.NewAllocationServiceClient(conn)
+	return nil
+}
+
+// allocateServers AllocateServiceに複数マッチ分のGameServerの割り当てを1回でまとめて依頼する
+// 結果とエラー、試行回数はrequestsと同じ順番で返し、リトライ可能なエラーになったものだけバックオフしながら再試行する
+func allocateServers(ctx context.Context, requests []*allocatorpb.AllocateRequest) ([]*allocatorpb.AllocateResponse, []error, []int) {
+	alos := make([]*allocatorpb.AllocateResponse, len(requests))
+	errs := make([]error, len(requests))
+	attempts := make([]int, len(requests))
+
+	pending := make([]int, len(requests))
+	for i := range requests {
+		pending[i] = i
+	}
+	backoff := allocateInitialBackoff
+	for attempt := 1; ; attempt++ {
+		batch := make([]*allocatorpb.AllocateRequest, len(pending))
+		for j, i := range pending {
+			batch[j] = requests[i]
+		}
+		results, err := requestBatchAllocation(ctx, batch)
+		for j, i := range pending {
+			attempts[i] = attempt
+			if err != nil {
+				alos[i], errs[i] = nil, err
+			} else {
+				alos[i], errs[i] = toAllocation(results[j])
+			}
+		}
+
+		var retry []int
+		for _, i := range pending {
+			if errs[i] != nil && isRetryableAllocateError(errs[i]) {
+				retry = append(retry, i)
+			}
+		}
+		if len(retry) == 0 || attempt >= allocateMaxAttempts {
+			return alos, errs, attempts
+		}
+		logger.Warnf("Allocate attempt %v failed for %v of %v matches, retrying in %v", attempt, len(retry), len(requests), backoff)
+		if err := sleepContext(ctx, backoff); err != nil {
+			for _, i := range retry {
+				errs[i] = err
+			}
+			return alos, errs, attempts
+		}
+		backoff *= 2
+		pending = retry
+	}
+}
+
+// requestBatchAllocation AllocateServiceのAllocateBatchを呼び出す
+// 上限を超える分はallocateBatchSizeずつに分けて呼び出す
+func requestBatchAllocation(ctx context.Context, requests []*allocatorpb.AllocateRequest) ([]*allocatorpb.AllocateResult, error) {
+	results := []*allocatorpb.AllocateResult{}
+	for start := 0; start < len(requests); start += allocateBatchSize {
+		end := start + allocateBatchSize
+		if end > len(requests) {
+			end = len(requests)
+		}
+		chunk, err := requestAllocationChunk(ctx, requests[start:end])
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, chunk...)
+	}
+	return results, nil
+}
+
+// requestAllocationChunk AllocateServiceのAllocateBatchを1回呼び出す
+func requestAllocationChunk(ctx context.Context, requests []*allocatorpb.AllocateRequest) ([]*allocatorpb.AllocateResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, conf.AllocateTimeout)
+	defer cancel()
+
+	res, err := allocatorClient.AllocateBatch(ctx, &allocatorpb.AllocateBatchRequest{Requests: requests})
+	if err != nil {
+		return nil, toAllocateError(err)
+	}
+	if len(res.GetResults()) != len(requests) {
+		return nil, fmt.Errorf("allocate batch returned %v results for %v requests", len(res.GetResults()), len(requests))
+	}
+	return res.GetResults(), nil
+}
+
+// toAllocation バッチの1件分の結果を割り当てかエラーにする
+func toAllocation(result *allocatorpb.AllocateResult) (*allocatorpb.AllocateResponse, error) {
+	if aloErr := result.GetError(); aloErr != nil {
+		return nil, &AllocateError{
+			StatusCode: codes.OK,
+			Code:       aloErr.GetCode(),
+			Reason:     aloErr.GetReason(),
+			Retryable:  aloErr.GetRetryable(),
+		}
+	}
+	alo := result.GetAllocation()
+	if alo.GetAddress() == "" || len(alo.GetPorts()) == 0 {
+		return nil, fmt.Errorf("allocate responce has no usable GameServer: %v", alo)
+	}
+	return alo, nil
+}
+
+// isRetryableAllocateError AllocateError以外(応答の不整合等)はリトライ対象にする
+func isRetryableAllocateError(err error) bool {
+	var aloErr *AllocateError
+	return !errors.As(err, &aloErr) || aloErr.Retryable
+}
+
+// deallocateServer 使われなくなったGameServerの解放(Shutdown)をAllocateServiceに依頼する
+func deallocateServer(ctx context.Context, namespace, gameServerName, reason string) error {
+	ctx, cancel := context.WithTimeout(ctx, conf.AllocateTimeout)
+	defer cancel()
+
+	_, err := allocatorClient.Deallocate(ctx, &allocatorpb.DeallocateRequest{
+		Namespace:      namespace,
+		GameServerName: gameServerName,
+		Reason:         reason,
+	})
+	if err != nil {
+		return toAllocateError(err)
+	}
+	return nil
+}
+
+// allocateRequest ゲームモードの割り当て条件とマッチ情報からAllocateServiceへのリクエストを作る
+func allocateRequest(settings allocationSettings, match *allocatorpb.MatchMetadata) *allocatorpb.AllocateRequest {
+	return &allocatorpb.AllocateRequest{
+		Namespace: settings.Namespace,
+		Fleets:    settings.Fleets,
+		Selector:  settings.Selector,
+		Preferred: settings.Preferred,
+		Match:     match,
+	}
+}
+
+// toAllocateError gRPCのエラーをAllocateErrorに変換する
+// AllocationErrorの詳細がないエラー(接続失敗等)は一時的なものだけリトライ対象にする
+func toAllocateError(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	for _, detail := range st.Details() {
+		if aloErr, ok := detail.(*allocatorpb.AllocationError); ok {
+			return &AllocateError{
+				StatusCode: st.Code(),
+				Code:       aloErr.GetCode(),
+				Reason:     aloErr.GetReason(),
+				Retryable:  aloErr.GetRetryable(),
+			}
+		}
+	}
+	retryable := false
+	switch st.Code() {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.ResourceExhausted, codes.Aborted:
+		retryable = true
+	}
+	return &AllocateError{
+		StatusCode: st.Code(),
+		Code:       allocatorpb.AllocationError_UNKNOWN,
+		Reason:     st.Message(),
+		Retryable:  retryable,
+	}
+}
+
+// allocatorCredentials AllocateServiceの認証情報を取得
+// Secretのローテーションに追従するため毎回読み直す
+// マウントされたSecretがなければ環境変数ALLOCATOR_CLIENT_KEY/ALLOCATOR_CLIENT_SECRETを使う
+func allocatorCredentials() (string, string, error) {
+	dir := conf.AllocatorCredentialsDir
+	key, err := ioutil.ReadFile(filepath.Join(dir, "key"))
+	if err == nil {
+		var secret []byte
+		secret, err = ioutil.ReadFile(filepath.Join(dir, "secret"))
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read allocator secret, got %w", err)
+		}
+		return strings.TrimSpace(string(key)), strings.TrimSpace(string(secret)), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", "", fmt.Errorf("failed to read allocator key, got %w", err)
+	}
+
+	envKey, envSecret := os.Getenv("ALLOCATOR_CLIENT_KEY"), os.Getenv("ALLOCATOR_CLIENT_SECRET")
+	if envKey == "" || envSecret == "" {
+		return "", "", fmt.Errorf("no allocator credentials in %v or ALLOCATOR_CLIENT_KEY/ALLOCATOR_CLIENT_SECRET", dir)
+	}
+	return envKey, envSecret, nil
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"director/allocatorpb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// fakeAllocator AllocateBatchの呼び出しを記録し、マッチIDごとに用意した結果を試行ごとに順に返すAllocationServiceClient
+type fakeAllocator struct {
+	allocatorpb.AllocationServiceClient
+
+	mu sync.Mutex
+	// results マッチIDごとの結果。試行回数より少なければ最後の結果を繰り返す。なければ割り当てる
+	results map[string][]*allocatorpb.AllocateResult
+	// callErrs 呼び出しごとに順に返すエラー。nilの呼び出しは結果を返す
+	callErrs []error
+	// batches 呼び出しごとのリクエスト数
+	batches []int
+	tries   map[string]int
+}
+
+func (f *fakeAllocator) AllocateBatch(ctx context.Context, in *allocatorpb.AllocateBatchRequest, opts ...grpc.CallOption) (*allocatorpb.AllocateBatchResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	call := len(f.batches)
+	f.batches = append(f.batches, len(in.GetRequests()))
+	if call < len(f.callErrs) && f.callErrs[call] != nil {
+		return nil, f.callErrs[call]
+	}
+
+	res := &allocatorpb.AllocateBatchResponse{}
+	for _, req := range in.GetRequests() {
+		id := req.GetMatch().GetMatchId()
+		try := f.tries[id]
+		f.tries[id]++
+		results := f.results[id]
+		switch {
+		case len(results) == 0:
+			res.Results = append(res.Results, allocated(id))
+		case try < len(results):
+			res.Results = append(res.Results, results[try])
+		default:
+			res.Results = append(res.Results, results[len(results)-1])
+		}
+	}
+	return res, nil
+}
+
+// useFakeAllocator allocatorClientをfに差し替え、テストの終わりに戻す
+func useFakeAllocator(t *testing.T, f *fakeAllocator) {
+	t.Helper()
+	f.tries = map[string]int{}
+	client, timeout := allocatorClient, conf.AllocateTimeout
+	allocatorClient, conf.AllocateTimeout = f, time.Second
+	t.Cleanup(func() {
+		allocatorClient, conf.AllocateTimeout = client, timeout
+	})
+}
+
+// allocated マッチidにGameServer gs-<id>を割り当てた結果
+func allocated(id string) *allocatorpb.AllocateResult {
+	return &allocatorpb.AllocateResult{Allocation: &allocatorpb.AllocateResponse{
+		GameServerName: "gs-" + id,
+		Address:        "10.0.0.1",
+		Ports:          []*allocatorpb.Port{{Name: "default", Port: 7000}},
+	}}
+}
+
+// allocationFailed 割り当てられなかった結果
+func allocationFailed(code allocatorpb.AllocationError_Code, retryable bool) *allocatorpb.AllocateResult {
+	return &allocatorpb.AllocateResult{Error: &allocatorpb.AllocationError{Code: code, Reason: code.String(), Retryable: retryable}}
+}
+
+// matchRequests マッチID ids分のリクエスト
+func matchRequests(ids ...string) []*allocatorpb.AllocateRequest {
+	requests := []*allocatorpb.AllocateRequest{}
+	for _, id := range ids {
+		requests = append(requests, allocateRequest(allocationSettings{Namespace: "default", Fleets: []string{"fleet-a"}}, &allocatorpb.MatchMetadata{MatchId: id}))
+	}
+	return requests
+}
+
+func TestAllocateServersRetry(t *testing.T) {
+	noReady := allocationFailed(allocatorpb.AllocationError_NO_READY_REPLICAS, true)
+	notAllowed := allocationFailed(allocatorpb.AllocationError_FLEET_NOT_ALLOWED, false)
+	noPorts := &allocatorpb.AllocateResult{Allocation: &allocatorpb.AllocateResponse{GameServerName: "gs-no-ports", Address: "10.0.0.1"}}
+	unavailable := status.Error(codes.Unavailable, "connection refused")
+
+	tests := []struct {
+		name     string
+		ids      []string
+		results  map[string][]*allocatorpb.AllocateResult
+		callErrs []error
+		// wantCodes マッチIDごとのエラーのコード。ないものは割り当てられる
+		wantCodes    map[string]allocatorpb.AllocationError_Code
+		wantAttempts []int
+		wantBatches  []int
+	}{
+		{
+			name:         "allocated on the first attempt",
+			ids:          []string{"m1", "m2"},
+			wantAttempts: []int{1, 1},
+			wantBatches:  []int{2},
+		},
+		{
+			name:         "only the retryable failures are retried",
+			ids:          []string{"m1", "m2", "m3"},
+			results:      map[string][]*allocatorpb.AllocateResult{"m2": {noReady, noReady, allocated("m2")}, "m3": {notAllowed}},
+			wantCodes:    map[string]allocatorpb.AllocationError_Code{"m3": allocatorpb.AllocationError_FLEET_NOT_ALLOWED},
+			wantAttempts: []int{1, 3, 1},
+			wantBatches:  []int{3, 1, 1},
+		},
+		{
+			name:         "retryable failure until the last attempt",
+			ids:          []string{"m1"},
+			results:      map[string][]*allocatorpb.AllocateResult{"m1": {noReady}},
+			wantCodes:    map[string]allocatorpb.AllocationError_Code{"m1": allocatorpb.AllocationError_NO_READY_REPLICAS},
+			wantAttempts: []int{allocateMaxAttempts},
+			wantBatches:  []int{1, 1, 1, 1},
+		},
+		{
+			name:         "failed call retries the whole batch",
+			ids:          []string{"m1", "m2"},
+			callErrs:     []error{unavailable},
+			wantAttempts: []int{2, 2},
+			wantBatches:  []int{2, 2},
+		},
+		{
+			name:         "response without ports is retried",
+			ids:          []string{"m1"},
+			results:      map[string][]*allocatorpb.AllocateResult{"m1": {noPorts, allocated("m1")}},
+			wantAttempts: []int{2},
+			wantBatches:  []int{1, 1},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := &fakeAllocator{results: tt.results, callErrs: tt.callErrs}
+			useFakeAllocator(t, f)
+
+			alos, errs, attempts := allocateServers(context.Background(), matchRequests(tt.ids...))
+			for i, id := range tt.ids {
+				if attempts[i] != tt.wantAttempts[i] {
+					t.Errorf("%v: attempts = %v, want %v", id, attempts[i], tt.wantAttempts[i])
+				}
+				code, ok := tt.wantCodes[id]
+				if !ok {
+					if errs[i] != nil || alos[i].GetGameServerName() != "gs-"+id {
+						t.Errorf("%v: allocateServers() = %v, %v, want gs-%v", id, alos[i], errs[i], id)
+					}
+					continue
+				}
+				var aloErr *AllocateError
+				if !errors.As(errs[i], &aloErr) || aloErr.Code != code {
+					t.Errorf("%v: error = %v, want %v", id, errs[i], code)
+				}
+				if alos[i] != nil {
+					t.Errorf("%v: allocation = %v with an error", id, alos[i])
+				}
+			}
+			if fmt.Sprint(f.batches) != fmt.Sprint(tt.wantBatches) {
+				t.Errorf("batches = %v, want %v", f.batches, tt.wantBatches)
+			}
+		})
+	}
+}
+
+func TestAllocateServersChunks(t *testing.T) {
+	f := &fakeAllocator{}
+	useFakeAllocator(t, f)
+
+	ids := []string{}
+	for i := 0; i < 2*allocateBatchSize+50; i++ {
+		ids = append(ids, fmt.Sprintf("m%d", i))
+	}
+	alos, errs, _ := allocateServers(context.Background(), matchRequests(ids...))
+
+	want := []int{allocateBatchSize, allocateBatchSize, 50}
+	if fmt.Sprint(f.batches) != fmt.Sprint(want) {
+		t.Errorf("batches = %v, want %v", f.batches, want)
+	}
+	// 分割しても結果はリクエストの順番に並ぶ
+	for i, id := range ids {
+		if errs[i] != nil || alos[i].GetGameServerName() != "gs-"+id {
+			t.Fatalf("result %v = %v, %v, want gs-%v", i, alos[i], errs[i], id)
+		}
+	}
+}
+
+func TestAllocateServersCanceledDuringBackoff(t *testing.T) {
+	f := &fakeAllocator{results: map[string][]*allocatorpb.AllocateResult{
+		"m1": {allocationFailed(allocatorpb.AllocationError_CONTENTION, true)},
+	}}
+	useFakeAllocator(t, f)
+
+	ctx, cancel := context.WithTimeout(context.Background(), allocateInitialBackoff/2)
+	defer cancel()
+	_, errs, attempts := allocateServers(ctx, matchRequests("m1"))
+	if !errors.Is(errs[0], context.DeadlineExceeded) {
+		t.Errorf("error = %v, want %v", errs[0], context.DeadlineExceeded)
+	}
+	if attempts[0] != 1 {
+		t.Errorf("attempts = %v, want 1", attempts[0])
+	}
+}
+
+func TestToAllocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		result   *allocatorpb.AllocateResult
+		wantCode allocatorpb.AllocationError_Code
+		wantErr  bool
+	}{
+		{name: "allocation", result: allocated("m1")},
+		{
+			name:     "allocation error",
+			result:   allocationFailed(allocatorpb.AllocationError_CONTENTION, true),
+			wantCode: allocatorpb.AllocationError_CONTENTION,
+			wantErr:  true,
+		},
+		{
+			name:    "no address",
+			result:  &allocatorpb.AllocateResult{Allocation: &allocatorpb.AllocateResponse{GameServerName: "gs-m1", Ports: []*allocatorpb.Port{{Port: 7000}}}},
+			wantErr: true,
+		},
+		{
+			name:    "no ports",
+			result:  &allocatorpb.AllocateResult{Allocation: &allocatorpb.AllocateResponse{GameServerName: "gs-m1", Address: "10.0.0.1"}},
+			wantErr: true,
+		},
+		{name: "empty result", result: &allocatorpb.AllocateResult{}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alo, err := toAllocation(tt.result)
+			if !tt.wantErr {
+				if err != nil || alo == nil {
+					t.Fatalf("toAllocation() = %v, %v, want an allocation", alo, err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("toAllocation() = %v, want an error", alo)
+			}
+			var aloErr *AllocateError
+			isAloErr := errors.As(err, &aloErr)
+			// バッチの1件分のエラーは呼び出し自体は成功しているのでStatusCodeはOK
+			if tt.wantCode != allocatorpb.AllocationError_UNKNOWN {
+				if !isAloErr || aloErr.Code != tt.wantCode || aloErr.StatusCode != codes.OK || !aloErr.Retryable {
+					t.Errorf("toAllocation() error = %#v, want %v with status OK", err, tt.wantCode)
+				}
+				return
+			}
+			// 使えない応答はAllocateErrorにせず、リトライの対象にする
+			if isAloErr || !isRetryableAllocateError(err) {
+				t.Errorf("toAllocation() error = %v, want a retryable response error", err)
+			}
+		})
+	}
+}
+
+// statusWithDetail AllocationErrorの詳細つきのgRPCステータスのエラー
+func statusWithDetail(t *testing.T, c codes.Code, detail *allocatorpb.AllocationError) error {
+	t.Helper()
+	st, err := status.New(c, detail.GetReason()).WithDetails(detail)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return st.Err()
+}
+
+func TestToAllocateError(t *testing.T) {
+	refused := errors.New("connection refused")
+	tests := []struct {
+		name          string
+		err           error
+		wantStatus    codes.Code
+		wantCode      allocatorpb.AllocationError_Code
+		wantRetryable bool
+		// wantUnchanged gRPCのステータスでないエラーはそのまま返す
+		wantUnchanged bool
+	}{
+		{
+			name:          "no ready replicas",
+			err:           statusWithDetail(t, codes.ResourceExhausted, &allocatorpb.AllocationError{Code: allocatorpb.AllocationError_NO_READY_REPLICAS, Reason: "no ready", Retryable: true}),
+			wantStatus:    codes.ResourceExhausted,
+			wantCode:      allocatorpb.AllocationError_NO_READY_REPLICAS,
+			wantRetryable: true,
+		},
+		{
+			name:       "fleet not allowed",
+			err:        statusWithDetail(t, codes.PermissionDenied, &allocatorpb.AllocationError{Code: allocatorpb.AllocationError_FLEET_NOT_ALLOWED, Reason: "not allowed"}),
+			wantStatus: codes.PermissionDenied,
+			wantCode:   allocatorpb.AllocationError_FLEET_NOT_ALLOWED,
+		},
+		{
+			// 詳細のretryableがステータスコードより優先される
+			name:       "detail decides retryable",
+			err:        statusWithDetail(t, codes.Unavailable, &allocatorpb.AllocationError{Code: allocatorpb.AllocationError_INVALID_REQUEST, Reason: "invalid"}),
+			wantStatus: codes.Unavailable,
+			wantCode:   allocatorpb.AllocationError_INVALID_REQUEST,
+		},
+		{
+			name:          "deadline exceeded detail",
+			err:           statusWithDetail(t, codes.DeadlineExceeded, &allocatorpb.AllocationError{Code: allocatorpb.AllocationError_DEADLINE_EXCEEDED, Reason: "deadline", Retryable: true}),
+			wantStatus:    codes.DeadlineExceeded,
+			wantCode:      allocatorpb.AllocationError_DEADLINE_EXCEEDED,
+			wantRetryable: true,
+		},
+		{name: "unavailable without detail", err: status.Error(codes.Unavailable, "refused"), wantStatus: codes.Unavailable, wantRetryable: true},
+		{name: "deadline exceeded without detail", err: status.Error(codes.DeadlineExceeded, "deadline"), wantStatus: codes.DeadlineExceeded, wantRetryable: true},
+		{name: "resource exhausted without detail", err: status.Error(codes.ResourceExhausted, "exhausted"), wantStatus: codes.ResourceExhausted, wantRetryable: true},
+		{name: "aborted without detail", err: status.Error(codes.Aborted, "aborted"), wantStatus: codes.Aborted, wantRetryable: true},
+		{name: "unauthenticated without detail", err: status.Error(codes.Unauthenticated, "bad credentials"), wantStatus: codes.Unauthenticated},
+		{name: "internal without detail", err: status.Error(codes.Internal, "internal"), wantStatus: codes.Internal},
+		{name: "not a status", err: refused, wantUnchanged: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := toAllocateError(tt.err)
+			if tt.wantUnchanged {
+				if err != tt.err {
+					t.Errorf("toAllocateError() = %v, want %v unchanged", err, tt.err)
+				}
+			} else {
+				var aloErr *AllocateError
+				if !errors.As(err, &aloErr) {
+					t.Fatalf("toAllocateError() = %#v, want an AllocateError", err)
+				}
+				if aloErr.StatusCode != tt.wantStatus || aloErr.Code != tt.wantCode || aloErr.Retryable != tt.wantRetryable {
+					t.Errorf("toAllocateError() = %+v, want status %v, code %v, retryable %v", aloErr, tt.wantStatus, tt.wantCode, tt.wantRetryable)
+				}
+			}
+
+			// 割り当てのエラーはコードによらずallocationに分類する
+			summary := newTickSummary()
+			summary.record(&pb.Match{MatchId: "m1", MatchProfile: "mode.demo"}, false, &matchError{category: failureAllocation, err: err})
+			if summary.failed[failureAllocation] != 1 || len(summary.failed) != 1 {
+				t.Errorf("failed = %v, want %v=1", summary.failed, failureAllocation)
+			}
+		})
+	}
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"common/config"
+	"common/relaxation"
+)
+
+// directorConfig Directorの接続先、認証情報、タイムアウトの設定
+type directorConfig struct {
+	// FrontendEndpoint Open MatchのFrontendのhost:port
+	FrontendEndpoint string
+	// BackendEndpoint Open MatchのBackendのhost:port
+	BackendEndpoint string
+	// QueryEndpoint Open MatchのQueryServiceのhost:port。期限切れのBackfillTicketを探す
+	QueryEndpoint string
+	// FunctionHost, FunctionPort Open MatchのBackendが呼び出すMMFのホストとポート
+	FunctionHost string
+	FunctionPort int
+	// AllocatorEndpoint AllocateServiceのgRPCのhost:port
+	AllocatorEndpoint string
+	// AllocatorCredentialsDir AllocateServiceの認証情報("key"と"secret")をマウントしたディレクトリ
+	AllocatorCredentialsDir string
+	// AllocatorCAFile 指定するとTLSでAllocateServiceのサーバー証明書を検証する
+	AllocatorCAFile string
+	// AllocatorCertFile, AllocatorKeyFile 指定するとクライアント証明書を提示する(mTLS)
+	AllocatorCertFile string
+	AllocatorKeyFile  string
+	// MetricsPort /metricsを公開するHTTPのポート
+	MetricsPort int
+
+	// FetchTimeout FetchMatches 1回(ストリームを最後まで受信するまで)のタイムアウト
+	FetchTimeout time.Duration
+	// AssignTimeout AssignTicketsの1回の呼び出しのタイムアウト
+	AssignTimeout time.Duration
+	// AllocateTimeout AllocateServiceの1回の呼び出しのタイムアウト
+	AllocateTimeout time.Duration
+	// NoticeTimeout GameServerへのCONNECTION通知の応答を待つ時間
+	NoticeTimeout time.Duration
+	// DrainTimeout 終了時に処理中の割り当てを待つ時間の上限
+	DrainTimeout time.Duration
+	// JanitorInterval 期限切れのBackfillTicketを削除する間隔
+	JanitorInterval time.Duration
+	// ReconcileGracePeriod GameServerが0人と報告してからこの時間が経ったAllocatedなサーバーを回収する
+	// GameServerのJOIN_TIMEOUT + EMPTY_TIMEOUTより長くし、GameServer自身の終了処理を優先させる
+	ReconcileGracePeriod time.Duration
+	// GameModes マッチメイクするゲームモードとGameServerの割り当て条件。ゲームモードごとにProfileを作る
+	GameModes map[string]allocationSettings
+	// ProfileSchedules ゲームモード(Profile)ごとのFetchMatchesの間隔
+	ProfileSchedules map[string]profileSchedule
+	// ProfileRelaxations ゲームモードごとの待ち時間に応じたマッチの条件の緩和スケジュール
+	ProfileRelaxations map[string]relaxation.Schedule
+}
+
+// conf Directorの設定。mainの最初で読み込む
+var conf directorConfig
+
+// loadConfig 設定を既定値、設定ファイル、フラグ、環境変数の順に読み込んで検証する
+// 既定値はクラスタ内のサービス名で、別のnamespaceや環境ではフラグか環境変数で上書きする
+func loadConfig(args []string) (directorConfig, error) {
+	c := directorConfig{
+		GameModes:          defaultGameModes(),
+		ProfileSchedules:   defaultProfileSchedules(),
+		ProfileRelaxations: defaultProfileRelaxations(),
+	}
+	l := config.NewLoader()
+	l.StringVar(&c.FrontendEndpoint, "om-frontend", "OM_FRONTEND_ENDPOINT", "om-frontend.open-match.svc.cluster.local:50504", "host:port of the Open Match Frontend")
+	l.StringVar(&c.BackendEndpoint, "om-backend", "OM_BACKEND_ENDPOINT", "om-backend.open-match.svc.cluster.local:50505", "host:port of the Open Match Backend")
+	l.StringVar(&c.QueryEndpoint, "om-query", "OM_QUERY_ENDPOINT", "om-query.open-match.svc.cluster.local:50503", "host:port of the Open Match QueryService")
+	l.StringVar(&c.FunctionHost, "function-host", "FUNCTION_HOST", "matchfunction.openmatch.svc.cluster.local", "Host of the match function called by the Open Match Backend")
+	l.IntVar(&c.FunctionPort, "function-port", "FUNCTION_PORT", 50502, "gRPC port of the match function")
+	l.StringVar(&c.AllocatorEndpoint, "allocator", "ALLOCATOR_ENDPOINT", "fleet-allocator-endpoint.default.svc.cluster.local:50551", "host:port of the gRPC AllocationService")
+	l.StringVar(&c.AllocatorCredentialsDir, "allocator-credentials-dir", "ALLOCATOR_CREDENTIALS_DIR", "/etc/director/allocator", "Directory of the mounted AllocationService client key and secret")
+	l.StringVar(&c.AllocatorCAFile, "allocator-ca-file", "ALLOCATOR_CA_FILE", "", "CA certificate verifying the AllocationService, enables TLS")
+	l.StringVar(&c.AllocatorCertFile, "allocator-cert-file", "ALLOCATOR_CERT_FILE", "", "Client certificate presented to the AllocationService")
+	l.StringVar(&c.AllocatorKeyFile, "allocator-key-file", "ALLOCATOR_KEY_FILE", "", "Private key of the client certificate")
+	l.IntVar(&c.MetricsPort, "metrics-port", "METRICS_PORT", 9090, "Port serving /metrics")
+	l.DurationVar(&c.FetchTimeout, "fetch-timeout", "FETCH_TIMEOUT", 10*time.Second, "Timeout of one FetchMatches call")
+	l.DurationVar(&c.AssignTimeout, "assign-timeout", "ASSIGN_TIMEOUT", 5*time.Second, "Timeout of one AssignTickets call")
+	l.DurationVar(&c.AllocateTimeout, "allocate-timeout", "ALLOCATE_TIMEOUT", 10*time.Second, "Timeout of one AllocationService call")
+	l.DurationVar(&c.NoticeTimeout, "notice-timeout", "NOTICE_TIMEOUT", 3*time.Second, "Time to wait for a game server to answer CONNECTION")
+	l.DurationVar(&c.DrainTimeout, "drain-timeout", "DRAIN_TIMEOUT", 30*time.Second, "Time to wait for in-flight assignments on shutdown")
+	l.DurationVar(&c.JanitorInterval, "janitor-interval", "JANITOR_INTERVAL", 30*time.Second, "Interval of deleting expired backfill tickets")
+	l.DurationVar(&c.ReconcileGracePeriod, "reconcile-grace-period", "RECONCILE_GRACE_PERIOD", 2*time.Minute, "Deallocate allocated servers that have reported no players for this long")
+	l.JSONVar(&c.GameModes, "game-modes", "GAME_MODES", `Game modes to matchmake and where to allocate their servers as JSON, e.g. {"mode.demo":{"namespace":"default","fleets":["simple-udp"],"selector":"region=asia"}}`)
+	l.JSONVar(&c.ProfileSchedules, "profile-schedules", "PROFILE_SCHEDULES", `FetchMatches interval of each game mode as JSON, e.g. {"mode.demo":{"interval":"1s","max_interval":"5s","full_batch":10}}`)
+	l.JSONVar(&c.ProfileRelaxations, "profile-relaxations", "PROFILE_RELAXATIONS", `Relaxation schedule of each game mode as JSON, e.g. {"mode.demo":[{"after_seconds":30,"min_players":1}]}`)
+	if err := l.Load(args); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+// validate 設定の誤りをまとめて返す
+func (c directorConfig) validate() error {
+	var errs config.Errors
+	errs.Endpoint("om-frontend", c.FrontendEndpoint)
+	errs.Endpoint("om-backend", c.BackendEndpoint)
+	errs.Endpoint("om-query", c.QueryEndpoint)
+	errs.NonEmpty("function-host", c.FunctionHost)
+	errs.Port("function-port", c.FunctionPort)
+	errs.Endpoint("allocator", c.AllocatorEndpoint)
+	errs.NonEmpty("allocator-credentials-dir", c.AllocatorCredentialsDir)
+	if c.AllocatorCAFile == "" && (c.AllocatorCertFile != "" || c.AllocatorKeyFile != "") {
+		errs.Add("allocator-cert-file and allocator-key-file require allocator-ca-file")
+	}
+	if (c.AllocatorCertFile == "") != (c.AllocatorKeyFile == "") {
+		errs.Add("allocator-cert-file and allocator-key-file must be set together")
+	}
+	errs.Port("metrics-port", c.MetricsPort)
+	errs.Positive("fetch-timeout", c.FetchTimeout)
+	errs.Positive("assign-timeout", c.AssignTimeout)
+	errs.Positive("allocate-timeout", c.AllocateTimeout)
+	errs.Positive("notice-timeout", c.NoticeTimeout)
+	errs.Positive("drain-timeout", c.DrainTimeout)
+	errs.Positive("janitor-interval", c.JanitorInterval)
+	errs.Positive("reconcile-grace-period", c.ReconcileGracePeriod)
+	if len(c.GameModes) == 0 {
+		errs.Add("game-modes must have at least one game mode")
+	}
+	modes := make([]string, 0, len(c.GameModes))
+	for mode := range c.GameModes {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
+	for _, mode := range modes {
+		if err := c.GameModes[mode].validate(); err != nil {
+			errs.Add("game-modes of %v: %v", mode, err)
+		}
+	}
+	scheduled := make([]string, 0, len(c.ProfileSchedules))
+	for mode := range c.ProfileSchedules {
+		scheduled = append(scheduled, mode)
+	}
+	sort.Strings(scheduled)
+	for _, mode := range scheduled {
+		if _, ok := c.GameModes[mode]; !ok {
+			errs.Add("profile-schedules has an unknown game mode %q", mode)
+		}
+		if err := c.ProfileSchedules[mode].validate(); err != nil {
+			errs.Add("profile-schedules of %v: %v", mode, err)
+		}
+	}
+	relaxed := make([]string, 0, len(c.ProfileRelaxations))
+	for mode := range c.ProfileRelaxations {
+		relaxed = append(relaxed, mode)
+	}
+	sort.Strings(relaxed)
+	for _, mode := range relaxed {
+		if _, ok := c.GameModes[mode]; !ok {
+			errs.Add("profile-relaxations has an unknown game mode %q", mode)
+		}
+		if err := c.ProfileRelaxations[mode].Validate(); err != nil {
+			errs.Add("profile-relaxations of %v: %v", mode, err)
+		}
+	}
+	return errs.Err()
+}
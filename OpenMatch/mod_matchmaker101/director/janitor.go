@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"open-match.dev/open-match/pkg/matchfunction"
+	"open-match.dev/open-match/pkg/pb"
+
+	"common/logging"
+)
+
+// expiresAtArg BackfillTicketの有効期限のUNIX時刻(秒)のDoubleArgsのキー。Frontendが付ける
+const expiresAtArg = "expires_at"
+
+var (
+	// assignedBackfills プレイヤーを割り当てたBackfillTicketの有効期限(TicketID -> 期限)
+	// 割り当て済みのチケットはQueryServiceから見えないので、期限切れを削除するためにここで覚えておく
+	// Directorが再起動すると失われるが、それまでのチケットはFrontendが期限切れで削除する
+	assignedBackfills   = map[string]time.Time{}
+	assignedBackfillsMu sync.Mutex
+)
+
+// cleanupExpiredBackfills GameServerがクラッシュや終了で登録し直さなくなったBackfillTicketを
+// JanitorIntervalごとにOpen Matchから削除する
+// Frontendが再起動してBackfillTicketの登録を見失った場合もここで削除される
+// ctxがキャンセルされたら終了する
+func cleanupExpiredBackfills(ctx context.Context, query pb.QueryServiceClient) {
+	ticker := time.NewTicker(conf.JanitorInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		now := time.Now()
+		deleteExpiredBackfills(ctx, query, now)
+		deleteExpiredAssignedBackfills(ctx, now)
+	}
+}
+
+// deleteExpiredBackfills 有効期限がnow以前のBackfillTicketを削除する
+// AssignTicketsで割り当て済みのチケットはQueryServiceから見えないので、deleteExpiredAssignedBackfillsで削除する
+func deleteExpiredBackfills(ctx context.Context, query pb.QueryServiceClient, now time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, conf.FetchTimeout)
+	defer cancel()
+
+	pool := &pb.Pool{
+		Name: "pool_expired_backfill",
+		TagPresentFilters: []*pb.TagPresentFilter{
+			{Tag: "backfill"},
+		},
+		DoubleRangeFilters: []*pb.DoubleRangeFilter{
+			{DoubleArg: expiresAtArg, Min: 0, Max: float64(now.UnixNano()) / float64(time.Second)},
+		},
+	}
+	tickets, err := matchfunction.QueryPool(ctx, query, pool)
+	if err != nil {
+		logger.WithError(err).Error("Failed to query expired backfill tickets")
+		return
+	}
+
+	for _, t := range tickets {
+		ticketLogger := logger.WithFields(logrus.Fields{logging.FieldTicketID: t.GetId(), "connection": t.GetAssignment().GetConnection()})
+		if _, err := fe.DeleteTicket(ctx, &pb.DeleteTicketRequest{TicketId: t.GetId()}); err != nil {
+			ticketLogger.WithError(err).Error("Failed to delete expired backfill ticket")
+			continue
+		}
+		backfillTicketsExpired.Inc()
+		ticketLogger.Info("Deleted expired backfill ticket")
+	}
+}
+
+// trackAssignedBackfill プレイヤーを割り当てたBackfillTicketを、期限切れで削除する対象として記録する
+func trackAssignedBackfill(t *pb.Ticket) {
+	expiresAt, ok := t.GetSearchFields().GetDoubleArgs()[expiresAtArg]
+	if !ok {
+		return
+	}
+	assignedBackfillsMu.Lock()
+	defer assignedBackfillsMu.Unlock()
+	assignedBackfills[t.GetId()] = time.Unix(0, int64(expiresAt*float64(time.Second)))
+}
+
+// deleteExpiredAssignedBackfills 記録した割り当て済みのBackfillTicketのうち、有効期限がnow以前のものを削除する
+// Frontendが置き換えや取り下げで削除済みのチケットは記録から消すだけにする
+func deleteExpiredAssignedBackfills(ctx context.Context, now time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, conf.FetchTimeout)
+	defer cancel()
+
+	expired := map[string]time.Time{}
+	assignedBackfillsMu.Lock()
+	for ticketID, expiresAt := range assignedBackfills {
+		if !expiresAt.After(now) {
+			expired[ticketID] = expiresAt
+			delete(assignedBackfills, ticketID)
+		}
+	}
+	assignedBackfillsMu.Unlock()
+
+	for ticketID, expiresAt := range expired {
+		ticketLogger := logger.WithField(logging.FieldTicketID, ticketID)
+		t, err := fe.GetTicket(ctx, &pb.GetTicketRequest{TicketId: ticketID})
+		if status.Code(err) == codes.NotFound {
+			continue
+		}
+		if err == nil {
+			ticketLogger = ticketLogger.WithField("connection", t.GetAssignment().GetConnection())
+			_, err = fe.DeleteTicket(ctx, &pb.DeleteTicketRequest{TicketId: ticketID})
+		}
+		if err != nil {
+			// 次の実行で削除し直す
+			ticketLogger.WithError(err).Error("Failed to delete expired assigned backfill ticket")
+			assignedBackfillsMu.Lock()
+			assignedBackfills[ticketID] = expiresAt
+			assignedBackfillsMu.Unlock()
+			continue
+		}
+		backfillTicketsExpired.Inc()
+		ticketLogger.Info("Deleted expired assigned backfill ticket")
+	}
+}
@@ -0,0 +1,23 @@
+package main
+
+import (
+	"github.com/sirupsen/logrus"
+	"open-match.dev/open-match/pkg/pb"
+
+	"common/logging"
+)
+
+// logger DirectorのJSON形式のlogger
+var logger = logging.New("director")
+
+// matchLogger マッチのIDとProfile、MMFが記録していれば緩和段階をフィールドに持つlogger
+func matchLogger(match *pb.Match) *logrus.Entry {
+	fields := logrus.Fields{
+		logging.FieldMatchID: match.GetMatchId(),
+		logging.FieldProfile: match.GetMatchProfile(),
+	}
+	if level, ok := relaxationLevel(match); ok {
+		fields["relaxation_level"] = level
+	}
+	return logger.WithFields(fields)
+}
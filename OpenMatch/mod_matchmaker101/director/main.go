@@ -2,122 +2,156 @@ package main
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
-	"log"
 	"net"
-	"net/http"
+	"os"
+	"os/signal"
 	"strconv"
 	"sync"
+	"syscall"
 	"time"
 
+	"common/logging"
+	"director/allocatorpb"
+
 	"github.com/golang/protobuf/ptypes/any"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 	"google.golang.org/grpc"
 	"open-match.dev/open-match/pkg/pb"
 )
 
-type AllocatePort struct {
-	Name string `json:"name"`
-	Port int    `json:"port"`
-}
-type AllocateStatus struct {
-	State          string         `json:"state"`
-	GameServerName string         `json:"gameServerName"`
-	Ports          []AllocatePort `json:"ports"`
-	Address        string         `json:"address"`
-	NodeName       string         `json:"nodeName"`
-}
-type AllocateResponce struct {
-	Status AllocateStatus `json:"status"`
-}
-
 // The Director in this tutorial continously polls Open Match for the Match
 // Profiles and makes random assignments for the Tickets in the returned matches.
+// The endpoints of Open Match, the match function and the AllocateService are
+// read from flags, environment variables or a config file, see config.go.
 
-const (
-	// The endpoint for the Open Match Frontend service.
-	omFrontendEndpoint = "om-frontend.open-match.svc.cluster.local:50504"
+var fe pb.FrontendServiceClient
 
-	// The endpoint for the Open Match Backend service.
-	omBackendEndpoint = "om-backend.open-match.svc.cluster.local:50505"
-	// The Host and Port for the Match Function service endpoint.
-	functionHostName       = "matchfunction.openmatch.svc.cluster.local"
-	functionPort     int32 = 50502
+func main() {
+	var err error
+	if conf, err = loadConfig(os.Args[1:]); err != nil {
+		logger.WithError(err).Fatal("Failed to load the config")
+	}
 
-	// The Host and Port for the AllocateService endpoint.
-	allocateHostName = "http://fleet-allocator-endpoint.default.svc.cluster.local/address"
-	allocateKey      = "v1GameClientKey"
-	allocatePass     = "EAEC945C371B2EC361DE399C2F11E"
-)
+	// SIGTERMを受けたらctxをキャンセルして新しいtickとFetchMatchesを止める
+	ctx, stop := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-sigs
+		logger.Infof("Received %v, draining in-flight assignments", sig)
+		stop()
+	}()
+	// 処理中の割り当てはctxとは別のworkCtxで行い、終了時はDrainTimeoutまで完了を待つ
+	workCtx, cancelWork := context.WithCancel(context.Background())
+	defer cancelWork()
+
+	shutdownTracing, err := setupTracing(context.Background(), "director")
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to set up tracing")
+	}
+	// 終了時にバッファ中のspanを送り切る
+	defer func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if err := shutdownTracing(ctx); err != nil {
+			logger.WithError(err).Warn("Failed to flush traces")
+		}
+	}()
 
-var fe pb.FrontendServiceClient
+	if err := setupAllocatorClient(); err != nil {
+		logger.WithError(err).Fatal("Failed to set up the AllocateService client")
+	}
 
-func main() {
 	// Connect to Open Match Backend.
-	beConn, err := grpc.Dial(omBackendEndpoint, grpc.WithInsecure())
+	beConn, err := grpc.Dial(conf.BackendEndpoint, grpc.WithInsecure())
 	if err != nil {
-		log.Fatalf("Failed to connect to Open Match Backend, got %s", err.Error())
+		logger.WithError(err).Fatal("Failed to connect to Open Match Backend")
 	}
 
 	defer beConn.Close()
 	be := pb.NewBackendServiceClient(beConn)
 
 	// Connect to Open Match Frontend.
-	feConn, err := grpc.Dial(omFrontendEndpoint, grpc.WithInsecure())
+	feConn, err := grpc.Dial(conf.FrontendEndpoint, grpc.WithInsecure())
 	if err != nil {
-		log.Fatalf("Failed to connect to Open Match, got %v", err)
+		logger.WithError(err).Fatal("Failed to connect to Open Match")
 	}
 
 	defer feConn.Close()
 	fe = pb.NewFrontendServiceClient(feConn)
 
+	// Connect to Open Match QueryService.
+	queryConn, err := grpc.Dial(conf.QueryEndpoint, grpc.WithInsecure())
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to connect to Open Match QueryService")
+	}
+
+	defer queryConn.Close()
+	query := pb.NewQueryServiceClient(queryConn)
+
+	go serveMetrics(conf.MetricsPort)
+
+	// プレイヤーのいないまま放置されたGameServerを定期的に回収する
+	go reconcileAllocations(ctx)
+	// GameServerが登録し直さずに期限の切れたBackfillTicketを定期的に削除する
+	go cleanupExpiredBackfills(ctx, query)
+
 	// Generate the profiles to fetch matches for.
-	profiles := generateProfiles()
-	log.Printf("Fetching matches for %v profiles", len(profiles))
-
-	for range time.Tick(time.Second * 1) {
-		// Fetch matches for each profile and make random assignments for Tickets in
-		// the matches returned.
-		var wg sync.WaitGroup
-		for _, p := range profiles {
-			wg.Add(1)
-			go func(wg *sync.WaitGroup, p *pb.MatchProfile) {
-				defer wg.Done()
-				matches, err := fetch(be, p)
-				if err != nil {
-					log.Printf("Failed to fetch matches for profile %v, got %s", p.GetName(), err.Error())
-					return
-				}
-
-				if len(matches) > 0 {
-					log.Printf("Generated %v matches for profile %v", len(matches), p.GetName())
-				}
-				if err := assign(be, matches); err != nil {
-					log.Printf("Failed to assign servers to matches, got %s", err.Error())
-					return
-				}
-			}(&wg, p)
-		}
+	profiles, err := generateProfiles()
+	if err != nil {
+		logger.WithError(err).Fatal("Failed to generate profiles")
+	}
+	logger.Infof("Fetching matches for %v profiles", len(profiles))
+
+	// Profileごとに自分の間隔でFetchMatchesと割り当てを繰り返す
+	var inflight sync.WaitGroup
+	for _, p := range profiles {
+		inflight.Add(1)
+		go func(p *pb.MatchProfile) {
+			defer inflight.Done()
+			runProfile(ctx, workCtx, be, p, scheduleFor(p.GetName()))
+		}(p)
+	}
 
-		wg.Wait()
+	<-ctx.Done()
+	drain(&inflight, conf.DrainTimeout)
+}
+
+// drain 処理中の割り当ての完了をtimeoutまで待つ
+func drain(inflight *sync.WaitGroup, timeout time.Duration) {
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		logger.Info("Drained in-flight assignments, exiting")
+	case <-time.After(timeout):
+		logger.Warnf("In-flight assignments did not finish within %v, exiting", timeout)
 	}
 }
 
-func fetch(be pb.BackendServiceClient, p *pb.MatchProfile) ([]*pb.Match, error) {
+func fetch(ctx context.Context, be pb.BackendServiceClient, p *pb.MatchProfile) ([]*pb.Match, error) {
 	req := &pb.FetchMatchesRequest{
 		Config: &pb.FunctionConfig{
-			Host: functionHostName,
-			Port: functionPort,
+			Host: conf.FunctionHost,
+			Port: int32(conf.FunctionPort),
 			Type: pb.FunctionConfig_GRPC,
 		},
 		Profile: p,
 	}
 
-	stream, err := be.FetchMatches(context.Background(), req)
+	// ストリームの受信が終わるまでをFetchTimeoutで打ち切る
+	ctx, cancel := context.WithTimeout(ctx, conf.FetchTimeout)
+	defer cancel()
+
+	stream, err := be.FetchMatches(ctx, req)
 	if err != nil {
-		log.Println()
 		return nil, err
 	}
 
@@ -138,68 +172,100 @@ func fetch(be pb.BackendServiceClient, p *pb.MatchProfile) ([]*pb.Match, error)
 	return result, nil
 }
 
-func assign(be pb.BackendServiceClient, matches []*pb.Match) error {
+// assign マッチごとにGameServerを割り当て、結果をsummaryに記録する
+// 1つのマッチの失敗で残りのマッチを取りこぼさないよう、失敗しても残りのマッチは続けて処理する
+// マッチごとにspanを作り、結果を記録して終了する
+func assign(ctx context.Context, be pb.BackendServiceClient, matches []*pb.Match, summary *tickSummary) {
+	regularMatches := []*pb.Match{}
+	regularCtxs := []context.Context{}
+	regularSpans := []trace.Span{}
 	for _, match := range matches {
+		mctx, span := startMatchSpan(ctx, match)
 
-		// BackFillTicketを含むMatchかチェック
-		var backfillTicket *pb.Ticket = nil
-		for _, t := range match.GetTickets() {
-			tags := t.GetSearchFields().GetTags()
-			for _, tag := range tags {
-				if tag == "backfill" {
-					//BackFillを含んでいる
-					backfillTicket = t
-					break
-				}
-			}
+		// 不正なマッチは割り当てずに捨てる(チケットはOpen Matchのプールに戻る)
+		backfillTicket, err := validateProposal(match)
+		if err != nil {
+			err = newMatchError(failureInvalid, "rejected invalid proposal, got %w", err)
+			summary.record(match, false, err)
+			endSpan(span, err)
+			continue
 		}
 		if backfillTicket != nil {
-			err := backfillAssign(be, match, backfillTicket)
-			if err != nil {
-				return err
-			}
+			retried, err := backfillAssign(mctx, be, match, backfillTicket)
+			summary.record(match, retried, err)
+			endSpan(span, err)
 		} else {
-			err := regularAssign(be, match)
-			if err != nil {
-				return err
-			}
+			regularMatches = append(regularMatches, match)
+			regularCtxs = append(regularCtxs, mctx)
+			regularSpans = append(regularSpans, span)
 		}
 	}
 
-	return nil
+	// 新規マッチのGameServerはまとめて1回で割り当てる
+	requests := []*allocatorpb.AllocateRequest{}
+	allocatable := []int{}
+	for i, match := range regularMatches {
+		// Profile名はゲームモード名
+		mode := match.GetMatchProfile()
+		settings, ok := conf.GameModes[mode]
+		if !ok {
+			err := newMatchError(failureConfig, "no allocation settings for game mode %v", mode)
+			summary.record(match, false, err)
+			endSpan(regularSpans[i], err)
+			continue
+		}
+		requests = append(requests, allocateRequest(settings, matchMetadata(regularCtxs[i], match)))
+		allocatable = append(allocatable, i)
+	}
+	if len(requests) == 0 {
+		return
+	}
+	alos, errs, attempts := allocateServers(ctx, requests)
+
+	for j, i := range allocatable {
+		match, span := regularMatches[i], regularSpans[i]
+		if errs[j] != nil {
+			err := &matchError{category: failureAllocation, err: errs[j]}
+			summary.record(match, attempts[j] > 1, err)
+			endSpan(span, err)
+			continue
+		}
+		span.SetAttributes(attribute.String("gameserver.name", alos[j].GetGameServerName()), attribute.Int("allocate.attempts", attempts[j]))
+		retried, err := regularAssign(regularCtxs[i], be, match, alos[j])
+		summary.record(match, retried || attempts[j] > 1, err)
+		endSpan(span, err)
+	}
 }
 
-func regularAssign(be pb.BackendServiceClient, match *pb.Match) error {
+// matchMetadata GameServerに記録するマッチ情報
+// バッチの1件ごとにマッチのトレースコンテキストを載せる
+func matchMetadata(ctx context.Context, match *pb.Match) *allocatorpb.MatchMetadata {
 	ticketIDs := []string{}
 	for _, t := range match.GetTickets() {
 		ticketIDs = append(ticketIDs, t.Id)
 	}
-
-	// Request Connection to AllocateService.
-	aloReq, err := http.NewRequest("GET", allocateHostName, nil)
-	if err != nil {
-		return err
+	return &allocatorpb.MatchMetadata{
+		MatchId:      match.GetMatchId(),
+		GameMode:     match.GetMatchProfile(),
+		TicketIds:    ticketIDs,
+		TraceContext: traceContextMap(ctx),
 	}
-	aloReq.SetBasicAuth(allocateKey, allocatePass)
+}
 
-	client := new(http.Client)
-	resp, err := client.Do(aloReq)
-	if err != nil {
-		return err
-	}
-	defer resp.Body.Close()
-	byteArray, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return err
+// regularAssign 割り当てたGameServerをマッチのチケットに通知する。AssignTicketsをリトライしたかも返す
+func regularAssign(ctx context.Context, be pb.BackendServiceClient, match *pb.Match, alo *allocatorpb.AllocateResponse) (bool, error) {
+	ticketIDs := []string{}
+	for _, t := range match.GetTickets() {
+		ticketIDs = append(ticketIDs, t.Id)
 	}
+	// Profile名はゲームモード名
+	mode := match.GetMatchProfile()
 
-	var alo AllocateResponce
-	json.Unmarshal(byteArray, &alo)
-	var conn string
-	conn = fmt.Sprintf("%s:%d", alo.Status.Address, alo.Status.Ports[0].Port)
+	// Connection of the GameServer allocated by AllocateService.
+	conn := fmt.Sprintf("%s:%d", alo.Address, alo.Ports[0].Port)
 
-	// GameServerに接続情報を通知しておく
-	go noticeConnection(conn)
+	// GameServerに接続情報と割り当てたプレイヤー数、ゲームモード、プレイヤーの参加を記録するためのトレースコンテキストを通知しておく
+	go noticeConnection(conn, len(ticketIDs), mode, traceContextMap(ctx)["traceparent"])
 
 	req := &pb.AssignTicketsRequest{
 		TicketIds: ticketIDs,
@@ -208,23 +274,43 @@ func regularAssign(be pb.BackendServiceClient, match *pb.Match) error {
 		},
 	}
 
-	if _, err := be.AssignTickets(context.Background(), req); err != nil {
-		return fmt.Errorf("AssignTickets failed for match %v, got %w", match.GetMatchId(), err)
+	retried, err := assignTickets(ctx, be, req)
+	if err != nil {
+		// 割り当て済みのGameServerはプレイヤーが来ないので解放する
+		// 解放にも失敗した場合はreconcileAllocationsが回収する
+		reason := fmt.Sprintf("AssignTickets failed for match %v", match.GetMatchId())
+		if deErr := deallocateServer(ctx, conf.GameModes[mode].Namespace, alo.GetGameServerName(), reason); deErr != nil {
+			matchLogger(match).WithError(deErr).WithField(logging.FieldGameServer, alo.GetGameServerName()).Error("Failed to deallocate server")
+		}
+		return retried, newMatchError(failureAssignment, "AssignTickets failed for match %v, got %w", match.GetMatchId(), err)
 	}
 
-	log.Printf("Assigned server %v to match %v", conn, match.GetMatchId())
-	return nil
+	matchLogger(match).WithFields(logrus.Fields{logging.FieldGameServer: alo.GetGameServerName(), logging.FieldTicketID: ticketIDs}).Infof("Assigned server %v", conn)
+	return retried, nil
 }
 
-func noticeConnection(connection string) {
-	conn, _ := net.Dial("udp", connection)
+// noticeConnection GameServerにCONNECTIONを送り、応答をNoticeTimeoutまで待つ
+// traceparentが空でなければ末尾に付ける
+func noticeConnection(connection string, playerNum int, mode string, traceparent string) {
+	conn, err := net.Dial("udp", connection)
+	if err != nil {
+		logger.WithError(err).Warnf("Failed to notice connection to %v", connection)
+		return
+	}
 	defer conn.Close()
-	conn.Write([]byte("CONNECTION " + connection))
+	conn.SetDeadline(time.Now().Add(conf.NoticeTimeout))
+	msg := fmt.Sprintf("CONNECTION %s %d %s", connection, playerNum, mode)
+	if traceparent != "" {
+		msg += " " + traceparent
+	}
+	conn.Write([]byte(msg))
 	buffer := make([]byte, 1500)
 	conn.Read(buffer)
 }
 
-func backfillAssign(be pb.BackendServiceClient, match *pb.Match, backfillTicket *pb.Ticket) error {
+// backfillAssign BackfillTicketのGameServerをマッチのチケットに通知し、BackfillTicketの空席数を更新する
+// AssignTicketsをリトライしたかも返す
+func backfillAssign(ctx context.Context, be pb.BackendServiceClient, match *pb.Match, backfillTicket *pb.Ticket) (bool, error) {
 	// Assigne対象となるBackfillTicketを除外したTicketIDのリストを作成
 	ticketIDs := []string{}
 	for _, t := range match.GetTickets() {
@@ -233,6 +319,13 @@ func backfillAssign(be pb.BackendServiceClient, match *pb.Match, backfillTicket
 		}
 	}
 
+	// プレイヤーを割り当てる前にBackfillTicketの空席数を確認する
+	extensions := backfillTicket.GetAssignment().GetExtensions()
+	joinablePlayerNum, err := joinablePlayerNum(backfillTicket)
+	if err != nil {
+		return false, &matchError{category: failureBackfill, err: err}
+	}
+
 	// BackfillTicketからConnectionを取得し他のTicketに反映
 	conn := backfillTicket.GetAssignment().GetConnection()
 	req := &pb.AssignTicketsRequest{
@@ -241,20 +334,14 @@ func backfillAssign(be pb.BackendServiceClient, match *pb.Match, backfillTicket
 			Connection: conn,
 		},
 	}
-	if _, err := be.AssignTickets(context.Background(), req); err != nil {
-		return fmt.Errorf("AssignTickets failed for match %v, got %w", match.GetMatchId(), err)
+	retried, err := assignTickets(ctx, be, req)
+	if err != nil {
+		return retried, newMatchError(failureAssignment, "AssignTickets failed for match %v, got %w", match.GetMatchId(), err)
 	}
 
-	log.Printf("Assigned Backfill %v to match %v", conn, match.GetMatchId())
+	matchLogger(match).WithFields(logrus.Fields{logging.FieldTicketID: ticketIDs, "backfill_ticket_id": backfillTicket.GetId()}).Infof("Assigned Backfill %v", conn)
 
 	// BackfillTicketを更新
-	extensions := backfillTicket.GetAssignment().GetExtensions()
-	joinablePlayerNumByte := extensions["joinablePlayerNum"].GetValue()
-	joinablePlayerNumStr := string(joinablePlayerNumByte)
-	joinablePlayerNum, err := strconv.Atoi(joinablePlayerNumStr)
-	if err != nil {
-		return err
-	}
 	// 参加可能人数を更新
 	joinablePlayerNum = joinablePlayerNum - len(ticketIDs)
 
@@ -272,9 +359,12 @@ func backfillAssign(be pb.BackendServiceClient, match *pb.Match, backfillTicket
 			Extensions: extensions,
 		},
 	}
-	if _, err := be.AssignTickets(context.Background(), req); err != nil {
-		return fmt.Errorf("Update BackfillTickets failed for match %v, got %w", match.GetMatchId(), err)
+	updateRetried, err := assignTickets(ctx, be, req)
+	if err != nil {
+		return retried || updateRetried, newMatchError(failureBackfill, "Update BackfillTickets failed for match %v, got %w", match.GetMatchId(), err)
 	}
+	// 割り当て済みになりQueryServiceから見えなくなるので、期限切れの削除はJanitorが記録から行う
+	trackAssignedBackfill(backfillTicket)
 
-	return nil
+	return retried || updateRetried, nil
 }
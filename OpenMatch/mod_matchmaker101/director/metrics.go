@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// matchesFetched ProfileごとのFetchMatchesで取得したマッチ数
+	matchesFetched = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "director_matches_fetched_total",
+		Help: "Number of matches fetched from Open Match.",
+	}, []string{"profile"})
+
+	// matchesAssigned Profileごとの割り当てまで完了したマッチ数
+	matchesAssigned = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "director_matches_assigned_total",
+		Help: "Number of matches whose tickets were assigned to a game server.",
+	}, []string{"profile"})
+
+	// matchesAssignedByRelaxation Profileと緩和段階ごとの割り当てまで完了した新規マッチ数
+	matchesAssignedByRelaxation = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "director_matches_assigned_by_relaxation_total",
+		Help: "Number of assigned new matches by relaxation level.",
+	}, []string{"profile", "level"})
+
+	// matchesFailed Profileと失敗の分類(failureCategory)ごとの割り当てに失敗したマッチ数
+	matchesFailed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "director_matches_failed_total",
+		Help: "Number of matches that could not be assigned, by failure category.",
+	}, []string{"profile", "category"})
+
+	// matchesRetried Profileごとのリトライを経て処理したマッチ数
+	matchesRetried = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "director_matches_retried_total",
+		Help: "Number of matches that needed at least one retry.",
+	}, []string{"profile"})
+
+	// backfillTicketsExpired 期限切れで削除したBackfillTicketの数
+	backfillTicketsExpired = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "director_backfill_tickets_expired_total",
+		Help: "Number of expired backfill tickets deleted from Open Match.",
+	})
+
+	// fetchErrors ProfileごとのFetchMatchesの失敗数
+	fetchErrors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "director_fetch_errors_total",
+		Help: "Number of failed FetchMatches calls.",
+	}, []string{"profile"})
+)
+
+// serveMetrics /metricsをportで公開する
+func serveMetrics(port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		logger.WithError(err).Errorf("Failed to serve metrics on port %v", port)
+	}
+}
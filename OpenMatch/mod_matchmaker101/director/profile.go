@@ -1,16 +1,216 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/any"
 	"open-match.dev/open-match/pkg/pb"
+
+	"common/relaxation"
 )
 
+// allocationSettings ゲームモードごとのGameServerの割り当て条件
+// 設定ファイルでは{"namespace": "default", "fleets": ["simple-udp"], "selector": "region=asia"}のように書く
+type allocationSettings struct {
+	// Namespace 割り当て対象のFleetのNamespace
+	Namespace string `json:"namespace"`
+	// Fleets 割り当てを試すFleet(先頭から順に試す)
+	Fleets []string `json:"fleets"`
+	// Selector 割り当てるGameServerが必ず満たすラベルセレクタ
+	Selector string `json:"selector,omitempty"`
+	// Preferred Selectorより優先して試すラベルセレクタ(先頭から順に試す)
+	Preferred []string `json:"preferred,omitempty"`
+}
+
+// validate 割り当て条件の誤りを返す
+// NamespaceとFleetはAllocateServiceのALLOWED_FLEETSと同じ"namespace/fleet"の形で照合されるので、
+// 空や"/"、","、空白を含む名前は許可リストに載せられない
+func (s allocationSettings) validate() error {
+	if err := validateResourceName(s.Namespace); err != nil {
+		return fmt.Errorf("namespace %w", err)
+	}
+	if len(s.Fleets) == 0 {
+		return fmt.Errorf("fleets must name at least one fleet")
+	}
+	for i, fleet := range s.Fleets {
+		if err := validateResourceName(fleet); err != nil {
+			return fmt.Errorf("fleet %v %w", i, err)
+		}
+	}
+	for i, selector := range s.Preferred {
+		if strings.TrimSpace(selector) == "" {
+			return fmt.Errorf("preferred selector %v must not be empty", i)
+		}
+	}
+	return nil
+}
+
+// validateResourceName NamespaceかFleetの名前が"namespace/fleet"の片方として使えなければエラーを返す
+func validateResourceName(name string) error {
+	if name == "" {
+		return fmt.Errorf("must not be empty")
+	}
+	if strings.ContainsAny(name, "/, \t") {
+		return fmt.Errorf("must not contain '/', ',' or spaces, got %q", name)
+	}
+	return nil
+}
+
+// defaultGameModes 設定で指定しない場合のマッチメイクするゲームモードとGameServerの割り当て条件
+func defaultGameModes() map[string]allocationSettings {
+	return map[string]allocationSettings{
+		"mode.demo": {
+			Namespace: "default",
+			Fleets:    []string{"simple-udp"},
+		},
+		"mode.ctf": {
+			Namespace: "default",
+			Fleets:    []string{"simple-udp"},
+		},
+		"mode.battleroyale": {
+			Namespace: "default",
+			Fleets:    []string{"simple-udp"},
+		},
+	}
+}
+
+// profileSchedule ゲームモード(Profile)ごとのFetchMatchesの間隔
+// 設定ファイルでは{"interval": "1s", "max_interval": "5s", "full_batch": 10}のように書く
+type profileSchedule struct {
+	// Interval マッチが見つかったときの次のFetchMatchesまでの間隔
+	Interval time.Duration
+	// MaxInterval マッチが見つからない間は間隔を倍々に伸ばし、この間隔で頭打ちにする
+	MaxInterval time.Duration
+	// FullBatch 1回でこの数以上のマッチができたら、待たずにすぐ次のFetchMatchesを行う(0なら無効)
+	FullBatch int
+}
+
+// profileScheduleJSON 設定ファイルでのprofileSchedule。間隔は"5s"のような文字列で書く
+type profileScheduleJSON struct {
+	Interval    string `json:"interval"`
+	MaxInterval string `json:"max_interval"`
+	FullBatch   int    `json:"full_batch"`
+}
+
+func (s profileSchedule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(profileScheduleJSON{
+		Interval:    s.Interval.String(),
+		MaxInterval: s.MaxInterval.String(),
+		FullBatch:   s.FullBatch,
+	})
+}
+
+func (s *profileSchedule) UnmarshalJSON(b []byte) error {
+	var raw profileScheduleJSON
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&raw); err != nil {
+		return err
+	}
+	interval, err := time.ParseDuration(raw.Interval)
+	if err != nil {
+		return fmt.Errorf("invalid interval, got %w", err)
+	}
+	maxInterval, err := time.ParseDuration(raw.MaxInterval)
+	if err != nil {
+		return fmt.Errorf("invalid max_interval, got %w", err)
+	}
+	*s = profileSchedule{Interval: interval, MaxInterval: maxInterval, FullBatch: raw.FullBatch}
+	return nil
+}
+
+// validate 間隔の設定の誤りを返す
+func (s profileSchedule) validate() error {
+	if s.Interval <= 0 {
+		return fmt.Errorf("interval must be positive, got %v", s.Interval)
+	}
+	if s.MaxInterval < s.Interval {
+		return fmt.Errorf("max_interval must not be shorter than interval %v, got %v", s.Interval, s.MaxInterval)
+	}
+	if s.FullBatch < 0 {
+		return fmt.Errorf("full_batch must not be negative, got %v", s.FullBatch)
+	}
+	return nil
+}
+
+// defaultSchedule ProfileSchedulesにないProfileの間隔
+var defaultSchedule = profileSchedule{
+	Interval:    1 * time.Second,
+	MaxInterval: 5 * time.Second,
+	FullBatch:   10,
+}
+
+// defaultProfileSchedules 設定で指定しない場合のゲームモードごとのFetchMatchesの間隔
+// 大人数のモードはプールが溜まるのを待つため間隔を長めにする
+func defaultProfileSchedules() map[string]profileSchedule {
+	return map[string]profileSchedule{
+		"mode.demo": {
+			Interval:    1 * time.Second,
+			MaxInterval: 5 * time.Second,
+			FullBatch:   10,
+		},
+		"mode.ctf": {
+			Interval:    1 * time.Second,
+			MaxInterval: 5 * time.Second,
+			FullBatch:   10,
+		},
+		"mode.battleroyale": {
+			Interval:    5 * time.Second,
+			MaxInterval: 15 * time.Second,
+			FullBatch:   4,
+		},
+	}
+}
+
+// scheduleFor Profileの間隔設定を返す
+func scheduleFor(profile string) profileSchedule {
+	if sched, ok := conf.ProfileSchedules[profile]; ok {
+		return sched
+	}
+	return defaultSchedule
+}
+
+// defaultProfileRelaxations 設定で指定しない場合のゲームモードごとの緩和スケジュール(待ち時間の昇順)
+// 人の少ないモードでも1人のプレイヤーが待ち続けないよう、最後は地域を問わず少人数でマッチさせる
+func defaultProfileRelaxations() map[string]relaxation.Schedule {
+	return map[string]relaxation.Schedule{
+		"mode.demo": {
+			{AfterSeconds: 15, CrossRegion: true},
+			{AfterSeconds: 30, MinPlayers: 1},
+		},
+		"mode.ctf": {
+			{AfterSeconds: 20, RatingWindow: 400},
+			{AfterSeconds: 40, CrossRegion: true},
+			{AfterSeconds: 90, MinPlayers: 1},
+		},
+		"mode.battleroyale": {
+			{AfterSeconds: 30, RatingWindow: 600, CrossRegion: true},
+			{AfterSeconds: 60, MinPlayers: 2},
+		},
+	}
+}
+
+// relaxationExtension MatchProfileのExtensionsの緩和スケジュールのキー
+const relaxationExtension = "relaxation"
+
 // generateProfiles generates test profiles for the matchmaker101 tutorial.
-func generateProfiles() []*pb.MatchProfile {
+// Each profile is named after a game mode of the config and carries its relaxation schedule
+// for the match function.
+func generateProfiles() ([]*pb.MatchProfile, error) {
 	var profiles []*pb.MatchProfile
-	modes := []string{"mode.demo", "mode.ctf", "mode.battleroyale"}
+	modes := make([]string, 0, len(conf.GameModes))
+	for mode := range conf.GameModes {
+		modes = append(modes, mode)
+	}
+	sort.Strings(modes)
 	for _, mode := range modes {
-		profiles = append(profiles, &pb.MatchProfile{
-			Name: "mode_based_profile",
+		profile := &pb.MatchProfile{
+			Name: mode,
 			Pools: []*pb.Pool{
 				{
 					Name: "pool_mode_" + mode,
@@ -21,9 +221,16 @@ func generateProfiles() []*pb.MatchProfile {
 					},
 				},
 			},
-		},
-		)
+		}
+		if steps, ok := conf.ProfileRelaxations[mode]; ok {
+			b, err := json.Marshal(steps)
+			if err != nil {
+				return nil, err
+			}
+			profile.Extensions = map[string]*any.Any{relaxationExtension: {Value: b}}
+		}
+		profiles = append(profiles, profile)
 	}
 
-	return profiles
+	return profiles, nil
 }
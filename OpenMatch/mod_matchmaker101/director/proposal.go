@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// validateProposal MMFが作ったマッチが割り当て可能かを確認し、BackfillTicketがあれば返す
+// 次のいずれかなら不正なマッチとしてエラーを返す
+//   - 同じチケットが複数回含まれる
+//   - BackfillTicketが2枚以上含まれる
+//   - プレイヤーのチケットがない
+//   - プレイヤー数がBackfillTicketの空席数を超える
+func validateProposal(match *pb.Match) (*pb.Ticket, error) {
+	var backfillTicket *pb.Ticket
+	seen := map[string]bool{}
+	players := 0
+	for _, t := range match.GetTickets() {
+		if seen[t.GetId()] {
+			return nil, fmt.Errorf("ticket %v appears more than once", t.GetId())
+		}
+		seen[t.GetId()] = true
+
+		if !isBackfillTicket(t) {
+			players++
+			continue
+		}
+		if backfillTicket != nil {
+			return nil, fmt.Errorf("more than one backfill ticket: %v and %v", backfillTicket.GetId(), t.GetId())
+		}
+		backfillTicket = t
+	}
+
+	if players == 0 {
+		return nil, fmt.Errorf("no player tickets")
+	}
+	if backfillTicket == nil {
+		return nil, nil
+	}
+
+	joinable, err := joinablePlayerNum(backfillTicket)
+	if err != nil {
+		return nil, err
+	}
+	if players > joinable {
+		return nil, fmt.Errorf("%v players exceed the %v joinable seats of backfill ticket %v", players, joinable, backfillTicket.GetId())
+	}
+	return backfillTicket, nil
+}
+
+// isBackfillTicket "backfill"タグのついたチケットか
+func isBackfillTicket(t *pb.Ticket) bool {
+	for _, tag := range t.GetSearchFields().GetTags() {
+		if tag == "backfill" {
+			return true
+		}
+	}
+	return false
+}
+
+// relaxationLevel MMFが新規マッチに記録した緩和段階("relaxation_level")
+func relaxationLevel(match *pb.Match) (int, bool) {
+	ext, ok := match.GetExtensions()["relaxation_level"]
+	if !ok {
+		return 0, false
+	}
+	level, err := strconv.Atoi(string(ext.GetValue()))
+	return level, err == nil
+}
+
+// joinablePlayerNum BackfillTicketの空席数
+func joinablePlayerNum(backfillTicket *pb.Ticket) (int, error) {
+	joinablePlayerNumByte := backfillTicket.GetAssignment().GetExtensions()["joinablePlayerNum"].GetValue()
+	joinablePlayerNumStr := string(joinablePlayerNumByte)
+	joinablePlayerNum, err := strconv.Atoi(joinablePlayerNumStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid joinablePlayerNum %q of backfill ticket %v, got %w", joinablePlayerNumStr, backfillTicket.GetId(), err)
+	}
+	return joinablePlayerNum, nil
+}
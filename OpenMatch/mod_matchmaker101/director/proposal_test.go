@@ -0,0 +1,95 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/ptypes/any"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// playerTicket idのPlayerTicket
+func playerTicket(id string) *pb.Ticket {
+	return &pb.Ticket{
+		Id:           id,
+		SearchFields: &pb.SearchFields{Tags: []string{"mode.demo", "player"}},
+	}
+}
+
+// backfillTicket joinablePlayerNumにjoinableを持つBackfillTicket。joinableが空なら持たない
+func backfillTicket(id, joinable string) *pb.Ticket {
+	t := &pb.Ticket{
+		Id:           id,
+		SearchFields: &pb.SearchFields{Tags: []string{"mode.demo", "backfill"}},
+		Assignment:   &pb.Assignment{Connection: "127.0.0.1:7000"},
+	}
+	if joinable != "" {
+		t.Assignment.Extensions = map[string]*any.Any{"joinablePlayerNum": {Value: []byte(joinable)}}
+	}
+	return t
+}
+
+func TestValidateProposal(t *testing.T) {
+	tests := []struct {
+		name    string
+		tickets []*pb.Ticket
+		// wantBackfill 返すBackfillTicketのid。空ならBackfillTicketなし
+		wantBackfill string
+		wantErr      bool
+	}{
+		{
+			name:    "valid without backfill",
+			tickets: []*pb.Ticket{playerTicket("p1"), playerTicket("p2")},
+		},
+		{
+			name:         "valid with one backfill",
+			tickets:      []*pb.Ticket{backfillTicket("b1", "2"), playerTicket("p1"), playerTicket("p2")},
+			wantBackfill: "b1",
+		},
+		{
+			name:    "two backfills",
+			tickets: []*pb.Ticket{backfillTicket("b1", "2"), backfillTicket("b2", "2"), playerTicket("p1")},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate player ticket",
+			tickets: []*pb.Ticket{playerTicket("p1"), playerTicket("p1")},
+			wantErr: true,
+		},
+		{
+			name:    "duplicate backfill ticket",
+			tickets: []*pb.Ticket{backfillTicket("b1", "2"), backfillTicket("b1", "2"), playerTicket("p1")},
+			wantErr: true,
+		},
+		{
+			name:    "no player tickets",
+			tickets: []*pb.Ticket{backfillTicket("b1", "2")},
+			wantErr: true,
+		},
+		{
+			name:    "players above joinable seats",
+			tickets: []*pb.Ticket{backfillTicket("b1", "1"), playerTicket("p1"), playerTicket("p2")},
+			wantErr: true,
+		},
+		{
+			name:    "missing joinablePlayerNum",
+			tickets: []*pb.Ticket{backfillTicket("b1", ""), playerTicket("p1")},
+			wantErr: true,
+		},
+		{
+			name:    "unparsable joinablePlayerNum",
+			tickets: []*pb.Ticket{backfillTicket("b1", "two"), playerTicket("p1")},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backfill, err := validateProposal(&pb.Match{MatchId: "match-1", Tickets: tt.tickets})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateProposal() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if backfill.GetId() != tt.wantBackfill {
+				t.Errorf("validateProposal() backfill = %q, want %q", backfill.GetId(), tt.wantBackfill)
+			}
+		})
+	}
+}
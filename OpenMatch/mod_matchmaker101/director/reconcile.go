@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"common/logging"
+	"director/allocatorpb"
+
+	"github.com/sirupsen/logrus"
+)
+
+// reconcileInterval Allocatedなサーバーを確認する間隔
+const reconcileInterval = 30 * time.Second
+
+// reconcileAllocations AssignTicketsの失敗や通知の取りこぼしで、
+// プレイヤーが来ないままAllocatedになっているGameServerを定期的に解放する
+// ctxがキャンセルされたら終了する
+func reconcileAllocations(ctx context.Context) {
+	ticker := time.NewTicker(reconcileInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for _, namespace := range allocationNamespaces() {
+			reconcileNamespace(ctx, namespace, time.Now())
+		}
+	}
+}
+
+// reconcileNamespace namespace内のAllocatedなGameServerのうち、0人と報告してから猶予期間を過ぎたものを解放する
+func reconcileNamespace(ctx context.Context, namespace string, now time.Time) {
+	ctx, cancel := context.WithTimeout(ctx, conf.AllocateTimeout)
+	defer cancel()
+
+	res, err := allocatorClient.ListAllocated(ctx, &allocatorpb.ListAllocatedRequest{Namespace: namespace})
+	if err != nil {
+		logger.WithError(toAllocateError(err)).Errorf("Failed to list allocated servers in %v", namespace)
+		return
+	}
+
+	for _, gs := range res.GetGameServers() {
+		since, ok := emptySince(gs)
+		if !ok || now.Sub(since) < conf.ReconcileGracePeriod {
+			continue
+		}
+		gsLogger := logger.WithFields(logrus.Fields{logging.FieldGameServer: gs.GetName(), logging.FieldMatchID: gs.GetMatch().GetMatchId()})
+		gsLogger.Infof("Deallocating server with no players since %v", since)
+		if err := deallocateServer(ctx, namespace, gs.GetName(), "no players after grace period"); err != nil {
+			gsLogger.WithError(err).Error("Failed to deallocate server")
+		}
+	}
+}
+
+// emptySince GameServerが今回の割り当て以降プレイヤー0人でいる起点の時刻
+// GameServerが0人と報告していなければfalse。Readyに戻して再利用されたGameServerは前のセッションの後から
+// 0人と報告しているので、割り当て時刻より前なら割り当て時刻から数える
+func emptySince(gs *allocatorpb.AllocatedGameServer) (time.Time, bool) {
+	if gs.GetPlayers() > 0 || gs.GetEmptySince() == 0 {
+		return time.Time{}, false
+	}
+	since := gs.GetEmptySince()
+	if gs.GetAllocatedAt() > since {
+		since = gs.GetAllocatedAt()
+	}
+	return time.Unix(since, 0), true
+}
+
+// allocationNamespaces ゲームモードの割り当て先のnamespace一覧(重複なし)
+func allocationNamespaces() []string {
+	seen := map[string]bool{}
+	namespaces := []string{}
+	for _, settings := range conf.GameModes {
+		if !seen[settings.Namespace] {
+			seen[settings.Namespace] = true
+			namespaces = append(namespaces, settings.Namespace)
+		}
+	}
+	return namespaces
+}
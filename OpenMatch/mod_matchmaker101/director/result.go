@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"open-match.dev/open-match/pkg/pb"
+
+	"common/logging"
+)
+
+const (
+	// assignMaxAttempts 一時的なエラーの場合にAssignTicketsを試す最大回数
+	assignMaxAttempts = 3
+	// assignInitialBackoff 最初のリトライまでの待ち時間(以降倍々にする)
+	assignInitialBackoff = 100 * time.Millisecond
+)
+
+// failureCategory マッチの割り当てに失敗した原因の分類
+type failureCategory string
+
+const (
+	// failureInvalid MMFのマッチが不正(BackfillTicketが複数、チケットの重複、空席数の超過など)
+	failureInvalid failureCategory = "invalid"
+	// failureConfig ゲームモードの割り当て条件がない
+	failureConfig failureCategory = "config"
+	// failureAllocation GameServerを割り当てられなかった
+	failureAllocation failureCategory = "allocation"
+	// failureAssignment プレイヤーのチケットへのAssignTicketsに失敗した
+	failureAssignment failureCategory = "assignment"
+	// failureBackfill BackfillTicketの情報が不正、または更新に失敗した
+	failureBackfill failureCategory = "backfill"
+)
+
+// matchError 失敗の分類つきのマッチのエラー
+type matchError struct {
+	category failureCategory
+	err      error
+}
+
+func (e *matchError) Error() string {
+	return fmt.Sprintf("%s: %v", e.category, e.err)
+}
+
+func (e *matchError) Unwrap() error {
+	return e.err
+}
+
+func newMatchError(category failureCategory, format string, a ...interface{}) error {
+	return &matchError{category: category, err: fmt.Errorf(format, a...)}
+}
+
+// tickSummary 1回のFetchMatchesで取得したマッチの処理結果。マッチごとのgoroutineから更新できるようにロックする
+type tickSummary struct {
+	mu       sync.Mutex
+	assigned int
+	retried  int
+	failed   map[failureCategory]int
+}
+
+func newTickSummary() *tickSummary {
+	return &tickSummary{failed: map[failureCategory]int{}}
+}
+
+// record マッチ1件の結果を記録する。retriedはリトライを経たか
+func (s *tickSummary) record(match *pb.Match, retried bool, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	profile := match.GetMatchProfile()
+	if retried {
+		s.retried++
+		matchesRetried.WithLabelValues(profile).Inc()
+	}
+	if err == nil {
+		s.assigned++
+		matchesAssigned.WithLabelValues(profile).Inc()
+		if level, ok := relaxationLevel(match); ok {
+			matchesAssignedByRelaxation.WithLabelValues(profile, strconv.Itoa(level)).Inc()
+		}
+		return
+	}
+
+	category := failureCategory("unknown")
+	var mErr *matchError
+	if errors.As(err, &mErr) {
+		category = mErr.category
+	}
+	s.failed[category]++
+	matchesFailed.WithLabelValues(profile, string(category)).Inc()
+	matchLogger(match).WithError(err).WithField("category", category).Warn("Failed to assign match")
+}
+
+// empty 処理したマッチがないか
+func (s *tickSummary) empty() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.assigned == 0 && s.retried == 0 && len(s.failed) == 0
+}
+
+func (s *tickSummary) String() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	failed := 0
+	categories := []string{}
+	for category, n := range s.failed {
+		failed += n
+		categories = append(categories, fmt.Sprintf("%s=%d", category, n))
+	}
+	sort.Strings(categories)
+	return fmt.Sprintf("assigned=%d failed=%d [%s] retried=%d", s.assigned, failed, strings.Join(categories, " "), s.retried)
+}
+
+// assignTickets AssignTicketsを一時的なエラーの間はバックオフしながら再試行する
+// 1回ごとにAssignTimeoutで打ち切り、リトライしたかどうかも返す
+func assignTickets(ctx context.Context, be pb.BackendServiceClient, req *pb.AssignTicketsRequest) (bool, error) {
+	backoff := assignInitialBackoff
+	for attempt := 1; ; attempt++ {
+		err := callAssignTickets(ctx, be, req)
+		if err == nil || !isTransient(err) || attempt >= assignMaxAttempts {
+			return attempt > 1, err
+		}
+		logger.WithError(err).WithField(logging.FieldTicketID, req.GetTicketIds()).Warnf("AssignTickets attempt %v failed, retrying in %v", attempt, backoff)
+		if err := sleepContext(ctx, backoff); err != nil {
+			return true, err
+		}
+		backoff *= 2
+	}
+}
+
+func callAssignTickets(ctx context.Context, be pb.BackendServiceClient, req *pb.AssignTicketsRequest) error {
+	ctx, cancel := context.WithTimeout(ctx, conf.AssignTimeout)
+	defer cancel()
+	_, err := be.AssignTickets(ctx, req)
+	return err
+}
+
+// sleepContext dだけ待つ。先にctxがキャンセルされたらそのエラーを返す
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// isTransient 時間をおけば成功する可能性のあるgRPCのエラーか
+func isTransient(err error) bool {
+	switch status.Code(err) {
+	case codes.Unavailable, codes.DeadlineExceeded, codes.Aborted, codes.ResourceExhausted:
+		return true
+	}
+	return false
+}
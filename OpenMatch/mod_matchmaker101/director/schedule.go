@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"open-match.dev/open-match/pkg/pb"
+
+	"common/logging"
+)
+
+// runProfile Profileのマッチの取得と割り当てをschedに従って繰り返す
+// 1つのProfileの処理は順番に行うので、前回の処理が終わらないうちに次の処理が積み上がることはない
+// ctxがキャンセルされたら処理中の割り当てを終えてから戻る
+func runProfile(ctx, workCtx context.Context, be pb.BackendServiceClient, p *pb.MatchProfile, sched profileSchedule) {
+	timer := time.NewTimer(sched.Interval)
+	defer timer.Stop()
+	interval := sched.Interval
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+
+		matches, err := fetchAndAssign(ctx, workCtx, be, p)
+		interval = nextInterval(sched, interval, matches, err)
+		timer.Reset(interval)
+	}
+}
+
+// nextInterval 前回の結果から次のFetchMatchesまでの間隔を決める
+// マッチがなければ倍々に伸ばし、FullBatch以上できたらすぐに次を取得する
+func nextInterval(sched profileSchedule, current time.Duration, matches int, err error) time.Duration {
+	switch {
+	case err != nil || matches == 0:
+		next := current * 2
+		if next < sched.Interval {
+			next = sched.Interval
+		}
+		if next > sched.MaxInterval {
+			next = sched.MaxInterval
+		}
+		return next
+	case sched.FullBatch > 0 && matches >= sched.FullBatch:
+		return 0
+	default:
+		return sched.Interval
+	}
+}
+
+// fetchAndAssign Profileのマッチを取得して割り当て、取得したマッチ数を返す
+// FetchMatchesはctxで、取得済みのマッチの割り当てはworkCtxで行い、終了時も取得済みのマッチは割り当てきる
+// 1回分の処理を1つのspanにし、マッチごとのspanからlinkする
+func fetchAndAssign(ctx, workCtx context.Context, be pb.BackendServiceClient, p *pb.MatchProfile) (int, error) {
+	ctx, span := tracer.Start(ctx, "FetchAndAssign", trace.WithAttributes(attribute.String("profile", p.GetName())))
+	defer span.End()
+	workCtx = trace.ContextWithSpan(workCtx, span)
+
+	matches, err := fetch(ctx, be, p)
+	if err != nil {
+		logger.WithError(err).WithField(logging.FieldProfile, p.GetName()).Error("Failed to fetch matches")
+		fetchErrors.WithLabelValues(p.GetName()).Inc()
+		recordSpanError(span, err)
+		return 0, err
+	}
+	span.SetAttributes(attribute.Int("matches", len(matches)))
+	matchesFetched.WithLabelValues(p.GetName()).Add(float64(len(matches)))
+	if len(matches) == 0 {
+		return 0, nil
+	}
+
+	logger.WithField(logging.FieldProfile, p.GetName()).Infof("Generated %v matches", len(matches))
+	summary := newTickSummary()
+	assign(workCtx, be, matches, summary)
+	logger.WithField(logging.FieldProfile, p.GetName()).Infof("Summary: %v", summary)
+	return len(matches), nil
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNextInterval(t *testing.T) {
+	sched := profileSchedule{Interval: time.Second, MaxInterval: 10 * time.Second, FullBatch: 5}
+	tests := []struct {
+		name    string
+		sched   profileSchedule
+		current time.Duration
+		matches int
+		err     error
+		want    time.Duration
+	}{
+		{name: "no matches doubles", sched: sched, current: time.Second, want: 2 * time.Second},
+		{name: "error doubles", sched: sched, current: 2 * time.Second, matches: 3, err: errors.New("unavailable"), want: 4 * time.Second},
+		{name: "backoff is capped at max_interval", sched: sched, current: 8 * time.Second, want: 10 * time.Second},
+		{name: "backoff stays at max_interval", sched: sched, current: 10 * time.Second, want: 10 * time.Second},
+		// 全バッチの直後は0なので、倍にしてもIntervalから伸ばす
+		{name: "backoff after immediate refetch starts from interval", sched: sched, current: 0, want: time.Second},
+		{name: "full batch refetches immediately", sched: sched, current: 4 * time.Second, matches: 5, want: 0},
+		{name: "more than full batch refetches immediately", sched: sched, current: time.Second, matches: 8, want: 0},
+		{name: "match resets backoff", sched: sched, current: 8 * time.Second, matches: 1, want: time.Second},
+		{
+			name:    "full_batch 0 never refetches immediately",
+			sched:   profileSchedule{Interval: time.Second, MaxInterval: 10 * time.Second},
+			current: 4 * time.Second,
+			matches: 100,
+			want:    time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nextInterval(tt.sched, tt.current, tt.matches, tt.err); got != tt.want {
+				t.Errorf("nextInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
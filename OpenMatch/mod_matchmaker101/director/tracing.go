@@ -0,0 +1,144 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/golang/protobuf/ptypes/any"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// tracer Directorのspanを作るTracer
+var tracer = otel.Tracer("director")
+
+// setupTracing トレースの出力先を環境変数OTEL_TRACES_EXPORTERで設定し、終了時に呼ぶ関数を返す
+// "otlp"ならOTEL_EXPORTER_OTLP_ENDPOINT(既定はlocalhost:4317)のcollectorへ、"stdout"なら標準出力へ出力する
+// 未設定または"none"なら出力せず、トレースコンテキストの伝搬だけ行う
+func setupTracing(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		// クラスタ内のcollectorを想定してTLSは使わない
+		exporter, err = otlptracegrpc.New(ctx, otlptracegrpc.WithInsecure())
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q, must be otlp, stdout or none", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %v trace exporter, got %w", os.Getenv("OTEL_TRACES_EXPORTER"), err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// extensionsTraceContext チケットやマッチのExtensionsに保存されたトレースコンテキストを取り出す
+// FrontendとMMFはpropagatorのフィールド名("traceparent"等)をキーにして値を保存している
+func extensionsTraceContext(ctx context.Context, extensions map[string]*any.Any) context.Context {
+	carrier := propagation.MapCarrier{}
+	for _, field := range otel.GetTextMapPropagator().Fields() {
+		if v, ok := extensions[field]; ok {
+			carrier[field] = string(v.GetValue())
+		}
+	}
+	return otel.GetTextMapPropagator().Extract(ctx, carrier)
+}
+
+// traceContextMap ctxのトレースコンテキストをpropagatorのフィールド名をキーにしたmapにする
+func traceContextMap(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	return carrier
+}
+
+// startMatchSpan マッチの割り当てのspanを開始する
+// 親はプレイヤーのチケットを作成したFrontendのspan(プレイヤーのチケットがなければBackfillTicket)で、
+// マッチの他のチケット、MMFのproposal、FetchMatchesのspanはlinkでつなぐ
+func startMatchSpan(ctx context.Context, match *pb.Match) (context.Context, trace.Span) {
+	links := []trace.Link{}
+	if tick := trace.SpanContextFromContext(ctx); tick.IsValid() {
+		links = append(links, trace.Link{SpanContext: tick})
+	}
+	if proposal := trace.SpanContextFromContext(extensionsTraceContext(context.Background(), match.GetExtensions())); proposal.IsValid() {
+		links = append(links, trace.Link{SpanContext: proposal})
+	}
+
+	// プレイヤーのチケットを優先して親を選び、残りはlinkにする
+	var parent trace.SpanContext
+	others := []trace.SpanContext{}
+	for _, t := range match.GetTickets() {
+		sc := trace.SpanContextFromContext(extensionsTraceContext(context.Background(), t.GetExtensions()))
+		switch {
+		case !sc.IsValid():
+		case !isBackfillTicket(t) && !parent.IsValid():
+			parent = sc
+		default:
+			others = append(others, sc)
+		}
+	}
+	if !parent.IsValid() && len(others) > 0 {
+		parent, others = others[0], others[1:]
+	}
+	for _, sc := range others {
+		links = append(links, trace.Link{SpanContext: sc})
+	}
+
+	opts := []trace.SpanStartOption{
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("match.id", match.GetMatchId()),
+			attribute.String("match.profile", match.GetMatchProfile()),
+			attribute.Int("match.tickets", len(match.GetTickets())),
+		),
+	}
+	if !parent.IsValid() {
+		// トレースコンテキストのないチケットだけのマッチは新しいトレースにする
+		opts = append(opts, trace.WithNewRoot())
+	}
+	return tracer.Start(trace.ContextWithRemoteSpanContext(ctx, parent), "AssignMatch", opts...)
+}
+
+// recordSpanError errをspanに記録し、spanを失敗にする
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(otelcodes.Error, err.Error())
+}
+
+// endSpan errがあればspanに記録して終了する
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		recordSpanError(span, err)
+	}
+	span.End()
+}
+
+// traceUnaryClientInterceptor 呼び出しのctxのトレースコンテキストをgRPCのメタデータで送る
+func traceUnaryClientInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	for k, v := range traceContextMap(ctx) {
+		ctx = metadata.AppendToOutgoingContext(ctx, k, v)
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
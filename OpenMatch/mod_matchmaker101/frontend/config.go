@@ -0,0 +1,48 @@
+package main
+
+import (
+	"time"
+
+	"common/config"
+)
+
+// frontendConfig Frontendの接続先と待ち受けの設定
+type frontendConfig struct {
+	// FrontendEndpoint Open MatchのFrontendのhost:port
+	FrontendEndpoint string
+	// Port /matchと/backend、/metricsを公開するHTTPのポート
+	Port int
+	// PollInterval チケットのAssignmentを確認する間隔
+	PollInterval time.Duration
+	// BackfillTTL BackfillTicketの有効期限。GameServerはBACKFILL_HEARTBEATごとに登録し直して期限を延ばす
+	// 空席数が変わらなければチケットは作り直さず、expires_atまで半分を切ったときだけ作り直す
+	// 期限の切れたBackfillTicketはMatch Functionが無視し、Directorが削除する
+	BackfillTTL time.Duration
+}
+
+// conf Frontendの設定。mainの最初で読み込む
+var conf frontendConfig
+
+// loadConfig 設定を既定値、設定ファイル、フラグ、環境変数の順に読み込んで検証する
+func loadConfig(args []string) (frontendConfig, error) {
+	var c frontendConfig
+	l := config.NewLoader()
+	l.StringVar(&c.FrontendEndpoint, "om-frontend", "OM_FRONTEND_ENDPOINT", "om-frontend.open-match.svc.cluster.local:50504", "host:port of the Open Match Frontend")
+	l.IntVar(&c.Port, "port", "PORT", 80, "Port serving the REST API and /metrics")
+	l.DurationVar(&c.PollInterval, "poll-interval", "POLL_INTERVAL", time.Second, "Interval of polling a ticket for its assignment")
+	l.DurationVar(&c.BackfillTTL, "backfill-ttl", "BACKFILL_TTL", time.Minute, "Lifetime of a backfill ticket unless the game server registers it again")
+	if err := l.Load(args); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+// validate 設定の誤りをまとめて返す
+func (c frontendConfig) validate() error {
+	var errs config.Errors
+	errs.Endpoint("om-frontend", c.FrontendEndpoint)
+	errs.Port("port", c.Port)
+	errs.Positive("poll-interval", c.PollInterval)
+	errs.Positive("backfill-ttl", c.BackfillTTL)
+	return errs.Err()
+}
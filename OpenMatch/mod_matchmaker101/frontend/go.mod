@@ -3,9 +3,20 @@ module frontend
 go 1.13
 
 require (
-	github.com/golang/protobuf v1.3.2
+	common v0.0.0
+	github.com/golang/protobuf v1.5.2
 	github.com/labstack/echo v3.3.10+incompatible
-	github.com/labstack/gommon v0.3.0 // indirect
-	google.golang.org/grpc v1.27.1
+	github.com/labstack/gommon v0.3.0
+	github.com/prometheus/client_golang v1.2.1
+	github.com/sirupsen/logrus v1.4.2
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.14.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.14.0
+	go.opentelemetry.io/otel/sdk v1.14.0
+	go.opentelemetry.io/otel/trace v1.14.0
+	google.golang.org/grpc v1.53.0
 	open-match.dev/open-match v0.9.0
 )
+
+// 全てのバイナリで共有する設定とログのパッケージ
+replace common => ../../../Common/mod_common
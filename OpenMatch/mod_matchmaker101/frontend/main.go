@@ -5,16 +5,23 @@ package main
 import (
 	"context"
 	"fmt"
-	"log"
 	"net/http"
+	"os"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/labstack/echo"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 
 	"google.golang.org/grpc"
 	"open-match.dev/open-match/pkg/pb"
+
+	"common/logging"
 )
 
 type matchResponce struct {
@@ -23,19 +30,50 @@ type matchResponce struct {
 }
 
 const (
-	// The endpoint for the Open Match Frontend service.
-	omFrontendEndpoint = "om-frontend.open-match.svc.cluster.local:50504"
 	// Number of tickets created per iteration
 	ticketsPerIter = 20
 )
 
 var fe pb.FrontendServiceClient
 
+var (
+	// backfillTickets 登録中のBackfillTicket(connection -> TicketID -> 登録内容)
+	backfillTickets = map[string]map[string]*backfillRegistration{}
+	// backfillOpenedAt GameServerが空席の募集を始めた時刻(connection -> 時刻)
+	// 空席数の変化でBackfillTicketを置き換えても引き継ぎ、満席や取り下げで募集が終わったら消す
+	backfillOpenedAt  = map[string]time.Time{}
+	backfillTicketsMu sync.Mutex
+)
+
+// backfillRegistration GameServerが登録したBackfillTicketの内容と期限
+// Open Matchのチケットは更新できないので、同じ内容の登録し直しではチケットを作り直さずにdeadlineだけ延ばす
+type backfillRegistration struct {
+	// joinablePlayerNum, endsAt GameServerが登録した空席数とセッションの終了予定
+	joinablePlayerNum string
+	endsAt            float64
+	// ticketExpiresAt チケットのexpires_at。過ぎるとMatch Functionに無視されるので、近づいたら作り直す
+	ticketExpiresAt time.Time
+	// deadline GameServerがこの時刻までに登録し直さなければ終了したものとみなしてチケットを削除する
+	deadline time.Time
+	// assigned Directorがプレイヤーを割り当てた。割り当て済みのチケットはQueryServiceから見えないので作り直す
+	assigned bool
+}
+
 func main() {
+	var err error
+	if conf, err = loadConfig(os.Args[1:]); err != nil {
+		logger.WithError(err).Fatal("Failed to load the config")
+	}
+
+	// echoのサーバーは終了しないので、終了時のspanの送信は行わない
+	if _, err := setupTracing(context.Background(), "frontend"); err != nil {
+		logger.WithError(err).Fatal("Failed to set up tracing")
+	}
+
 	// Connect to Open Match Frontend.
-	conn, err := grpc.Dial(omFrontendEndpoint, grpc.WithInsecure())
+	conn, err := grpc.Dial(conf.FrontendEndpoint, grpc.WithInsecure())
 	if err != nil {
-		log.Fatalf("Failed to connect to Open Match, got %v", err)
+		logger.WithError(err).Fatal("Failed to connect to Open Match")
 	}
 
 	defer conn.Close()
@@ -45,51 +83,71 @@ func main() {
 	e := echo.New()
 	e.GET("/match/:gamemode", handleGetMatch)
 	e.POST("/backend/:gamemode", handleRegisterBackfill)
-	e.Start(":80")
+	e.DELETE("/backend/:gamemode", handleWithdrawBackfill)
+	e.GET("/metrics", echo.WrapHandler(promhttp.Handler()))
+	e.Start(fmt.Sprintf(":%d", conf.Port))
 }
 
 func handleGetMatch(c echo.Context) error {
+	// プレイヤーのマッチングのトレースはこのspanから始まる
+	ctx, span := startRequestSpan(c, "GET /match/:gamemode")
+	defer span.End()
+	gamemode := c.Param("gamemode")
+	// regionは任意。指定すると同じ地域のプレイヤーを優先してマッチさせる
+	region := c.QueryParam("region")
+	reqLogger := logger.WithFields(logrus.Fields{logging.FieldMode: gamemode, "region": region})
+
 	matchRes := new(matchResponce)
 	if err := c.Bind(matchRes); err != nil {
-		log.Printf("Failed to echo Bind, got %v", err)
+		reqLogger.WithError(err).Error("Failed to echo Bind")
+		recordSpanError(span, err)
 		return c.JSON(http.StatusInternalServerError, matchRes)
 	}
 
 	// Create Ticket.
-	gamemode := c.Param("gamemode")
 	req := &pb.CreateTicketRequest{
-		Ticket: makeTicket(gamemode),
+		Ticket: makeTicket(ctx, gamemode, region, time.Now()),
 	}
 	resp, err := fe.CreateTicket(context.Background(), req)
 	if err != nil {
-		log.Printf("Failed to CreateTicket, got %v", err)
+		reqLogger.WithError(err).Error("Failed to CreateTicket")
+		recordSpanError(span, err)
 		return c.JSON(http.StatusInternalServerError, matchRes)
 	}
 	t := resp.Ticket
-	log.Printf("Create Ticket: %v", t.GetId())
+	reqLogger = reqLogger.WithField(logging.FieldTicketID, t.GetId())
+	reqLogger.Info("Create Ticket")
+	span.SetAttributes(attribute.String("ticket.id", t.GetId()))
+	ticketsCreated.WithLabelValues(gamemode, kindPlayer).Inc()
+	createdAt := time.Now()
 
 	// Polling TicketAssignment.
 	for {
 		got, err := fe.GetTicket(context.Background(), &pb.GetTicketRequest{TicketId: t.GetId()})
 		if err != nil {
-			log.Printf("Failed to GetTicket, got %v", err)
+			reqLogger.WithError(err).Error("Failed to GetTicket")
+			recordSpanError(span, err)
 			return c.JSON(http.StatusInternalServerError, matchRes)
 		}
 
 		if got.GetAssignment() != nil {
-			log.Printf("Ticket %v got assignment %v", got.GetId(), got.GetAssignment())
+			reqLogger.WithField("connection", got.GetAssignment().Connection).Info("Ticket got assignment")
+			span.AddEvent("assigned", trace.WithAttributes(attribute.String("connection", got.GetAssignment().Connection)))
+			timeToAssignment.WithLabelValues(gamemode).Observe(time.Since(createdAt).Seconds())
 			conn := got.GetAssignment().Connection
 			slice := strings.Split(conn, ":")
 			matchRes.IP = slice[0]
 			matchRes.Port = slice[1]
 			break
 		}
-		time.Sleep(time.Second * 1)
+		time.Sleep(conf.PollInterval)
 	}
 
 	_, err = fe.DeleteTicket(context.Background(), &pb.DeleteTicketRequest{TicketId: t.GetId()})
 	if err != nil {
-		log.Printf("Failed to Delete Ticket %v, got %s", t.GetId(), err.Error())
+		reqLogger.WithError(err).Error("Failed to Delete Ticket")
+	} else {
+		ticketsDeleted.WithLabelValues(gamemode, kindPlayer).Inc()
 	}
 	return c.JSON(http.StatusOK, matchRes)
 }
@@ -98,37 +156,91 @@ func handleGetMatch(c echo.Context) error {
 type backfillRequest struct {
 	Connection        string `json:"connection" form:"connection" query:"connection"`
 	JoinablePlayerNum string `json:"joinableplayernum" form:"joinableplayernum" query:"joinableplayernum"`
+	// EndsAt セッションが終わる予定のUNIX時刻(秒)。終了時刻がなければ空
+	EndsAt string `json:"endsat" form:"endsat" query:"endsat"`
 }
 
 func handleRegisterBackfill(c echo.Context) error {
+	// GameServerがセッションのtraceparentを送っていれば、BackfillTicketはそのトレースにつながる
+	ctx, span := startRequestSpan(c, "POST /backend/:gamemode")
+	defer span.End()
+	gamemode := c.Param("gamemode")
+	reqLogger := logger.WithField(logging.FieldMode, gamemode)
+
 	backfill := new(backfillRequest)
 	if err := c.Bind(backfill); err != nil {
-		errstr := fmt.Sprint("Failed to echo Bind, got %v", err)
-		log.Printf(errstr)
-		return c.String(http.StatusInternalServerError, errstr)
+		reqLogger.WithError(err).Error("Failed to echo Bind")
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to echo Bind, got %v", err))
+	}
+	reqLogger = reqLogger.WithField("connection", backfill.Connection)
+	endsAt := 0.0
+	if backfill.EndsAt != "" {
+		var err error
+		if endsAt, err = strconv.ParseFloat(backfill.EndsAt, 64); err != nil {
+			reqLogger.WithError(err).Warn("Invalid endsat")
+			return c.String(http.StatusBadRequest, fmt.Sprintf("Invalid endsat %q, got %v", backfill.EndsAt, err))
+		}
+	}
+	now := time.Now()
+	if refreshBackfill(backfill.Connection, backfill.JoinablePlayerNum, endsAt, now) {
+		reqLogger.Debug("Refreshed Backfill")
+		return c.String(http.StatusOK, "Refreshed")
+	}
+	createdAt := openBackfill(backfill.Connection, now)
+	expiresAt := now.Add(conf.BackfillTTL)
+
+	// 空席数が変わったときなどは、同じGameServerのBackfillTicketが常に1枚になるよう既存のものを置き換える
+	if _, err := withdrawBackfill(gamemode, backfill.Connection); err != nil {
+		reqLogger.WithError(err).Error("Failed to replace Backfill")
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to replace Backfill conn(%v), got %v", backfill.Connection, err))
 	}
 
 	// Create Ticket.
-	gamemode := c.Param("gamemode")
 	req := &pb.CreateTicketRequest{
-		Ticket: makeBackfillTicket(gamemode, backfill.Connection, backfill.JoinablePlayerNum),
+		Ticket: makeBackfillTicket(ctx, gamemode, backfill.Connection, backfill.JoinablePlayerNum, createdAt, endsAt, expiresAt),
 	}
 	resp, err := fe.CreateTicket(context.Background(), req)
 	if err != nil {
-		errstr := fmt.Sprint("Failed to CreateTicket, got %v", err)
-		log.Printf(errstr)
-		return c.String(http.StatusInternalServerError, errstr)
+		reqLogger.WithError(err).Error("Failed to CreateTicket")
+		recordSpanError(span, err)
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to CreateTicket, got %v", err))
 	}
 	t := resp.Ticket
-	log.Printf("Create BackfillTicket: %v", t.GetId())
+	reqLogger = reqLogger.WithField(logging.FieldTicketID, t.GetId())
+	reqLogger.Info("Create BackfillTicket")
+	span.SetAttributes(attribute.String("ticket.id", t.GetId()))
+	ticketsCreated.WithLabelValues(gamemode, kindBackfill).Inc()
+	registerBackfill(backfill.Connection, t.GetId(), &backfillRegistration{
+		joinablePlayerNum: backfill.JoinablePlayerNum,
+		endsAt:            endsAt,
+		ticketExpiresAt:   expiresAt,
+		deadline:          expiresAt,
+	})
 
 	// Polling TicketAssignment.
 	for {
+		// GameServerから取り下げられた、または置き換えられたBackfillTicketは削除済み
+		deadline, ok := backfillDeadline(backfill.Connection, t.GetId())
+		if !ok {
+			reqLogger.Info("Withdrawn Backfill")
+			return c.String(http.StatusOK, "Withdrawn")
+		}
+		// 期限までに登録し直されなかったGameServerは終了したものとみなす
+		if time.Now().After(deadline) {
+			closeBackfill(backfill.Connection)
+			reqLogger.Info("Expired Backfill")
+			break
+		}
+
 		got, err := fe.GetTicket(context.Background(), &pb.GetTicketRequest{TicketId: t.GetId()})
 		if err != nil {
-			errstr := fmt.Sprint("Failed to GetTicket, got %v", err)
-			log.Printf(errstr)
-			return c.String(http.StatusInternalServerError, errstr)
+			if _, ok := backfillDeadline(backfill.Connection, t.GetId()); !ok {
+				reqLogger.Info("Withdrawn Backfill")
+				return c.String(http.StatusOK, "Withdrawn")
+			}
+			unregisterBackfill(backfill.Connection, t.GetId())
+			reqLogger.WithError(err).Error("Failed to GetTicket")
+			return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to GetTicket, got %v", err))
 		}
 
 		if got.GetAssignment() != nil {
@@ -137,22 +249,145 @@ func handleRegisterBackfill(c echo.Context) error {
 			joinablePlayerNumStr := string(joinablePlayerNumByte)
 			joinablePlayerNum, err := strconv.Atoi(joinablePlayerNumStr)
 			if err != nil {
-				errstr := fmt.Sprint("Failed to Atoi, %v", err)
-				log.Printf(errstr)
-				return c.String(http.StatusInternalServerError, errstr)
+				unregisterBackfill(backfill.Connection, t.GetId())
+				reqLogger.WithError(err).Error("Failed to Atoi joinablePlayerNum")
+				return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to Atoi, %v", err))
 			}
 
 			if joinablePlayerNum <= 0 {
-				log.Printf("End Backfill. Ticket(%v) conn(%v)", got.GetId(), got.GetAssignment().Connection)
+				closeBackfill(backfill.Connection)
+				reqLogger.Info("End Backfill")
 				break
 			}
+			// Directorが空席数を減らしていれば、次の登録し直しで残りの空席のチケットを作り直す
+			if joinablePlayerNumStr != backfill.JoinablePlayerNum {
+				markBackfillAssigned(backfill.Connection, t.GetId())
+			}
 		}
-		time.Sleep(time.Second * 1)
+		time.Sleep(conf.PollInterval)
 	}
 
+	unregisterBackfill(backfill.Connection, t.GetId())
 	_, err = fe.DeleteTicket(context.Background(), &pb.DeleteTicketRequest{TicketId: t.GetId()})
 	if err != nil {
-		log.Printf("Failed to Delete Ticket %v, got %s", t.GetId(), err.Error())
+		reqLogger.WithError(err).Error("Failed to Delete Ticket")
+	} else {
+		ticketsDeleted.WithLabelValues(gamemode, kindBackfill).Inc()
+	}
+	return c.String(http.StatusOK, "OK")
+}
+
+// handleWithdrawBackfill GameServerのセッション終了時や満席時にBackfillTicketを取り下げる
+func handleWithdrawBackfill(c echo.Context) error {
+	reqLogger := logger.WithField(logging.FieldMode, c.Param("gamemode"))
+	backfill := new(backfillRequest)
+	if err := c.Bind(backfill); err != nil {
+		reqLogger.WithError(err).Error("Failed to echo Bind")
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to echo Bind, got %v", err))
+	}
+
+	closeBackfill(backfill.Connection)
+	found, err := withdrawBackfill(c.Param("gamemode"), backfill.Connection)
+	if err != nil {
+		reqLogger.WithError(err).WithField("connection", backfill.Connection).Error("Failed to withdraw Backfill")
+		return c.String(http.StatusInternalServerError, fmt.Sprintf("Failed to withdraw Backfill conn(%v), got %v", backfill.Connection, err))
+	}
+	if !found {
+		return c.String(http.StatusNotFound, "Backfill not found")
 	}
 	return c.String(http.StatusOK, "OK")
 }
+
+// withdrawBackfill connectionに対応する登録中のBackfillTicketを全て削除
+func withdrawBackfill(gamemode string, connection string) (bool, error) {
+	backfillTicketsMu.Lock()
+	ticketIDs := backfillTickets[connection]
+	delete(backfillTickets, connection)
+	backfillTicketsMu.Unlock()
+
+	for ticketID := range ticketIDs {
+		_, err := fe.DeleteTicket(context.Background(), &pb.DeleteTicketRequest{TicketId: ticketID})
+		if err != nil {
+			return true, fmt.Errorf("DeleteTicket %v failed, got %w", ticketID, err)
+		}
+		logger.WithFields(logrus.Fields{logging.FieldTicketID: ticketID, logging.FieldMode: gamemode, "connection": connection}).Info("Delete BackfillTicket")
+		ticketsDeleted.WithLabelValues(gamemode, kindBackfill).Inc()
+	}
+	return len(ticketIDs) > 0, nil
+}
+
+// registerBackfill connectionに対応するBackfillTicketを登録
+func registerBackfill(connection string, ticketID string, r *backfillRegistration) {
+	backfillTicketsMu.Lock()
+	defer backfillTicketsMu.Unlock()
+	if backfillTickets[connection] == nil {
+		backfillTickets[connection] = map[string]*backfillRegistration{}
+	}
+	backfillTickets[connection][ticketID] = r
+}
+
+// refreshBackfill 登録中のBackfillTicketが同じ空席数と終了予定のままマッチに使えるなら、deadlineを延ばしてtrueを返す
+// Directorが割り当てたチケットと、expires_atまでBackfillTTLの半分を切ったチケットは作り直すのでfalse
+func refreshBackfill(connection string, joinablePlayerNum string, endsAt float64, now time.Time) bool {
+	backfillTicketsMu.Lock()
+	defer backfillTicketsMu.Unlock()
+	for _, r := range backfillTickets[connection] {
+		if r.joinablePlayerNum != joinablePlayerNum || r.endsAt != endsAt || r.assigned {
+			continue
+		}
+		if r.ticketExpiresAt.Sub(now) < conf.BackfillTTL/2 {
+			continue
+		}
+		r.deadline = now.Add(conf.BackfillTTL)
+		return true
+	}
+	return false
+}
+
+// markBackfillAssigned connectionのBackfillTicketにDirectorがプレイヤーを割り当てたことを記録する
+func markBackfillAssigned(connection string, ticketID string) {
+	backfillTicketsMu.Lock()
+	defer backfillTicketsMu.Unlock()
+	if r, ok := backfillTickets[connection][ticketID]; ok {
+		r.assigned = true
+	}
+}
+
+// unregisterBackfill connectionに対応するBackfillTicketの登録を解除
+func unregisterBackfill(connection string, ticketID string) {
+	backfillTicketsMu.Lock()
+	defer backfillTicketsMu.Unlock()
+	delete(backfillTickets[connection], ticketID)
+	if len(backfillTickets[connection]) == 0 {
+		delete(backfillTickets, connection)
+	}
+}
+
+// openBackfill connectionが空席の募集を始めた時刻。募集中でなければnowから募集を始める
+func openBackfill(connection string, now time.Time) time.Time {
+	backfillTicketsMu.Lock()
+	defer backfillTicketsMu.Unlock()
+	if openedAt, ok := backfillOpenedAt[connection]; ok {
+		return openedAt
+	}
+	backfillOpenedAt[connection] = now
+	return now
+}
+
+// closeBackfill connectionの空席の募集を終える
+func closeBackfill(connection string) {
+	backfillTicketsMu.Lock()
+	defer backfillTicketsMu.Unlock()
+	delete(backfillOpenedAt, connection)
+}
+
+// backfillDeadline connectionに対応するBackfillTicketとしてticketIDが登録されていれば、その期限を返す
+func backfillDeadline(connection string, ticketID string) (time.Time, bool) {
+	backfillTicketsMu.Lock()
+	defer backfillTicketsMu.Unlock()
+	r, ok := backfillTickets[connection][ticketID]
+	if !ok {
+		return time.Time{}, false
+	}
+	return r.deadline, true
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRefreshBackfill(t *testing.T) {
+	conf.BackfillTTL = time.Minute
+	now := time.Unix(1600000000, 0)
+	tests := []struct {
+		name       string
+		registered *backfillRegistration
+		// joinablePlayerNum, endsAt GameServerが登録し直した内容
+		joinablePlayerNum string
+		endsAt            float64
+		want              bool
+	}{
+		{
+			name:              "same registration is refreshed",
+			registered:        &backfillRegistration{joinablePlayerNum: "2", endsAt: 100, ticketExpiresAt: now.Add(time.Minute)},
+			joinablePlayerNum: "2",
+			endsAt:            100,
+			want:              true,
+		},
+		{
+			name:              "changed seats recreate the ticket",
+			registered:        &backfillRegistration{joinablePlayerNum: "2", endsAt: 100, ticketExpiresAt: now.Add(time.Minute)},
+			joinablePlayerNum: "1",
+			endsAt:            100,
+		},
+		{
+			name:              "changed session end recreates the ticket",
+			registered:        &backfillRegistration{joinablePlayerNum: "2", endsAt: 100, ticketExpiresAt: now.Add(time.Minute)},
+			joinablePlayerNum: "2",
+			endsAt:            200,
+		},
+		{
+			name:              "assigned ticket is recreated",
+			registered:        &backfillRegistration{joinablePlayerNum: "2", endsAt: 100, ticketExpiresAt: now.Add(time.Minute), assigned: true},
+			joinablePlayerNum: "2",
+			endsAt:            100,
+		},
+		{
+			// expires_atまでBackfillTTLの半分を切ったら、Match Functionに無視される前に作り直す
+			name:              "ticket close to expiry is recreated",
+			registered:        &backfillRegistration{joinablePlayerNum: "2", endsAt: 100, ticketExpiresAt: now.Add(29 * time.Second)},
+			joinablePlayerNum: "2",
+			endsAt:            100,
+		},
+		{
+			name:              "unregistered connection",
+			joinablePlayerNum: "2",
+			endsAt:            100,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			backfillTickets = map[string]map[string]*backfillRegistration{}
+			if tt.registered != nil {
+				tt.registered.deadline = now
+				registerBackfill("127.0.0.1:7000", "ticket-1", tt.registered)
+			}
+
+			got := refreshBackfill("127.0.0.1:7000", tt.joinablePlayerNum, tt.endsAt, now)
+			if got != tt.want {
+				t.Fatalf("refreshBackfill() = %v, want %v", got, tt.want)
+			}
+			deadline, ok := backfillDeadline("127.0.0.1:7000", "ticket-1")
+			if tt.want && deadline != now.Add(conf.BackfillTTL) {
+				t.Errorf("deadline = %v, want %v", deadline, now.Add(conf.BackfillTTL))
+			}
+			// 作り直すチケットは、新しいチケットに置き換えるまで期限を延ばさない
+			if !tt.want && ok && deadline != now {
+				t.Errorf("deadline = %v, want unchanged %v", deadline, now)
+			}
+		})
+	}
+}
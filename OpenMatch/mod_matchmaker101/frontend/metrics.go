@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// チケットの種類(metricsのkindラベル)
+const (
+	kindPlayer   = "player"
+	kindBackfill = "backfill"
+)
+
+var (
+	// ticketsCreated ゲームモードとチケットの種類ごとの作成したチケット数
+	ticketsCreated = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "frontend_tickets_created_total",
+		Help: "Number of tickets created in Open Match.",
+	}, []string{"mode", "kind"})
+
+	// ticketsDeleted ゲームモードとチケットの種類ごとの削除したチケット数
+	ticketsDeleted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "frontend_tickets_deleted_total",
+		Help: "Number of tickets deleted from Open Match.",
+	}, []string{"mode", "kind"})
+
+	// timeToAssignment プレイヤーのチケットを作成してからAssignmentを受け取るまでの時間
+	timeToAssignment = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "frontend_time_to_assignment_seconds",
+		Help:    "Time from creating a player ticket until it got an assignment.",
+		Buckets: []float64{1, 2, 5, 10, 20, 30, 60, 120, 300},
+	}, []string{"mode"})
+)
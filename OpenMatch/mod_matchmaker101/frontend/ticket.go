@@ -1,13 +1,34 @@
 package main
 
 import (
+	"context"
+	"time"
+
 	any "github.com/golang/protobuf/ptypes/any"
 	"open-match.dev/open-match/pkg/pb"
 )
 
+// チケットのSearchFieldsのキー。Match Functionがこれを見てマッチの条件と空席を埋める順序を決める
+const (
+	// createdAtArg PlayerTicketを作成した、またはGameServerが空席の募集を始めたUNIX時刻(秒)
+	createdAtArg = "created_at"
+	// endsAtArg GameServerのセッションが終わる予定のUNIX時刻(秒)。終了時刻のないセッションでは付けない
+	endsAtArg = "ends_at"
+	// expiresAtArg BackfillTicketの有効期限のUNIX時刻(秒)
+	expiresAtArg = "expires_at"
+	// regionArg PlayerTicketの地域のStringArgsのキー。地域を指定しないプレイヤーには付けない
+	regionArg = "region"
+)
+
 // Ticket generates a Ticket with a mode search field that has one of the
 // randomly selected modes.
-func makeTicket(gamemode string) *pb.Ticket {
+// The trace context of ctx is stored in the extensions to follow the ticket through Open Match.
+// The creation time lets the match function relax the match for players who waited long.
+func makeTicket(ctx context.Context, gamemode string, region string, createdAt time.Time) *pb.Ticket {
+	var stringArgs map[string]string
+	if region != "" {
+		stringArgs = map[string]string{regionArg: region}
+	}
 	ticket := &pb.Ticket{
 		SearchFields: &pb.SearchFields{
 			// Tags can support multiple values but for simplicity, the demo function
@@ -16,15 +37,29 @@ func makeTicket(gamemode string) *pb.Ticket {
 				gamemode,
 				"player",
 			},
+			DoubleArgs: map[string]float64{
+				createdAtArg: unixSeconds(createdAt),
+			},
+			StringArgs: stringArgs,
 		},
+		Extensions: ticketTraceExtensions(ctx),
 	}
 
 	return ticket
 }
 
-func makeBackfillTicket(gamemode string, connection string, joinablePlayerNum string) *pb.Ticket {
+// makeBackfillTicket GameServerの空席を埋めるBackfillTicket
+// createdAtは空席の募集を始めた時刻、endsAtはセッションの終了予定のUNIX時刻(0なら終了時刻なし)、expiresAtは有効期限
+func makeBackfillTicket(ctx context.Context, gamemode string, connection string, joinablePlayerNum string, createdAt time.Time, endsAt float64, expiresAt time.Time) *pb.Ticket {
 	var anyJoinablePlayerNum any.Any
 	anyJoinablePlayerNum.Value = []byte(joinablePlayerNum)
+	args := map[string]float64{
+		createdAtArg: unixSeconds(createdAt),
+		expiresAtArg: unixSeconds(expiresAt),
+	}
+	if endsAt > 0 {
+		args[endsAtArg] = endsAt
+	}
 
 	ticket := &pb.Ticket{
 		SearchFields: &pb.SearchFields{
@@ -32,6 +67,7 @@ func makeBackfillTicket(gamemode string, connection string, joinablePlayerNum st
 				gamemode,
 				"backfill",
 			},
+			DoubleArgs: args,
 		},
 		Assignment: &pb.Assignment{
 			Connection: connection,
@@ -39,7 +75,13 @@ func makeBackfillTicket(gamemode string, connection string, joinablePlayerNum st
 				"joinablePlayerNum": &anyJoinablePlayerNum,
 			},
 		},
+		Extensions: ticketTraceExtensions(ctx),
 	}
 
 	return ticket
 }
+
+// unixSeconds 秒単位のUNIX時刻
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
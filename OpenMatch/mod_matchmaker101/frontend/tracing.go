@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/golang/protobuf/ptypes/any"
+	"github.com/labstack/echo"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracer Frontendのspanを作るTracer
+var tracer = otel.Tracer("frontend")
+
+// setupTracing トレースの出力先を環境変数OTEL_TRACES_EXPORTERで設定し、終了時に呼ぶ関数を返す
+// "otlp"ならOTEL_EXPORTER_OTLP_ENDPOINT(既定はlocalhost:4317)のcollectorへ、"stdout"なら標準出力へ出力する
+// 未設定または"none"なら出力せず、トレースコンテキストの伝搬だけ行う
+func setupTracing(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		// クラスタ内のcollectorを想定してTLSは使わない
+		exporter, err = otlptracegrpc.New(ctx, otlptracegrpc.WithInsecure())
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q, must be otlp, stdout or none", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %v trace exporter, got %w", os.Getenv("OTEL_TRACES_EXPORTER"), err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// startRequestSpan リクエストのspanを開始する
+// クライアント(GameServerのBackfill依頼等)がtraceparentヘッダーを送っていればその子にする
+func startRequestSpan(c echo.Context, name string) (context.Context, trace.Span) {
+	ctx := otel.GetTextMapPropagator().Extract(c.Request().Context(), propagation.HeaderCarrier(c.Request().Header))
+	return tracer.Start(ctx, name, trace.WithAttributes(attribute.String("gamemode", c.Param("gamemode"))))
+}
+
+// ticketTraceExtensions チケットに保存するトレースコンテキスト
+// MMFとDirectorがチケットのspanにつなげられるよう、propagatorのフィールド名("traceparent"等)をキーにする
+func ticketTraceExtensions(ctx context.Context) map[string]*any.Any {
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	extensions := map[string]*any.Any{}
+	for k, v := range carrier {
+		extensions[k] = &any.Any{Value: []byte(v)}
+	}
+	return extensions
+}
+
+// recordSpanError errをspanに記録し、spanを失敗にする
+func recordSpanError(span trace.Span, err error) {
+	span.RecordError(err)
+	span.SetStatus(otelcodes.Error, err.Error())
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"common/config"
+	"matchfunction/mmf"
+)
+
+// mmfConfig Match Functionの接続先と待ち受けの設定
+type mmfConfig struct {
+	// QueryServiceEndpoint Open MatchのQueryServiceのhost:port
+	QueryServiceEndpoint string
+	// Port Open MatchのBackendが呼び出すgRPCのポート
+	Port int
+	// MetricsPort /metricsを公開するHTTPのポート
+	MetricsPort int
+	// Params マッチの人数、レーティングの幅、Backfillの優先と対象。simulatorで比較した値を設定する
+	Params mmf.Params
+}
+
+// conf Match Functionの設定。mainの最初で読み込む
+var conf mmfConfig
+
+// loadConfig 設定を既定値、設定ファイル、フラグ、環境変数の順に読み込んで検証する
+func loadConfig(args []string) (mmfConfig, error) {
+	var c mmfConfig
+	l := config.NewLoader()
+	l.StringVar(&c.QueryServiceEndpoint, "query-service", "QUERY_SERVICE_ENDPOINT", "om-query.open-match.svc.cluster.local:50503", "host:port of the Open Match QueryService")
+	l.IntVar(&c.Port, "port", "PORT", 50502, "gRPC port of the match function")
+	l.IntVar(&c.MetricsPort, "metrics-port", "METRICS_PORT", 9090, "Port serving /metrics")
+	paramsVars(l, &c.Params)
+	if err := l.Load(args); err != nil {
+		return c, err
+	}
+	return c, c.validate()
+}
+
+// validate 設定の誤りをまとめて返す
+func (c mmfConfig) validate() error {
+	var errs config.Errors
+	errs.Endpoint("query-service", c.QueryServiceEndpoint)
+	errs.Port("port", c.Port)
+	errs.Port("metrics-port", c.MetricsPort)
+	if c.Port == c.MetricsPort {
+		errs.Add("port and metrics-port must differ, got %v", c.Port)
+	}
+	if err := c.Params.Validate(); err != nil {
+		errs.Add("%v", err)
+	}
+	return errs.Err()
+}
+
+// paramsVars MakeMatchesのパラメータのフラグを定義する。simulatorと同じフラグ名にする
+func paramsVars(l *config.Loader, p *mmf.Params) {
+	l.IntVar(&p.MinPlayers, "min-players", "MIN_PLAYERS", mmf.DefaultParams.MinPlayers, "Smallest new match")
+	l.IntVar(&p.MaxPlayers, "max-players", "MAX_PLAYERS", mmf.DefaultParams.MaxPlayers, "Largest new match")
+	l.FloatVar(&p.RatingWindow, "rating-window", "RATING_WINDOW", mmf.DefaultParams.RatingWindow, "Largest rating difference within a new match, 0 disables it")
+	l.BoolVar(&p.BackfillFirst, "backfill-first", "BACKFILL_FIRST", mmf.DefaultParams.BackfillFirst, "Fill backfill tickets before making new matches")
+	l.BoolVar(&p.OldestBackfillFirst, "oldest-backfill-first", "OLDEST_BACKFILL_FIRST", mmf.DefaultParams.OldestBackfillFirst, "Fill the backfill tickets that have offered seats the longest first")
+	l.FloatVar(&p.MaxBackfillShare, "max-backfill-share", "MAX_BACKFILL_SHARE", mmf.DefaultParams.MaxBackfillShare, "Largest fraction of the players of a run sent to backfill")
+	l.DurationVar(&p.MinRemainingTime, "min-remaining-time", "MIN_REMAINING_TIME", mmf.DefaultParams.MinRemainingTime, "Do not send players to sessions ending sooner than this")
+}
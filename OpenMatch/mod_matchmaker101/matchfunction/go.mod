@@ -3,6 +3,17 @@ module matchfunction
 go 1.13
 
 require (
-	google.golang.org/grpc v1.27.1
+	common v0.0.0
+	github.com/prometheus/client_golang v1.2.1
+	github.com/sirupsen/logrus v1.4.2
+	go.opentelemetry.io/otel v1.14.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.14.0
+	go.opentelemetry.io/otel/exporters/stdout/stdouttrace v1.14.0
+	go.opentelemetry.io/otel/sdk v1.14.0
+	go.opentelemetry.io/otel/trace v1.14.0
+	google.golang.org/grpc v1.53.0
 	open-match.dev/open-match v0.9.0
 )
+
+// 全てのバイナリで共有する設定とログのパッケージ
+replace common => ../../../Common/mod_common
@@ -1,18 +1,26 @@
 package main
 
 import (
+	"context"
+	"os"
+
 	"matchfunction/mmf"
 )
 
-// This tutorial implenents a basic Match Function that is hosted in the below
-// configured port. You can also configure the Open Match QueryService endpoint
-// with which the Match Function communicates to query the Tickets.
-
-const (
-	queryServiceAddress = "om-query.open-match.svc.cluster.local:50503" // Address of the QueryService endpoint.
-	serverPort          = 50502                                         // The port for hosting the Match Function.
-)
+// This tutorial implenents a basic Match Function that is hosted in the
+// configured port. The Open Match QueryService endpoint with which the Match
+// Function communicates to query the Tickets and the ports are read from flags,
+// environment variables or a config file, see config.go.
 
 func main() {
-	mmf.Start(queryServiceAddress, serverPort)
+	var err error
+	if conf, err = loadConfig(os.Args[1:]); err != nil {
+		mmf.Logger.WithError(err).Fatal("Failed to load the config")
+	}
+	// The match function runs until it is killed, so spans are not flushed on exit.
+	if _, err := mmf.SetupTracing(context.Background(), "matchfunction"); err != nil {
+		mmf.Logger.WithError(err).Fatal("Failed to set up tracing")
+	}
+	go mmf.ServeMetrics(conf.MetricsPort)
+	mmf.Start(conf.QueryServiceEndpoint, conf.Port, conf.Params)
 }
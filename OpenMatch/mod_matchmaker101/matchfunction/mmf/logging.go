@@ -0,0 +1,7 @@
+package mmf
+
+import "common/logging"
+
+// Logger is the JSON logger of the match function. Its level is set by LOG_LEVEL
+// (debug, info, warn or error, defaults to info).
+var Logger = logging.New("matchfunction")
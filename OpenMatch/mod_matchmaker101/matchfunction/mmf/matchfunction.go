@@ -2,12 +2,18 @@ package mmf
 
 import (
 	"fmt"
-	"log"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel/attribute"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
 	"open-match.dev/open-match/pkg/matchfunction"
 	"open-match.dev/open-match/pkg/pb"
+
+	"common/logging"
 )
 
 // This match function fetches all the Tickets for all the pools specified in
@@ -15,15 +21,40 @@ import (
 // a Match Proposal. It continues to generate proposals till one of the pools
 // runs out of Tickets.
 const (
-	matchName                 = "basic-matchfunction"
-	mixTicketsPerPoolPerMatch = 2
-	maxTicketsPerPoolPerMatch = 4
+	matchName = "basic-matchfunction"
+	// ratingArg PlayerTicketのレーティングのSearchFieldsのDoubleArgsのキー
+	ratingArg = "rating"
+	// createdAtArg チケットの作成時刻のUNIX時刻(秒)のDoubleArgsのキー
+	// PlayerTicketはFrontendが作成した時刻、BackfillTicketはGameServerが空席の募集を始めた時刻
+	createdAtArg = "created_at"
+	// endsAtArg BackfillTicketのセッションが終わる予定のUNIX時刻(秒)のDoubleArgsのキー
+	endsAtArg = "ends_at"
+	// expiresAtArg BackfillTicketの有効期限のUNIX時刻(秒)のDoubleArgsのキー
+	expiresAtArg = "expires_at"
 )
 
 // Run is this match function's implementation of the gRPC call defined in api/matchfunction.proto.
-func (s *MatchFunctionService) Run(req *pb.RunRequest, stream pb.MatchFunction_RunServer) error {
+func (s *MatchFunctionService) Run(req *pb.RunRequest, stream pb.MatchFunction_RunServer) (err error) {
 	// Fetch tickets for the pools specified in the Match Profile.
-	log.Printf("Generating proposals for function %v", req.GetProfile().GetName())
+	profile := req.GetProfile().GetName()
+	runLogger := Logger.WithField(logging.FieldProfile, profile)
+	runLogger.Debug("Generating proposals")
+	runs.WithLabelValues(profile).Inc()
+	proposalNum := 0
+	defer func() {
+		proposalsPerRun.WithLabelValues(profile).Observe(float64(proposalNum))
+	}()
+
+	// Open MatchのBackendはトレースコンテキストを中継しないので、Runごとに新しいトレースにする
+	ctx, span := tracer.Start(stream.Context(), "MatchFunction.Run", trace.WithAttributes(attribute.String("profile", profile)))
+	defer func() {
+		span.SetAttributes(attribute.Int("proposals", proposalNum))
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(otelcodes.Error, err.Error())
+		}
+		span.End()
+	}()
 
 	for _, pool := range req.GetProfile().GetPools() {
 		// Get Player Tickets.
@@ -32,7 +63,7 @@ func (s *MatchFunctionService) Run(req *pb.RunRequest, stream pb.MatchFunction_R
 		playerPool.TagPresentFilters = append(playerPool.TagPresentFilters, &playerTag)
 		playerTickets, err := matchfunction.QueryPool(stream.Context(), s.queryServiceClient, &playerPool)
 		if err != nil {
-			log.Printf("Failed to query tickets for the given pool, got %s", err.Error())
+			runLogger.WithError(err).WithField("pool", pool.GetName()).Error("Failed to query player tickets for the given pool")
 			return err
 		}
 
@@ -42,95 +73,257 @@ func (s *MatchFunctionService) Run(req *pb.RunRequest, stream pb.MatchFunction_R
 		backfillPool.TagPresentFilters = append(backfillPool.TagPresentFilters, &backfillTag)
 		backfillTickets, err := matchfunction.QueryPool(stream.Context(), s.queryServiceClient, &backfillPool)
 		if err != nil {
-			log.Printf("Failed to query tickets for the given pool, got %s", err.Error())
+			runLogger.WithError(err).WithField("pool", pool.GetName()).Error("Failed to query backfill tickets for the given pool")
 			return err
 		}
 
+		poolSize.WithLabelValues(profile, "player").Observe(float64(len(playerTickets)))
+		poolSize.WithLabelValues(profile, "backfill").Observe(float64(len(backfillTickets)))
+
 		// Generate proposal.
-		proposals, err := makeMatches(req.GetProfile(), playerTickets, backfillTickets)
+		proposals, err := MakeMatches(s.params, time.Now(), req.GetProfile(), playerTickets, backfillTickets)
 		if err != nil {
-			log.Printf("Failed to generate matches, got %s", err.Error())
+			runLogger.WithError(err).Error("Failed to generate matches")
 			return err
 		}
 
-		log.Printf("Streaming %v proposals to Open Match", len(proposals))
+		runLogger.Debugf("Streaming %v proposals to Open Match", len(proposals))
 
 		// Stream the generated proposals back to Open Match.
 		for _, proposal := range proposals {
+			if level, ok := RelaxationLevel(proposal); ok {
+				proposalsByRelaxation.WithLabelValues(profile, strconv.Itoa(level)).Inc()
+			}
+			traceProposal(ctx, proposal)
+			proposalLogger := runLogger.WithFields(logrus.Fields{logging.FieldMatchID: proposal.GetMatchId(), logging.FieldTicketID: ticketIDs(proposal)})
 			if err := stream.Send(&pb.RunResponse{Proposal: proposal}); err != nil {
-				log.Printf("Failed to stream proposals to Open Match, got %s", err.Error())
+				proposalLogger.WithError(err).Error("Failed to stream proposal to Open Match")
 				return err
 			}
+			proposalLogger.Debug("Streamed proposal")
+			proposalNum++
 		}
 	}
 
 	return nil
 }
 
-// makeMatches Matcheを作成
-func makeMatches(p *pb.MatchProfile, playerTickets []*pb.Ticket, backfillTickets []*pb.Ticket) ([]*pb.Match, error) {
-	var matches []*pb.Match
+// MakeMatches groups the player tickets of one pool into new matches and into the
+// free seats of the backfill tickets under params at now. Run streams the result
+// as proposals; the simulator calls it directly to compare params offline.
+// Every backfill ticket is in at most one match, so the proposals never overlap.
+// Players who have waited long are matched under the relaxation schedule of p,
+// and every new match records its relaxation level.
+func MakeMatches(params Params, now time.Time, p *pb.MatchProfile, playerTickets []*pb.Ticket, backfillTickets []*pb.Ticket) ([]*pb.Match, error) {
+	schedule, err := ProfileRelaxation(p)
+	if err != nil {
+		return nil, err
+	}
+	backfills, err := backfillSlots(params, now, backfillTickets)
+	if err != nil {
+		return nil, err
+	}
 
-	matchTickets := []*pb.Ticket{}
+	// BackFillチケットに送るのは、新規マッチが作れなくならないようMaxBackfillShareの割合までにする
+	// 上限は新規マッチの前と後に埋める分の合計
+	limit := int(params.MaxBackfillShare * float64(len(playerTickets)))
 
 	// BackFillチケットから空いているプレイヤーを埋めていく
-	for _, backfillTicket := range backfillTickets {
-		// 現在のプレイヤー数を取得
-		extensions := backfillTicket.GetAssignment().GetExtensions()
-		joinablePlayerNumByte := extensions["joinablePlayerNum"].GetValue()
-		joinablePlayerNumStr := string(joinablePlayerNumByte)
-		joinablePlayerNum, err := strconv.Atoi(joinablePlayerNumStr)
+	if params.BackfillFirst {
+		rest := fillBackfills(backfills, playerTickets, limit)
+		limit -= len(playerTickets) - len(rest)
+		playerTickets = rest
+	}
+
+	// 通常のマッチメイク
+	matches, rest := newMatches(params, p, playerTickets)
+	for _, m := range matches {
+		setRelaxationLevel(m, 0)
+	}
+
+	// 長く待っているプレイヤーは条件を緩めてマッチさせる
+	if len(schedule) > 0 {
+		relaxed, relaxedRest := relaxedMatches(params, schedule, now, p, rest)
+		matches = append(matches, relaxed...)
+		rest = relaxedRest
+	}
+
+	// 新規マッチに入らなかったプレイヤーで、上限の残りまで空席を埋める
+	fillBackfills(backfills, rest, limit)
+	for _, b := range backfills {
+		if len(b.players) > 0 {
+			matches = append(matches, newMatch(p, append([]*pb.Ticket{b.ticket}, b.players...)))
+		}
+	}
+	return matches, nil
+}
+
+// backfillSlot 1枚のBackfillTicketの空席と、そこに埋めるPlayerTicket
+type backfillSlot struct {
+	ticket  *pb.Ticket
+	seats   int
+	players []*pb.Ticket
+}
+
+// backfillSlots プレイヤーを送れるBackfillTicketの空席を、埋める順に返す
+// 有効期限の切れたチケットと、終了済みか終了までMinRemainingTimeより短いセッションは除き、
+// OldestBackfillFirstなら空席の募集を始めた順に並べる
+func backfillSlots(params Params, now time.Time, backfillTickets []*pb.Ticket) ([]*backfillSlot, error) {
+	var slots []*backfillSlot
+	for _, t := range backfillTickets {
+		// 登録し直されなかったGameServerは終了している可能性があるので送らない
+		if expiresAt, ok := t.GetSearchFields().GetDoubleArgs()[expiresAtArg]; ok && !unixTime(expiresAt).After(now) {
+			continue
+		}
+		seats, err := joinablePlayerNum(t)
 		if err != nil {
-			return matches, err
+			return nil, err
+		}
+		if seats <= 0 {
+			continue
+		}
+		// 終了済みのセッションには、MinRemainingTimeが0でも送らない
+		if endsAt, ok := t.GetSearchFields().GetDoubleArgs()[endsAtArg]; ok {
+			if remaining := unixTime(endsAt).Sub(now); remaining <= 0 || remaining < params.MinRemainingTime {
+				continue
+			}
 		}
+		slots = append(slots, &backfillSlot{ticket: t, seats: seats})
+	}
+	if params.OldestBackfillFirst {
+		// 作成時刻のないチケットは最も古いものとして扱う
+		sort.SliceStable(slots, func(i, j int) bool { return createdAt(slots[i].ticket) < createdAt(slots[j].ticket) })
+	}
+	return slots, nil
+}
 
-		// 不足しているプレイヤー数分のPlayerTicketを1Matcheにまとめる
-		var joinPalyerNum int
-		if len(playerTickets) <= joinablePlayerNum {
-			joinPalyerNum = len(playerTickets)
-		} else {
-			joinPalyerNum = joinablePlayerNum
+// fillBackfills 先頭のBackfillTicketから順にlimit枚までのPlayerTicketで空席を埋め、残ったPlayerTicketを返す
+// BackfillTicketにはレーティングがないので、RatingWindowは考慮しない
+func fillBackfills(backfills []*backfillSlot, playerTickets []*pb.Ticket, limit int) []*pb.Ticket {
+	for _, b := range backfills {
+		// 不足しているプレイヤー数分のPlayerTicketをBackfillTicketのMatchにまとめる
+		n := b.seats - len(b.players)
+		if n > limit {
+			n = limit
 		}
-		if joinPalyerNum <= 0 {
+		if n > len(playerTickets) {
+			n = len(playerTickets)
+		}
+		if n <= 0 {
 			continue
 		}
-		matchTickets = append(matchTickets, backfillTicket)
-		matchTickets = append(matchTickets, playerTickets[0:joinPalyerNum]...)
-		playerTickets = playerTickets[joinPalyerNum:]
+		b.players = append(b.players, playerTickets[0:n]...)
+		playerTickets = playerTickets[n:]
+		limit -= n
+	}
+	return playerTickets
+}
 
-		matches = append(matches, &pb.Match{
-			MatchId:       fmt.Sprintf("profile-%v-time-%v", p.GetName(), time.Now().Format("2006-01-02T15:04:05.00")),
-			MatchProfile:  p.GetName(),
-			MatchFunction: matchName,
-			Tickets:       matchTickets,
-		})
+// newMatches PlayerTicketを地域ごとにMinPlayers〜MaxPlayers人のMatchにまとめ、残ったPlayerTicketを返す
+func newMatches(params Params, p *pb.MatchProfile, playerTickets []*pb.Ticket) ([]*pb.Match, []*pb.Ticket) {
+	var regions []string
+	byRegion := map[string][]*pb.Ticket{}
+	for _, t := range playerTickets {
+		r := region(t)
+		if _, ok := byRegion[r]; !ok {
+			regions = append(regions, r)
+		}
+		byRegion[r] = append(byRegion[r], t)
 	}
 
-	// 通常のマッチメイク
-	if len(playerTickets) <= 0 {
-		return matches, nil
+	var matches []*pb.Match
+	var leftovers []*pb.Ticket
+	for _, r := range regions {
+		m, rest := regionMatches(params, p, byRegion[r])
+		matches = append(matches, m...)
+		leftovers = append(leftovers, rest...)
 	}
+	return matches, leftovers
+}
 
-	for {
-		if len(playerTickets) < mixTicketsPerPoolPerMatch {
-			break
+// regionMatches 同じ地域のPlayerTicketをMinPlayers〜MaxPlayers人のMatchにまとめ、残ったPlayerTicketを返す
+// RatingWindowが0より大きければレーティング順に並べ、先頭との差がRatingWindow以内のチケットだけでまとめる
+func regionMatches(params Params, p *pb.MatchProfile, playerTickets []*pb.Ticket) ([]*pb.Match, []*pb.Ticket) {
+	var matches []*pb.Match
+	var leftovers []*pb.Ticket
+	if params.RatingWindow > 0 {
+		playerTickets = sortByRating(playerTickets)
+	}
+	for len(playerTickets) >= params.MinPlayers {
+		n := groupSize(params, playerTickets)
+		if n < params.MinPlayers {
+			// 先頭のチケットと組めるチケットが足りないので、次のチケットから探す
+			leftovers = append(leftovers, playerTickets[0])
+			playerTickets = playerTickets[1:]
+			continue
 		}
+		matches = append(matches, newMatch(p, playerTickets[0:n]))
+		playerTickets = playerTickets[n:]
+	}
+	return matches, append(leftovers, playerTickets...)
+}
 
-		if maxTicketsPerPoolPerMatch <= len(playerTickets) {
-			matchTickets = append(matchTickets, playerTickets[0:maxTicketsPerPoolPerMatch]...)
-			playerTickets = playerTickets[maxTicketsPerPoolPerMatch:]
-		} else {
-			currentTickets := len(playerTickets)
-			matchTickets = append(matchTickets, playerTickets[0:currentTickets]...)
-			playerTickets = playerTickets[currentTickets:]
+// groupSize 先頭から何枚のPlayerTicketを1つのMatchにまとめられるか
+func groupSize(params Params, playerTickets []*pb.Ticket) int {
+	n := 0
+	for n < len(playerTickets) && n < params.MaxPlayers {
+		if params.RatingWindow > 0 && rating(playerTickets[n])-rating(playerTickets[0]) > params.RatingWindow {
+			break
 		}
-		matches = append(matches, &pb.Match{
-			MatchId:       fmt.Sprintf("profile-%v-time-%v", p.GetName(), time.Now().Format("2006-01-02T15:04:05.00")),
-			MatchProfile:  p.GetName(),
-			MatchFunction: matchName,
-			Tickets:       matchTickets,
-		})
+		n++
 	}
+	return n
+}
 
-	return matches, nil
+// newMatch ticketsの提案するMatch
+// 同じ時刻に作った複数のMatchのIDが重ならないよう、先頭のチケットのIDを付ける
+// 1回のMakeMatchesでチケットが入るMatchは1つだけなので、先頭のチケットはMatchごとに異なる
+func newMatch(p *pb.MatchProfile, tickets []*pb.Ticket) *pb.Match {
+	return &pb.Match{
+		MatchId:       fmt.Sprintf("profile-%v-time-%v-%v", p.GetName(), time.Now().Format("2006-01-02T15:04:05.00"), tickets[0].GetId()),
+		MatchProfile:  p.GetName(),
+		MatchFunction: matchName,
+		Tickets:       tickets,
+	}
+}
+
+// joinablePlayerNum BackfillTicketの空席数
+func joinablePlayerNum(backfillTicket *pb.Ticket) (int, error) {
+	joinablePlayerNumStr := string(backfillTicket.GetAssignment().GetExtensions()["joinablePlayerNum"].GetValue())
+	joinablePlayerNum, err := strconv.Atoi(joinablePlayerNumStr)
+	if err != nil {
+		return 0, fmt.Errorf("invalid joinablePlayerNum %q of backfill ticket %v, got %w", joinablePlayerNumStr, backfillTicket.GetId(), err)
+	}
+	return joinablePlayerNum, nil
+}
+
+// rating PlayerTicketのレーティング(SearchFieldsのDoubleArgs["rating"]、なければ0)
+func rating(t *pb.Ticket) float64 {
+	return t.GetSearchFields().GetDoubleArgs()[ratingArg]
+}
+
+// createdAt チケットのDoubleArgs["created_at"]のUNIX時刻(秒)。なければ0
+func createdAt(t *pb.Ticket) float64 {
+	return t.GetSearchFields().GetDoubleArgs()[createdAtArg]
+}
+
+// unixTime 秒単位のUNIX時刻
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// sortByRating レーティングの昇順に並べ替えたPlayerTicket。同じレーティングは元の順序を保つ
+func sortByRating(playerTickets []*pb.Ticket) []*pb.Ticket {
+	sorted := append([]*pb.Ticket{}, playerTickets...)
+	sort.SliceStable(sorted, func(i, j int) bool { return rating(sorted[i]) < rating(sorted[j]) })
+	return sorted
+}
+
+// ticketIDs マッチのチケットのIDの一覧(ログ用)
+func ticketIDs(match *pb.Match) []string {
+	ids := []string{}
+	for _, t := range match.GetTickets() {
+		ids = append(ids, t.GetId())
+	}
+	return ids
 }
@@ -0,0 +1,219 @@
+package mmf
+
+import (
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/any"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// playerTickets n枚のPlayerTicket
+func playerTickets(n int) []*pb.Ticket {
+	var tickets []*pb.Ticket
+	for i := 0; i < n; i++ {
+		tickets = append(tickets, &pb.Ticket{
+			Id:           fmt.Sprintf("player-%d", i),
+			SearchFields: &pb.SearchFields{Tags: []string{"mode.demo", "player"}},
+		})
+	}
+	return tickets
+}
+
+// backfillTicket seats席が空いているBackfillTicket
+func backfillTicket(id string, seats int) *pb.Ticket {
+	return &pb.Ticket{
+		Id:           id,
+		SearchFields: &pb.SearchFields{Tags: []string{"mode.demo", "backfill"}},
+		Assignment: &pb.Assignment{
+			Connection: id,
+			Extensions: map[string]*any.Any{
+				"joinablePlayerNum": {Value: []byte(strconv.Itoa(seats))},
+			},
+		},
+	}
+}
+
+func TestMakeMatchesUniqueMatchIDs(t *testing.T) {
+	params := DefaultParams
+	params.MinPlayers = 2
+	params.MaxPlayers = 2
+	backfills := []*pb.Ticket{backfillTicket("backfill-0", 1), backfillTicket("backfill-1", 1)}
+	matches, err := MakeMatches(params, time.Now(), &pb.MatchProfile{Name: "mode.demo"}, playerTickets(10), backfills)
+	if err != nil {
+		t.Fatalf("MakeMatches failed: %v", err)
+	}
+	if len(matches) != 2+4 {
+		t.Fatalf("got %v matches, want %v", len(matches), 2+4)
+	}
+	seen := map[string]bool{}
+	for _, m := range matches {
+		if seen[m.GetMatchId()] {
+			t.Errorf("match id %v is not unique", m.GetMatchId())
+		}
+		seen[m.GetMatchId()] = true
+	}
+}
+
+func TestMakeMatchesMaxBackfillShare(t *testing.T) {
+	tests := []struct {
+		name          string
+		backfillFirst bool
+		share         float64
+		want          int
+	}{
+		// 10人の3割の3人を先に送り、新規マッチの残りの3人は送らない
+		{name: "backfill first", backfillFirst: true, share: 0.3, want: 3},
+		// 新規マッチの残りの2人のうち、10人の1割の1人だけを送る
+		{name: "leftovers only", backfillFirst: false, share: 0.1, want: 1},
+		{name: "no limit", backfillFirst: true, share: 1, want: 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := DefaultParams
+			params.MinPlayers = 4
+			params.MaxPlayers = 4
+			params.BackfillFirst = tt.backfillFirst
+			params.MaxBackfillShare = tt.share
+			backfills := []*pb.Ticket{backfillTicket("backfill-0", 5)}
+			matches, err := MakeMatches(params, time.Now(), &pb.MatchProfile{Name: "mode.demo"}, playerTickets(10), backfills)
+			if err != nil {
+				t.Fatalf("MakeMatches failed: %v", err)
+			}
+			backfilled := 0
+			for _, m := range matches {
+				if m.GetTickets()[0].GetId() == "backfill-0" {
+					backfilled += len(m.GetTickets()) - 1
+				}
+			}
+			if backfilled != tt.want {
+				t.Errorf("sent %v players to backfill, want %v", backfilled, tt.want)
+			}
+		})
+	}
+}
+
+// withDoubleArgs BackfillTicketのSearchFieldsのDoubleArgsにargsを設定する
+func withDoubleArgs(t *pb.Ticket, args map[string]float64) *pb.Ticket {
+	t.SearchFields.DoubleArgs = args
+	return t
+}
+
+func TestBackfillSlotsSkipsExpired(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	at := func(d time.Duration) float64 { return float64(now.Add(d).UnixNano()) / float64(time.Second) }
+	tests := []struct {
+		name   string
+		ticket *pb.Ticket
+		want   bool
+	}{
+		{name: "no deadlines", ticket: backfillTicket("b", 2), want: true},
+		{name: "expires later", ticket: withDoubleArgs(backfillTicket("b", 2), map[string]float64{expiresAtArg: at(time.Second)}), want: true},
+		{name: "expired", ticket: withDoubleArgs(backfillTicket("b", 2), map[string]float64{expiresAtArg: at(-time.Second)}), want: false},
+		{name: "expires now", ticket: withDoubleArgs(backfillTicket("b", 2), map[string]float64{expiresAtArg: at(0)}), want: false},
+		{name: "session ends later", ticket: withDoubleArgs(backfillTicket("b", 2), map[string]float64{endsAtArg: at(time.Minute)}), want: true},
+		{name: "session ended", ticket: withDoubleArgs(backfillTicket("b", 2), map[string]float64{endsAtArg: at(-time.Second)}), want: false},
+		{name: "no seats", ticket: backfillTicket("b", 0), want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := DefaultParams
+			slots, err := backfillSlots(params, now, []*pb.Ticket{tt.ticket})
+			if err != nil {
+				t.Fatalf("backfillSlots failed: %v", err)
+			}
+			if got := len(slots) == 1; got != tt.want {
+				t.Errorf("backfill used = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMakeMatchesSkipsExpiredBackfills(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	expired := withDoubleArgs(backfillTicket("expired", 4), map[string]float64{expiresAtArg: float64(now.Unix() - 1)})
+	live := withDoubleArgs(backfillTicket("live", 1), map[string]float64{expiresAtArg: float64(now.Unix() + 60)})
+	matches, err := MakeMatches(DefaultParams, now, &pb.MatchProfile{Name: "mode.demo"}, playerTickets(1), []*pb.Ticket{expired, live})
+	if err != nil {
+		t.Fatalf("MakeMatches failed: %v", err)
+	}
+	if len(matches) != 1 || matches[0].GetTickets()[0].GetId() != "live" {
+		t.Fatalf("got matches %v, want only the live backfill", matches)
+	}
+}
+
+func TestBackfillSlotsMinRemainingTime(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	endsIn := func(d time.Duration) *pb.Ticket {
+		return withDoubleArgs(backfillTicket("b", 2), map[string]float64{endsAtArg: float64(now.Add(d).Unix())})
+	}
+	tests := []struct {
+		name      string
+		remaining time.Duration
+		ticket    *pb.Ticket
+		want      bool
+	}{
+		{name: "ends after the limit", remaining: time.Minute, ticket: endsIn(2 * time.Minute), want: true},
+		{name: "ends exactly at the limit", remaining: time.Minute, ticket: endsIn(time.Minute), want: true},
+		{name: "ends before the limit", remaining: time.Minute, ticket: endsIn(59 * time.Second), want: false},
+		{name: "no session end", remaining: time.Minute, ticket: backfillTicket("b", 2), want: true},
+		{name: "no limit", remaining: 0, ticket: endsIn(time.Second), want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := DefaultParams
+			params.MinRemainingTime = tt.remaining
+			slots, err := backfillSlots(params, now, []*pb.Ticket{tt.ticket})
+			if err != nil {
+				t.Fatalf("backfillSlots failed: %v", err)
+			}
+			if got := len(slots) == 1; got != tt.want {
+				t.Errorf("backfill used = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFillBackfillsOldestBackfillFirst(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	openedAt := func(id string, ago time.Duration) *pb.Ticket {
+		return withDoubleArgs(backfillTicket(id, 2), map[string]float64{createdAtArg: float64(now.Add(-ago).Unix())})
+	}
+	// クエリの順は新しいもの、古いもの、作成時刻なし
+	backfills := []*pb.Ticket{openedAt("new", time.Second), openedAt("old", time.Minute), backfillTicket("unknown", 2)}
+	tests := []struct {
+		name   string
+		oldest bool
+		// want 3人のプレイヤーを送るBackfillTicketの順
+		want []string
+	}{
+		{name: "query order", oldest: false, want: []string{"new", "new", "old"}},
+		// 作成時刻のないチケットは最も古いものとして扱う
+		{name: "oldest first", oldest: true, want: []string{"unknown", "unknown", "old"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := DefaultParams
+			params.OldestBackfillFirst = tt.oldest
+			slots, err := backfillSlots(params, now, backfills)
+			if err != nil {
+				t.Fatalf("backfillSlots failed: %v", err)
+			}
+			rest := fillBackfills(slots, playerTickets(3), 3)
+			if len(rest) != 0 {
+				t.Fatalf("%v players left, want 0", len(rest))
+			}
+			var got []string
+			for _, b := range slots {
+				for range b.players {
+					got = append(got, b.ticket.GetId())
+				}
+			}
+			if !equalIDs(got, tt.want) {
+				t.Errorf("players sent to %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
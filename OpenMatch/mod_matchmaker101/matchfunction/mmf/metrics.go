@@ -0,0 +1,47 @@
+package mmf
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	// runs ProfileごとのRunの呼び出し数
+	runs = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mmf_runs_total",
+		Help: "Number of match function runs.",
+	}, []string{"profile"})
+
+	// proposalsPerRun Profileごとの1回のRunで作成したマッチ数
+	proposalsPerRun = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mmf_proposals_per_run",
+		Help:    "Number of match proposals generated per run.",
+		Buckets: []float64{0, 1, 2, 5, 10, 20, 50, 100},
+	}, []string{"profile"})
+
+	// poolSize Profileとチケットの種類(player/backfill)ごとの1回のRunで取得したプールのチケット数
+	poolSize = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "mmf_pool_tickets",
+		Help:    "Number of tickets in a pool per run.",
+		Buckets: []float64{0, 1, 2, 5, 10, 20, 50, 100, 200, 500},
+	}, []string{"profile", "kind"})
+
+	// proposalsByRelaxation Profileと緩和段階ごとの作成した新規マッチ数
+	proposalsByRelaxation = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "mmf_proposals_by_relaxation_total",
+		Help: "Number of new match proposals by relaxation level.",
+	}, []string{"profile", "level"})
+)
+
+// ServeMetrics exposes the Prometheus metrics of the match function on /metrics.
+func ServeMetrics(port int) {
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+	if err := http.ListenAndServe(fmt.Sprintf(":%d", port), mux); err != nil {
+		Logger.WithError(err).Errorf("Failed to serve metrics on port %v", port)
+	}
+}
@@ -0,0 +1,58 @@
+package mmf
+
+import (
+	"fmt"
+	"time"
+)
+
+// Params are the tunable parameters of MakeMatches.
+type Params struct {
+	// MinPlayers is the smallest new match made from player tickets.
+	MinPlayers int
+	// MaxPlayers is the largest new match made from player tickets.
+	MaxPlayers int
+	// RatingWindow is the largest difference of the "rating" search field between
+	// the players of a new match. 0 disables rating based matching.
+	RatingWindow float64
+	// BackfillFirst fills the seats of backfill tickets before making new matches
+	// instead of only with the players left over from them.
+	BackfillFirst bool
+	// OldestBackfillFirst fills the backfill tickets in the order their game servers
+	// started offering seats instead of the order of the query.
+	OldestBackfillFirst bool
+	// MaxBackfillShare is the largest fraction of the player tickets of a run sent to
+	// backfill, counting both the players sent before new matches are made and those
+	// left over from them. 1 puts no limit.
+	MaxBackfillShare float64
+	// MinRemainingTime skips backfill tickets whose session ends sooner than this.
+	// 0 sends players to any session that has not ended.
+	MinRemainingTime time.Duration
+}
+
+// DefaultParams are the parameters the match function has always used.
+var DefaultParams = Params{
+	MinPlayers:       2,
+	MaxPlayers:       4,
+	BackfillFirst:    true,
+	MaxBackfillShare: 1,
+}
+
+// Validate reports parameters MakeMatches cannot work with.
+func (p Params) Validate() error {
+	if p.MinPlayers < 1 {
+		return fmt.Errorf("min players must be at least 1, got %v", p.MinPlayers)
+	}
+	if p.MaxPlayers < p.MinPlayers {
+		return fmt.Errorf("max players must not be less than min players %v, got %v", p.MinPlayers, p.MaxPlayers)
+	}
+	if p.RatingWindow < 0 {
+		return fmt.Errorf("rating window must not be negative, got %v", p.RatingWindow)
+	}
+	if p.MaxBackfillShare < 0 || p.MaxBackfillShare > 1 {
+		return fmt.Errorf("max backfill share must be between 0 and 1, got %v", p.MaxBackfillShare)
+	}
+	if p.MinRemainingTime < 0 {
+		return fmt.Errorf("min remaining time must not be negative, got %v", p.MinRemainingTime)
+	}
+	return nil
+}
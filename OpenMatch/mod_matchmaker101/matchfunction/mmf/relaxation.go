@@ -0,0 +1,195 @@
+package mmf
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/any"
+	"open-match.dev/open-match/pkg/pb"
+
+	"common/relaxation"
+)
+
+const (
+	// relaxationExtension MatchProfileのExtensionsの緩和スケジュール(JSON)のキー。Directorが設定する
+	relaxationExtension = "relaxation"
+	// relaxationLevelExtension Matchに記録する緩和段階のExtensionsのキー
+	relaxationLevelExtension = "relaxation_level"
+	// regionArg PlayerTicketの地域のSearchFieldsのStringArgsのキー
+	regionArg = "region"
+)
+
+// RelaxationStep is a step of a relaxation schedule, shared with the director.
+type RelaxationStep = relaxation.Step
+
+// RelaxationSchedule is the relaxation steps of a profile in ascending order of
+// AfterSeconds, stored as JSON in the "relaxation" extension of the profile.
+type RelaxationSchedule = relaxation.Schedule
+
+// ProfileRelaxation reads the relaxation schedule of p, which is empty if p has none.
+func ProfileRelaxation(p *pb.MatchProfile) (RelaxationSchedule, error) {
+	var schedule RelaxationSchedule
+	ext, ok := p.GetExtensions()[relaxationExtension]
+	if !ok {
+		return schedule, nil
+	}
+	if err := json.Unmarshal(ext.GetValue(), &schedule); err != nil {
+		return nil, fmt.Errorf("invalid relaxation schedule of profile %v, got %w", p.GetName(), err)
+	}
+	if err := schedule.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid relaxation schedule of profile %v, got %w", p.GetName(), err)
+	}
+	return schedule, nil
+}
+
+// RelaxationLevel returns the relaxation level MakeMatches recorded on a new match.
+func RelaxationLevel(m *pb.Match) (int, bool) {
+	ext, ok := m.GetExtensions()[relaxationLevelExtension]
+	if !ok {
+		return 0, false
+	}
+	level, err := strconv.Atoi(string(ext.GetValue()))
+	return level, err == nil
+}
+
+// relaxedParams 緩和段階ごとのパラメータ
+type relaxedParams struct {
+	Params
+	crossRegion bool
+}
+
+// scheduleLevels 緩和段階ごとのパラメータ。0番目は緩和なしのparams
+// 各段階は前の段階の緩和を引き継ぐ
+func scheduleLevels(s RelaxationSchedule, params Params) []relaxedParams {
+	levels := []relaxedParams{{Params: params}}
+	for _, step := range s {
+		next := levels[len(levels)-1]
+		if step.MinPlayers > 0 {
+			next.MinPlayers = step.MinPlayers
+		}
+		if step.RatingWindow > 0 {
+			next.RatingWindow = step.RatingWindow
+		}
+		next.crossRegion = next.crossRegion || step.CrossRegion
+		levels = append(levels, next)
+	}
+	return levels
+}
+
+// scheduleLevel waitだけ待ったプレイヤーに適用する緩和段階
+func scheduleLevel(s RelaxationSchedule, wait time.Duration) int {
+	level := 0
+	for _, step := range s {
+		if wait.Seconds() < step.AfterSeconds {
+			break
+		}
+		level++
+	}
+	return level
+}
+
+// relaxedMatches 緩和なしでマッチに入らなかったPlayerTicketを、待ち時間の長いものから順に
+// そのチケットの待ち時間の緩和段階の条件でまとめ、緩和段階を記録したMatchと残ったPlayerTicketを返す
+func relaxedMatches(params Params, schedule RelaxationSchedule, now time.Time, p *pb.MatchProfile, playerTickets []*pb.Ticket) ([]*pb.Match, []*pb.Ticket) {
+	levels := scheduleLevels(schedule, params)
+	waiting := append([]*pb.Ticket{}, playerTickets...)
+	sort.SliceStable(waiting, func(i, j int) bool { return ticketWait(waiting[i], now) > ticketWait(waiting[j], now) })
+
+	var matches []*pb.Match
+	used := map[*pb.Ticket]bool{}
+	for _, head := range waiting {
+		if used[head] {
+			continue
+		}
+		level := scheduleLevel(schedule, ticketWait(head, now))
+		if level == 0 {
+			// 以降のチケットは待ち時間がもっと短い
+			break
+		}
+		group := relaxedGroup(levels[level], head, waiting, used)
+		if len(group) < levels[level].MinPlayers {
+			continue
+		}
+		for _, t := range group {
+			used[t] = true
+		}
+		match := newMatch(p, group)
+		setRelaxationLevel(match, level)
+		matches = append(matches, match)
+	}
+
+	var rest []*pb.Ticket
+	for _, t := range playerTickets {
+		if !used[t] {
+			rest = append(rest, t)
+		}
+	}
+	return matches, rest
+}
+
+// relaxedGroup headと、lpの条件で組めるまだ使われていないPlayerTicketを最大MaxPlayers枚まとめる
+// 同じ地域、レーティングの近い順、待ち時間の長い順に選ぶ
+func relaxedGroup(lp relaxedParams, head *pb.Ticket, waiting []*pb.Ticket, used map[*pb.Ticket]bool) []*pb.Ticket {
+	var candidates []*pb.Ticket
+	for _, t := range waiting {
+		if t == head || used[t] {
+			continue
+		}
+		if !lp.crossRegion && region(t) != region(head) {
+			continue
+		}
+		candidates = append(candidates, t)
+	}
+	distance := func(t *pb.Ticket) float64 {
+		if lp.RatingWindow <= 0 {
+			return 0
+		}
+		return math.Abs(rating(t) - rating(head))
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if si, sj := region(candidates[i]) == region(head), region(candidates[j]) == region(head); si != sj {
+			return si
+		}
+		return distance(candidates[i]) < distance(candidates[j])
+	})
+
+	group := []*pb.Ticket{head}
+	lo, hi := rating(head), rating(head)
+	for _, t := range candidates {
+		if len(group) >= lp.MaxPlayers {
+			break
+		}
+		if lp.RatingWindow > 0 && math.Max(hi, rating(t))-math.Min(lo, rating(t)) > lp.RatingWindow {
+			continue
+		}
+		lo, hi = math.Min(lo, rating(t)), math.Max(hi, rating(t))
+		group = append(group, t)
+	}
+	return group
+}
+
+// setRelaxationLevel Matchに緩和段階を記録する
+func setRelaxationLevel(m *pb.Match, level int) {
+	if m.Extensions == nil {
+		m.Extensions = map[string]*any.Any{}
+	}
+	m.Extensions[relaxationLevelExtension] = &any.Any{Value: []byte(strconv.Itoa(level))}
+}
+
+// ticketWait チケットがnowまでに待った時間。作成時刻がなければ0
+func ticketWait(t *pb.Ticket, now time.Time) time.Duration {
+	created, ok := t.GetSearchFields().GetDoubleArgs()[createdAtArg]
+	if !ok {
+		return 0
+	}
+	return now.Sub(unixTime(created))
+}
+
+// region PlayerTicketの地域(SearchFieldsのStringArgs["region"]、なければ空)
+func region(t *pb.Ticket) string {
+	return t.GetSearchFields().GetStringArgs()[regionArg]
+}
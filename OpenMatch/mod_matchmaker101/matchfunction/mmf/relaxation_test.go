@@ -0,0 +1,216 @@
+package mmf
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// waitingTicket nowまでにwait秒待っている、地域regionとレーティングratingのPlayerTicket
+func waitingTicket(id, region string, rating float64, wait float64, now time.Time) *pb.Ticket {
+	return &pb.Ticket{
+		Id: id,
+		SearchFields: &pb.SearchFields{
+			Tags:       []string{"mode.demo", "player"},
+			StringArgs: map[string]string{regionArg: region},
+			DoubleArgs: map[string]float64{
+				ratingArg:    rating,
+				createdAtArg: float64(now.UnixNano())/float64(time.Second) - wait,
+			},
+		},
+	}
+}
+
+// idsOf チケットのIDの一覧
+func idsOf(tickets []*pb.Ticket) []string {
+	var ids []string
+	for _, t := range tickets {
+		ids = append(ids, t.GetId())
+	}
+	return ids
+}
+
+func TestScheduleLevel(t *testing.T) {
+	schedule := RelaxationSchedule{{AfterSeconds: 10}, {AfterSeconds: 30}, {AfterSeconds: 60}}
+	tests := []struct {
+		wait time.Duration
+		want int
+	}{
+		{wait: 0, want: 0},
+		{wait: 9 * time.Second, want: 0},
+		{wait: 10 * time.Second, want: 1},
+		{wait: 29 * time.Second, want: 1},
+		{wait: 30 * time.Second, want: 2},
+		{wait: 60 * time.Second, want: 3},
+		{wait: time.Hour, want: 3},
+	}
+	for _, tt := range tests {
+		if got := scheduleLevel(schedule, tt.wait); got != tt.want {
+			t.Errorf("scheduleLevel(%v) = %v, want %v", tt.wait, got, tt.want)
+		}
+	}
+}
+
+func TestScheduleLevels(t *testing.T) {
+	params := DefaultParams
+	params.RatingWindow = 100
+	schedule := RelaxationSchedule{
+		{AfterSeconds: 10, RatingWindow: 300},
+		{AfterSeconds: 30, CrossRegion: true},
+		{AfterSeconds: 60, MinPlayers: 1},
+	}
+	levels := scheduleLevels(schedule, params)
+	if len(levels) != len(schedule)+1 {
+		t.Fatalf("got %v levels, want %v", len(levels), len(schedule)+1)
+	}
+	// 各段階は前の段階の緩和を引き継ぎ、0の項目は変えない
+	want := []relaxedParams{
+		{Params: params},
+		{Params: withParams(params, params.MinPlayers, 300)},
+		{Params: withParams(params, params.MinPlayers, 300), crossRegion: true},
+		{Params: withParams(params, 1, 300), crossRegion: true},
+	}
+	for i := range want {
+		if levels[i] != want[i] {
+			t.Errorf("level %v = %+v, want %+v", i, levels[i], want[i])
+		}
+	}
+}
+
+// withParams MinPlayersとRatingWindowを置き換えたparams
+func withParams(params Params, minPlayers int, ratingWindow float64) Params {
+	params.MinPlayers = minPlayers
+	params.RatingWindow = ratingWindow
+	return params
+}
+
+func TestRelaxedMatches(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	params := DefaultParams
+	params.MinPlayers = 4
+	schedule := RelaxationSchedule{
+		{AfterSeconds: 10, MinPlayers: 2},
+		{AfterSeconds: 30, CrossRegion: true},
+	}
+	tests := []struct {
+		name    string
+		tickets []*pb.Ticket
+		// want 緩和したマッチごとのチケットのIDと緩和段階
+		want      [][]string
+		wantLevel []int
+		wantRest  []string
+	}{
+		{
+			name: "nobody has waited long enough",
+			tickets: []*pb.Ticket{
+				waitingTicket("a", "asia", 0, 9, now),
+				waitingTicket("b", "asia", 0, 5, now),
+			},
+			wantRest: []string{"a", "b"},
+		},
+		{
+			name: "level 1 matches fewer players of the same region",
+			tickets: []*pb.Ticket{
+				waitingTicket("young", "asia", 0, 1, now),
+				waitingTicket("eu", "eu", 0, 12, now),
+				waitingTicket("old", "asia", 0, 15, now),
+			},
+			want:      [][]string{{"old", "young"}},
+			wantLevel: []int{1},
+			wantRest:  []string{"eu"},
+		},
+		{
+			name: "level 2 matches across regions",
+			tickets: []*pb.Ticket{
+				waitingTicket("eu", "eu", 0, 1, now),
+				waitingTicket("old", "asia", 0, 40, now),
+			},
+			want:      [][]string{{"old", "eu"}},
+			wantLevel: []int{2},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matches, rest := relaxedMatches(params, schedule, now, &pb.MatchProfile{Name: "mode.demo"}, tt.tickets)
+			if len(matches) != len(tt.want) {
+				t.Fatalf("got %v matches, want %v", len(matches), len(tt.want))
+			}
+			for i, m := range matches {
+				if got := idsOf(m.GetTickets()); !equalIDs(got, tt.want[i]) {
+					t.Errorf("match %v tickets = %v, want %v", i, got, tt.want[i])
+				}
+				if level, ok := RelaxationLevel(m); !ok || level != tt.wantLevel[i] {
+					t.Errorf("match %v relaxation level = %v, %v, want %v", i, level, ok, tt.wantLevel[i])
+				}
+			}
+			if got := idsOf(rest); !equalIDs(got, tt.wantRest) {
+				t.Errorf("rest = %v, want %v", got, tt.wantRest)
+			}
+		})
+	}
+}
+
+// equalIDs 同じIDが同じ順に並んでいるか
+func equalIDs(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestRelaxedGroupStaysWithinBounds(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	head := waitingTicket("head", "asia", 1000, 60, now)
+	used := waitingTicket("used", "asia", 1000, 50, now)
+	waiting := []*pb.Ticket{
+		head,
+		used,
+		waitingTicket("r1100", "asia", 1100, 40, now),
+		waitingTicket("r1250", "asia", 1250, 30, now),
+		waitingTicket("r900", "asia", 900, 20, now),
+		waitingTicket("r1400", "asia", 1400, 10, now),
+		waitingTicket("eu", "eu", 1000, 5, now),
+	}
+	tests := []struct {
+		name string
+		lp   relaxedParams
+		want []string
+	}{
+		// レーティングの近い順に、全体の差がRatingWindow以内のチケットだけを加える
+		{name: "rating window", lp: relaxedParams{Params: Params{MinPlayers: 2, MaxPlayers: 5, RatingWindow: 300}}, want: []string{"head", "r1100", "r900"}},
+		{name: "max players", lp: relaxedParams{Params: Params{MinPlayers: 2, MaxPlayers: 2, RatingWindow: 300}}, want: []string{"head", "r1100"}},
+		// 地域をまたぐ場合も同じ地域のチケットを先に選ぶ
+		{name: "cross region", lp: relaxedParams{Params: Params{MinPlayers: 2, MaxPlayers: 6}, crossRegion: true}, want: []string{"head", "r1100", "r1250", "r900", "r1400", "eu"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			group := relaxedGroup(tt.lp, head, waiting, map[*pb.Ticket]bool{used: true})
+			if got := idsOf(group); !equalIDs(got, tt.want) {
+				t.Errorf("relaxedGroup() = %v, want %v", got, tt.want)
+			}
+			if len(group) > tt.lp.MaxPlayers {
+				t.Errorf("group has %v players, more than %v", len(group), tt.lp.MaxPlayers)
+			}
+			lo, hi := math.Inf(1), math.Inf(-1)
+			for _, ticket := range group {
+				if ticket == used {
+					t.Errorf("group has the used ticket")
+				}
+				if !tt.lp.crossRegion && region(ticket) != region(head) {
+					t.Errorf("ticket %v of region %v is in the group of region %v", ticket.GetId(), region(ticket), region(head))
+				}
+				lo, hi = math.Min(lo, rating(ticket)), math.Max(hi, rating(ticket))
+			}
+			if tt.lp.RatingWindow > 0 && hi-lo > tt.lp.RatingWindow {
+				t.Errorf("ratings of the group span %v, more than %v", hi-lo, tt.lp.RatingWindow)
+			}
+		})
+	}
+}
@@ -2,7 +2,6 @@ package mmf
 
 import (
 	"fmt"
-	"log"
 	"net"
 
 	"google.golang.org/grpc"
@@ -15,21 +14,23 @@ type MatchFunctionService struct {
 	grpc               *grpc.Server
 	queryServiceClient pb.QueryServiceClient
 	port               int
+	params             Params
 }
 
 // Start creates and starts the Match Function server and also connects to Open
 // Match's queryService service. This connection is used at runtime to fetch tickets
-// for pools specified in MatchProfile.
-func Start(queryServiceAddr string, serverPort int) {
+// for pools specified in MatchProfile. Matches are made under params.
+func Start(queryServiceAddr string, serverPort int, params Params) {
 	// Connect to QueryService.
 	conn, err := grpc.Dial(queryServiceAddr, grpc.WithInsecure())
 	if err != nil {
-		log.Fatalf("Failed to connect to Open Match, got %s", err.Error())
+		Logger.WithError(err).Fatal("Failed to connect to Open Match")
 	}
 	defer conn.Close()
 
 	mmfService := MatchFunctionService{
 		queryServiceClient: pb.NewQueryServiceClient(conn),
+		params:             params,
 	}
 
 	// Create and host a new gRPC service on the configured port.
@@ -37,12 +38,12 @@ func Start(queryServiceAddr string, serverPort int) {
 	pb.RegisterMatchFunctionServer(server, &mmfService)
 	ln, err := net.Listen("tcp", fmt.Sprintf(":%d", serverPort))
 	if err != nil {
-		log.Fatalf("TCP net listener initialization failed for port %v, got %s", serverPort, err.Error())
+		Logger.WithError(err).Fatalf("TCP net listener initialization failed for port %v", serverPort)
 	}
 
-	log.Printf("TCP net listener initialized for port %v", serverPort)
+	Logger.Infof("TCP net listener initialized for port %v", serverPort)
 	err = server.Serve(ln)
 	if err != nil {
-		log.Fatalf("gRPC serve failed, got %s", err.Error())
+		Logger.WithError(err).Fatal("gRPC serve failed")
 	}
 }
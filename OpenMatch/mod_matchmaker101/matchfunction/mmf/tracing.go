@@ -0,0 +1,92 @@
+package mmf
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/golang/protobuf/ptypes/any"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.12.0"
+	"go.opentelemetry.io/otel/trace"
+	"open-match.dev/open-match/pkg/pb"
+)
+
+// tracer MMFのspanを作るTracer
+var tracer = otel.Tracer("matchfunction")
+
+// SetupTracing configures where spans are exported from OTEL_TRACES_EXPORTER and returns
+// a function flushing them on shutdown. "otlp" sends them to the collector at
+// OTEL_EXPORTER_OTLP_ENDPOINT (localhost:4317 by default), "stdout" prints them and
+// "none" or unset only propagates the trace context.
+func SetupTracing(ctx context.Context, service string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	var err error
+	switch name := os.Getenv("OTEL_TRACES_EXPORTER"); name {
+	case "", "none":
+		return func(context.Context) error { return nil }, nil
+	case "otlp":
+		// The collector is expected inside the cluster, so TLS is not used.
+		exporter, err = otlptracegrpc.New(ctx, otlptracegrpc.WithInsecure())
+	case "stdout":
+		exporter, err = stdouttrace.New()
+	default:
+		return nil, fmt.Errorf("unknown OTEL_TRACES_EXPORTER %q, must be otlp, stdout or none", name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create the %v trace exporter, got %w", os.Getenv("OTEL_TRACES_EXPORTER"), err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(service))),
+	)
+	otel.SetTracerProvider(tp)
+	return tp.Shutdown, nil
+}
+
+// ticketSpanContext Frontendがチケットを作成したときのspan(チケットのExtensionsに保存されている)
+func ticketSpanContext(ticket *pb.Ticket) trace.SpanContext {
+	carrier := propagation.MapCarrier{}
+	for _, field := range otel.GetTextMapPropagator().Fields() {
+		if v, ok := ticket.GetExtensions()[field]; ok {
+			carrier[field] = string(v.GetValue())
+		}
+	}
+	return trace.SpanContextFromContext(otel.GetTextMapPropagator().Extract(context.Background(), carrier))
+}
+
+// traceProposal proposalを作成したことをチケットのspanにlinkしたspanとして記録し、
+// DirectorがマッチのspanからlinkできるようにそのトレースコンテキストをマッチのExtensionsに保存する
+func traceProposal(ctx context.Context, match *pb.Match) {
+	links := []trace.Link{}
+	for _, t := range match.GetTickets() {
+		if sc := ticketSpanContext(t); sc.IsValid() {
+			links = append(links, trace.Link{SpanContext: sc})
+		}
+	}
+	ctx, span := tracer.Start(ctx, "MakeMatch",
+		trace.WithLinks(links...),
+		trace.WithAttributes(
+			attribute.String("match.id", match.GetMatchId()),
+			attribute.Int("match.tickets", len(match.GetTickets())),
+		))
+	defer span.End()
+
+	carrier := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, carrier)
+	if match.Extensions == nil {
+		match.Extensions = map[string]*any.Any{}
+	}
+	for k, v := range carrier {
+		match.Extensions[k] = &any.Any{Value: []byte(v)}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// arrival 1人のプレイヤーの到着。チケットのSearchFieldsになる属性を持つ
+type arrival struct {
+	at   time.Duration
+	mode string
+	// doubleArgs, stringArgs SearchFieldsのDoubleArgsとStringArgs
+	doubleArgs map[string]float64
+	stringArgs map[string]string
+}
+
+// readArrivals 記録した到着をCSVから読み、到着順に並べて返す
+// 1行目はヘッダーで、arrival(開始からの秒数)とmodeの列が必須
+// 他の列は数値ならDoubleArgs、それ以外ならStringArgsとして、列名をキーにしてチケットに付ける(空欄は付けない)
+//
+//	arrival,mode,rating,region
+//	0.4,mode.demo,1520,asia
+func readArrivals(path string, duration time.Duration) ([]arrival, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open arrivals, got %w", err)
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the header of %v, got %w", path, err)
+	}
+	atCol, modeCol := -1, -1
+	for i, name := range header {
+		switch name {
+		case "arrival":
+			atCol = i
+		case "mode":
+			modeCol = i
+		}
+	}
+	if atCol < 0 || modeCol < 0 {
+		return nil, fmt.Errorf("header of %v must have arrival and mode columns, got %v", path, header)
+	}
+
+	var arrivals []arrival
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %v, got %w", path, err)
+		}
+		seconds, err := strconv.ParseFloat(record[atCol], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid arrival %q at line %v of %v, got %w", record[atCol], line, path, err)
+		}
+		a := arrival{
+			at:         time.Duration(seconds * float64(time.Second)),
+			mode:       record[modeCol],
+			doubleArgs: map[string]float64{},
+			stringArgs: map[string]string{},
+		}
+		if a.at > duration {
+			continue
+		}
+		for i, value := range record {
+			if i == atCol || i == modeCol || value == "" {
+				continue
+			}
+			if v, err := strconv.ParseFloat(value, 64); err == nil {
+				a.doubleArgs[header[i]] = v
+			} else {
+				a.stringArgs[header[i]] = value
+			}
+		}
+		arrivals = append(arrivals, a)
+	}
+	sort.SliceStable(arrivals, func(i, j int) bool { return arrivals[i].at < arrivals[j].at })
+	return arrivals, nil
+}
+
+// syntheticArrivals ArrivalRateのポアソン到着をDurationの間生成する
+// ゲームモードと地域は一様に選び、RatingStddevが0より大きければ正規分布のレーティングを付ける
+func syntheticArrivals(rnd *rand.Rand) []arrival {
+	var arrivals []arrival
+	at := time.Duration(0)
+	for {
+		at += time.Duration(rnd.ExpFloat64() / conf.ArrivalRate * float64(time.Second))
+		if at > conf.Duration {
+			return arrivals
+		}
+		a := arrival{
+			at:         at,
+			mode:       conf.GameModes[rnd.Intn(len(conf.GameModes))],
+			doubleArgs: map[string]float64{},
+			stringArgs: map[string]string{},
+		}
+		if len(conf.Regions) > 0 {
+			a.stringArgs["region"] = conf.Regions[rnd.Intn(len(conf.Regions))]
+		}
+		if conf.RatingStddev > 0 {
+			a.doubleArgs["rating"] = conf.RatingMean + rnd.NormFloat64()*conf.RatingStddev
+		}
+		arrivals = append(arrivals, a)
+	}
+}
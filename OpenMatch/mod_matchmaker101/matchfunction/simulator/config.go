@@ -0,0 +1,151 @@
+package main
+
+import (
+	"strings"
+	"time"
+
+	"common/config"
+	"matchfunction/mmf"
+)
+
+// simConfig シミュレーションする到着、GameServer、出力の設定と、比較するMakeMatchesのパラメータ
+type simConfig struct {
+	// ArrivalsFile 記録した到着のCSV。空なら到着レートから合成する
+	ArrivalsFile string
+	// Duration 到着させる時間。合成した到着と、記録した到着のどちらもこの時間で打ち切る
+	Duration time.Duration
+	// ArrivalRate 合成する到着の1秒あたりの平均人数(ポアソン到着)
+	ArrivalRate float64
+	// GameModes 合成するプレイヤーが無作為に選ぶゲームモード(カンマ区切り)
+	GameModes []string
+	// Regions 合成するプレイヤーが無作為に選ぶ地域(カンマ区切り)。空なら地域を付けない
+	Regions []string
+	// RatingMean, RatingStddev 合成するプレイヤーのレーティングの正規分布。標準偏差が0ならレーティングを付けない
+	RatingMean   float64
+	RatingStddev float64
+	// Tick Match Functionを実行する間隔。DirectorがマッチをFetchする間隔に合わせる
+	Tick time.Duration
+	// StayMin, StayMax GameServerに接続してから離脱するまでの時間の範囲。この範囲から一様に選ぶ
+	StayMin time.Duration
+	StayMax time.Duration
+	// ServerCapacity GameServer 1台の定員
+	ServerCapacity int
+	// MaxSessionDuration セッションの長さの上限。0なら上限なし。GameServerのMAX_SESSIONに合わせる
+	MaxSessionDuration time.Duration
+	// MaxWait プレイヤーがマッチングを諦めるまでの時間。0なら諦めない
+	MaxWait time.Duration
+	// Seed 到着と滞在時間の乱数のシード
+	Seed int64
+	// Format 結果の形式(jsonかcsv)
+	Format string
+	// Out 結果の出力先。空なら標準出力。CSVで既にあるファイルなら行を追記する
+	Out string
+	// MatchesFile マッチごとの記録のCSVの出力先。空なら出力しない
+	MatchesFile string
+	// Params 比較するMakeMatchesのパラメータ
+	Params mmf.Params
+	// Relaxation 全てのゲームモードのProfileに設定する緩和スケジュール
+	Relaxation mmf.RelaxationSchedule
+}
+
+// conf simulatorの設定。mainの最初で読み込む
+var conf simConfig
+
+// loadConfig 設定を既定値、設定ファイル、フラグ、環境変数の順に読み込んで検証する
+func loadConfig(args []string) (simConfig, error) {
+	var c simConfig
+	var modes, regions string
+	var seed int
+	l := config.NewLoader()
+	l.StringVar(&c.ArrivalsFile, "arrivals", "ARRIVALS_FILE", "", "CSV of recorded arrivals (arrival seconds, mode, attributes), synthetic arrivals if empty")
+	l.DurationVar(&c.Duration, "duration", "DURATION", 10*time.Minute, "Simulated time during which players arrive")
+	l.FloatVar(&c.ArrivalRate, "rate", "ARRIVAL_RATE", 1, "Mean players arriving per second for synthetic arrivals")
+	l.StringVar(&modes, "game-modes", "GAME_MODES", "mode.demo", "Comma separated game modes synthetic players choose from at random")
+	l.StringVar(&regions, "regions", "REGIONS", "", "Comma separated regions synthetic players choose from at random, none if empty")
+	l.FloatVar(&c.RatingMean, "rating-mean", "RATING_MEAN", 1500, "Mean rating of synthetic players")
+	l.FloatVar(&c.RatingStddev, "rating-stddev", "RATING_STDDEV", 0, "Standard deviation of the rating of synthetic players, 0 gives no rating")
+	l.DurationVar(&c.Tick, "tick", "TICK", time.Second, "Interval at which the match function runs")
+	l.DurationVar(&c.StayMin, "stay-min", "STAY_MIN", 2*time.Minute, "Minimum time a player stays on the game server")
+	l.DurationVar(&c.StayMax, "stay-max", "STAY_MAX", 10*time.Minute, "Maximum time a player stays on the game server")
+	l.IntVar(&c.ServerCapacity, "server-capacity", "SERVER_CAPACITY", 4, "Capacity of one game server")
+	l.DurationVar(&c.MaxSessionDuration, "max-session-duration", "MAX_SESSION_DURATION", 0, "Sessions end after this long even with players, 0 has no limit")
+	l.DurationVar(&c.MaxWait, "max-wait", "MAX_WAIT", 0, "Time a player waits for a match before giving up, 0 waits forever")
+	l.IntVar(&seed, "seed", "SEED", 1, "Random seed")
+	l.StringVar(&c.Format, "format", "FORMAT", "json", "Output format, json or csv")
+	l.StringVar(&c.Out, "out", "OUT_FILE", "", "File to write the result to, stdout if empty. A csv row is appended to an existing file")
+	l.StringVar(&c.MatchesFile, "matches", "MATCHES_FILE", "", "CSV file to write every match to, none if empty")
+	l.JSONVar(&c.Relaxation, "relaxation", "RELAXATION", `Relaxation schedule of every profile as JSON, e.g. [{"after_seconds":30,"min_players":1}]`)
+	paramsVars(l, &c.Params)
+	if err := l.Load(args); err != nil {
+		return c, err
+	}
+	for _, region := range strings.Split(regions, ",") {
+		if region = strings.TrimSpace(region); region != "" {
+			c.Regions = append(c.Regions, region)
+		}
+	}
+	for _, mode := range strings.Split(modes, ",") {
+		if mode = strings.TrimSpace(mode); mode != "" {
+			c.GameModes = append(c.GameModes, mode)
+		}
+	}
+	c.Seed = int64(seed)
+	return c, c.validate()
+}
+
+// validate 設定の誤りをまとめて返す
+func (c simConfig) validate() error {
+	var errs config.Errors
+	errs.Positive("duration", c.Duration)
+	if c.ArrivalsFile == "" {
+		if c.ArrivalRate <= 0 {
+			errs.Add("rate must be positive, got %v", c.ArrivalRate)
+		}
+		if len(c.GameModes) == 0 {
+			errs.Add("game-modes must not be empty")
+		}
+	}
+	if c.RatingStddev < 0 {
+		errs.Add("rating-stddev must not be negative, got %v", c.RatingStddev)
+	}
+	errs.Positive("tick", c.Tick)
+	errs.Positive("stay-min", c.StayMin)
+	if c.StayMax < c.StayMin {
+		errs.Add("stay-max must not be shorter than stay-min, got %v < %v", c.StayMax, c.StayMin)
+	}
+	if c.MaxSessionDuration < 0 {
+		errs.Add("max-session-duration must not be negative, got %v", c.MaxSessionDuration)
+	}
+	if c.MaxWait < 0 {
+		errs.Add("max-wait must not be negative, got %v", c.MaxWait)
+	}
+	if c.Format != "json" && c.Format != "csv" {
+		errs.Add("format must be json or csv, got %q", c.Format)
+	}
+	if err := c.Params.Validate(); err != nil {
+		errs.Add("%v", err)
+	}
+	if err := c.Relaxation.Validate(); err != nil {
+		errs.Add("relaxation: %v", err)
+	}
+	for _, step := range c.Relaxation {
+		if step.MinPlayers > c.Params.MaxPlayers {
+			errs.Add("min_players of relaxation must not exceed max-players %v, got %v", c.Params.MaxPlayers, step.MinPlayers)
+		}
+	}
+	if c.Params.MaxPlayers > c.ServerCapacity {
+		errs.Add("max-players must not exceed server-capacity %v, got %v", c.ServerCapacity, c.Params.MaxPlayers)
+	}
+	return errs.Err()
+}
+
+// paramsVars MakeMatchesのパラメータのフラグを定義する。Match Functionと同じフラグ名にする
+func paramsVars(l *config.Loader, p *mmf.Params) {
+	l.IntVar(&p.MinPlayers, "min-players", "MIN_PLAYERS", mmf.DefaultParams.MinPlayers, "Smallest new match")
+	l.IntVar(&p.MaxPlayers, "max-players", "MAX_PLAYERS", mmf.DefaultParams.MaxPlayers, "Largest new match")
+	l.FloatVar(&p.RatingWindow, "rating-window", "RATING_WINDOW", mmf.DefaultParams.RatingWindow, "Largest rating difference within a new match, 0 disables it")
+	l.BoolVar(&p.BackfillFirst, "backfill-first", "BACKFILL_FIRST", mmf.DefaultParams.BackfillFirst, "Fill backfill tickets before making new matches")
+	l.BoolVar(&p.OldestBackfillFirst, "oldest-backfill-first", "OLDEST_BACKFILL_FIRST", mmf.DefaultParams.OldestBackfillFirst, "Fill the backfill tickets that have offered seats the longest first")
+	l.FloatVar(&p.MaxBackfillShare, "max-backfill-share", "MAX_BACKFILL_SHARE", mmf.DefaultParams.MaxBackfillShare, "Largest fraction of the players of a run sent to backfill")
+	l.DurationVar(&p.MinRemainingTime, "min-remaining-time", "MIN_REMAINING_TIME", mmf.DefaultParams.MinRemainingTime, "Do not send players to sessions ending sooner than this")
+}
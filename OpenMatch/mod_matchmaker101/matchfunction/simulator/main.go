@@ -0,0 +1,71 @@
+// Package main はMatch FunctionのMakeMatchesをクラスタなしで実行し、パラメータを比較するシミュレーター
+//
+// 記録したチケットの到着(CSV)か、到着レートから合成したポアソン到着を、Tickごとの離散時間でMakeMatchesに通す
+// マッチしたプレイヤーはGameServerのセッションに入り、滞在時間が過ぎると離脱する
+// 空席のあるセッションはGameServerと同じ形のBackfillTicketを出すので、Backfillの効果も含めて比較できる
+// 待ち時間の分布、マッチの人数とレーティングの幅、Backfillの利用をJSONかCSVで出力する
+//
+//	cd OpenMatch/mod_matchmaker101/matchfunction
+//	go run ./simulator -rate 0.5 -rating-stddev 200 -rating-window 100
+//	go run ./simulator -format csv -out results.csv -min-players 4 -backfill-first=false
+//	go run ./simulator -regions asia,us -relaxation '[{"after_seconds":30,"cross_region":true}]'
+//
+// パラメータのフラグ名と環境変数はMatch Functionと同じなので、比較して決めた値をそのまま設定できる
+// 緩和スケジュール(-relaxation)はDirectorのprofile-relaxationsの1モード分と同じJSONで、全てのゲームモードに適用する
+// Match Functionを実行する間隔(-tick)はDirectorがマッチをFetchする間隔に合わせる
+package main
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+
+	"github.com/sirupsen/logrus"
+
+	"common/logging"
+)
+
+func main() {
+	var err error
+	if conf, err = loadConfig(os.Args[1:]); err != nil {
+		logger.WithError(err).Fatal("Failed to load the config")
+	}
+
+	rnd := rand.New(rand.NewSource(conf.Seed))
+	var arrivals []arrival
+	if conf.ArrivalsFile != "" {
+		arrivals, err = readArrivals(conf.ArrivalsFile, conf.Duration)
+		if err != nil {
+			logger.WithError(err).Fatal("Failed to read the arrivals")
+		}
+	} else {
+		arrivals = syntheticArrivals(rnd)
+	}
+
+	logger.WithFields(logrus.Fields{"params": conf.Params, "seed": conf.Seed}).Infof("Simulating %v arrivals over %v", len(arrivals), conf.Duration)
+	var relaxation []byte
+	if len(conf.Relaxation) > 0 {
+		if relaxation, err = json.Marshal(conf.Relaxation); err != nil {
+			logger.WithError(err).Fatal("Failed to encode the relaxation schedule")
+		}
+	}
+	s := newSimulator(conf.Params, relaxation)
+	if err := s.run(arrivals, rnd, conf.Duration); err != nil {
+		logger.WithError(err).Fatal("Failed to simulate")
+	}
+
+	r := newReport(s)
+	for mode, wait := range r.WaitByMode {
+		logger.WithField(logging.FieldMode, mode).Infof("wait p50=%.1fs p90=%.1fs p99=%.1fs", wait.P50, wait.P90, wait.P99)
+	}
+	logger.Infof("%v matched, %v abandoned, %v unmatched, %v new matches (%v relaxed), backfill share %.2f",
+		r.Matched, r.Abandoned, r.Unmatched, r.NewMatches, r.RelaxedMatches, r.BackfillShare)
+	if err := r.write(conf.Format, conf.Out); err != nil {
+		logger.WithError(err).Fatal("Failed to write the result")
+	}
+	if conf.MatchesFile != "" {
+		if err := writeMatches(conf.MatchesFile, s.matches); err != nil {
+			logger.WithError(err).Fatal("Failed to write the matches")
+		}
+	}
+}
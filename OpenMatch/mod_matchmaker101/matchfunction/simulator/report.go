@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+
+	"matchfunction/mmf"
+)
+
+// report シミュレーションの結果。パラメータごとに比較できるようにJSONかCSVで出力する
+type report struct {
+	Params mmf.Params `json:"params"`
+	// Players 到着したプレイヤー数
+	Players int `json:"players"`
+	Matched int `json:"matched"`
+	// Abandoned MaxWaitより長く待ってマッチングを諦めたプレイヤー数
+	Abandoned int `json:"abandoned"`
+	// Unmatched Durationの時点でまだ待っていたプレイヤー数
+	Unmatched int `json:"unmatched"`
+	// Wait 到着してからマッチするまでの秒数(マッチしたプレイヤーのみ)
+	Wait percentiles `json:"wait_seconds"`
+	// WaitByMode ゲームモードごとのWait
+	WaitByMode map[string]percentiles `json:"wait_seconds_by_mode"`
+	// NewMatches 新しいセッションを始めたマッチの数
+	NewMatches int `json:"new_matches"`
+	// MeanNewMatchSize 新しいマッチの平均人数
+	MeanNewMatchSize float64 `json:"mean_new_match_size"`
+	// MatchesByRelaxation 新しいマッチの緩和段階ごとの数
+	MatchesByRelaxation map[string]int `json:"new_matches_by_relaxation"`
+	// RelaxedMatches 条件を緩和した(緩和段階が1以上の)新しいマッチの数
+	RelaxedMatches int `json:"relaxed_matches"`
+	// CrossRegionMatches 異なる地域のプレイヤーを含む新しいマッチの数
+	CrossRegionMatches int `json:"cross_region_matches"`
+	// FullMatchFraction 新しいマッチのうち定員まで埋まっていた割合
+	FullMatchFraction float64 `json:"full_match_fraction"`
+	// RatingSpread マッチした後のセッションのレーティングの最大と最小の差(全員にレーティングがあるマッチのみ)
+	RatingSpread percentiles `json:"rating_spread"`
+	// BackfillMatches 既存のセッションの空席を埋めたマッチの数
+	BackfillMatches int `json:"backfill_matches"`
+	// BackfillPlayers Backfillで既存のセッションに割り当てたプレイヤー数
+	BackfillPlayers int `json:"backfill_players"`
+	// BackfillShare マッチしたプレイヤーのうちBackfillで割り当てた割合
+	BackfillShare float64 `json:"backfill_share"`
+	// BackfillSeatWait Backfillのマッチで、セッションが空席の募集を始めてから埋まるまでの秒数
+	BackfillSeatWait percentiles `json:"backfill_seat_wait_seconds"`
+	// BackfillRemaining Backfillのマッチで、セッションが終わるまでの秒数(終了時刻のあるセッションのみ)
+	BackfillRemaining percentiles `json:"backfill_remaining_seconds"`
+	// GameServers 始まったセッションの数
+	GameServers int `json:"game_servers"`
+	// SeatOccupancy 進行中のセッションの定員のうち埋まっていた割合(Tickごとの平均)
+	SeatOccupancy float64 `json:"seat_occupancy"`
+}
+
+// percentiles 値の分布
+type percentiles struct {
+	Count int     `json:"count"`
+	Mean  float64 `json:"mean"`
+	P50   float64 `json:"p50"`
+	P90   float64 `json:"p90"`
+	P99   float64 `json:"p99"`
+	Max   float64 `json:"max"`
+}
+
+// newReport シミュレーションの終わったsimulatorから結果をまとめる
+func newReport(s *simulator) report {
+	r := report{
+		Params:              s.params,
+		Players:             len(s.players),
+		WaitByMode:          map[string]percentiles{},
+		MatchesByRelaxation: map[string]int{},
+		GameServers:         s.sessionNum,
+	}
+	if s.offeredSeats > 0 {
+		r.SeatOccupancy = float64(s.occupiedSeats) / float64(s.offeredSeats)
+	}
+
+	var waits []float64
+	byMode := map[string][]float64{}
+	for _, p := range s.players {
+		switch {
+		case p.matched:
+			r.Matched++
+			wait := (p.matchedAt - p.at).Seconds()
+			waits = append(waits, wait)
+			byMode[p.mode] = append(byMode[p.mode], wait)
+			if p.backfill {
+				r.BackfillPlayers++
+			}
+		case p.abandoned:
+			r.Abandoned++
+		default:
+			r.Unmatched++
+		}
+	}
+	r.Wait = newPercentiles(waits)
+	for mode, w := range byMode {
+		r.WaitByMode[mode] = newPercentiles(w)
+	}
+	if r.Matched > 0 {
+		r.BackfillShare = float64(r.BackfillPlayers) / float64(r.Matched)
+	}
+
+	var spreads, seatWaits, remainings []float64
+	full, newPlayers := 0, 0
+	for _, m := range s.matches {
+		if !math.IsNaN(m.ratingSpread) {
+			spreads = append(spreads, m.ratingSpread)
+		}
+		if m.backfill {
+			r.BackfillMatches++
+			seatWaits = append(seatWaits, m.seatWait.Seconds())
+			if m.remaining >= 0 {
+				remainings = append(remainings, m.remaining.Seconds())
+			}
+			continue
+		}
+		r.NewMatches++
+		newPlayers += m.players
+		r.MatchesByRelaxation[strconv.Itoa(m.level)]++
+		if m.level > 0 {
+			r.RelaxedMatches++
+		}
+		if m.crossRegion {
+			r.CrossRegionMatches++
+		}
+		if m.players >= s.capacity {
+			full++
+		}
+	}
+	r.RatingSpread = newPercentiles(spreads)
+	r.BackfillSeatWait = newPercentiles(seatWaits)
+	r.BackfillRemaining = newPercentiles(remainings)
+	if r.NewMatches > 0 {
+		r.MeanNewMatchSize = float64(newPlayers) / float64(r.NewMatches)
+		r.FullMatchFraction = float64(full) / float64(r.NewMatches)
+	}
+	return r
+}
+
+func newPercentiles(values []float64) percentiles {
+	if len(values) == 0 {
+		return percentiles{}
+	}
+	sort.Float64s(values)
+	sum := 0.0
+	for _, v := range values {
+		sum += v
+	}
+	return percentiles{
+		Count: len(values),
+		Mean:  sum / float64(len(values)),
+		P50:   percentile(values, 0.50),
+		P90:   percentile(values, 0.90),
+		P99:   percentile(values, 0.99),
+		Max:   values[len(values)-1],
+	}
+}
+
+// percentile ソート済みのvaluesのp分位点(nearest-rank法)
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+// csvHeader 結果のCSVの列。ゲームモードごとの待ち時間はJSONでのみ出力する
+var csvHeader = []string{
+	"min_players", "max_players", "rating_window", "backfill_first", "oldest_backfill_first", "max_backfill_share", "min_remaining_time",
+	"players", "matched", "abandoned", "unmatched",
+	"wait_mean", "wait_p50", "wait_p90", "wait_p99", "wait_max",
+	"new_matches", "mean_new_match_size", "full_match_fraction", "relaxed_matches", "cross_region_matches", "rating_spread_mean", "rating_spread_p90",
+	"backfill_matches", "backfill_players", "backfill_share", "backfill_seat_wait_mean", "backfill_seat_wait_p90", "backfill_remaining_mean",
+	"game_servers", "seat_occupancy",
+}
+
+func (r report) csvRecord() []string {
+	return []string{
+		strconv.Itoa(r.Params.MinPlayers), strconv.Itoa(r.Params.MaxPlayers), formatFloat(r.Params.RatingWindow), strconv.FormatBool(r.Params.BackfillFirst),
+		strconv.FormatBool(r.Params.OldestBackfillFirst), formatFloat(r.Params.MaxBackfillShare), formatFloat(r.Params.MinRemainingTime.Seconds()),
+		strconv.Itoa(r.Players), strconv.Itoa(r.Matched), strconv.Itoa(r.Abandoned), strconv.Itoa(r.Unmatched),
+		formatFloat(r.Wait.Mean), formatFloat(r.Wait.P50), formatFloat(r.Wait.P90), formatFloat(r.Wait.P99), formatFloat(r.Wait.Max),
+		strconv.Itoa(r.NewMatches), formatFloat(r.MeanNewMatchSize), formatFloat(r.FullMatchFraction),
+		strconv.Itoa(r.RelaxedMatches), strconv.Itoa(r.CrossRegionMatches), formatFloat(r.RatingSpread.Mean), formatFloat(r.RatingSpread.P90),
+		strconv.Itoa(r.BackfillMatches), strconv.Itoa(r.BackfillPlayers), formatFloat(r.BackfillShare),
+		formatFloat(r.BackfillSeatWait.Mean), formatFloat(r.BackfillSeatWait.P90), formatFloat(r.BackfillRemaining.Mean),
+		strconv.Itoa(r.GameServers), formatFloat(r.SeatOccupancy),
+	}
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'f', 3, 64)
+}
+
+// write 結果をformatでpathに書き込む。pathが空なら標準出力に出力する
+// CSVは既にあるファイルには行だけを追記し、パラメータを変えた実行結果を1つの表にまとめられるようにする
+func (r report) write(format, path string) error {
+	if format == "json" {
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+		return writeOutput(path, false, func(w io.Writer, _ bool) error {
+			_, err := w.Write(append(b, '\n'))
+			return err
+		})
+	}
+	return writeOutput(path, true, func(w io.Writer, header bool) error {
+		cw := csv.NewWriter(w)
+		if header {
+			cw.Write(csvHeader)
+		}
+		cw.Write(r.csvRecord())
+		cw.Flush()
+		return cw.Error()
+	})
+}
+
+// writeMatches マッチごとの記録をCSVでpathに書き込む
+func writeMatches(path string, matches []matchRecord) error {
+	return writeOutput(path, false, func(w io.Writer, _ bool) error {
+		cw := csv.NewWriter(w)
+		cw.Write([]string{"time_seconds", "mode", "backfill", "players", "session_players", "rating_spread", "seat_wait_seconds", "remaining_seconds", "relaxation_level", "cross_region"})
+		for _, m := range matches {
+			spread := ""
+			if !math.IsNaN(m.ratingSpread) {
+				spread = formatFloat(m.ratingSpread)
+			}
+			// 新規マッチのseat_wait_secondsと、終了時刻のないセッションのremaining_secondsは空欄にする
+			seatWait, remaining := "", ""
+			if m.backfill {
+				seatWait = formatFloat(m.seatWait.Seconds())
+			}
+			if m.remaining >= 0 {
+				remaining = formatFloat(m.remaining.Seconds())
+			}
+			cw.Write([]string{formatFloat(m.at.Seconds()), m.mode, strconv.FormatBool(m.backfill), strconv.Itoa(m.players), strconv.Itoa(m.sessionPlayers), spread, seatWait, remaining,
+				strconv.Itoa(m.level), strconv.FormatBool(m.crossRegion)})
+		}
+		cw.Flush()
+		return cw.Error()
+	})
+}
+
+// writeOutput pathを開いてwriteで書き込む。pathが空なら標準出力に書き込む
+// appendModeがtrueならファイルに追記し、新しいファイルか標準出力の場合だけwriteのheaderをtrueにする
+func writeOutput(path string, appendMode bool, write func(w io.Writer, header bool) error) error {
+	if path == "" {
+		return write(os.Stdout, true)
+	}
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if appendMode {
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	}
+	f, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	if err := write(f, info.Size() == 0); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
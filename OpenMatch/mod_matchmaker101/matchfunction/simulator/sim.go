@@ -0,0 +1,354 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/golang/protobuf/ptypes/any"
+	"open-match.dev/open-match/pkg/pb"
+
+	"matchfunction/mmf"
+)
+
+// simPlayer シミュレーションの1人のプレイヤー
+type simPlayer struct {
+	id string
+	arrival
+	// stay GameServerに接続してから離脱するまでの時間。到着時に決める
+	stay time.Duration
+
+	matched   bool
+	backfill  bool
+	abandoned bool
+	matchedAt time.Duration
+	leaveAt   time.Duration
+}
+
+// ticket プレイヤーのPlayerTicket。Frontendが作るチケットに到着の属性を加えたもの
+func (p *simPlayer) ticket() *pb.Ticket {
+	args := map[string]float64{"created_at": seconds(simTime(p.at))}
+	for k, v := range p.doubleArgs {
+		args[k] = v
+	}
+	return &pb.Ticket{
+		Id: p.id,
+		SearchFields: &pb.SearchFields{
+			Tags:       []string{p.mode, "player"},
+			DoubleArgs: args,
+			StringArgs: p.stringArgs,
+		},
+	}
+}
+
+// simEpoch シミュレーションの開始時刻。チケットの時刻はここからの経過時間をUNIX時刻にしたもの
+var simEpoch = time.Unix(0, 0)
+
+// simTime シミュレーションの経過時間の時刻
+func simTime(d time.Duration) time.Time {
+	return simEpoch.Add(d)
+}
+
+// session 1台のGameServerで進行中のセッション。全員が離脱するか、MaxSessionDurationが経過したら終わる
+type session struct {
+	id        string
+	mode      string
+	players   []*simPlayer
+	startedAt time.Duration
+	// endsAt MaxSessionDurationで終わる時刻。0なら終了時刻なし
+	endsAt time.Duration
+	// open, openedAt 空席を募集しているか、募集を始めた時刻
+	open     bool
+	openedAt time.Duration
+}
+
+// backfillTicket 空席のあるセッションのBackfillTicket。GameServerがFrontendに登録するチケットと同じ形にする
+func (s *session) backfillTicket(capacity int) *pb.Ticket {
+	args := map[string]float64{"created_at": seconds(simTime(s.openedAt))}
+	if s.endsAt > 0 {
+		args["ends_at"] = seconds(simTime(s.endsAt))
+	}
+	return &pb.Ticket{
+		Id: "backfill-" + s.id,
+		SearchFields: &pb.SearchFields{
+			Tags:       []string{s.mode, "backfill"},
+			DoubleArgs: args,
+		},
+		Assignment: &pb.Assignment{
+			Connection: s.id,
+			Extensions: map[string]*any.Any{
+				"joinablePlayerNum": {Value: []byte(strconv.Itoa(capacity - len(s.players)))},
+			},
+		},
+	}
+}
+
+// matchRecord 1つのマッチの記録
+type matchRecord struct {
+	at       time.Duration
+	mode     string
+	backfill bool
+	// players マッチで割り当てたプレイヤー数
+	players int
+	// sessionPlayers 割り当てた後のセッションの人数
+	sessionPlayers int
+	// ratingSpread 割り当てた後のセッションのレーティングの最大と最小の差。レーティングのないプレイヤーがいればNaN
+	ratingSpread float64
+	// seatWait Backfillのマッチで、セッションが空席の募集を始めてから埋まるまでの時間
+	seatWait time.Duration
+	// remaining Backfillのマッチで、セッションが終わるまでの時間。終了時刻がなければ-1
+	remaining time.Duration
+	// level 新規マッチの緩和段階
+	level int
+	// crossRegion 新規マッチに異なる地域のプレイヤーがいるか
+	crossRegion bool
+}
+
+// simulator 到着したプレイヤーをTickごとにMakeMatchesでマッチさせ、GameServerのセッションへの割り当てと離脱を進める
+type simulator struct {
+	params mmf.Params
+	// relaxation プロファイルに設定する緩和スケジュールのJSON。nilなら緩和しない
+	relaxation []byte
+	capacity   int
+	tick       time.Duration
+	maxWait    time.Duration
+	// maxSession セッションの長さの上限。0なら上限なし
+	maxSession time.Duration
+
+	players  []*simPlayer
+	byID     map[string]*simPlayer
+	waiting  map[string][]*simPlayer
+	sessions []*session
+	matches  []matchRecord
+
+	sessionNum int
+	// occupiedSeats, offeredSeats Tickごとの進行中のセッションの在席数と定員の合計
+	occupiedSeats int
+	offeredSeats  int
+}
+
+func newSimulator(params mmf.Params, relaxation []byte) *simulator {
+	return &simulator{
+		params:     params,
+		relaxation: relaxation,
+		capacity:   conf.ServerCapacity,
+		tick:       conf.Tick,
+		maxWait:    conf.MaxWait,
+		maxSession: conf.MaxSessionDuration,
+		byID:       map[string]*simPlayer{},
+		waiting:    map[string][]*simPlayer{},
+	}
+}
+
+// run arrivalsを到着させ、Durationまでの各TickでMatch Functionを実行する
+// Durationの時点でマッチしていないプレイヤーは未マッチとして残す
+func (s *simulator) run(arrivals []arrival, rnd *rand.Rand, duration time.Duration) error {
+	next := 0
+	for now := time.Duration(0); now <= duration; now += s.tick {
+		for ; next < len(arrivals) && arrivals[next].at <= now; next++ {
+			s.arrive(arrivals[next], rnd)
+		}
+		s.leave(now)
+		s.abandon(now)
+		s.updateOpen(now)
+		for _, mode := range s.modes() {
+			if err := s.runMatchFunction(now, mode); err != nil {
+				return err
+			}
+		}
+		s.updateOpen(now)
+		for _, ss := range s.sessions {
+			s.occupiedSeats += len(ss.players)
+			s.offeredSeats += s.capacity
+		}
+	}
+	return nil
+}
+
+// arrive プレイヤーを待機させる。滞在時間は到着順に決め、シードが同じなら同じ結果になるようにする
+func (s *simulator) arrive(a arrival, rnd *rand.Rand) {
+	p := &simPlayer{
+		id:      fmt.Sprintf("player-%v", len(s.players)),
+		arrival: a,
+		stay:    conf.StayMin + time.Duration(rnd.Int63n(int64(conf.StayMax-conf.StayMin)+1)),
+	}
+	s.players = append(s.players, p)
+	s.byID[p.id] = p
+	s.waiting[p.mode] = append(s.waiting[p.mode], p)
+}
+
+// leave 滞在時間の過ぎたプレイヤーを離脱させ、誰もいなくなったセッションと終了時刻の来たセッションを終える
+func (s *simulator) leave(now time.Duration) {
+	var sessions []*session
+	for _, ss := range s.sessions {
+		if ss.endsAt > 0 && now >= ss.endsAt {
+			continue
+		}
+		var staying []*simPlayer
+		for _, p := range ss.players {
+			if p.leaveAt > now {
+				staying = append(staying, p)
+			}
+		}
+		ss.players = staying
+		if len(staying) > 0 {
+			sessions = append(sessions, ss)
+		}
+	}
+	s.sessions = sessions
+}
+
+// updateOpen 空席のできたセッションの募集を始め、満席になったセッションの募集を終える
+// Frontendと同じく、空席数が変わっても満席になるまでは募集を始めた時刻を引き継ぐ
+func (s *simulator) updateOpen(now time.Duration) {
+	for _, ss := range s.sessions {
+		hasSeats := len(ss.players) < s.capacity
+		if hasSeats && !ss.open {
+			ss.openedAt = now
+		}
+		ss.open = hasSeats
+	}
+}
+
+// abandon MaxWaitより長く待ったプレイヤーにマッチングを諦めさせる
+func (s *simulator) abandon(now time.Duration) {
+	if s.maxWait <= 0 {
+		return
+	}
+	for mode, players := range s.waiting {
+		var waiting []*simPlayer
+		for _, p := range players {
+			if now-p.at >= s.maxWait {
+				p.abandoned = true
+				continue
+			}
+			waiting = append(waiting, p)
+		}
+		s.waiting[mode] = waiting
+	}
+}
+
+// modes 待機中のプレイヤーがいるゲームモード。マッチの順序を固定するため名前順にする
+func (s *simulator) modes() []string {
+	var modes []string
+	for mode, players := range s.waiting {
+		if len(players) > 0 {
+			modes = append(modes, mode)
+		}
+	}
+	sort.Strings(modes)
+	return modes
+}
+
+// runMatchFunction ゲームモードのプロファイルでMakeMatchesを実行し、マッチを割り当てる
+// PlayerTicketは到着順、BackfillTicketはセッションの開始順に渡す
+func (s *simulator) runMatchFunction(now time.Duration, mode string) error {
+	var playerTickets, backfillTickets []*pb.Ticket
+	for _, p := range s.waiting[mode] {
+		playerTickets = append(playerTickets, p.ticket())
+	}
+	sessions := map[string]*session{}
+	for _, ss := range s.sessions {
+		if ss.mode == mode && len(ss.players) < s.capacity {
+			backfillTickets = append(backfillTickets, ss.backfillTicket(s.capacity))
+			sessions[ss.id] = ss
+		}
+	}
+
+	profile := &pb.MatchProfile{Name: mode}
+	if s.relaxation != nil {
+		profile.Extensions = map[string]*any.Any{"relaxation": {Value: s.relaxation}}
+	}
+	matches, err := mmf.MakeMatches(s.params, simTime(now), profile, playerTickets, backfillTickets)
+	if err != nil {
+		return fmt.Errorf("failed to make matches for %v at %v, got %w", mode, now, err)
+	}
+	for _, m := range matches {
+		s.assign(now, mode, m, sessions)
+	}
+
+	var waiting []*simPlayer
+	for _, p := range s.waiting[mode] {
+		if !p.matched {
+			waiting = append(waiting, p)
+		}
+	}
+	s.waiting[mode] = waiting
+	return nil
+}
+
+// assign マッチのプレイヤーを、BackfillTicketのマッチならそのセッションに、そうでなければ新しいセッションに割り当てる
+func (s *simulator) assign(now time.Duration, mode string, m *pb.Match, sessions map[string]*session) {
+	ss := &session{id: fmt.Sprintf("gameserver-%v", s.sessionNum), mode: mode}
+	backfill := false
+	for _, t := range m.GetTickets() {
+		if t.GetAssignment() != nil {
+			ss = sessions[t.GetAssignment().GetConnection()]
+			backfill = true
+		}
+	}
+	r := matchRecord{at: now, mode: mode, backfill: backfill, remaining: -1}
+	r.level, _ = mmf.RelaxationLevel(m)
+	if backfill {
+		r.seatWait = now - ss.openedAt
+		if ss.endsAt > 0 {
+			r.remaining = ss.endsAt - now
+		}
+	} else {
+		s.sessionNum++
+		ss.startedAt = now
+		if s.maxSession > 0 {
+			ss.endsAt = now + s.maxSession
+		}
+		s.sessions = append(s.sessions, ss)
+	}
+
+	for _, t := range m.GetTickets() {
+		p, ok := s.byID[t.GetId()]
+		if !ok {
+			continue
+		}
+		p.matched = true
+		p.backfill = backfill
+		p.matchedAt = now
+		p.leaveAt = now + p.stay
+		ss.players = append(ss.players, p)
+		r.players++
+	}
+	r.sessionPlayers = len(ss.players)
+	r.crossRegion = !backfill && crossRegion(ss.players)
+	r.ratingSpread = ratingSpread(ss.players)
+	s.matches = append(s.matches, r)
+}
+
+// seconds 秒単位のUNIX時刻
+func seconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// crossRegion プレイヤーに異なる地域が混ざっているか
+func crossRegion(players []*simPlayer) bool {
+	for _, p := range players {
+		if p.stringArgs["region"] != players[0].stringArgs["region"] {
+			return true
+		}
+	}
+	return false
+}
+
+// ratingSpread プレイヤーのレーティングの最大と最小の差。レーティングのないプレイヤーがいればNaN
+func ratingSpread(players []*simPlayer) float64 {
+	lo, hi := math.Inf(1), math.Inf(-1)
+	for _, p := range players {
+		r, ok := p.doubleArgs["rating"]
+		if !ok {
+			return math.NaN()
+		}
+		lo = math.Min(lo, r)
+		hi = math.Max(hi, r)
+	}
+	return hi - lo
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"math/rand"
+	"reflect"
+	"testing"
+	"time"
+
+	"matchfunction/mmf"
+)
+
+// useSimConfig テスト用の設定をconfにし、テストの終わりに戻す。updateで既定から変える
+func useSimConfig(t *testing.T, update func(c *simConfig)) {
+	t.Helper()
+	saved := conf
+	conf = simConfig{
+		Duration:       time.Minute,
+		ArrivalRate:    1,
+		GameModes:      []string{"mode.demo"},
+		Tick:           time.Second,
+		StayMin:        time.Minute,
+		StayMax:        time.Minute,
+		ServerCapacity: 4,
+		Params:         mmf.DefaultParams,
+	}
+	if update != nil {
+		update(&conf)
+	}
+	t.Cleanup(func() { conf = saved })
+}
+
+// arrivalsAt ゲームモードmode.demoのプレイヤーがatsに到着する
+func arrivalsAt(ats ...time.Duration) []arrival {
+	var arrivals []arrival
+	for _, at := range ats {
+		arrivals = append(arrivals, arrival{at: at, mode: "mode.demo", doubleArgs: map[string]float64{}, stringArgs: map[string]string{}})
+	}
+	return arrivals
+}
+
+// simMatch 比較するマッチの記録
+type simMatch struct {
+	at       time.Duration
+	backfill bool
+	players  int
+}
+
+func TestRunSameSeed(t *testing.T) {
+	useSimConfig(t, func(c *simConfig) {
+		c.Duration = 5 * time.Minute
+		c.ArrivalRate = 0.5
+		c.GameModes = []string{"mode.demo", "mode.ranked"}
+		c.Regions = []string{"asia", "us"}
+		c.RatingMean, c.RatingStddev = 1500, 200
+		c.StayMin, c.StayMax = 30*time.Second, 3*time.Minute
+		c.MaxWait = time.Minute
+		c.MaxSessionDuration = 2 * time.Minute
+		c.Params.RatingWindow = 150
+	})
+	simulate := func(seed int64) (report, []matchRecord) {
+		rnd := rand.New(rand.NewSource(seed))
+		s := newSimulator(conf.Params, []byte(`[{"after_seconds":20,"rating_window":400}]`))
+		if err := s.run(syntheticArrivals(rnd), rnd, conf.Duration); err != nil {
+			t.Fatalf("run() error = %v", err)
+		}
+		return newReport(s), s.matches
+	}
+
+	r1, m1 := simulate(1)
+	r2, m2 := simulate(1)
+	if r1.Players == 0 || len(m1) == 0 {
+		t.Fatalf("simulated %v players and %v matches, want some", r1.Players, len(m1))
+	}
+	if !reflect.DeepEqual(r1, r2) {
+		t.Errorf("reports of the same seed differ:\n%+v\n%+v", r1, r2)
+	}
+	if !reflect.DeepEqual(m1, m2) {
+		t.Error("matches of the same seed differ")
+	}
+
+	// シードを変えれば到着も変わる
+	if r3, _ := simulate(2); reflect.DeepEqual(r1, r3) {
+		t.Error("reports of different seeds are the same")
+	}
+}
+
+func TestRunScenarios(t *testing.T) {
+	tests := []struct {
+		name     string
+		update   func(c *simConfig)
+		arrivals []arrival
+		duration time.Duration
+		want     []simMatch
+		// wantMatched, wantBackfill, wantAbandoned, wantUnmatched, wantServers プレイヤーとセッションの集計
+		wantMatched   int
+		wantBackfill  int
+		wantAbandoned int
+		wantUnmatched int
+		wantServers   int
+	}{
+		{
+			name:         "late player fills the empty seat",
+			arrivals:     arrivalsAt(0, 0, 0, 5*time.Second),
+			duration:     10 * time.Second,
+			want:         []simMatch{{at: 0, players: 3}, {at: 5 * time.Second, backfill: true, players: 1}},
+			wantMatched:  4,
+			wantBackfill: 1,
+			wantServers:  1,
+		},
+		{
+			name:        "second server once the first is full",
+			arrivals:    arrivalsAt(0, 0, 0, 0, 5*time.Second, 5*time.Second),
+			duration:    10 * time.Second,
+			want:        []simMatch{{at: 0, players: 4}, {at: 5 * time.Second, players: 2}},
+			wantMatched: 6,
+			wantServers: 2,
+		},
+		{
+			name:          "player gives up after max wait",
+			update:        func(c *simConfig) { c.MaxWait = 10 * time.Second },
+			arrivals:      arrivalsAt(0, 20*time.Second),
+			duration:      25 * time.Second,
+			wantAbandoned: 1,
+			wantUnmatched: 1,
+		},
+		{
+			name: "session ends when every player left",
+			update: func(c *simConfig) {
+				c.StayMin, c.StayMax = 10*time.Second, 10*time.Second
+			},
+			arrivals:      arrivalsAt(0, 0, 12*time.Second),
+			duration:      20 * time.Second,
+			want:          []simMatch{{at: 0, players: 2}},
+			wantMatched:   2,
+			wantUnmatched: 1,
+			wantServers:   1,
+		},
+		{
+			name:         "backfill before max session duration",
+			update:       func(c *simConfig) { c.MaxSessionDuration = 5 * time.Second },
+			arrivals:     arrivalsAt(0, 0, 3*time.Second),
+			duration:     10 * time.Second,
+			want:         []simMatch{{at: 0, players: 2}, {at: 3 * time.Second, backfill: true, players: 1}},
+			wantMatched:  3,
+			wantBackfill: 1,
+			wantServers:  1,
+		},
+		{
+			// 終了時刻の来たセッションには空席があっても割り当てない
+			name:          "no backfill after max session duration",
+			update:        func(c *simConfig) { c.MaxSessionDuration = 5 * time.Second },
+			arrivals:      arrivalsAt(0, 0, 8*time.Second),
+			duration:      10 * time.Second,
+			want:          []simMatch{{at: 0, players: 2}},
+			wantMatched:   2,
+			wantUnmatched: 1,
+			wantServers:   1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			useSimConfig(t, tt.update)
+			s := newSimulator(conf.Params, nil)
+			if err := s.run(tt.arrivals, rand.New(rand.NewSource(1)), tt.duration); err != nil {
+				t.Fatalf("run() error = %v", err)
+			}
+
+			var got []simMatch
+			for _, m := range s.matches {
+				got = append(got, simMatch{at: m.at, backfill: m.backfill, players: m.players})
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("matches = %+v, want %+v", got, tt.want)
+			}
+			r := newReport(s)
+			if r.Matched != tt.wantMatched || r.BackfillPlayers != tt.wantBackfill || r.Abandoned != tt.wantAbandoned ||
+				r.Unmatched != tt.wantUnmatched || r.GameServers != tt.wantServers {
+				t.Errorf("matched %v, backfill %v, abandoned %v, unmatched %v, servers %v, want %v, %v, %v, %v, %v",
+					r.Matched, r.BackfillPlayers, r.Abandoned, r.Unmatched, r.GameServers,
+					tt.wantMatched, tt.wantBackfill, tt.wantAbandoned, tt.wantUnmatched, tt.wantServers)
+			}
+			if r.Players != r.Matched+r.Abandoned+r.Unmatched {
+				t.Errorf("%v players are not %v matched, %v abandoned and %v unmatched", r.Players, r.Matched, r.Abandoned, r.Unmatched)
+			}
+		})
+	}
+}